@@ -4,14 +4,18 @@ package api
 import (
 	"dicom-store-api/api/app"
 	"dicom-store-api/api/dicomweb"
+	"dicom-store-api/api/ris"
+	"dicom-store-api/api/scp"
 	"time"
 
 	"dicom-store-api/database"
+	"dicom-store-api/fs"
 	"dicom-store-api/logging"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 	"github.com/go-chi/render"
+	"github.com/spf13/viper"
 )
 
 // New configures application resources and routes.
@@ -24,13 +28,31 @@ func New(enableCORS bool) (*chi.Mux, error) {
 		return nil, err
 	}
 
+	if err := fs.Configure(); err != nil {
+		logger.WithField("module", "storage").Error(err)
+		return nil, err
+	}
+
 	wadoAPI, err := dicomweb.NewAPI(db)
 	if err != nil {
 		logger.WithField("module", "dicomweb").Error(err)
 		return nil, err
 	}
 
-	appAPI, err := app.NewAPI(db)
+	if viper.GetBool("dimse.enabled") {
+		dimseSCP, err := scp.NewSCPFromConfig(wadoAPI.STOW, wadoAPI.QIDO)
+		if err != nil {
+			logger.WithField("module", "dimse").Error(err)
+			return nil, err
+		}
+		dimseSCP.Start()
+	}
+
+	if viper.GetBool("hl7.enabled") {
+		ris.NewListenerFromConfig(db, wadoAPI.STOW.Worklist, wadoAPI.STOW.Coercion).Start()
+	}
+
+	appAPI, err := app.NewAPI(db, wadoAPI.STOW.Queue, wadoAPI.STOW.Worklist)
 	if err != nil {
 		logger.WithField("module", "app").Error(err)
 		return nil, err
@@ -3,24 +3,45 @@ package app
 import (
 	"dicom-store-api/models"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-pg/pg"
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 
 	"dicom-store-api/database"
 	"dicom-store-api/logging"
+	"dicom-store-api/prefetch"
+	"dicom-store-api/routing"
+	"dicom-store-api/worklist"
 )
 
 type ctxKey int
 
 const (
 	ctxInstance ctxKey = iota
+	ctxJob
+	ctxTrashItem
+	ctxRejection
+	ctxRemoteNode
+	ctxRouteItem
+	ctxPrefetch
+	ctxCommitment
+	ctxWorklistItem
 )
 
 type API struct {
-	instanceResource *InstanceResource
-	summaryResource  *SummaryResource
+	instanceResource   *InstanceResource
+	summaryResource    *SummaryResource
+	jobResource        *JobResource
+	trashResource      *TrashResource
+	rejectionResource  *RejectionResource
+	remoteResource     *RemoteNodeResource
+	routingResource    *RoutingResource
+	prefetchResource   *PrefetchResource
+	commitmentResource *CommitmentResource
+	worklistResource   *WorklistResource
 }
 
 type StudyStore interface {
@@ -28,31 +49,130 @@ type StudyStore interface {
 	Create(s *models.Study, tx *pg.Tx) error
 	Update(s *models.Study, tx *pg.Tx) error
 	CountBy(fields map[string]any, tx *pg.Tx) (int, error)
+	UpdateComputedFields(s *models.Study, tx *pg.Tx) error
+	Lock(s *models.Study, tx *pg.Tx) error
+	Restore(s *models.Study, tx *pg.Tx) error
+	Purge(s *models.Study, tx *pg.Tx) error
+	FindDeleted(deletedBefore time.Time, options *database.SelectQueryOptions, tx *pg.Tx) ([]*models.Study, error)
+	GetDeleted(studyInstanceUID string, tx *pg.Tx) (*models.Study, error)
 }
 type SeriesStore interface {
 	FindBy(fields map[string]any, options *database.SelectQueryOptions, tx *pg.Tx) ([]*models.Series, error)
 	Create(s *models.Series, tx *pg.Tx) error
 	Update(s *models.Series, tx *pg.Tx) error
 	CountBy(fields map[string]any, tx *pg.Tx) (int, error)
+	Restore(s *models.Series, tx *pg.Tx) error
+	Purge(s *models.Series, tx *pg.Tx) error
+	FindDeleted(deletedBefore time.Time, options *database.SelectQueryOptions, tx *pg.Tx) ([]*models.Series, error)
+	GetDeleted(seriesInstanceUID string, tx *pg.Tx) (*models.Series, error)
 }
 type InstanceStore interface {
 	FindBy(fields map[string]any, options *database.SelectQueryOptions, tx *pg.Tx) ([]*models.Instance, error)
 	Create(s *models.Instance, tx *pg.Tx) error
 	Update(s *models.Instance, tx *pg.Tx) error
 	CountBy(fields map[string]any, tx *pg.Tx) (int, error)
+	Restore(s *models.Instance, tx *pg.Tx) error
+	Purge(s *models.Instance, tx *pg.Tx) error
+	FindDeleted(deletedBefore time.Time, options *database.SelectQueryOptions, tx *pg.Tx) ([]*models.Instance, error)
+	FindDeletedByStudy(studyID int, tx *pg.Tx) ([]*models.Instance, error)
+	FindDeletedBySeries(seriesID int, tx *pg.Tx) ([]*models.Instance, error)
+	GetDeleted(sopInstanceUID string, tx *pg.Tx) (*models.Instance, error)
+	CountByStoragePath(storagePath string, tx *pg.Tx) (int, error)
+	FindToRecompress(compression string, afterID int, limit int, tx *pg.Tx) ([]*models.Instance, error)
+	LockFile(instance *models.Instance, tx *pg.Tx) error
+	UpdateCompression(instance *models.Instance, tx *pg.Tx) error
+}
+type RejectionStore interface {
+	Get(rejectionNoteID int) (*models.RejectionNote, error)
+	FindBy(codeValue string, options *database.SelectQueryOptions) ([]*models.RejectionNote, error)
+}
+type RemoteNodeStore interface {
+	List() ([]*models.RemoteNode, error)
+	GetByAETitle(aeTitle string) (*models.RemoteNode, error)
+	Create(n *models.RemoteNode, tx *pg.Tx) error
+	Update(n *models.RemoteNode, tx *pg.Tx) error
+	Delete(n *models.RemoteNode, tx *pg.Tx) error
+}
+type RouteStore interface {
+	Get(itemID int) (*models.RouteItem, error)
+	Update(item *models.RouteItem) error
+	Delete(item *models.RouteItem) error
+	CountByDestination() ([]*database.RouteCount, error)
+	FindLastErrors() ([]*models.RouteItem, error)
+	FindDead(destination string, options *database.SelectQueryOptions) ([]*models.RouteItem, error)
+	RequeueDead(destination string) (int, error)
+}
+type PrefetchStore interface {
+	Get(prefetchID int) (*models.Prefetch, error)
+	List(patientID string, options *database.SelectQueryOptions) ([]*models.Prefetch, error)
+}
+type CommitmentStore interface {
+	Get(commitmentID int) (*models.StorageCommitment, error)
+	List(callingAETitle string, options *database.SelectQueryOptions) ([]*models.StorageCommitment, error)
+}
+type WorklistStore interface {
+	Get(itemID int) (*models.WorklistItem, error)
+	FindBy(fields map[string]any, options *database.SelectQueryOptions, tx *pg.Tx) ([]*models.WorklistItem, error)
+}
+type IngestQueue interface {
+	Retrieve(fetch func(add func(file []byte) error) error) (*models.Job, error)
+}
+type JobStore interface {
+	Get(jobID int) (*models.Job, error)
 }
 
-func NewAPI(db *pg.DB) (*API, error) {
+func NewAPI(db *pg.DB, ingestQueue IngestQueue, worklistManager *worklist.Manager) (*API, error) {
 	studyStore := database.NewStudyStore(db)
 	seriesStore := database.NewSeriesStore(db)
 	instanceStore := database.NewInstanceStore(db)
 
 	instanceResource := NewInstanceResource(db, instanceStore)
 	summaryResource := NewSummaryResource(db, studyStore, seriesStore, instanceStore)
+	jobResource := NewJobResource(db, database.NewJobStore(db))
+
+	viper.SetDefault("trash_grace_period", "168h")
+	viper.SetDefault("trash_purge_interval", "1h")
+	trashResource := NewTrashResource(db, studyStore, seriesStore, instanceStore, viper.GetDuration("trash_grace_period"))
+	trashResource.StartPurger(viper.GetDuration("trash_purge_interval"))
+
+	viper.SetDefault("storage.recompress_interval", "0")
+	if interval := viper.GetDuration("storage.recompress_interval"); interval > 0 {
+		NewRecompressor(db, instanceStore).Start(interval)
+	}
+
+	rejectionResource := NewRejectionResource(db, database.NewRejectionStore(db))
+	viper.SetDefault("remote.timeout", "30s")
+	viper.SetDefault("remote.retrieve_timeout", "1h")
+	remoteResource := NewRemoteNodeResource(db, database.NewRemoteNodeStore(db), ingestQueue,
+		viper.GetDuration("remote.timeout"), viper.GetDuration("remote.retrieve_timeout"))
+
+	// the engine routing the stored instances is started by the DICOMweb API, this one only describes it
+	routingEngine, err := routing.NewEngineFromConfig(db)
+	if err != nil {
+		return nil, err
+	}
+	routingResource := NewRoutingResource(db, database.NewRouteStore(db), routingEngine)
+
+	// likewise the prefetcher is started by the DICOMweb API
+	prefetcher, err := prefetch.NewPrefetcherFromConfig(db, ingestQueue)
+	if err != nil {
+		return nil, err
+	}
+	prefetchResource := NewPrefetchResource(db, database.NewPrefetchStore(db), prefetcher)
+	commitmentResource := NewCommitmentResource(db, instanceStore, database.NewCommitmentStore(db))
+	worklistResource := NewWorklistResource(db, database.NewWorklistStore(db), worklistManager)
 
 	api := &API{
 		instanceResource,
 		summaryResource,
+		jobResource,
+		trashResource,
+		rejectionResource,
+		remoteResource,
+		routingResource,
+		prefetchResource,
+		commitmentResource,
+		worklistResource,
 	}
 	return api, nil
 }
@@ -70,6 +190,87 @@ func (a *API) Router() *chi.Mux {
 		r.Put("/tools", a.instanceResource.updateToolsData)
 	})
 
+	r.Route("/jobs/{jobID}", func(r chi.Router) {
+		r.Use(a.jobResource.ctx)
+		r.Get("/", a.jobResource.getJob)
+	})
+
+	r.Route("/rejections", func(r chi.Router) {
+		r.Get("/", a.rejectionResource.list)
+		r.Route("/{rejectionID}", func(r chi.Router) {
+			r.Use(a.rejectionResource.ctx)
+			r.Get("/", a.rejectionResource.getRejection)
+		})
+	})
+
+	r.Route("/remote", func(r chi.Router) {
+		r.Get("/", a.remoteResource.list)
+		r.Post("/", a.remoteResource.create)
+		r.Route("/{aeTitle}", func(r chi.Router) {
+			r.Use(a.remoteResource.ctx)
+			r.Get("/", a.remoteResource.get)
+			r.Put("/", a.remoteResource.update)
+			r.Delete("/", a.remoteResource.delete)
+
+			r.Get("/studies", a.remoteResource.searchStudies)
+			r.Get("/studies/{studyUID}/metadata", a.remoteResource.studyMetadata)
+			r.Get("/studies/{studyUID}/series", a.remoteResource.searchSeries)
+			r.Get("/studies/{studyUID}/series/{seriesUID}/instances", a.remoteResource.searchInstances)
+			r.Post("/studies/{studyUID}/retrieve", a.remoteResource.retrieveStudy)
+			r.Post("/studies/{studyUID}/series/{seriesUID}/retrieve", a.remoteResource.retrieveSeries)
+		})
+	})
+
+	r.Route("/routing", func(r chi.Router) {
+		r.Get("/", a.routingResource.getStatus)
+		r.Get("/dead", a.routingResource.listDead)
+		r.Post("/dead/retry", a.routingResource.retryDead)
+		r.Route("/dead/{itemID}", func(r chi.Router) {
+			r.Use(a.routingResource.ctx)
+			r.Post("/retry", a.routingResource.retryItem)
+			r.Delete("/", a.routingResource.discardItem)
+		})
+	})
+
+	r.Route("/prefetch", func(r chi.Router) {
+		r.Get("/", a.prefetchResource.list)
+		r.Get("/rules", a.prefetchResource.listRules)
+		r.Route("/{prefetchID}", func(r chi.Router) {
+			r.Use(a.prefetchResource.ctx)
+			r.Get("/", a.prefetchResource.getPrefetch)
+		})
+	})
+
+	r.Route("/commitment", func(r chi.Router) {
+		r.Get("/", a.commitmentResource.list)
+		r.Post("/", a.commitmentResource.check)
+		r.Route("/{commitmentID}", func(r chi.Router) {
+			r.Use(a.commitmentResource.ctx)
+			r.Get("/", a.commitmentResource.getCommitment)
+		})
+	})
+
+	r.Route("/worklist", func(r chi.Router) {
+		r.Get("/", a.worklistResource.list)
+		r.Post("/", a.worklistResource.create)
+		r.Route("/{itemID}", func(r chi.Router) {
+			r.Use(a.worklistResource.ctx)
+			r.Get("/", a.worklistResource.get)
+			r.Put("/", a.worklistResource.update)
+			r.Post("/cancel", a.worklistResource.cancel)
+		})
+	})
+
+	r.Route("/trash", func(r chi.Router) {
+		r.Get("/", a.trashResource.list)
+		r.Delete("/", a.trashResource.empty)
+		r.Route("/{level}/{uid}", func(r chi.Router) {
+			r.Use(a.trashResource.ctx)
+			r.Post("/restore", a.trashResource.restore)
+			r.Delete("/", a.trashResource.purge)
+		})
+	})
+
 	return r
 }
 
@@ -0,0 +1,150 @@
+package app
+
+import (
+	"context"
+	"dicom-store-api/commitment"
+	"dicom-store-api/database"
+	"dicom-store-api/dicomwebclient"
+	"dicom-store-api/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/go-pg/pg"
+)
+
+// Keys of the DICOM JSON attributes of a commitment check.
+const (
+	keyTransactionUID        = "00081195"
+	keyReferencedSOPSequence = "00081199"
+	keyFailedSOPSequence     = "00081198"
+	keyFailureReason         = "00081197"
+	keyReferencedSOPClassUID = "00081150"
+	keyReferencedInstanceUID = "00081155"
+)
+
+var errNoReferencedInstances = errors.New("the request references no instances in 00081199")
+
+// CommitmentResource checks at once whether instances are committed, and lists the storage commitment
+// requests received by the DIMSE service with the state of their reports.
+type CommitmentResource struct {
+	DB              *pg.DB
+	InstanceStore   InstanceStore
+	CommitmentStore CommitmentStore
+}
+
+func NewCommitmentResource(db *pg.DB, instanceStore InstanceStore, commitmentStore CommitmentStore) *CommitmentResource {
+	return &CommitmentResource{
+		DB:              db,
+		InstanceStore:   instanceStore,
+		CommitmentStore: commitmentStore,
+	}
+}
+
+func (rs *CommitmentResource) ctx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		commitmentID, err := strconv.Atoi(chi.URLParam(r, "commitmentID"))
+		if err != nil {
+			render.Render(w, r, ErrBadRequest)
+			return
+		}
+
+		commitment, err := rs.CommitmentStore.Get(commitmentID)
+		if err != nil {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), ctxCommitment, commitment)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// check verifies the instances referenced by a DICOM JSON object, like the data set of a storage commitment
+// request, and answers like its report: the committed instances in 00081199, the others in 00081198 with
+// their failure reason.
+func (rs *CommitmentResource) check(w http.ResponseWriter, r *http.Request) {
+	var req dicomwebclient.Attributes
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		render.Render(w, r, ErrBadRequest)
+		return
+	}
+	var items []*models.StorageCommitmentItem
+	for _, reference := range req.Items(keyReferencedSOPSequence) {
+		item := &models.StorageCommitmentItem{
+			SOPClassUID:    reference.String(keyReferencedSOPClassUID),
+			SOPInstanceUID: reference.String(keyReferencedInstanceUID),
+		}
+		if item.SOPInstanceUID == "" {
+			render.Render(w, r, ErrInvalidRequest(errors.New("a referenced instance has no 00081155")))
+			return
+		}
+		items = append(items, item)
+	}
+	if len(items) == 0 {
+		render.Render(w, r, ErrInvalidRequest(errNoReferencedInstances))
+		return
+	}
+
+	if _, err := commitment.Verify(rs.InstanceStore, items); err != nil {
+		log(r).Error(err)
+		render.Render(w, r, ErrInternalServerError)
+		return
+	}
+
+	committed, failed := []any{}, []any{}
+	for _, item := range items {
+		reference := map[string]any{
+			keyReferencedSOPClassUID: map[string]any{"vr": "UI", "Value": []string{item.SOPClassUID}},
+			keyReferencedInstanceUID: map[string]any{"vr": "UI", "Value": []string{item.SOPInstanceUID}},
+		}
+		if item.FailureReason == 0 {
+			committed = append(committed, reference)
+			continue
+		}
+		reference[keyFailureReason] = map[string]any{"vr": "US", "Value": []int{item.FailureReason}}
+		failed = append(failed, reference)
+	}
+	response := map[string]any{}
+	if transactionUID := req.String(keyTransactionUID); transactionUID != "" {
+		response[keyTransactionUID] = map[string]any{"vr": "UI", "Value": []string{transactionUID}}
+	}
+	if len(committed) > 0 {
+		response[keyReferencedSOPSequence] = map[string]any{"vr": "SQ", "Value": committed}
+	}
+	if len(failed) > 0 {
+		response[keyFailedSOPSequence] = map[string]any{"vr": "SQ", "Value": failed}
+	}
+	render.JSON(w, r, response)
+}
+
+// list returns the storage commitment requests, most recent first, of the calling_ae_title query parameter
+// if it is set.
+func (rs *CommitmentResource) list(w http.ResponseWriter, r *http.Request) {
+	options := &database.SelectQueryOptions{OrderBy: "storage_commitment.id", OrderDirection: "DESC"}
+	options.Limit, _ = strconv.Atoi(r.URL.Query().Get("limit"))
+	options.Offset, _ = strconv.Atoi(r.URL.Query().Get("offset"))
+
+	commitments, err := rs.CommitmentStore.List(r.URL.Query().Get("calling_ae_title"), options)
+	if err != nil {
+		log(r).Error(err)
+		render.Render(w, r, ErrInternalServerError)
+		return
+	}
+	if commitments == nil {
+		commitments = []*models.StorageCommitment{}
+	}
+	render.JSON(w, r, commitments)
+}
+
+func (rs *CommitmentResource) getCommitment(w http.ResponseWriter, r *http.Request) {
+	commitment, ok := r.Context().Value(ctxCommitment).(*models.StorageCommitment)
+	if !ok {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	render.JSON(w, r, commitment)
+}
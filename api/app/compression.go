@@ -0,0 +1,124 @@
+package app
+
+import (
+	"bytes"
+	"dicom-store-api/fs"
+	"dicom-store-api/logging"
+	"dicom-store-api/models"
+	"fmt"
+	"time"
+
+	"github.com/go-pg/pg"
+)
+
+// Recompressor compresses the files of instances stored before compression was enabled,
+// or with another compression, with the configured one.
+type Recompressor struct {
+	DB            *pg.DB
+	InstanceStore InstanceStore
+	BatchSize     int
+}
+
+func NewRecompressor(db *pg.DB, instanceStore InstanceStore) *Recompressor {
+	return &Recompressor{
+		DB:            db,
+		InstanceStore: instanceStore,
+		BatchSize:     100,
+	}
+}
+
+// Start recompresses the instance files every interval.
+func (rc *Recompressor) Start(interval time.Duration) {
+	logger := logging.Logger.WithField("module", "compression")
+	go func() {
+		for {
+			recompressed, err := rc.Run()
+			if err != nil {
+				logger.Error(err)
+			} else if recompressed > 0 {
+				logger.Infof("recompressed %d instance files", recompressed)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// Run recompresses every instance file not yet stored with the configured compression and returns how many
+// files were considered. Files failing their checksum are skipped and logged, they are left for verify.
+func (rc *Recompressor) Run() (int, error) {
+	logger := logging.Logger.WithField("module", "compression")
+	compression := fs.GetCompression()
+	if compression == fs.CompressionNone {
+		return 0, nil
+	}
+
+	recompressed := 0
+	for afterID := 0; ; {
+		instances, err := rc.InstanceStore.FindToRecompress(compression, afterID, rc.BatchSize, nil)
+		if err != nil {
+			return recompressed, err
+		}
+		if len(instances) == 0 {
+			return recompressed, nil
+		}
+		for _, instance := range instances {
+			done, err := rc.recompress(instance)
+			if err != nil {
+				logger.WithField("instance", instance.SOPInstanceUID).Warn(err)
+			} else if done {
+				recompressed++
+			}
+		}
+		afterID = instances[len(instances)-1].ID
+	}
+}
+
+// recompress rewrites the file of the instance while holding its row, so that a concurrent STOW or purge
+// of the instance waits for it. It reports false if the instance is gone or was recompressed meanwhile.
+func (rc *Recompressor) recompress(instance *models.Instance) (bool, error) {
+	tx, err := rc.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if err = rc.InstanceStore.LockFile(instance, tx); err == pg.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if instance.FileCompression == fs.GetCompression() || instance.FileCompression == fs.CompressionNone || instance.Tier != "" {
+		return false, nil
+	}
+
+	key := fs.GetDicomPath(instance.Series.Study, instance.Series, instance)
+	stored, err := fs.ReadFile(key)
+	if err != nil {
+		return false, err
+	}
+	data, err := fs.DecodeDicomFile(stored, instance.FileCompression)
+	if err != nil {
+		return false, err
+	}
+	checksum := fs.Checksum(data)
+	if instance.FileSHA256 == "" {
+		instance.FileSize, instance.FileSHA256 = int64(len(data)), checksum
+	} else if int64(len(data)) != instance.FileSize || checksum != instance.FileSHA256 {
+		return false, fmt.Errorf("%w: %s", fs.ErrChecksumMismatch, key)
+	}
+
+	encoded, compression, err := fs.EncodeDicomFile(data)
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(encoded, stored) {
+		if err = fs.Save(key, encoded); err != nil {
+			return false, err
+		}
+	}
+	instance.FileCompression, instance.StoredSize = compression, int64(len(encoded))
+	if err = rc.InstanceStore.UpdateCompression(instance, tx); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
@@ -55,6 +55,16 @@ func ErrRender(err error) render.Renderer {
 	}
 }
 
+// ErrRemoteRequest returns status 502 Bad Gateway including the error of a failed request to a remote server.
+func ErrRemoteRequest(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: http.StatusBadGateway,
+		StatusText:     http.StatusText(http.StatusBadGateway),
+		ErrorText:      err.Error(),
+	}
+}
+
 var (
 	// ErrBadRequest returns status 400 Bad Request for malformed request body.
 	ErrBadRequest = &ErrResponse{HTTPStatusCode: http.StatusBadRequest, StatusText: http.StatusText(http.StatusBadRequest)}
@@ -0,0 +1,51 @@
+package app
+
+import (
+	"context"
+	"dicom-store-api/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/go-pg/pg"
+	"net/http"
+	"strconv"
+)
+
+type JobResource struct {
+	DB       *pg.DB
+	JobStore JobStore
+}
+
+func NewJobResource(db *pg.DB, jobStore JobStore) *JobResource {
+	return &JobResource{
+		DB:       db,
+		JobStore: jobStore,
+	}
+}
+
+func (rs *JobResource) ctx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jobID, err := strconv.Atoi(chi.URLParam(r, "jobID"))
+		if err != nil {
+			render.Render(w, r, ErrBadRequest)
+			return
+		}
+
+		job, err := rs.JobStore.Get(jobID)
+		if err != nil {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), ctxJob, job)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (rs *JobResource) getJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := r.Context().Value(ctxJob).(*models.Job)
+	if !ok {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	render.JSON(w, r, job)
+}
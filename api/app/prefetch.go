@@ -0,0 +1,82 @@
+package app
+
+import (
+	"context"
+	"dicom-store-api/database"
+	"dicom-store-api/models"
+	"dicom-store-api/prefetch"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/go-pg/pg"
+	"net/http"
+	"strconv"
+)
+
+// PrefetchResource lists the prefetches of priors made for new studies, with the jobs retrieving them.
+type PrefetchResource struct {
+	DB            *pg.DB
+	PrefetchStore PrefetchStore
+	Prefetcher    *prefetch.Prefetcher
+}
+
+func NewPrefetchResource(db *pg.DB, prefetchStore PrefetchStore, prefetcher *prefetch.Prefetcher) *PrefetchResource {
+	return &PrefetchResource{
+		DB:            db,
+		PrefetchStore: prefetchStore,
+		Prefetcher:    prefetcher,
+	}
+}
+
+func (rs *PrefetchResource) ctx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefetchID, err := strconv.Atoi(chi.URLParam(r, "prefetchID"))
+		if err != nil {
+			render.Render(w, r, ErrBadRequest)
+			return
+		}
+
+		prefetch, err := rs.PrefetchStore.Get(prefetchID)
+		if err != nil {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), ctxPrefetch, prefetch)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// list returns the prefetches, most recent first, of the patient_id query parameter if it is set.
+func (rs *PrefetchResource) list(w http.ResponseWriter, r *http.Request) {
+	options := &database.SelectQueryOptions{OrderBy: "prefetch.id", OrderDirection: "DESC"}
+	options.Limit, _ = strconv.Atoi(r.URL.Query().Get("limit"))
+	options.Offset, _ = strconv.Atoi(r.URL.Query().Get("offset"))
+
+	prefetches, err := rs.PrefetchStore.List(r.URL.Query().Get("patient_id"), options)
+	if err != nil {
+		log(r).Error(err)
+		render.Render(w, r, ErrInternalServerError)
+		return
+	}
+	if prefetches == nil {
+		prefetches = []*models.Prefetch{}
+	}
+	render.JSON(w, r, prefetches)
+}
+
+func (rs *PrefetchResource) getPrefetch(w http.ResponseWriter, r *http.Request) {
+	prefetch, ok := r.Context().Value(ctxPrefetch).(*models.Prefetch)
+	if !ok {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	render.JSON(w, r, prefetch)
+}
+
+func (rs *PrefetchResource) listRules(w http.ResponseWriter, r *http.Request) {
+	rules := rs.Prefetcher.Rules()
+	if rules == nil {
+		rules = []*prefetch.Rule{}
+	}
+	render.JSON(w, r, rules)
+}
@@ -0,0 +1,70 @@
+package app
+
+import (
+	"context"
+	"dicom-store-api/database"
+	"dicom-store-api/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/go-pg/pg"
+	"net/http"
+	"strconv"
+)
+
+// RejectionResource lists the received IOCM rejection notes and the instances they reject.
+type RejectionResource struct {
+	DB             *pg.DB
+	RejectionStore RejectionStore
+}
+
+func NewRejectionResource(db *pg.DB, rejectionStore RejectionStore) *RejectionResource {
+	return &RejectionResource{
+		DB:             db,
+		RejectionStore: rejectionStore,
+	}
+}
+
+func (rs *RejectionResource) ctx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rejectionID, err := strconv.Atoi(chi.URLParam(r, "rejectionID"))
+		if err != nil {
+			render.Render(w, r, ErrBadRequest)
+			return
+		}
+
+		note, err := rs.RejectionStore.Get(rejectionID)
+		if err != nil {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), ctxRejection, note)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (rs *RejectionResource) list(w http.ResponseWriter, r *http.Request) {
+	options := &database.SelectQueryOptions{OrderBy: "rejection_note.id", OrderDirection: "DESC"}
+	options.Limit, _ = strconv.Atoi(r.URL.Query().Get("limit"))
+	options.Offset, _ = strconv.Atoi(r.URL.Query().Get("offset"))
+
+	notes, err := rs.RejectionStore.FindBy(r.URL.Query().Get("code"), options)
+	if err != nil {
+		log(r).Error(err)
+		render.Render(w, r, ErrInternalServerError)
+		return
+	}
+	if notes == nil {
+		notes = []*models.RejectionNote{}
+	}
+	render.JSON(w, r, notes)
+}
+
+func (rs *RejectionResource) getRejection(w http.ResponseWriter, r *http.Request) {
+	note, ok := r.Context().Value(ctxRejection).(*models.RejectionNote)
+	if !ok {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	render.JSON(w, r, note)
+}
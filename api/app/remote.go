@@ -0,0 +1,149 @@
+package app
+
+import (
+	"context"
+	"dicom-store-api/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/go-pg/pg"
+)
+
+// RemoteNodeResource manages the known remote application entities, such as C-MOVE destinations, queries
+// them and retrieves from them with DICOMweb.
+type RemoteNodeResource struct {
+	DB              *pg.DB
+	RemoteNodeStore RemoteNodeStore
+	// Queue ingests the files retrieved from the remote nodes
+	Queue IngestQueue
+	// Timeout bounds the queries of remote nodes, RetrieveTimeout their retrieves.
+	Timeout         time.Duration
+	RetrieveTimeout time.Duration
+}
+
+func NewRemoteNodeResource(db *pg.DB, remoteNodeStore RemoteNodeStore, queue IngestQueue, timeout time.Duration,
+	retrieveTimeout time.Duration) *RemoteNodeResource {
+	return &RemoteNodeResource{
+		DB:              db,
+		RemoteNodeStore: remoteNodeStore,
+		Queue:           queue,
+		Timeout:         timeout,
+		RetrieveTimeout: retrieveTimeout,
+	}
+}
+
+func (rs *RemoteNodeResource) ctx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		node, err := rs.RemoteNodeStore.GetByAETitle(chi.URLParam(r, "aeTitle"))
+		if err != nil {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), ctxRemoteNode, node)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RemoteNodeRequest is the body of a request creating or updating a remote node.
+type RemoteNodeRequest struct {
+	AETitle     string `json:"ae_title"`
+	Host        string `json:"host"`
+	Port        int    `json:"port"`
+	URL         string `json:"url"`
+	Description string `json:"description"`
+}
+
+func (rs *RemoteNodeResource) list(w http.ResponseWriter, r *http.Request) {
+	nodes, err := rs.RemoteNodeStore.List()
+	if err != nil {
+		log(r).Error(err)
+		render.Render(w, r, ErrInternalServerError)
+		return
+	}
+	if nodes == nil {
+		nodes = []*models.RemoteNode{}
+	}
+	render.JSON(w, r, nodes)
+}
+
+func (rs *RemoteNodeResource) create(w http.ResponseWriter, r *http.Request) {
+	var req RemoteNodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		render.Render(w, r, ErrBadRequest)
+		return
+	}
+
+	node := &models.RemoteNode{AETitle: req.AETitle, Host: req.Host, Port: req.Port, URL: req.URL, Description: req.Description}
+	if err := rs.RemoteNodeStore.Create(node, nil); err != nil {
+		rs.renderError(w, r, err)
+		return
+	}
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, node)
+}
+
+func (rs *RemoteNodeResource) get(w http.ResponseWriter, r *http.Request) {
+	node, ok := r.Context().Value(ctxRemoteNode).(*models.RemoteNode)
+	if !ok {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	render.JSON(w, r, node)
+}
+
+func (rs *RemoteNodeResource) update(w http.ResponseWriter, r *http.Request) {
+	node, ok := r.Context().Value(ctxRemoteNode).(*models.RemoteNode)
+	if !ok {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+
+	req := RemoteNodeRequest{AETitle: node.AETitle, Host: node.Host, Port: node.Port, URL: node.URL, Description: node.Description}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		render.Render(w, r, ErrBadRequest)
+		return
+	}
+
+	node.AETitle, node.Host, node.Port, node.URL, node.Description = req.AETitle, req.Host, req.Port, req.URL, req.Description
+	if err := rs.RemoteNodeStore.Update(node, nil); err != nil {
+		rs.renderError(w, r, err)
+		return
+	}
+	render.JSON(w, r, node)
+}
+
+func (rs *RemoteNodeResource) delete(w http.ResponseWriter, r *http.Request) {
+	node, ok := r.Context().Value(ctxRemoteNode).(*models.RemoteNode)
+	if !ok {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+
+	if err := rs.RemoteNodeStore.Delete(node, nil); err != nil {
+		log(r).Error(err)
+		render.Render(w, r, ErrInternalServerError)
+		return
+	}
+	render.NoContent(w, r)
+}
+
+// renderError renders validation errors and duplicate AE titles as 422 and other errors as 500.
+func (rs *RemoteNodeResource) renderError(w http.ResponseWriter, r *http.Request, err error) {
+	var validationErrors validation.Errors
+	var pgErr pg.Error
+	switch {
+	case errors.As(err, &validationErrors):
+		render.Render(w, r, ErrValidation(err, validationErrors))
+	case errors.As(err, &pgErr) && pgErr.IntegrityViolation():
+		render.Render(w, r, ErrInvalidRequest(errors.New("a remote node with this AE title already exists")))
+	default:
+		log(r).Error(err)
+		render.Render(w, r, ErrInternalServerError)
+	}
+}
@@ -0,0 +1,136 @@
+package app
+
+import (
+	"context"
+	"dicom-store-api/api/dicomweb"
+	"dicom-store-api/dicomwebclient"
+	"dicom-store-api/models"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+// errNoURL is returned for DICOMweb requests to remote nodes without a URL.
+var errNoURL = errors.New("the remote node has no DICOMweb url")
+
+// client returns a DICOMweb client for the remote node of the request, or renders an error.
+func (rs *RemoteNodeResource) client(w http.ResponseWriter, r *http.Request) *dicomwebclient.Client {
+	node, ok := r.Context().Value(ctxRemoteNode).(*models.RemoteNode)
+	if !ok {
+		render.Render(w, r, ErrNotFound)
+		return nil
+	}
+	if node.URL == "" {
+		render.Render(w, r, ErrInvalidRequest(errNoURL))
+		return nil
+	}
+	client := dicomwebclient.NewClient(node.URL)
+	client.MaxSize = dicomweb.MaxUploadSize
+	return client
+}
+
+// searchStudies forwards a QIDO-RS study query to the remote node.
+func (rs *RemoteNodeResource) searchStudies(w http.ResponseWriter, r *http.Request) {
+	client := rs.client(w, r)
+	if client == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), rs.Timeout)
+	defer cancel()
+	result, err := client.SearchStudies(ctx, r.URL.Query())
+	rs.respondRemote(w, r, result, err)
+}
+
+func (rs *RemoteNodeResource) searchSeries(w http.ResponseWriter, r *http.Request) {
+	client := rs.client(w, r)
+	if client == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), rs.Timeout)
+	defer cancel()
+	result, err := client.SearchSeries(ctx, chi.URLParam(r, "studyUID"), r.URL.Query())
+	rs.respondRemote(w, r, result, err)
+}
+
+func (rs *RemoteNodeResource) searchInstances(w http.ResponseWriter, r *http.Request) {
+	client := rs.client(w, r)
+	if client == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), rs.Timeout)
+	defer cancel()
+	result, err := client.SearchInstances(ctx, chi.URLParam(r, "studyUID"), chi.URLParam(r, "seriesUID"), r.URL.Query())
+	rs.respondRemote(w, r, result, err)
+}
+
+func (rs *RemoteNodeResource) studyMetadata(w http.ResponseWriter, r *http.Request) {
+	client := rs.client(w, r)
+	if client == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), rs.Timeout)
+	defer cancel()
+	result, err := client.RetrieveStudyMetadata(ctx, chi.URLParam(r, "studyUID"))
+	rs.respondRemote(w, r, result, err)
+}
+
+// retrieveStudy pulls a study from the remote node with WADO-RS and ingests it, answering with the job.
+func (rs *RemoteNodeResource) retrieveStudy(w http.ResponseWriter, r *http.Request) {
+	client := rs.client(w, r)
+	if client == nil {
+		return
+	}
+	studyUID := chi.URLParam(r, "studyUID")
+	rs.startRetrieve(w, r, func(add func(file []byte) error) error {
+		ctx, cancel := context.WithTimeout(context.Background(), rs.RetrieveTimeout)
+		defer cancel()
+		return client.RetrieveStudy(ctx, studyUID, add)
+	})
+}
+
+func (rs *RemoteNodeResource) retrieveSeries(w http.ResponseWriter, r *http.Request) {
+	client := rs.client(w, r)
+	if client == nil {
+		return
+	}
+	studyUID, seriesUID := chi.URLParam(r, "studyUID"), chi.URLParam(r, "seriesUID")
+	rs.startRetrieve(w, r, func(add func(file []byte) error) error {
+		ctx, cancel := context.WithTimeout(context.Background(), rs.RetrieveTimeout)
+		defer cancel()
+		return client.RetrieveSeries(ctx, studyUID, seriesUID, add)
+	})
+}
+
+func (rs *RemoteNodeResource) startRetrieve(w http.ResponseWriter, r *http.Request, fetch func(add func(file []byte) error) error) {
+	job, err := rs.Queue.Retrieve(fetch)
+	if err != nil {
+		log(r).Error(err)
+		render.Render(w, r, ErrInternalServerError)
+		return
+	}
+	jobURL := fmt.Sprintf("/api/jobs/%d", job.ID)
+	w.Header().Set("Content-Location", jobURL)
+	render.Status(r, http.StatusAccepted)
+	render.JSON(w, r, map[string]any{
+		"id":     job.ID,
+		"status": job.Status,
+		"url":    jobURL,
+	})
+}
+
+// respondRemote renders the result of a remote query, a remote 404 as 404 and other failures as 502.
+func (rs *RemoteNodeResource) respondRemote(w http.ResponseWriter, r *http.Request, result []dicomwebclient.Attributes, err error) {
+	var statusErr *dicomwebclient.StatusError
+	switch {
+	case err == nil:
+		render.JSON(w, r, result)
+	case errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound:
+		render.Render(w, r, ErrNotFound)
+	default:
+		log(r).Warn(err)
+		render.Render(w, r, ErrRemoteRequest(err))
+	}
+}
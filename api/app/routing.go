@@ -0,0 +1,187 @@
+package app
+
+import (
+	"context"
+	"dicom-store-api/database"
+	"dicom-store-api/models"
+	"dicom-store-api/routing"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/go-pg/pg"
+)
+
+// RoutingResource shows the routing rules and the queue of every destination, and retries or discards
+// the items left dead once their attempts are exhausted.
+type RoutingResource struct {
+	DB         *pg.DB
+	RouteStore RouteStore
+	Engine     *routing.Engine
+}
+
+func NewRoutingResource(db *pg.DB, routeStore RouteStore, engine *routing.Engine) *RoutingResource {
+	return &RoutingResource{
+		DB:         db,
+		RouteStore: routeStore,
+		Engine:     engine,
+	}
+}
+
+// RoutingStatus lists the rules and the destinations with the state of their queue.
+type RoutingStatus struct {
+	Rules        []*routing.Rule      `json:"rules"`
+	Destinations []*DestinationStatus `json:"destinations"`
+}
+
+// DestinationStatus counts the queued items of a destination by status, with its most recent failure.
+type DestinationStatus struct {
+	*routing.Destination
+	Pending     int        `json:"pending"`
+	Retrying    int        `json:"retrying"`
+	Processing  int        `json:"processing"`
+	Completed   int        `json:"completed"`
+	Dead        int        `json:"dead"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+func (rs *RoutingResource) ctx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		itemID, err := strconv.Atoi(chi.URLParam(r, "itemID"))
+		if err != nil {
+			render.Render(w, r, ErrBadRequest)
+			return
+		}
+
+		item, err := rs.RouteStore.Get(itemID)
+		if err != nil || item.Status != models.RouteStatusDead {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), ctxRouteItem, item)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (rs *RoutingResource) getStatus(w http.ResponseWriter, r *http.Request) {
+	counts, err := rs.RouteStore.CountByDestination()
+	if err != nil {
+		log(r).Error(err)
+		render.Render(w, r, ErrInternalServerError)
+		return
+	}
+	lastErrors, err := rs.RouteStore.FindLastErrors()
+	if err != nil {
+		log(r).Error(err)
+		render.Render(w, r, ErrInternalServerError)
+		return
+	}
+
+	status := &RoutingStatus{Rules: rs.Engine.Rules(), Destinations: []*DestinationStatus{}}
+	if status.Rules == nil {
+		status.Rules = []*routing.Rule{}
+	}
+	byName := map[string]*DestinationStatus{}
+	for _, destination := range rs.Engine.Destinations() {
+		byName[destination.Name] = &DestinationStatus{Destination: destination}
+		status.Destinations = append(status.Destinations, byName[destination.Name])
+	}
+	// items of destinations removed from the configuration are listed too, until they are discarded
+	destinationStatus := func(name string) *DestinationStatus {
+		if byName[name] == nil {
+			byName[name] = &DestinationStatus{Destination: &routing.Destination{Name: name}}
+			status.Destinations = append(status.Destinations, byName[name])
+		}
+		return byName[name]
+	}
+
+	for _, count := range counts {
+		destination := destinationStatus(count.Destination)
+		switch count.Status {
+		case models.RouteStatusPending:
+			if count.Retrying {
+				destination.Retrying += count.Count
+			} else {
+				destination.Pending += count.Count
+			}
+		case models.RouteStatusProcessing:
+			destination.Processing += count.Count
+		case models.RouteStatusCompleted:
+			destination.Completed += count.Count
+		case models.RouteStatusDead:
+			destination.Dead += count.Count
+		}
+	}
+	for _, item := range lastErrors {
+		destination := destinationStatus(item.Destination)
+		destination.LastError = item.LastError
+		destination.LastErrorAt = &item.UpdatedAt
+	}
+	render.JSON(w, r, status)
+}
+
+func (rs *RoutingResource) listDead(w http.ResponseWriter, r *http.Request) {
+	options := &database.SelectQueryOptions{OrderBy: "route_item.id", OrderDirection: "DESC"}
+	options.Limit, _ = strconv.Atoi(r.URL.Query().Get("limit"))
+	options.Offset, _ = strconv.Atoi(r.URL.Query().Get("offset"))
+
+	items, err := rs.RouteStore.FindDead(r.URL.Query().Get("destination"), options)
+	if err != nil {
+		log(r).Error(err)
+		render.Render(w, r, ErrInternalServerError)
+		return
+	}
+	if items == nil {
+		items = []*models.RouteItem{}
+	}
+	render.JSON(w, r, items)
+}
+
+// retryDead puts all dead items, or those of the destination query parameter, back in the queue
+// for the workers to pick up at their next poll.
+func (rs *RoutingResource) retryDead(w http.ResponseWriter, r *http.Request) {
+	count, err := rs.RouteStore.RequeueDead(r.URL.Query().Get("destination"))
+	if err != nil {
+		log(r).Error(err)
+		render.Render(w, r, ErrInternalServerError)
+		return
+	}
+	render.JSON(w, r, map[string]int{"requeued": count})
+}
+
+func (rs *RoutingResource) retryItem(w http.ResponseWriter, r *http.Request) {
+	item, ok := r.Context().Value(ctxRouteItem).(*models.RouteItem)
+	if !ok {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+
+	item.Status = models.RouteStatusPending
+	item.Attempts = 0
+	item.NextAttemptAt = time.Now()
+	if err := rs.RouteStore.Update(item); err != nil {
+		log(r).Error(err)
+		render.Render(w, r, ErrInternalServerError)
+		return
+	}
+	render.JSON(w, r, item)
+}
+
+func (rs *RoutingResource) discardItem(w http.ResponseWriter, r *http.Request) {
+	item, ok := r.Context().Value(ctxRouteItem).(*models.RouteItem)
+	if !ok {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+
+	if err := rs.RouteStore.Delete(item); err != nil {
+		log(r).Error(err)
+		render.Render(w, r, ErrInternalServerError)
+		return
+	}
+	render.NoContent(w, r)
+}
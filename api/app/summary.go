@@ -28,12 +28,21 @@ type ModalitiesCount struct {
 	Count    int    `json:"count"`
 }
 
+// CompressionStats sums up the instance files by their compression at rest.
+type CompressionStats struct {
+	Compression  string `json:"compression"`
+	Count        int    `json:"count"`
+	OriginalSize int64  `json:"originalSize"`
+	StoredSize   int64  `json:"storedSize"`
+}
+
 type SummaryResponse struct {
-	StudyCount       int               `json:"studyCount"`
-	SeriesCount      int               `json:"seriesCount"`
-	InstanceCount    int               `json:"instanceCount"`
-	PatientsCount    int               `json:"patientsCount"`
-	ModalitiesCounts []ModalitiesCount `json:"modalitiesCounts"`
+	StudyCount       int                `json:"studyCount"`
+	SeriesCount      int                `json:"seriesCount"`
+	InstanceCount    int                `json:"instanceCount"`
+	PatientsCount    int                `json:"patientsCount"`
+	ModalitiesCounts []ModalitiesCount  `json:"modalitiesCounts"`
+	CompressionStats []CompressionStats `json:"compressionStats"`
 }
 
 func (rs *SummaryResource) getSummary(w http.ResponseWriter, r *http.Request) {
@@ -64,19 +73,30 @@ func (rs *SummaryResource) getSummary(w http.ResponseWriter, r *http.Request) {
 	patientsCount := len(uniquePatientsStudiesIds)
 
 	var modalitiesCounts []ModalitiesCount
-	stringQuery := "SELECT modality, COUNT(*) FROM " + (&models.Series{}).GetTableName() + " GROUP BY modality"
+	stringQuery := "SELECT modality, COUNT(*) FROM " + (&models.Series{}).GetTableName() + " WHERE deleted_at IS NULL GROUP BY modality"
 	_, err = rs.DB.Query(&modalitiesCounts, stringQuery)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	var compressionStats []CompressionStats
+	stringQuery = "SELECT coalesce(file_compression, 'none') AS compression, COUNT(*), " +
+		"SUM(coalesce(file_size, 0)) AS original_size, SUM(coalesce(stored_size, file_size, 0)) AS stored_size FROM " +
+		(&models.Instance{}).GetTableName() + " WHERE deleted_at IS NULL GROUP BY 1"
+	_, err = rs.DB.Query(&compressionStats, stringQuery)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	summary := SummaryResponse{
 		studiesCount,
 		seriesCount,
 		instancesCount,
 		patientsCount,
 		modalitiesCounts,
+		compressionStats,
 	}
 
 	render.JSON(w, r, summary)
@@ -0,0 +1,309 @@
+package app
+
+import (
+	"context"
+	"dicom-store-api/database"
+	"dicom-store-api/fs"
+	"dicom-store-api/logging"
+	"dicom-store-api/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/go-pg/pg"
+)
+
+const (
+	TrashLevelStudy    = "study"
+	TrashLevelSeries   = "series"
+	TrashLevelInstance = "instance"
+)
+
+// TrashItem is a deleted study, series or instance waiting in the trash. Objects deleted along with
+// their parent are not listed on their own, they come back or go away with the parent.
+type TrashItem struct {
+	Level     string           `json:"level"`
+	UID       string           `json:"uid"`
+	DeletedAt time.Time        `json:"deleted_at"`
+	ExpiresAt time.Time        `json:"expires_at"`
+	Study     *models.Study    `json:"study,omitempty"`
+	Series    *models.Series   `json:"series,omitempty"`
+	Instance  *models.Instance `json:"instance,omitempty"`
+}
+
+type TrashResource struct {
+	DB            *pg.DB
+	StudyStore    StudyStore
+	SeriesStore   SeriesStore
+	InstanceStore InstanceStore
+	GracePeriod   time.Duration
+}
+
+func NewTrashResource(db *pg.DB, studyStore StudyStore, seriesStore SeriesStore, instanceStore InstanceStore, gracePeriod time.Duration) *TrashResource {
+	return &TrashResource{
+		DB:            db,
+		StudyStore:    studyStore,
+		SeriesStore:   seriesStore,
+		InstanceStore: instanceStore,
+		GracePeriod:   gracePeriod,
+	}
+}
+
+// StartPurger purges the items whose grace period has expired every interval.
+func (rs *TrashResource) StartPurger(interval time.Duration) {
+	logger := logging.Logger.WithField("module", "trash")
+	go func() {
+		for {
+			purged, err := rs.PurgeExpired(time.Now().Add(-rs.GracePeriod))
+			if err != nil {
+				logger.Error(err)
+			} else if purged > 0 {
+				logger.Infof("purged %d expired trash items", purged)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+func (rs *TrashResource) ctx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uid := chi.URLParam(r, "uid")
+		item := &TrashItem{Level: chi.URLParam(r, "level"), UID: uid}
+
+		var err error
+		switch item.Level {
+		case TrashLevelStudy:
+			item.Study, err = rs.StudyStore.GetDeleted(uid, nil)
+		case TrashLevelSeries:
+			item.Series, err = rs.SeriesStore.GetDeleted(uid, nil)
+		case TrashLevelInstance:
+			item.Instance, err = rs.InstanceStore.GetDeleted(uid, nil)
+		default:
+			render.Render(w, r, ErrBadRequest)
+			return
+		}
+		if err != nil {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), ctxTrashItem, item)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (rs *TrashResource) list(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	options := func(table string) *database.SelectQueryOptions {
+		return &database.SelectQueryOptions{Limit: limit, Offset: offset, OrderBy: table + ".deleted_at", OrderDirection: "DESC"}
+	}
+
+	level := r.URL.Query().Get("level")
+	items := []*TrashItem{}
+
+	if level == "" || level == TrashLevelStudy {
+		studies, err := rs.StudyStore.FindDeleted(time.Time{}, options("study"), nil)
+		if err != nil {
+			log(r).Error(err)
+			render.Render(w, r, ErrInternalServerError)
+			return
+		}
+		for _, study := range studies {
+			items = append(items, rs.newItem(TrashLevelStudy, study.StudyInstanceUID, study.DeletedAt.Time, study, nil, nil))
+		}
+	}
+	if level == "" || level == TrashLevelSeries {
+		seriesList, err := rs.SeriesStore.FindDeleted(time.Time{}, options("series"), nil)
+		if err != nil {
+			log(r).Error(err)
+			render.Render(w, r, ErrInternalServerError)
+			return
+		}
+		for _, series := range seriesList {
+			items = append(items, rs.newItem(TrashLevelSeries, series.SeriesInstanceUID, series.DeletedAt.Time, nil, series, nil))
+		}
+	}
+	if level == "" || level == TrashLevelInstance {
+		instances, err := rs.InstanceStore.FindDeleted(time.Time{}, options("instance"), nil)
+		if err != nil {
+			log(r).Error(err)
+			render.Render(w, r, ErrInternalServerError)
+			return
+		}
+		for _, instance := range instances {
+			items = append(items, rs.newItem(TrashLevelInstance, instance.SOPInstanceUID, instance.DeletedAt.Time, nil, nil, instance))
+		}
+	}
+
+	render.JSON(w, r, items)
+}
+
+func (rs *TrashResource) restore(w http.ResponseWriter, r *http.Request) {
+	item := r.Context().Value(ctxTrashItem).(*TrashItem)
+
+	if err := rs.Restore(item); err != nil {
+		log(r).Error(err)
+		render.Render(w, r, ErrInternalServerError)
+		return
+	}
+	render.NoContent(w, r)
+}
+
+func (rs *TrashResource) purge(w http.ResponseWriter, r *http.Request) {
+	item := r.Context().Value(ctxTrashItem).(*TrashItem)
+
+	if err := rs.Purge(item); err != nil {
+		log(r).Error(err)
+		render.Render(w, r, ErrInternalServerError)
+		return
+	}
+	render.NoContent(w, r)
+}
+
+func (rs *TrashResource) empty(w http.ResponseWriter, r *http.Request) {
+	purged, err := rs.PurgeExpired(time.Now())
+	if err != nil {
+		log(r).Error(err)
+		render.Render(w, r, ErrInternalServerError)
+		return
+	}
+	render.JSON(w, r, map[string]int{"purged": purged})
+}
+
+// Restore takes the item out of the trash and recounts the study it belongs to.
+func (rs *TrashResource) Restore(item *TrashItem) error {
+	study := item.study()
+	return rs.DB.RunInTransaction(func(tx *pg.Tx) error {
+		if err := rs.StudyStore.Lock(study, tx); err != nil {
+			return err
+		}
+
+		var err error
+		switch item.Level {
+		case TrashLevelStudy:
+			err = rs.StudyStore.Restore(item.Study, tx)
+		case TrashLevelSeries:
+			err = rs.SeriesStore.Restore(item.Series, tx)
+		case TrashLevelInstance:
+			err = rs.InstanceStore.Restore(item.Instance, tx)
+		}
+		if err != nil {
+			return err
+		}
+		return rs.StudyStore.UpdateComputedFields(study, tx)
+	})
+}
+
+// Purge permanently deletes the item with everything deleted along with it and removes the files.
+func (rs *TrashResource) Purge(item *TrashItem) error {
+	var instances []*models.Instance
+	err := rs.DB.RunInTransaction(func(tx *pg.Tx) error {
+		if err := rs.StudyStore.Lock(item.study(), tx); err != nil {
+			return err
+		}
+
+		// The files are collected under the study lock, an instance stored again in the meantime is no longer deleted.
+		var err error
+		switch item.Level {
+		case TrashLevelStudy:
+			if instances, err = rs.InstanceStore.FindDeletedByStudy(item.Study.ID, tx); err == nil {
+				err = rs.StudyStore.Purge(item.Study, tx)
+			}
+		case TrashLevelSeries:
+			if instances, err = rs.InstanceStore.FindDeletedBySeries(item.Series.ID, tx); err == nil {
+				err = rs.SeriesStore.Purge(item.Series, tx)
+			}
+		case TrashLevelInstance:
+			var instance *models.Instance
+			if instance, err = rs.InstanceStore.GetDeleted(item.Instance.SOPInstanceUID, tx); err == pg.ErrNoRows {
+				return nil
+			} else if err == nil {
+				instances = []*models.Instance{instance}
+				err = rs.InstanceStore.Purge(instance, tx)
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, instance := range instances {
+		if err := rs.removeFile(instance); err != nil {
+			logging.Logger.WithField("module", "trash").WithField("instance", instance.SOPInstanceUID).Error(err)
+		}
+	}
+	return nil
+}
+
+// removeFile removes the file of a purged instance unless other instances still share it.
+func (rs *TrashResource) removeFile(instance *models.Instance) error {
+	if instance.StoragePath != "" {
+		count, err := rs.InstanceStore.CountByStoragePath(instance.StoragePath, nil)
+		if err != nil || count > 0 {
+			return err
+		}
+	}
+	return fs.RemoveDicomFile(instance.Series.Study, instance.Series, instance)
+}
+
+// PurgeExpired purges the items deleted before the given time, instances first so that
+// an item deleted on its own expires independently of a parent deleted later.
+func (rs *TrashResource) PurgeExpired(deletedBefore time.Time) (int, error) {
+	var items []*TrashItem
+
+	instances, err := rs.InstanceStore.FindDeleted(deletedBefore, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	for _, instance := range instances {
+		items = append(items, &TrashItem{Level: TrashLevelInstance, Instance: instance})
+	}
+	seriesList, err := rs.SeriesStore.FindDeleted(deletedBefore, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	for _, series := range seriesList {
+		items = append(items, &TrashItem{Level: TrashLevelSeries, Series: series})
+	}
+	studies, err := rs.StudyStore.FindDeleted(deletedBefore, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	for _, study := range studies {
+		items = append(items, &TrashItem{Level: TrashLevelStudy, Study: study})
+	}
+
+	for i, item := range items {
+		if err := rs.Purge(item); err != nil {
+			return i, err
+		}
+	}
+	return len(items), nil
+}
+
+func (rs *TrashResource) newItem(level, uid string, deletedAt time.Time, study *models.Study, series *models.Series, instance *models.Instance) *TrashItem {
+	return &TrashItem{
+		Level:     level,
+		UID:       uid,
+		DeletedAt: deletedAt,
+		ExpiresAt: deletedAt.Add(rs.GracePeriod),
+		Study:     study,
+		Series:    series,
+		Instance:  instance,
+	}
+}
+
+func (item *TrashItem) study() *models.Study {
+	switch {
+	case item.Study != nil:
+		return item.Study
+	case item.Series != nil:
+		return item.Series.Study
+	default:
+		return item.Instance.Series.Study
+	}
+}
@@ -0,0 +1,183 @@
+package app
+
+import (
+	"context"
+	"dicom-store-api/database"
+	"dicom-store-api/models"
+	"dicom-store-api/worklist"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/go-pg/pg"
+)
+
+// worklistFilters are the query parameters filtering the worklist, by WorklistItem field.
+var worklistFilters = map[string]string{
+	"patient_id":       "PatientID",
+	"accession_number": "AccessionNumber",
+	"modality":         "Modality",
+	"ae_title":         "ScheduledStationAETitle",
+	"date":             "ScheduledProcedureStepStartDate",
+	"state":            "ProcedureStepState",
+}
+
+// WorklistResource schedules the procedure steps offered to the modalities with Modality Worklist C-FIND
+// and as UPS-RS workitems.
+type WorklistResource struct {
+	DB            *pg.DB
+	WorklistStore WorklistStore
+	Worklist      *worklist.Manager
+}
+
+func NewWorklistResource(db *pg.DB, worklistStore WorklistStore, manager *worklist.Manager) *WorklistResource {
+	return &WorklistResource{
+		DB:            db,
+		WorklistStore: worklistStore,
+		Worklist:      manager,
+	}
+}
+
+func (rs *WorklistResource) ctx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		itemID, err := strconv.Atoi(chi.URLParam(r, "itemID"))
+		if err != nil {
+			render.Render(w, r, ErrBadRequest)
+			return
+		}
+
+		item, err := rs.WorklistStore.Get(itemID)
+		if err != nil {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), ctxWorklistItem, item)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// WorklistCancelRequest is the optional body of a request canceling a worklist item.
+type WorklistCancelRequest struct {
+	Reason string `json:"reason"`
+}
+
+// list returns the worklist items by start date, filtered by the worklistFilters query parameters.
+func (rs *WorklistResource) list(w http.ResponseWriter, r *http.Request) {
+	fields := map[string]any{}
+	for param, fieldName := range worklistFilters {
+		if value := r.URL.Query().Get(param); value != "" {
+			fields[fieldName] = value
+		}
+	}
+	options := &database.SelectQueryOptions{OrderBy: "worklist_item.scheduled_procedure_step_start_date, worklist_item.scheduled_procedure_step_start_time, worklist_item.id"}
+	options.Limit, _ = strconv.Atoi(r.URL.Query().Get("limit"))
+	options.Offset, _ = strconv.Atoi(r.URL.Query().Get("offset"))
+
+	items, err := rs.WorklistStore.FindBy(fields, options, nil)
+	if err != nil {
+		log(r).Error(err)
+		render.Render(w, r, ErrInternalServerError)
+		return
+	}
+	if items == nil {
+		items = []*models.WorklistItem{}
+	}
+	render.JSON(w, r, items)
+}
+
+// create schedules the item of the body, with a new UID unless it has one.
+func (rs *WorklistResource) create(w http.ResponseWriter, r *http.Request) {
+	item := &models.WorklistItem{}
+	if err := json.NewDecoder(r.Body).Decode(item); err != nil {
+		render.Render(w, r, ErrBadRequest)
+		return
+	}
+	item.ID, item.LinkedAt = 0, pg.NullTime{}
+
+	if err := rs.Worklist.Create(item); err != nil {
+		rs.renderError(w, r, err)
+		return
+	}
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, item)
+}
+
+func (rs *WorklistResource) get(w http.ResponseWriter, r *http.Request) {
+	item, ok := r.Context().Value(ctxWorklistItem).(*models.WorklistItem)
+	if !ok {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	render.JSON(w, r, item)
+}
+
+// update changes the attributes of a scheduled item, but neither its UID nor its state.
+func (rs *WorklistResource) update(w http.ResponseWriter, r *http.Request) {
+	item, ok := r.Context().Value(ctxWorklistItem).(*models.WorklistItem)
+	if !ok {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+
+	updated := *item
+	if err := json.NewDecoder(r.Body).Decode(&updated); err != nil {
+		render.Render(w, r, ErrBadRequest)
+		return
+	}
+	updated.ID, updated.CreatedAt, updated.LinkedAt = item.ID, item.CreatedAt, item.LinkedAt
+	updated.SOPInstanceUID, updated.ProcedureStepState = item.SOPInstanceUID, item.ProcedureStepState
+
+	if err := rs.Worklist.Update(&updated); err != nil {
+		rs.renderError(w, r, err)
+		return
+	}
+	render.JSON(w, r, &updated)
+}
+
+// cancel cancels a scheduled item, or asks the performer of an item in progress to cancel it with 202.
+func (rs *WorklistResource) cancel(w http.ResponseWriter, r *http.Request) {
+	item, ok := r.Context().Value(ctxWorklistItem).(*models.WorklistItem)
+	if !ok {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+
+	var req WorklistCancelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		render.Render(w, r, ErrBadRequest)
+		return
+	}
+
+	if err := rs.Worklist.RequestCancel(item, req.Reason); err != nil {
+		rs.renderError(w, r, err)
+		return
+	}
+	if item.ProcedureStepState == models.WorklistStateInProgress {
+		render.Status(r, http.StatusAccepted)
+	}
+	render.JSON(w, r, item)
+}
+
+// renderError renders validation errors, duplicate UIDs and changes the state of the item forbids as 422
+// and other errors as 500.
+func (rs *WorklistResource) renderError(w http.ResponseWriter, r *http.Request, err error) {
+	var validationErrors validation.Errors
+	var pgErr pg.Error
+	switch {
+	case errors.As(err, &validationErrors):
+		render.Render(w, r, ErrValidation(err, validationErrors))
+	case errors.As(err, &pgErr) && pgErr.IntegrityViolation():
+		render.Render(w, r, ErrInvalidRequest(errors.New("a worklist item with this UID already exists")))
+	case errors.Is(err, worklist.ErrNotScheduled), errors.Is(err, worklist.ErrInvalidTransition):
+		render.Render(w, r, ErrInvalidRequest(err))
+	default:
+		log(r).Error(err)
+		render.Render(w, r, ErrInternalServerError)
+	}
+}
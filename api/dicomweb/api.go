@@ -1,15 +1,21 @@
 package dicomweb
 
 import (
+	"dicom-store-api/coercion"
 	"dicom-store-api/models"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-pg/pg"
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 
 	"dicom-store-api/database"
 	"dicom-store-api/logging"
+	"dicom-store-api/prefetch"
+	"dicom-store-api/routing"
+	"dicom-store-api/worklist"
 )
 
 type ctxKey int
@@ -18,29 +24,63 @@ const (
 	ctxStudy ctxKey = iota
 	ctxSeries
 	ctxInstance
+	ctxWorkitem
 )
 
 // API provides application resources and handlers.
 type API struct {
-	QIDO *QIDOResource
-	STOW *STOWResource
-	WADO *WADOResource
+	QIDO      *QIDOResource
+	STOW      *STOWResource
+	WADO      *WADOResource
+	Delete    *DeleteResource
+	Federated *FederatedResource
+	Workitems *WorkitemsResource
 }
 
 type StudyStore interface {
 	FindBy(fields map[string]any, options *database.SelectQueryOptions, tx *pg.Tx) ([]*models.Study, error)
+	FindPatients(fields map[string]any, options *database.SelectQueryOptions, tx *pg.Tx) ([]*database.Patient, error)
 	Create(s *models.Study, tx *pg.Tx) error
 	Update(s *models.Study, tx *pg.Tx) error
+	Upsert(s *models.Study, tx *pg.Tx) error
+	UpdateComputedFields(s *models.Study, tx *pg.Tx) error
+	UpdateComputedFieldsByUID(studyInstanceUIDs []string, tx *pg.Tx) error
+	Lock(s *models.Study, tx *pg.Tx) error
+	Delete(s *models.Study, deletedAt time.Time, tx *pg.Tx) error
+	Touch(studyIDs []int, tx *pg.Tx) error
+	FindToArchive(studiedBefore time.Time, accessedBefore time.Time, limit int, tx *pg.Tx) ([]*models.Study, error)
 }
 type SeriesStore interface {
 	FindBy(fields map[string]any, options *database.SelectQueryOptions, tx *pg.Tx) ([]*models.Series, error)
 	Create(s *models.Series, tx *pg.Tx) error
 	Update(s *models.Series, tx *pg.Tx) error
+	Upsert(s *models.Series, tx *pg.Tx) error
+	Delete(s *models.Series, deletedAt time.Time, tx *pg.Tx) error
+	CountBy(fields map[string]any, tx *pg.Tx) (int, error)
 }
 type InstanceStore interface {
 	FindBy(fields map[string]any, options *database.SelectQueryOptions, tx *pg.Tx) ([]*models.Instance, error)
 	Create(s *models.Instance, tx *pg.Tx) error
 	Update(s *models.Instance, tx *pg.Tx) error
+	Upsert(s *models.Instance, tx *pg.Tx) error
+	UpdateFile(s *models.Instance, tx *pg.Tx) error
+	CountByStoragePath(storagePath string, tx *pg.Tx) (int, error)
+	Delete(s *models.Instance, deletedAt time.Time, tx *pg.Tx) error
+	CountBy(fields map[string]any, tx *pg.Tx) (int, error)
+	FindRejected(rejectionNoteID int, tx *pg.Tx) ([]*models.Instance, error)
+	FindByStudyAndTier(studyID int, tier string, tx *pg.Tx) ([]*models.Instance, error)
+	UpdateTier(instance *models.Instance, tx *pg.Tx) error
+}
+type RemoteNodeStore interface {
+	List() ([]*models.RemoteNode, error)
+}
+type WorklistStore interface {
+	GetByUID(sopInstanceUID string) (*models.WorklistItem, error)
+	FindBy(fields map[string]any, options *database.SelectQueryOptions, tx *pg.Tx) ([]*models.WorklistItem, error)
+}
+type RejectionStore interface {
+	Upsert(n *models.RejectionNote, tx *pg.Tx) error
+	AddInstances(n *models.RejectionNote, instances []*models.RejectedInstance, tx *pg.Tx) error
 }
 
 // NewAPI configures and returns application API.
@@ -49,14 +89,62 @@ func NewAPI(db *pg.DB) (*API, error) {
 	seriesStore := database.NewSeriesStore(db)
 	instanceStore := database.NewInstanceStore(db)
 
+	coercionEngine, err := coercion.NewEngineFromConfig()
+	if err != nil {
+		return nil, err
+	}
+
 	QIDO := NewQIDOResource(db, studyStore, seriesStore, instanceStore)
-	STOW := NewSTOWResource(db, studyStore, seriesStore, instanceStore)
+	STOW := NewSTOWResource(db, studyStore, seriesStore, instanceStore, coercionEngine)
 	WADO := NewWADOResource(db, studyStore, seriesStore, instanceStore)
+	Delete := NewDeleteResource(db, studyStore, seriesStore, instanceStore)
+
+	STOW.RejectionStore = database.NewRejectionStore(db)
+	STOW.Delete = Delete
+
+	viper.SetDefault("ingest_workers", 4)
+	STOW.Queue = NewIngestQueue(db, STOW, database.NewJobStore(db), viper.GetInt("ingest_workers"))
+
+	if STOW.Routing, err = routing.NewEngineFromConfig(db); err != nil {
+		return nil, err
+	}
+	if STOW.Prefetch, err = prefetch.NewPrefetcherFromConfig(db, STOW.Queue); err != nil {
+		return nil, err
+	}
+	worklistStore := database.NewWorklistStore(db)
+	STOW.Worklist = worklist.NewManager(worklistStore)
+
+	// the queue resumes interrupted items as soon as it starts, every dependency of store is set by then,
+	// and it ends interrupted retrieves before the prefetcher starts new ones
+	if err := STOW.Queue.Start(); err != nil {
+		return nil, err
+	}
+	if err := STOW.Routing.Start(); err != nil {
+		return nil, err
+	}
+	if err := STOW.Prefetch.Start(); err != nil {
+		return nil, err
+	}
+
+	if WADO.Tiering, err = NewTieringFromConfig(db, studyStore, instanceStore); err != nil {
+		return nil, err
+	}
+	if WADO.Tiering != nil {
+		viper.SetDefault("storage.tiering.interval", "1h")
+		WADO.Tiering.Start(viper.GetDuration("storage.tiering.interval"))
+	}
+
+	Federated := NewFederatedResourceFromConfig(QIDO, database.NewRemoteNodeStore(db))
+
+	Workitems := NewWorkitemsResource(db, worklistStore, STOW.Worklist)
 
 	api := &API{
 		QIDO,
 		STOW,
 		WADO,
+		Delete,
+		Federated,
+		Workitems,
 	}
 	return api, nil
 }
@@ -77,6 +165,11 @@ func (a *API) Router() *chi.Mux {
 		r.Get("/instances", a.QIDO.instances)
 	})
 
+	// federated QIDO group
+	r.Group(func(r chi.Router) {
+		r.Get("/federated/studies", a.Federated.studies)
+	})
+
 	// WADO group
 	r.Group(func(r chi.Router) {
 		r.Use(a.WADO.ctx)
@@ -86,6 +179,7 @@ func (a *API) Router() *chi.Mux {
 			r.Get("/studies/{studyUID}", a.WADO.study)
 			r.Get("/studies/{studyUID}/series/{seriesUID}", a.WADO.series)
 			r.Get("/studies/{studyUID}/series/{seriesUID}/instances/{instanceUID}", a.WADO.instance)
+			r.Get("/studies/{studyUID}/series/{seriesUID}/instances/{instanceUID}/thumbnail", a.WADO.thumbnail)
 		})
 
 		r.Group(func(r chi.Router) {
@@ -103,11 +197,37 @@ func (a *API) Router() *chi.Mux {
 		// todo: implement image rendering
 	})
 
+	// Delete group
+	r.Group(func(r chi.Router) {
+		r.Use(a.WADO.ctx)
+		r.Delete("/studies/{studyUID}", a.Delete.study)
+		r.Delete("/studies/{studyUID}/series/{seriesUID}", a.Delete.series)
+		r.Delete("/studies/{studyUID}/series/{seriesUID}/instances/{instanceUID}", a.Delete.instance)
+	})
+
 	// STOW group
 	r.Group(func(r chi.Router) {
 		r.Post("/studies", a.STOW.save)
 	})
 
+	// UPS-RS group
+	r.Route("/workitems", func(r chi.Router) {
+		r.Post("/", a.Workitems.create)
+		r.Get("/", a.Workitems.search)
+		r.Route("/{workitemUID}", func(r chi.Router) {
+			r.Post("/subscribers/{aeTitle}", a.Workitems.subscribe)
+			r.Delete("/subscribers/{aeTitle}", a.Workitems.unsubscribe)
+			r.Post("/subscribers/{aeTitle}/suspend", a.Workitems.suspend)
+			r.Group(func(r chi.Router) {
+				r.Use(a.Workitems.ctx)
+				r.Get("/", a.Workitems.retrieve)
+				r.Put("/state", a.Workitems.changeState)
+				r.Post("/cancelrequest", a.Workitems.cancelRequest)
+			})
+		})
+	})
+	r.Get("/subscribers/{aeTitle}", a.Workitems.events)
+
 	return r
 }
 
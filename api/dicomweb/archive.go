@@ -0,0 +1,145 @@
+package dicomweb
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"dicom-store-api/utils"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// decodeRequestBody undoes the Content-Encoding of the request body.
+// "deflate" is accepted both zlib wrapped as specified and as the raw stream some clients send.
+func decodeRequestBody(r *http.Request) (io.Reader, error) {
+	switch strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))) {
+	case "", "identity":
+		return r.Body, nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(r.Body)
+	case "deflate":
+		body := bufio.NewReader(r.Body)
+		header, err := body.Peek(2)
+		if err == nil && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 && header[0]&0x0f == 8 {
+			return zlib.NewReader(body)
+		}
+		return flate.NewReader(body), nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %s", r.Header.Get("Content-Encoding"))
+	}
+}
+
+// maxZipEntries bounds the number of entries of an archive, far above the files of a study.
+const maxZipEntries = 100000
+
+func isZipContentType(contentType string) bool {
+	return contentType == "application/zip" || contentType == "application/x-zip-compressed"
+}
+
+// getFilesFromZip returns the DICOM files of an archive. If the archive holds a DICOMDIR, the files it
+// references are used regardless of their names. Every other entry is stored if it is a Part 10 file and
+// reported as skipped otherwise. The request fails once the entries read exceed the unpack limit.
+func getFilesFromZip(data []byte, unpacked *unpackLimit) ([][]byte, []string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid zip archive: %w", err)
+	}
+	if len(archive.File) > maxZipEntries {
+		return nil, nil, fmt.Errorf("zip archive exceeds %d entries", maxZipEntries)
+	}
+
+	// entries are read by their own names, DICOMDIR references are matched case-insensitively since
+	// the file IDs of a DICOMDIR are uppercase whatever the case of the names in the archive
+	var entries []*zip.File
+	folded := map[string]bool{}
+	referenced := map[string]bool{}
+	for _, file := range archive.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		name := strings.ToUpper(path.Clean(file.Name))
+		folded[name] = true
+
+		if path.Base(name) != "DICOMDIR" {
+			entries = append(entries, file)
+			continue
+		}
+		content, err := unpacked.read(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		referencedFiles, err := utils.GetDicomDirReferencedFiles(content)
+		if err != nil {
+			continue
+		}
+		for _, referencedFile := range referencedFiles {
+			referenced[strings.ToUpper(path.Join(path.Dir(name), referencedFile))] = true
+		}
+	}
+
+	var files [][]byte
+	var skipped []string
+	for _, file := range entries {
+		content, err := unpacked.read(file)
+		if err == errUnpackLimit {
+			return nil, nil, err
+		}
+		if err != nil {
+			skipped = append(skipped, fmt.Sprintf("%s: %s", file.Name, err))
+			continue
+		}
+		if !utils.IsDicomFile(content) {
+			if referenced[strings.ToUpper(path.Clean(file.Name))] {
+				skipped = append(skipped, fmt.Sprintf("%s: referenced by DICOMDIR but not a DICOM file", file.Name))
+			} else {
+				skipped = append(skipped, fmt.Sprintf("%s: not a DICOM file", file.Name))
+			}
+			continue
+		}
+		files = append(files, content)
+	}
+
+	for name := range referenced {
+		if !folded[name] {
+			skipped = append(skipped, fmt.Sprintf("%s: referenced by DICOMDIR but missing", name))
+		}
+	}
+
+	return files, skipped, nil
+}
+
+var errUnpackLimit = fmt.Errorf("zip archive unpacks to more than the max upload size of %d bytes", MaxUploadSize)
+
+// unpackLimit keeps the total size of the entries read from the archives of a request under a limit.
+type unpackLimit struct {
+	remaining int
+}
+
+func newUnpackLimit() *unpackLimit {
+	return &unpackLimit{remaining: MaxUploadSize}
+}
+
+func (l *unpackLimit) read(file *zip.File) ([]byte, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	content, err := ioutil.ReadAll(io.LimitReader(reader, int64(l.remaining)+1))
+	l.remaining -= len(content)
+	if l.remaining < 0 {
+		return nil, errUnpackLimit
+	}
+	if err != nil {
+		return nil, err
+	}
+	return content, nil
+}
@@ -0,0 +1,124 @@
+package dicomweb
+
+import (
+	"dicom-store-api/models"
+	"github.com/go-chi/render"
+	"github.com/go-pg/pg"
+	"net/http"
+	"time"
+)
+
+// DeleteResource implements removal of studies, series and instances. Deleted objects are moved to the trash,
+// their files stay in place until the trash is purged.
+type DeleteResource struct {
+	DB            *pg.DB
+	StudyStore    StudyStore
+	SeriesStore   SeriesStore
+	InstanceStore InstanceStore
+}
+
+// NewDeleteResource creates and returns a DeleteResource.
+func NewDeleteResource(db *pg.DB, studyStore StudyStore, seriesStore SeriesStore, instanceStore InstanceStore) *DeleteResource {
+	return &DeleteResource{
+		DB:            db,
+		StudyStore:    studyStore,
+		SeriesStore:   seriesStore,
+		InstanceStore: instanceStore,
+	}
+}
+
+func (rs *DeleteResource) study(w http.ResponseWriter, r *http.Request) {
+	study := r.Context().Value(ctxStudy).(*models.Study)
+
+	if err := rs.DeleteStudy(study); err != nil {
+		log(r).Error(err)
+		render.Render(w, r, ErrInternalServerError)
+		return
+	}
+	render.NoContent(w, r)
+}
+
+func (rs *DeleteResource) series(w http.ResponseWriter, r *http.Request) {
+	study := r.Context().Value(ctxStudy).(*models.Study)
+	series := r.Context().Value(ctxSeries).(*models.Series)
+
+	if err := rs.DeleteSeries(study, series); err != nil {
+		log(r).Error(err)
+		render.Render(w, r, ErrInternalServerError)
+		return
+	}
+	render.NoContent(w, r)
+}
+
+func (rs *DeleteResource) instance(w http.ResponseWriter, r *http.Request) {
+	study := r.Context().Value(ctxStudy).(*models.Study)
+	series := r.Context().Value(ctxSeries).(*models.Series)
+	instance := r.Context().Value(ctxInstance).(*models.Instance)
+
+	if err := rs.DeleteInstance(study, series, instance); err != nil {
+		log(r).Error(err)
+		render.Render(w, r, ErrInternalServerError)
+		return
+	}
+	render.NoContent(w, r)
+}
+
+// DeleteStudy deletes the study with all its series and instances.
+func (rs *DeleteResource) DeleteStudy(study *models.Study) error {
+	return rs.DB.RunInTransaction(func(tx *pg.Tx) error {
+		if err := rs.StudyStore.Lock(study, tx); err != nil {
+			return err
+		}
+		return rs.StudyStore.Delete(study, time.Now(), tx)
+	})
+}
+
+// DeleteSeries deletes the series with its instances and updates or deletes the parent study.
+func (rs *DeleteResource) DeleteSeries(study *models.Study, series *models.Series) error {
+	deletedAt := time.Now()
+	return rs.DB.RunInTransaction(func(tx *pg.Tx) error {
+		if err := rs.StudyStore.Lock(study, tx); err != nil {
+			return err
+		}
+		if err := rs.SeriesStore.Delete(series, deletedAt, tx); err != nil {
+			return err
+		}
+		return rs.updateOrDeleteStudy(study, deletedAt, tx)
+	})
+}
+
+// DeleteInstance deletes the instance and updates or deletes the parent series and study.
+// Parents left empty are stamped with the same time, so restoring the topmost of them brings everything back.
+func (rs *DeleteResource) DeleteInstance(study *models.Study, series *models.Series, instance *models.Instance) error {
+	deletedAt := time.Now()
+	return rs.DB.RunInTransaction(func(tx *pg.Tx) error {
+		if err := rs.StudyStore.Lock(study, tx); err != nil {
+			return err
+		}
+		if err := rs.InstanceStore.Delete(instance, deletedAt, tx); err != nil {
+			return err
+		}
+
+		remainingInstances, err := rs.InstanceStore.CountBy(map[string]any{"SeriesId": series.ID}, tx)
+		if err != nil {
+			return err
+		}
+		if remainingInstances == 0 {
+			if err = rs.SeriesStore.Delete(series, deletedAt, tx); err != nil {
+				return err
+			}
+		}
+		return rs.updateOrDeleteStudy(study, deletedAt, tx)
+	})
+}
+
+func (rs *DeleteResource) updateOrDeleteStudy(study *models.Study, deletedAt time.Time, tx *pg.Tx) error {
+	remainingSeries, err := rs.SeriesStore.CountBy(map[string]any{"StudyId": study.ID}, tx)
+	if err != nil {
+		return err
+	}
+	if remainingSeries == 0 {
+		return rs.StudyStore.Delete(study, deletedAt, tx)
+	}
+	return rs.StudyStore.UpdateComputedFields(study, tx)
+}
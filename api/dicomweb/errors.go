@@ -55,6 +55,16 @@ func ErrRender(err error) render.Renderer {
 	}
 }
 
+// ErrConflict returns status 409 Conflict for a request inconsistent with the state of the resource.
+func ErrConflict(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: http.StatusConflict,
+		StatusText:     http.StatusText(http.StatusConflict),
+		ErrorText:      err.Error(),
+	}
+}
+
 var (
 	// ErrBadRequest returns status 400 Bad Request for malformed request body.
 	ErrBadRequest = &ErrResponse{HTTPStatusCode: http.StatusBadRequest, StatusText: http.StatusText(http.StatusBadRequest)}
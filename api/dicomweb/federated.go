@@ -0,0 +1,167 @@
+package dicomweb
+
+import (
+	"context"
+	"dicom-store-api/dicomwebclient"
+	"dicom-store-api/models"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/spf13/viper"
+)
+
+// Keys of the attributes marking where a federated search result lives.
+const (
+	keyRetrieveAETitle = "00080054"
+	keyRetrieveURL     = "00081190"
+	keyStudyUID        = "0020000D"
+)
+
+// FederatedResource searches the local store and the remote nodes with a DICOMweb url at once.
+type FederatedResource struct {
+	QIDO            *QIDOResource
+	RemoteNodeStore RemoteNodeStore
+	// AETitle and RetrieveURL mark the local results, RetrieveURL defaults to the url of the request.
+	AETitle     string
+	RetrieveURL string
+	// Nodes limits the searched remote nodes to these AE titles, all nodes with a url are searched when empty.
+	Nodes   []string
+	Timeout time.Duration
+}
+
+// NewFederatedResourceFromConfig returns the FederatedResource configured by the federation config key.
+func NewFederatedResourceFromConfig(qido *QIDOResource, remoteNodeStore RemoteNodeStore) *FederatedResource {
+	viper.SetDefault("dimse.ae_title", "DICOM_STORE")
+	viper.SetDefault("federation.timeout", "10s")
+	return &FederatedResource{
+		QIDO:            qido,
+		RemoteNodeStore: remoteNodeStore,
+		AETitle:         viper.GetString("dimse.ae_title"),
+		RetrieveURL:     strings.TrimSuffix(viper.GetString("federation.retrieve_url"), "/"),
+		Nodes:           viper.GetStringSlice("federation.nodes"),
+		Timeout:         viper.GetDuration("federation.timeout"),
+	}
+}
+
+// federatedResult is a study found by the federated search, with the AE titles of the archives holding it.
+type federatedResult struct {
+	attributes map[string]any
+	aeTitles   []string
+}
+
+// studies answers a study query with the matches of every archive, merged by StudyInstanceUID. Limit and
+// offset apply to each archive. Archives failing or not answering in time are left out with a warning.
+func (rs *FederatedResource) studies(w http.ResponseWriter, r *http.Request) {
+	requestData := getQIDORequest(r)
+	studies, err := rs.QIDO.Search(QueryLevelStudy, requestData)
+	if err != nil {
+		render.Render(w, r, ErrInternalServerError)
+		return
+	}
+	nodes, err := rs.nodes()
+	if err != nil {
+		render.Render(w, r, ErrInternalServerError)
+		return
+	}
+
+	remoteResults := make([][]dicomwebclient.Attributes, len(nodes))
+	remoteErrors := make([]error, len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func(i int, node *models.RemoteNode) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(r.Context(), rs.Timeout)
+			defer cancel()
+			remoteResults[i], remoteErrors[i] = dicomwebclient.NewClient(node.URL).SearchStudies(ctx, r.URL.Query())
+		}(i, node)
+	}
+	wg.Wait()
+
+	var results []*federatedResult
+	byUID := map[string]*federatedResult{}
+	add := func(uid string, aeTitle string, attributes map[string]any) {
+		if result, ok := byUID[uid]; ok {
+			result.aeTitles = append(result.aeTitles, aeTitle)
+			return
+		}
+		result := &federatedResult{attributes: attributes, aeTitles: []string{aeTitle}}
+		results = append(results, result)
+		if uid != "" {
+			byUID[uid] = result
+		}
+	}
+
+	localURL := rs.localRetrieveURL(r)
+	for i, object := range *newQIDOResponse(studies, requestData) {
+		uid := studies[i].(*models.Study).StudyInstanceUID
+		attributes := object.(map[string]any)
+		attributes[keyRetrieveURL] = map[string]any{"vr": "UR", "Value": []string{localURL + "/studies/" + uid}}
+		add(uid, rs.AETitle, attributes)
+	}
+	for i, node := range nodes {
+		if remoteErrors[i] != nil {
+			log(r).WithField("ae_title", node.AETitle).Warnf("federated search failed: %s", remoteErrors[i])
+			w.Header().Add("Warning", fmt.Sprintf("299 %s \"%s did not answer the search\"", rs.AETitle, node.AETitle))
+			continue
+		}
+		for _, remote := range remoteResults[i] {
+			uid := remote.String(keyStudyUID)
+			attributes := make(map[string]any, len(remote)+2)
+			for key, attribute := range remote {
+				attributes[key] = attribute
+			}
+			if remote.String(keyRetrieveURL) == "" {
+				attributes[keyRetrieveURL] = map[string]any{"vr": "UR", "Value": []string{strings.TrimSuffix(node.URL, "/") + "/studies/" + uid}}
+			}
+			add(uid, node.AETitle, attributes)
+		}
+	}
+
+	response := make(QIDOResponse, len(results))
+	for i, result := range results {
+		result.attributes[keyRetrieveAETitle] = map[string]any{"vr": "AE", "Value": result.aeTitles}
+		response[i] = result.attributes
+	}
+	render.Respond(w, r, &response)
+}
+
+// nodes returns the remote nodes to search.
+func (rs *FederatedResource) nodes() ([]*models.RemoteNode, error) {
+	if rs.RemoteNodeStore == nil {
+		return nil, nil
+	}
+	all, err := rs.RemoteNodeStore.List()
+	if err != nil {
+		return nil, err
+	}
+	var nodes []*models.RemoteNode
+	for _, node := range all {
+		if node.URL == "" || (len(rs.Nodes) > 0 && !contains(rs.Nodes, node.AETitle)) {
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// localRetrieveURL returns the DICOMweb base url of the store, the configured one or that of the request.
+func (rs *FederatedResource) localRetrieveURL(r *http.Request) string {
+	if rs.RetrieveURL != "" {
+		return rs.RetrieveURL
+	}
+	return baseURL(r, "/federated/studies")
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
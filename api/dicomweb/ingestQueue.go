@@ -0,0 +1,236 @@
+package dicomweb
+
+import (
+	"dicom-store-api/fs"
+	"dicom-store-api/logging"
+	"dicom-store-api/models"
+	"dicom-store-api/utils"
+	"fmt"
+	"github.com/go-pg/pg"
+	"time"
+)
+
+type JobStore interface {
+	Create(job *models.Job, tx *pg.Tx) error
+	CreateItem(item *models.JobItem, tx *pg.Tx) error
+	ClaimNextItem() (*models.JobItem, error)
+	FinishItem(item *models.JobItem) error
+	ResetProcessingItems() (int, error)
+	AddItem(item *models.JobItem) error
+	EndRetrieve(job *models.Job, retrieveError string) error
+	EndInterruptedRetrieves() (int, error)
+}
+
+// IngestQueue stores asynchronous STOW uploads durably and processes them with a pool of workers.
+type IngestQueue struct {
+	DB           *pg.DB
+	STOW         *STOWResource
+	JobStore     JobStore
+	Workers      int
+	PollInterval time.Duration
+	wake         chan struct{}
+}
+
+// NewIngestQueue creates and returns an IngestQueue.
+func NewIngestQueue(db *pg.DB, stow *STOWResource, jobStore JobStore, workers int) *IngestQueue {
+	if workers < 1 {
+		workers = 1
+	}
+	return &IngestQueue{
+		DB:           db,
+		STOW:         stow,
+		JobStore:     jobStore,
+		Workers:      workers,
+		PollInterval: 5 * time.Second,
+		wake:         make(chan struct{}, workers),
+	}
+}
+
+// Start requeues items interrupted by a previous shutdown and starts the workers.
+func (q *IngestQueue) Start() error {
+	count, err := q.JobStore.ResetProcessingItems()
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		logging.Logger.WithField("module", "ingest").Infof("resuming %d interrupted job items", count)
+	}
+	if count, err = q.JobStore.EndInterruptedRetrieves(); err != nil {
+		return err
+	}
+	if count > 0 {
+		logging.Logger.WithField("module", "ingest").Warnf("%d retrieve jobs were interrupted", count)
+	}
+
+	for i := 0; i < q.Workers; i++ {
+		go q.work()
+	}
+	return nil
+}
+
+// Enqueue spools the files and creates a pending job for them.
+func (q *IngestQueue) Enqueue(files [][]byte) (*models.Job, error) {
+	job := &models.Job{
+		Status: models.JobStatusPending,
+		Total:  len(files),
+	}
+
+	var spooled []string
+	err := q.DB.RunInTransaction(func(tx *pg.Tx) error {
+		if err := q.JobStore.Create(job, tx); err != nil {
+			return err
+		}
+		for index, fileBytes := range files {
+			path := fs.GetSpoolPath(job.ID, index)
+			if err := fs.Save(path, fileBytes); err != nil {
+				return err
+			}
+			spooled = append(spooled, path)
+
+			item := &models.JobItem{
+				JobId:     job.ID,
+				ItemIndex: index,
+				Status:    models.JobStatusPending,
+				SpoolPath: path,
+			}
+			if err := q.JobStore.CreateItem(item, tx); err != nil {
+				return err
+			}
+			job.Items = append(job.Items, item)
+		}
+		return nil
+	})
+	if err != nil {
+		for _, path := range spooled {
+			fs.Remove(path)
+		}
+		return nil, err
+	}
+
+	q.wakeWorkers()
+	return job, nil
+}
+
+// Retrieve creates a job in the retrieving status and runs fetch in the background. Every file fetch
+// passes to add is spooled and queued as soon as it is received, and the job ends with the fetch.
+func (q *IngestQueue) Retrieve(fetch func(add func(file []byte) error) error) (*models.Job, error) {
+	job := &models.Job{Status: models.JobStatusRetrieving}
+	if err := q.JobStore.Create(job, nil); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		logger := logging.Logger.WithField("module", "ingest").WithField("job", job.ID)
+		index := 0
+		err := fetch(func(file []byte) error {
+			path := fs.GetSpoolPath(job.ID, index)
+			if err := fs.Save(path, file); err != nil {
+				return err
+			}
+			item := &models.JobItem{
+				JobId:     job.ID,
+				ItemIndex: index,
+				Status:    models.JobStatusPending,
+				SpoolPath: path,
+			}
+			if err := q.JobStore.AddItem(item); err != nil {
+				fs.Remove(path)
+				return err
+			}
+			index++
+			q.wakeWorkers()
+			return nil
+		})
+
+		retrieveError := ""
+		if err != nil {
+			logger.Warnf("retrieve stopped after %d files: %v", index, err)
+			retrieveError = err.Error()
+		}
+		if err := q.JobStore.EndRetrieve(&models.Job{ID: job.ID}, retrieveError); err != nil {
+			logger.Error(err)
+		}
+	}()
+	return job, nil
+}
+
+func (q *IngestQueue) wakeWorkers() {
+	for i := 0; i < q.Workers; i++ {
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (q *IngestQueue) work() {
+	logger := logging.Logger.WithField("module", "ingest")
+	for {
+		item, err := q.JobStore.ClaimNextItem()
+		if err != nil {
+			logger.Error(err)
+		}
+		if item == nil {
+			select {
+			case <-q.wake:
+			case <-time.After(q.PollInterval):
+			}
+			continue
+		}
+
+		q.process(item)
+		if err := q.JobStore.FinishItem(item); err != nil {
+			logger.WithField("job", item.JobId).Error(err)
+			continue
+		}
+		if err := fs.Remove(item.SpoolPath); err != nil {
+			logger.WithField("job", item.JobId).Error(err)
+		}
+	}
+}
+
+// process parses and indexes a spooled file, renders its thumbnail and records the outcome on the item.
+// A file that panics fails its item, left processing it would be requeued and crash every restart.
+func (q *IngestQueue) process(item *models.JobItem) {
+	defer func() {
+		if r := recover(); r != nil {
+			logging.Logger.WithField("module", "ingest").WithField("job", item.JobId).Errorf("panic processing item %d: %v", item.ItemIndex, r)
+			item.Status = models.JobStatusFailed
+			item.Error = fmt.Sprintf("failed to process the file: %v", r)
+		}
+	}()
+
+	fileBytes, err := fs.ReadFile(item.SpoolPath)
+	if err != nil {
+		item.Status = models.JobStatusFailed
+		item.Error = err.Error()
+		return
+	}
+
+	result, err := q.STOW.store(fileBytes)
+	if err != nil {
+		item.Status = models.JobStatusFailed
+		item.Error = err.Error()
+		return
+	}
+	item.Status = models.JobStatusCompleted
+	item.SOPInstanceUID = result.sopInstanceUID
+
+	if err := saveThumbnail(result); err != nil {
+		logging.Logger.WithField("module", "ingest").WithField("job", item.JobId).Warn(err)
+	}
+}
+
+// saveThumbnail recovers from the panics of the thumbnail rendering, the instance is stored by then.
+func saveThumbnail(result *stowResult) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to render the thumbnail: %v", r)
+		}
+	}()
+	thumbnail, err := utils.GenerateThumbnail(result.dataset, utils.ThumbnailSize)
+	if err != nil {
+		return err
+	}
+	return fs.Save(fs.GetThumbnailPath(result.study, result.series, result.instance), thumbnail)
+}
@@ -208,71 +208,46 @@ func getQIDORequest(r *http.Request) *QIDORequest {
 	return data
 }
 
+// Query levels of Search, as the values of QueryRetrieveLevel.
+const (
+	QueryLevelStudy    = "STUDY"
+	QueryLevelSeries   = "SERIES"
+	QueryLevelInstance = "IMAGE"
+)
+
 func (rs *QIDOResource) studies(w http.ResponseWriter, r *http.Request) {
 	requestData := getQIDORequest(r)
-
-	options := &database.SelectQueryOptions{
-		Limit:   requestData.Limit,
-		Offset:  requestData.Offset,
-		OrderBy: "id",
-	}
-
-	fields := requestData.getFieldsForStoreRequest()
-
-	studyList, err := rs.StudyStore.FindBy(fields, options, nil)
-	if err != nil {
-		render.Render(w, r, ErrInternalServerError)
-		return
-	}
-
-	dicomObjectsList := make([]models.DicomObject, len(studyList))
-	for i, study := range studyList {
-		dicomObjectsList[i] = study
-	}
-	render.Respond(w, r, newQIDOResponse(dicomObjectsList, requestData))
+	rs.respond(w, r, QueryLevelStudy, requestData)
 }
 
 func (rs *QIDOResource) series(w http.ResponseWriter, r *http.Request) {
 	requestData := getQIDORequest(r)
-
-	options := &database.SelectQueryOptions{
-		Limit:   requestData.Limit,
-		Offset:  requestData.Offset,
-		OrderBy: "id",
-	}
-
-	fields := requestData.getFieldsForStoreRequest()
-	fields, err := transformFieldsForObject(rs, fields, &models.Series{})
-	if err != nil {
-		if err == ErrEmptyParentEntitiesList {
-			render.Respond(w, r, newQIDOResponse([]models.DicomObject{}, requestData))
-			return
-		}
-		render.Render(w, r, ErrInternalServerError)
-		return
+	if study, ok := r.Context().Value(ctxStudy).(*models.Study); ok {
+		requestData.Filters[tag.StudyInstanceUID] = []string{study.StudyInstanceUID}
 	}
+	rs.respond(w, r, QueryLevelSeries, requestData)
+}
 
-	study, ok := r.Context().Value(ctxStudy).(*models.Study)
-	if ok {
-		fields["StudyId"] = study.ID
+func (rs *QIDOResource) instances(w http.ResponseWriter, r *http.Request) {
+	requestData := getQIDORequest(r)
+	if series, ok := r.Context().Value(ctxSeries).(*models.Series); ok {
+		requestData.Filters[tag.SeriesInstanceUID] = []string{series.SeriesInstanceUID}
 	}
+	rs.respond(w, r, QueryLevelInstance, requestData)
+}
 
-	seriesList, err := rs.SeriesStore.FindBy(fields, options, nil)
+func (rs *QIDOResource) respond(w http.ResponseWriter, r *http.Request, level string, requestData *QIDORequest) {
+	dicomObjectsList, err := rs.Search(level, requestData)
 	if err != nil {
 		render.Render(w, r, ErrInternalServerError)
 		return
 	}
-
-	dicomObjectsList := make([]models.DicomObject, len(seriesList))
-	for i, series := range seriesList {
-		dicomObjectsList[i] = series
-	}
 	render.Respond(w, r, newQIDOResponse(dicomObjectsList, requestData))
 }
 
-func (rs *QIDOResource) instances(w http.ResponseWriter, r *http.Request) {
-	requestData := getQIDORequest(r)
-
+// Search returns the studies, series or instances matching the filters of the request at the query level,
+// series with their study and instances with their series and study.
+func (rs *QIDOResource) Search(level string, requestData *QIDORequest) ([]models.DicomObject, error) {
 	options := &database.SelectQueryOptions{
 		Limit:   requestData.Limit,
 		Offset:  requestData.Offset,
@@ -280,32 +255,61 @@ func (rs *QIDOResource) instances(w http.ResponseWriter, r *http.Request) {
 	}
 
 	fields := requestData.getFieldsForStoreRequest()
-	fields, err := transformFieldsForObject(rs, fields, &models.Instance{})
-	if err != nil {
+	var dicomObjectsList []models.DicomObject
+	switch level {
+	case QueryLevelStudy:
+		studyList, err := rs.StudyStore.FindBy(fields, options, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, study := range studyList {
+			dicomObjectsList = append(dicomObjectsList, study)
+		}
+	case QueryLevelSeries:
+		fields, err := transformFieldsForObject(rs, fields, &models.Series{})
 		if err == ErrEmptyParentEntitiesList {
-			render.Respond(w, r, newQIDOResponse([]models.DicomObject{}, requestData))
-			return
+			return []models.DicomObject{}, nil
+		} else if err != nil {
+			return nil, err
 		}
-		render.Render(w, r, ErrInternalServerError)
-		return
-	}
-
-	series, ok := r.Context().Value(ctxSeries).(*models.Series)
-	if ok {
-		fields["SeriesId"] = series.ID
+		seriesList, err := rs.SeriesStore.FindBy(fields, options, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, series := range seriesList {
+			dicomObjectsList = append(dicomObjectsList, series)
+		}
+	case QueryLevelInstance:
+		fields, err := transformFieldsForObject(rs, fields, &models.Instance{})
+		if err == ErrEmptyParentEntitiesList {
+			return []models.DicomObject{}, nil
+		} else if err != nil {
+			return nil, err
+		}
+		instanceList, err := rs.InstanceStore.FindBy(fields, options, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, instance := range instanceList {
+			dicomObjectsList = append(dicomObjectsList, instance)
+		}
+	default:
+		return nil, fmt.Errorf("invalid query level %q", level)
 	}
-
-	instanceList, err := rs.InstanceStore.FindBy(fields, options, nil)
-	if err != nil {
-		render.Render(w, r, ErrInternalServerError)
-		return
+	if dicomObjectsList == nil {
+		dicomObjectsList = []models.DicomObject{}
 	}
+	return dicomObjectsList, nil
+}
 
-	dicomObjectsList := make([]models.DicomObject, len(instanceList))
-	for i, study := range instanceList {
-		dicomObjectsList[i] = study
+// SearchPatients returns the patients of the studies matching the request, the request limit applying to
+// the patients.
+func (rs *QIDOResource) SearchPatients(requestData *QIDORequest) ([]*database.Patient, error) {
+	options := &database.SelectQueryOptions{
+		Limit:  requestData.Limit,
+		Offset: requestData.Offset,
 	}
-	render.Respond(w, r, newQIDOResponse(dicomObjectsList, requestData))
+	return rs.StudyStore.FindPatients(requestData.getFieldsForStoreRequest(), options, nil)
 }
 
 func transformFieldsForObject(rs *QIDOResource, fields map[string]any, dicomObject models.DicomObject) (map[string]any, error) {
@@ -374,12 +378,12 @@ func (requestData *QIDORequest) getFieldsForStoreRequest() map[string]any {
 	fields := map[string]any{}
 	if requestData.Filters != nil {
 		for key, value := range requestData.Filters {
-			if len(value) == 0 {
+			if len(value) == 0 || (len(value) == 1 && (value[0] == "" || value[0] == "*")) {
 				continue
 			}
 			tagInfo, _ := tag.Find(key)
 			if len(value) == 1 {
-				fields[tagInfo.Name] = value[0]
+				fields[tagInfo.Name] = filterValue(tagInfo, value[0])
 			} else {
 				fields[tagInfo.Name] = value
 			}
@@ -387,3 +391,19 @@ func (requestData *QIDORequest) getFieldsForStoreRequest() map[string]any {
 	}
 	return fields
 }
+
+// filterValue translates a matching value to a store field value: range matching for dates and times with a
+// hyphen, wildcard matching for values with * or ?, and matching any value of multi-valued attributes,
+// which are stored as JSON arrays.
+func filterValue(tagInfo tag.Info, value string) any {
+	switch {
+	case (tagInfo.VR == "DA" || tagInfo.VR == "TM" || tagInfo.VR == "DT") && strings.Contains(value, "-"):
+		bounds := strings.SplitN(value, "-", 2)
+		return database.Range{From: bounds[0], To: bounds[1]}
+	case tagInfo.VM != "1" && tagInfo.VR != "SQ":
+		return database.Wildcard(`*"` + value + `"*`)
+	case strings.ContainsAny(value, "*?"):
+		return database.Wildcard(value)
+	}
+	return value
+}
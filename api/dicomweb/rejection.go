@@ -0,0 +1,86 @@
+package dicomweb
+
+import (
+	"dicom-store-api/models"
+	"dicom-store-api/utils"
+
+	"github.com/go-pg/pg"
+	"github.com/suyashkumar/dicom"
+)
+
+// getRejectionNote returns the IHE IOCM rejection note carried by a Key Object Selection document and the
+// instances it rejects, or nil for any other instance.
+func getRejectionNote(dataset dicom.Dataset, series *models.Series, instance *models.Instance) (*models.RejectionNote, []*models.RejectedInstance, error) {
+	if instance.SOPClassUID != utils.KeyObjectSelectionDocumentSOPClassUID {
+		return nil, nil, nil
+	}
+	title, err := utils.GetDocumentTitle(dataset)
+	if err != nil || title.CodingSchemeDesignator != models.RejectionCodingSchemeDesignator {
+		return nil, nil, nil
+	}
+	codeMeaning, ok := models.RejectionCodeMeanings[title.CodeValue]
+	if !ok {
+		return nil, nil, nil
+	}
+
+	references, err := utils.GetEvidenceReferences(dataset)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	note := &models.RejectionNote{
+		StudyInstanceUID:  series.Study.StudyInstanceUID,
+		SeriesInstanceUID: series.SeriesInstanceUID,
+		SOPInstanceUID:    instance.SOPInstanceUID,
+		CodeValue:         title.CodeValue,
+		CodeMeaning:       codeMeaning,
+	}
+	var rejected []*models.RejectedInstance
+	for _, reference := range references {
+		if reference.SOPInstanceUID == "" || reference.SOPInstanceUID == instance.SOPInstanceUID {
+			continue
+		}
+		rejected = append(rejected, &models.RejectedInstance{
+			StudyInstanceUID:  reference.StudyInstanceUID,
+			SeriesInstanceUID: reference.SeriesInstanceUID,
+			SOPClassUID:       reference.SOPClassUID,
+			SOPInstanceUID:    reference.SOPInstanceUID,
+		})
+	}
+	return note, rejected, nil
+}
+
+// applyRejectionNote records the note and the instances it rejects and recounts the other studies they belong to.
+// From then on the rejected instances are hidden from QIDO and WADO.
+func (rs *STOWResource) applyRejectionNote(note *models.RejectionNote, rejected []*models.RejectedInstance, tx *pg.Tx) error {
+	if err := rs.RejectionStore.Upsert(note, tx); err != nil {
+		return err
+	}
+	if err := rs.RejectionStore.AddInstances(note, rejected, tx); err != nil {
+		return err
+	}
+
+	var studyInstanceUIDs []string
+	seen := map[string]bool{note.StudyInstanceUID: true}
+	for _, instance := range rejected {
+		if !seen[instance.StudyInstanceUID] {
+			seen[instance.StudyInstanceUID] = true
+			studyInstanceUIDs = append(studyInstanceUIDs, instance.StudyInstanceUID)
+		}
+	}
+	return rs.StudyStore.UpdateComputedFieldsByUID(studyInstanceUIDs, tx)
+}
+
+// expireRejectedInstances moves the stored instances rejected by a data retention expiry note to the trash.
+func (rs *STOWResource) expireRejectedInstances(note *models.RejectionNote) error {
+	instances, err := rs.InstanceStore.FindRejected(note.ID, nil)
+	if err != nil {
+		return err
+	}
+	for _, instance := range instances {
+		if err = rs.Delete.DeleteInstance(instance.Series.Study, instance.Series, instance); err != nil {
+			return err
+		}
+	}
+	return nil
+}
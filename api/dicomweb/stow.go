@@ -1,45 +1,85 @@
 package dicomweb
 
 import (
-	"bytes"
+	"dicom-store-api/coercion"
+	"dicom-store-api/database"
 	"dicom-store-api/fs"
+	"dicom-store-api/logging"
 	"dicom-store-api/models"
+	"dicom-store-api/prefetch"
+	"dicom-store-api/routing"
 	"dicom-store-api/utils"
+	"dicom-store-api/worklist"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/go-chi/render"
 	"github.com/go-pg/pg"
+	"github.com/spf13/viper"
 	"github.com/suyashkumar/dicom"
+	"github.com/suyashkumar/dicom/pkg/tag"
+	"io"
 	"io/ioutil"
 	"mime"
 	"mime/multipart"
 	"net/http"
-	"strconv"
 	"strings"
 )
 
+// MaxUploadSize limits the size of a STOW request body and of every file unpacked from it.
+const MaxUploadSize = 128 << 20
+
 // STOWResource implements management handler.
 type STOWResource struct {
 	DB            *pg.DB
 	StudyStore    StudyStore
 	SeriesStore   SeriesStore
 	InstanceStore InstanceStore
+	Coercion      *coercion.Engine
+	Queue         *IngestQueue
+	// RejectionStore and Delete handle received IOCM rejection notes
+	RejectionStore RejectionStore
+	Delete         *DeleteResource
+	// Routing forwards the stored instances matching its rules, nothing is forwarded without it
+	Routing *routing.Engine
+	// Prefetch fetches the priors of new studies, none are fetched without it
+	Prefetch *prefetch.Prefetcher
+	// Worklist links the series to the scheduled procedure steps they perform, none are linked without it
+	Worklist *worklist.Manager
 }
 
 // NewSTOWResource creates and returns a STOWResource.
-func NewSTOWResource(db *pg.DB, studyStore StudyStore, seriesStore SeriesStore, instanceStore InstanceStore) *STOWResource {
+func NewSTOWResource(db *pg.DB, studyStore StudyStore, seriesStore SeriesStore, instanceStore InstanceStore, coercionEngine *coercion.Engine) *STOWResource {
 	return &STOWResource{
 		DB:            db,
 		StudyStore:    studyStore,
 		SeriesStore:   seriesStore,
 		InstanceStore: instanceStore,
+		Coercion:      coercionEngine,
 	}
 }
 
+// NewSTOWResourceFromConfig returns a STOWResource with its own stores and the configured coercion rules,
+// for indexing files outside of the API. It has no ingest queue.
+func NewSTOWResourceFromConfig(db *pg.DB) (*STOWResource, error) {
+	studyStore := database.NewStudyStore(db)
+	seriesStore := database.NewSeriesStore(db)
+	instanceStore := database.NewInstanceStore(db)
+
+	coercionEngine, err := coercion.NewEngineFromConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	rs := NewSTOWResource(db, studyStore, seriesStore, instanceStore, coercionEngine)
+	rs.RejectionStore = database.NewRejectionStore(db)
+	rs.Delete = NewDeleteResource(db, studyStore, seriesStore, instanceStore)
+	return rs, nil
+}
+
 func (rs *STOWResource) save(w http.ResponseWriter, r *http.Request) {
-	const MaxUploadSize = 128 << 20
 	if r.ContentLength > MaxUploadSize {
-		http.Error(w, fmt.Sprintf("request exceeds max upload size of %d bytes", MaxUploadSize), http.StatusBadRequest)
+		http.Error(w, errUploadSize.Error(), http.StatusRequestEntityTooLarge)
 		return
 	}
 
@@ -49,209 +89,524 @@ func (rs *STOWResource) save(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var files [][]byte
-	if contentType == "application/dicom" {
-		bodyReader := http.MaxBytesReader(w, r.Body, MaxUploadSize)
+	defer r.Body.Close()
+	r.Body = &uploadLimitReader{ReadCloser: r.Body}
 
-		defer bodyReader.Close()
+	decodedBody, err := decodeRequestBody(r)
+	if err != nil {
+		renderBodyError(w, err)
+		return
+	}
+	// the decoded size is bounded as well, a small compressed body may expand far beyond it
+	body := &uploadLimitReader{ReadCloser: io.NopCloser(decodedBody)}
 
-		body, err := ioutil.ReadAll(bodyReader)
-		if err != nil || len(body) == 0 {
+	var files [][]byte
+	var skipped []string
+	if contentType == "application/dicom" || isZipContentType(contentType) {
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			renderBodyError(w, err)
+			return
+		}
+		if len(data) == 0 {
 			http.Error(w, "Wrong request body", http.StatusBadRequest)
 			return
 		}
-		files = append(files, body)
+		if isZipContentType(contentType) {
+			files, skipped, err = getFilesFromZip(data, newUnpackLimit())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		} else {
+			files = append(files, data)
+		}
 	} else {
 		if !strings.HasPrefix(contentType, "multipart/") {
 			http.Error(w, "expecting a multipart message", http.StatusBadRequest)
 			return
 		}
-		multipartReader := multipart.NewReader(r.Body, params["boundary"])
-
-		for {
-			part, err := multipartReader.NextPart()
-			if err != nil {
-				break
-			}
-
-			if part.Header.Get("Content-Type") != "application/dicom" {
-				http.Error(w, "expecting a multipart message of application/dicom content", http.StatusBadRequest)
-				return
-			}
-
-			fileBytes, err := ioutil.ReadAll(part)
-			if err != nil {
-				part.Close()
-				http.Error(w, "failed to read content of the part", http.StatusInternalServerError)
-				return
-			}
-			files = append(files, fileBytes)
+		parts, err := readSTOWParts(multipart.NewReader(body, params["boundary"]))
+		if err != nil {
+			renderBodyError(w, err)
+			return
+		}
 
-			part.Close()
+		files, skipped, err = getFilesFromSTOWParts(parts)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
 	}
 
+	for _, message := range skipped {
+		w.Header().Add("Warning", fmt.Sprintf("299 %s \"skipped %s\"", coercion.ModifyingSystem, message))
+	}
+
 	if len(files) == 0 {
 		http.Error(w, "no files found in the request", http.StatusBadRequest)
 		return
 	}
 
-	var successfullySavedFiles = make(map[int]bool)
-	for index, fileBytes := range files {
-		successfullySavedFiles[index] = false
-		dataset, _ := dicom.Parse(bytes.NewReader(fileBytes), MaxUploadSize, nil)
-
-		study := &models.Study{}
-		utils.ExtractDicomObjectFromDataset(dataset, study)
-
-		series := &models.Series{Study: study}
-		utils.ExtractDicomObjectFromDataset(dataset, series)
-
-		instance := &models.Instance{Series: series}
-		utils.ExtractDicomObjectFromDataset(dataset, instance)
-
-		tx, err := rs.DB.Begin()
-
-		studyList, err := rs.StudyStore.FindBy(map[string]any{
-			"StudyInstanceUID": study.StudyInstanceUID,
-		}, nil, nil)
+	if rs.Queue != nil && (viper.GetBool("stow_async") || strings.Contains(r.Header.Get("Prefer"), "respond-async")) {
+		job, err := rs.Queue.Enqueue(files)
 		if err != nil {
+			log(r).Error(err)
 			render.Render(w, r, ErrInternalServerError)
 			return
 		}
+		jobURL := fmt.Sprintf("/api/jobs/%d", job.ID)
+		w.Header().Set("Content-Location", jobURL)
+		render.Status(r, http.StatusAccepted)
+		render.JSON(w, r, map[string]any{
+			"id":     job.ID,
+			"status": job.Status,
+			"total":  job.Total,
+			"url":    jobURL,
+		})
+		return
+	}
 
-		if len(studyList) == 1 {
-			study = studyList[0]
-			if err = rs.StudyStore.Update(study, tx); err != nil {
-				tx.Rollback()
-				render.Render(w, r, ErrInternalServerError)
-				return
-			}
-		} else {
-			if err = rs.StudyStore.Create(study, tx); err != nil {
-				tx.Rollback()
-				render.Render(w, r, ErrInternalServerError)
-				return
-			}
+	var successfullySavedFiles = make(map[int]bool)
+	var results []*stowResult
+	for index, fileBytes := range files {
+		successfullySavedFiles[index] = false
+		result, err := rs.store(fileBytes)
+		if errors.Is(err, errUnreadableInstance) {
+			log(r).WithField("index", index).Warn(err)
+			results = append(results, &stowResult{failure: err})
+			continue
 		}
-
-		seriesList, err := rs.SeriesStore.FindBy(map[string]any{
-			"SeriesInstanceUID": series.SeriesInstanceUID,
-		}, nil, nil)
 		if err != nil {
+			log(r).WithField("index", index).Error(err)
 			render.Render(w, r, ErrInternalServerError)
 			return
 		}
+		successfullySavedFiles[index] = true
+		results = append(results, result)
+	}
 
-		if len(seriesList) == 1 {
-			series = seriesList[0]
-			if err = rs.SeriesStore.Update(series, tx); err != nil {
-				tx.Rollback()
-				render.Render(w, r, ErrInternalServerError)
-				return
-			}
-		} else {
-			series.StudyId = study.ID
-			series.Study = study
-			if err = rs.SeriesStore.Create(series, tx); err != nil {
-				tx.Rollback()
-				render.Render(w, r, ErrInternalServerError)
-				return
-			}
-		}
+	writeSTOWResponse(w, r, successfullySavedFiles, results)
+}
 
-		instanceList, err := rs.InstanceStore.FindBy(map[string]any{
-			"SOPInstanceUID": instance.SOPInstanceUID,
-		}, nil, nil)
-		if err != nil {
-			render.Render(w, r, ErrInternalServerError)
-			return
-		}
+var errUploadSize = fmt.Errorf("request exceeds the max upload size of %d bytes", MaxUploadSize)
 
-		if len(instanceList) == 1 {
-			instance = instanceList[0]
-			if err = rs.InstanceStore.Update(instance, tx); err != nil {
-				tx.Rollback()
-				render.Render(w, r, ErrInternalServerError)
-				return
-			}
-		} else {
-			instance.SeriesId = series.ID
-			instance.Series = series
-			if err = rs.InstanceStore.Create(instance, tx); err != nil {
-				tx.Rollback()
-				render.Render(w, r, ErrInternalServerError)
-				return
-			}
+// uploadLimitReader fails with errUploadSize once the body goes past MaxUploadSize, where a plain
+// io.LimitReader would end it early and let the truncated body through.
+type uploadLimitReader struct {
+	io.ReadCloser
+	read int64
+}
+
+func (l *uploadLimitReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > MaxUploadSize+1-l.read {
+		p = p[:MaxUploadSize+1-l.read]
+	}
+	n, err := l.ReadCloser.Read(p)
+	l.read += int64(n)
+	if l.read > MaxUploadSize {
+		return n, errUploadSize
+	}
+	return n, err
+}
+
+func renderBodyError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errUploadSize) {
+		http.Error(w, errUploadSize.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+// Store coerces, indexes and saves a single Part 10 file like a STOW request and returns the stored instance.
+func (rs *STOWResource) Store(fileBytes []byte) (*models.Instance, error) {
+	result, err := rs.store(fileBytes)
+	if err != nil {
+		return nil, err
+	}
+	return result.instance, nil
+}
+
+// errUnreadableInstance fails a single instance of a request, the other instances are still stored.
+var errUnreadableInstance = errors.New("failed to parse the DICOM file")
+
+// store coerces, indexes and saves a single Part 10 file.
+func (rs *STOWResource) store(fileBytes []byte) (*stowResult, error) {
+	// deflated files are parsed inflated, but stored and checksummed as received
+	dataset, err := parseDicomFile(fileBytes)
+	if errors.Is(err, fs.ErrDecodedSize) {
+		return nil, fmt.Errorf("%w: it inflates beyond the max upload size of %d bytes", errUnreadableInstance, MaxUploadSize)
+	}
+	// a partially parsed dataset is never indexed, its missing attributes would be stored as empty
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnreadableInstance, err)
+	}
+
+	result := &stowResult{}
+	if result.coerced, err = rs.Coercion.Apply(&dataset); err != nil {
+		return nil, err
+	}
+	if len(result.coerced) > 0 {
+		if err = coercion.AddOriginalAttributes(&dataset, result.coerced, "COERCE"); err != nil {
+			return nil, err
+		}
+		if fileBytes, err = utils.WriteDatasetToBytes(dataset); err != nil {
+			return nil, err
 		}
+	}
 
-		err = updateComputedFields(rs, study, series, tx)
+	study := &models.Study{}
+	utils.ExtractDicomObjectFromDataset(dataset, study)
 
-		if err != nil {
+	series := &models.Series{Study: study}
+	utils.ExtractDicomObjectFromDataset(dataset, series)
+
+	instance := &models.Instance{Series: series}
+	utils.ExtractDicomObjectFromDataset(dataset, instance)
+
+	tx, err := rs.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = rs.StudyStore.Upsert(study, tx); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	series.StudyId = study.ID
+	series.Study = study
+	if err = rs.SeriesStore.Upsert(series, tx); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	instance.SeriesId = series.ID
+	instance.Series = series
+	instance.FileSize = int64(len(fileBytes))
+	instance.FileSHA256 = fs.Checksum(fileBytes)
+	instance.StoragePath = fs.NewDicomPath(study, series, instance)
+	storedBytes, fileCompression, err := fs.EncodeDicomFile(fileBytes)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	instance.FileCompression = fileCompression
+	instance.StoredSize = int64(len(storedBytes))
+	fileSize, fileSHA256, storagePath := instance.FileSize, instance.FileSHA256, instance.StoragePath
+	storedSize := instance.StoredSize
+	if err = rs.InstanceStore.Upsert(instance, tx); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// a resend with different content, or compressed differently, replaces the file recorded for the stored instance
+	replacedPath := ""
+	wasCold := instance.Tier == models.TierCold
+	if instance.FileSHA256 != fileSHA256 || instance.StoragePath != storagePath ||
+		instance.FileCompression != fileCompression || instance.StoredSize != storedSize || wasCold {
+		replacedPath = fs.GetDicomPath(study, series, instance)
+		instance.FileSize, instance.FileSHA256, instance.StoragePath = fileSize, fileSHA256, storagePath
+		instance.FileCompression, instance.StoredSize, instance.Tier = fileCompression, storedSize, ""
+		if err = rs.InstanceStore.UpdateFile(instance, tx); err != nil {
 			tx.Rollback()
-			render.Render(w, r, ErrInternalServerError)
-			return
+			return nil, err
 		}
+	}
 
-		path := fs.GetDicomPath(study, series, instance)
-		if err = fs.Save(path, fileBytes); err != nil {
+	note, rejected, err := getRejectionNote(dataset, series, instance)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if note != nil {
+		if err = rs.applyRejectionNote(note, rejected, tx); err != nil {
 			tx.Rollback()
-			render.Render(w, r, ErrInternalServerError)
-			return
+			return nil, err
 		}
+	}
 
-		err = tx.Commit()
-		if err != nil {
+	if err = rs.StudyStore.UpdateComputedFields(study, tx); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// routed instances are queued along with the instance, the queue is never missing a stored instance
+	routed := false
+	if rs.Routing != nil {
+		if routed, err = rs.Routing.Route(&dataset, instance, tx); err != nil {
 			tx.Rollback()
-			render.Render(w, r, ErrInternalServerError)
-			return
+			return nil, err
+		}
+	}
+
+	if err = fs.SaveDicomFile(study, series, instance, storedBytes); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if routed {
+		rs.Routing.Wake()
+	}
+	if rs.Prefetch != nil {
+		rs.Prefetch.Trigger(&dataset)
+	}
+	if rs.Worklist != nil {
+		rs.Worklist.Link(series, study)
+	}
+
+	if replacedPath != "" {
+		if err = rs.removeReplacedFile(replacedPath, storagePath, wasCold); err != nil {
+			logging.Logger.WithField("module", "stow").WithField("instance", instance.SOPInstanceUID).Warn(err)
+		}
+	}
+
+	if note != nil && note.CodeValue == models.RejectionCodeRetentionExpired {
+		if err = rs.expireRejectedInstances(note); err != nil {
+			logging.Logger.WithField("module", "stow").WithField("note", note.SOPInstanceUID).Error(err)
 		}
-		successfullySavedFiles[index] = true
 	}
 
-	render.JSON(w, r, successfullySavedFiles)
+	result.sopClassUID = instance.SOPClassUID
+	result.sopInstanceUID = instance.SOPInstanceUID
+	result.study = study
+	result.series = series
+	result.instance = instance
+	result.dataset = dataset
+	return result, nil
 }
 
-func updateComputedFields(rs *STOWResource, study *models.Study, series *models.Series, tx *pg.Tx) error {
-	studyRelatedSeriesList, err := rs.SeriesStore.FindBy(map[string]any{
-		"StudyId": series.StudyId,
-	}, nil, tx)
-	if err != nil {
-		return err
+type stowResult struct {
+	sopClassUID    string
+	sopInstanceUID string
+	study          *models.Study
+	series         *models.Series
+	instance       *models.Instance
+	dataset        dicom.Dataset
+	// coerced holds the original values of the attributes changed by the coercion rules
+	coerced []*dicom.Element
+	// failure is set for the files that were not stored
+	failure error
+}
+
+// warningReasonCoercion is the WarningReason (0008,1196) for instances whose attributes were coerced.
+const warningReasonCoercion = 0xB000
+
+// failureReasonUnreadable is the FailureReason (0008,1197) for files that could not be parsed.
+const failureReasonUnreadable = 0xC000
+
+// writeSTOWResponse responds with a DICOM JSON store response when the client accepts application/dicom+json
+// and with the map of saved file indexes otherwise. Coercions are reported in Warning headers either way.
+// The status is 202 when some files failed and 409 when all of them did.
+func writeSTOWResponse(w http.ResponseWriter, r *http.Request, successfullySavedFiles map[int]bool, results []*stowResult) {
+	failed := 0
+	for _, result := range results {
+		if result.failure != nil {
+			failed++
+			continue
+		}
+		if len(result.coerced) == 0 {
+			continue
+		}
+		names := make([]string, 0, len(result.coerced))
+		for _, element := range result.coerced {
+			names = append(names, tagName(element.Tag))
+		}
+		w.Header().Add("Warning", fmt.Sprintf("299 %s \"%s: coerced %s\"", coercion.ModifyingSystem, result.sopInstanceUID, strings.Join(names, ", ")))
+	}
+	status := http.StatusOK
+	if failed == len(results) {
+		status = http.StatusConflict
+	} else if failed > 0 {
+		status = http.StatusAccepted
 	}
-	study.NumberOfStudyRelatedSeries = strconv.Itoa(len(studyRelatedSeriesList))
 
-	modalitiesOfRelatedSeriesMap := make(map[string]bool)
-	for _, series := range studyRelatedSeriesList {
-		modalitiesOfRelatedSeriesMap[series.Modality] = true
+	if !strings.Contains(r.Header.Get("Accept"), "application/dicom+json") {
+		render.Status(r, status)
+		render.JSON(w, r, successfullySavedFiles)
+		return
 	}
 
-	modalitiesOfRelatedSeries := make([]string, 0)
-	for modality := range modalitiesOfRelatedSeriesMap {
-		modalitiesOfRelatedSeries = append(modalitiesOfRelatedSeries, modality)
+	referencedSOPSequence := make([]any, 0, len(results))
+	failedSOPSequence := make([]any, 0, failed)
+	for _, result := range results {
+		if result.failure != nil {
+			// the UIDs of a file that does not parse are unknown
+			failedSOPSequence = append(failedSOPSequence, map[string]any{
+				"00081197": map[string]any{"vr": "US", "Value": []int{failureReasonUnreadable}},
+			})
+			continue
+		}
+		item := map[string]any{
+			"00081150": map[string]any{"vr": "UI", "Value": []string{result.sopClassUID}},
+			"00081155": map[string]any{"vr": "UI", "Value": []string{result.sopInstanceUID}},
+		}
+		if len(result.coerced) > 0 {
+			item["00081196"] = map[string]any{"vr": "US", "Value": []int{warningReasonCoercion}}
+			item["04000561"] = map[string]any{"vr": "SQ", "Value": []any{
+				map[string]any{
+					"04000550": map[string]any{"vr": "SQ", "Value": []any{utils.DatasetElementsToDicomJSON(result.coerced)}},
+					"04000563": map[string]any{"vr": "LO", "Value": []string{coercion.ModifyingSystem}},
+					"04000565": map[string]any{"vr": "CS", "Value": []string{"COERCE"}},
+				},
+			}}
+		}
+		referencedSOPSequence = append(referencedSOPSequence, item)
 	}
 
-	modalitiesInStudy, err := json.Marshal(modalitiesOfRelatedSeries)
-	if err != nil {
-		return err
+	response := map[string]any{}
+	if len(referencedSOPSequence) > 0 {
+		response["00081199"] = map[string]any{"vr": "SQ", "Value": referencedSOPSequence}
 	}
-	study.ModalitiesInStudy = string(modalitiesInStudy)
+	if len(failedSOPSequence) > 0 {
+		response["00081198"] = map[string]any{"vr": "SQ", "Value": failedSOPSequence}
+	}
+	w.Header().Set("Content-Type", "application/dicom+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
 
-	studyRelatedSeriesListIds := make([]int, 0)
-	for _, series := range studyRelatedSeriesList {
-		studyRelatedSeriesListIds = append(studyRelatedSeriesListIds, series.ID)
+func tagName(t tag.Tag) string {
+	if tagInfo, err := tag.Find(t); err == nil {
+		return tagInfo.Name
 	}
+	return t.String()
+}
 
-	studyRelatedInstanceList, err := rs.InstanceStore.FindBy(map[string]any{
-		"SeriesId": studyRelatedSeriesListIds,
-	}, nil, tx)
-	if err != nil {
-		return err
+type stowPart struct {
+	contentType string
+	params      map[string]string
+	location    string
+	data        []byte
+}
+
+func readSTOWParts(multipartReader *multipart.Reader) ([]*stowPart, error) {
+	var parts []*stowPart
+	for {
+		part, err := multipartReader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid multipart message: %w", err)
+		}
+
+		partContentType, partParams, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if err != nil {
+			part.Close()
+			return nil, fmt.Errorf("invalid content type of the part: %w", err)
+		}
+
+		data, err := ioutil.ReadAll(part)
+		part.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read content of the part: %w", err)
+		}
+
+		parts = append(parts, &stowPart{
+			contentType: partContentType,
+			params:      partParams,
+			location:    part.Header.Get("Content-Location"),
+			data:        data,
+		})
 	}
-	study.NumberOfStudyRelatedInstances = strconv.Itoa(len(studyRelatedInstanceList))
+	return parts, nil
+}
+
+// getFilesFromSTOWParts returns Part 10 files from application/dicom parts as is and assembles
+// application/dicom+json metadata parts with the bulk data parts they reference by BulkDataURI.
+func getFilesFromSTOWParts(parts []*stowPart) ([][]byte, []string, error) {
+	bulkDataParts := map[string]*stowPart{}
+	for _, part := range parts {
+		if part.location != "" {
+			bulkDataParts[part.location] = part
+		}
+	}
+
+	// a BulkDataURI references the part whose Content-Location is exactly the same
+	resolve := func(uri string) (*utils.BulkData, error) {
+		part, ok := bulkDataParts[uri]
+		if !ok {
+			return nil, fmt.Errorf("bulk data part %s not found", uri)
+		}
+		if part.contentType == "application/octet-stream" {
+			return &utils.BulkData{Data: part.data, TransferSyntax: part.params["transfer-syntax"]}, nil
+		}
+		transferSyntax := part.params["transfer-syntax"]
+		if transferSyntax == "" {
+			if transferSyntax, ok = utils.BulkDataTransferSyntax(part.contentType); !ok {
+				return nil, fmt.Errorf("bulk data part %s of %s content has no transfer syntax", uri, part.contentType)
+			}
+		}
+		return &utils.BulkData{
+			Data:           part.data,
+			Encapsulated:   true,
+			TransferSyntax: transferSyntax,
+		}, nil
+	}
+
+	var files [][]byte
+	var skipped []string
+	unpacked := newUnpackLimit()
+	for _, part := range parts {
+		switch {
+		case part.contentType == "application/dicom":
+			files = append(files, part.data)
+		case isZipContentType(part.contentType):
+			zipFiles, zipSkipped, err := getFilesFromZip(part.data, unpacked)
+			if err != nil {
+				return nil, nil, err
+			}
+			files = append(files, zipFiles...)
+			skipped = append(skipped, zipSkipped...)
+		case part.contentType == "application/dicom+json" || part.contentType == "application/json":
+			datasets, err := utils.ParseDicomJSON(part.data)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid metadata part: %w", err)
+			}
+			for _, object := range datasets {
+				dataset, err := utils.BuildDatasetFromDicomJSON(object, resolve)
+				if err != nil {
+					return nil, nil, fmt.Errorf("invalid metadata part: %w", err)
+				}
+				fileBytes, err := utils.WriteDatasetToBytes(dataset)
+				if err != nil {
+					return nil, nil, fmt.Errorf("failed to encode metadata part: %w", err)
+				}
+				files = append(files, fileBytes)
+			}
+		case part.contentType == "application/octet-stream" || strings.HasPrefix(part.contentType, "image/") || strings.HasPrefix(part.contentType, "video/"):
+			if part.location == "" {
+				return nil, nil, fmt.Errorf("bulk data part of %s content has no Content-Location", part.contentType)
+			}
+		default:
+			return nil, nil, fmt.Errorf("expecting a multipart message of application/dicom or application/dicom+json content")
+		}
+	}
+	return files, skipped, nil
+}
 
-	if err = rs.StudyStore.Update(study, tx); err != nil {
+// removeReplacedFile cleans up after the file of an instance was replaced. A file overwritten at the same key
+// only loses its thumbnail and, if it was archived, its cold copy unless other instances share it. A file at
+// another key is removed unless other instances still share it.
+func (rs *STOWResource) removeReplacedFile(replacedPath string, storagePath string, wasCold bool) error {
+	if replacedPath == storagePath {
+		if err := fs.RemoveThumbnail(replacedPath); err != nil || !wasCold {
+			return err
+		}
+		count, err := rs.InstanceStore.CountByStoragePath(replacedPath, nil)
+		if err != nil || count > 1 {
+			return err
+		}
+		return fs.RemoveColdDicomPath(replacedPath)
+	}
+	count, err := rs.InstanceStore.CountByStoragePath(replacedPath, nil)
+	if err != nil || count > 0 {
 		return err
 	}
-	return nil
+	return fs.RemoveDicomPath(replacedPath)
 }
@@ -0,0 +1,240 @@
+package dicomweb
+
+import (
+	"dicom-store-api/fs"
+	"dicom-store-api/logging"
+	"dicom-store-api/models"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/go-pg/pg"
+	"github.com/spf13/viper"
+)
+
+// Recall modes for retrieves of NEARLINE studies.
+const (
+	// RecallSync recalls the study before answering the retrieve.
+	RecallSync = "sync"
+	// RecallAsync answers 202 while the study is recalled in the background.
+	RecallAsync = "async"
+)
+
+// Tiering moves the files of studies between the hot storage and the cold one. Studies are archived by a
+// policy on their age and their last retrieve, and recalled when they are retrieved again.
+type Tiering struct {
+	DB            *pg.DB
+	StudyStore    StudyStore
+	InstanceStore InstanceStore
+	// MinAge and Idle select the studies to archive, older than MinAge by StudyDate and not retrieved for Idle.
+	MinAge    time.Duration
+	Idle      time.Duration
+	Recall    string
+	BatchSize int
+
+	mutex     sync.Mutex
+	recalling map[int]chan struct{}
+}
+
+// NewTieringFromConfig returns the Tiering configured by the storage.tiering config key, or nil if no cold storage is configured.
+func NewTieringFromConfig(db *pg.DB, studyStore StudyStore, instanceStore InstanceStore) (*Tiering, error) {
+	if fs.GetColdStorage() == nil {
+		return nil, nil
+	}
+
+	viper.SetDefault("storage.tiering.recall", RecallSync)
+	recall := viper.GetString("storage.tiering.recall")
+	if recall != RecallSync && recall != RecallAsync {
+		return nil, fmt.Errorf("unknown recall mode %q", recall)
+	}
+	return &Tiering{
+		DB:            db,
+		StudyStore:    studyStore,
+		InstanceStore: instanceStore,
+		MinAge:        viper.GetDuration("storage.tiering.min_age"),
+		Idle:          viper.GetDuration("storage.tiering.idle"),
+		Recall:        recall,
+		BatchSize:     100,
+		recalling:     map[int]chan struct{}{},
+	}, nil
+}
+
+// Start applies the archive policy every interval. Without an age or idle limit nothing is archived.
+func (t *Tiering) Start(interval time.Duration) {
+	if t.MinAge <= 0 && t.Idle <= 0 {
+		return
+	}
+	logger := logging.Logger.WithField("module", "tiering")
+	go func() {
+		for {
+			archived, err := t.Archive()
+			if err != nil {
+				logger.Error(err)
+			} else if archived > 0 {
+				logger.Infof("moved %d studies to the cold tier", archived)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// Archive moves the files of the studies selected by the policy to the cold tier and returns their number.
+func (t *Tiering) Archive() (int, error) {
+	logger := logging.Logger.WithField("module", "tiering")
+	var studiedBefore, accessedBefore time.Time
+	if t.MinAge > 0 {
+		studiedBefore = time.Now().Add(-t.MinAge)
+	}
+	if t.Idle > 0 {
+		accessedBefore = time.Now().Add(-t.Idle)
+	}
+
+	archived := 0
+	failed := map[int]bool{}
+	for {
+		studies, err := t.StudyStore.FindToArchive(studiedBefore, accessedBefore, t.BatchSize+len(failed), nil)
+		if err != nil {
+			return archived, err
+		}
+		progress := false
+		for _, study := range studies {
+			if failed[study.ID] {
+				continue
+			}
+			progress = true
+			if err := t.move(study, models.TierHot, models.TierCold); err != nil {
+				logger.WithField("study", study.StudyInstanceUID).Warn(err)
+				failed[study.ID] = true
+				continue
+			}
+			archived++
+		}
+		if !progress {
+			return archived, nil
+		}
+	}
+}
+
+// startRecall moves the files of the study back to the hot tier in the background, unless they are recalled
+// already. The returned channel is closed once the recall is done.
+func (t *Tiering) startRecall(study *models.Study) chan struct{} {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if done, ok := t.recalling[study.ID]; ok {
+		return done
+	}
+
+	done := make(chan struct{})
+	t.recalling[study.ID] = done
+	// the recall locks and reloads its own copy of the study
+	study = &models.Study{ID: study.ID, StudyInstanceUID: study.StudyInstanceUID}
+	go func() {
+		if err := t.move(study, models.TierCold, models.TierHot); err != nil {
+			logging.Logger.WithField("module", "tiering").WithField("study", study.StudyInstanceUID).Error(err)
+		}
+		t.mutex.Lock()
+		delete(t.recalling, study.ID)
+		t.mutex.Unlock()
+		close(done)
+	}()
+	return done
+}
+
+// move copies the files of the study in one tier to the other and records the move while the study is locked,
+// so that STOW and deletes of the study wait for it. The copies in the old tier are removed after the commit.
+func (t *Tiering) move(study *models.Study, from string, to string) error {
+	tx, err := t.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = t.StudyStore.Lock(study, tx); err != nil {
+		return err
+	}
+	instances, err := t.InstanceStore.FindByStudyAndTier(study.ID, from, tx)
+	if err != nil {
+		return err
+	}
+
+	moved := map[string]bool{}
+	for _, instance := range instances {
+		key := fs.GetDicomPath(instance.Series.Study, instance.Series, instance)
+		if !moved[key] {
+			if to == models.TierCold {
+				err = fs.ArchiveDicomPath(key)
+			} else {
+				err = fs.RecallDicomPath(key)
+			}
+			if err != nil {
+				return err
+			}
+			moved[key] = true
+		}
+		instance.Tier = to
+		if err = t.InstanceStore.UpdateTier(instance, tx); err != nil {
+			return err
+		}
+	}
+	if err = t.StudyStore.UpdateComputedFields(study, tx); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	for key := range moved {
+		if to == models.TierCold {
+			err = fs.RemoveHotDicomPath(key)
+		} else {
+			err = fs.RemoveColdDicomPath(key)
+		}
+		if err != nil {
+			logging.Logger.WithField("module", "tiering").WithField("key", key).Warn(err)
+		}
+	}
+	return nil
+}
+
+// makeOnline recalls the NEARLINE studies of the instances before they are retrieved. In async mode it writes
+// a 202 response and returns false while a recall runs. Failed recalls are logged and the files are read from
+// the cold tier.
+func (t *Tiering) makeOnline(w http.ResponseWriter, r *http.Request, instances []*models.Instance) bool {
+	studies := map[int]*models.Study{}
+	for _, instance := range instances {
+		if instance.Tier == models.TierCold {
+			studies[instance.Series.Study.ID] = instance.Series.Study
+		}
+	}
+	if len(studies) == 0 {
+		return true
+	}
+
+	var recalls []chan struct{}
+	var uids []string
+	for _, study := range studies {
+		done := t.startRecall(study)
+		recalls = append(recalls, done)
+		uids = append(uids, study.StudyInstanceUID)
+	}
+
+	if t.Recall == RecallAsync {
+		w.Header().Set("Retry-After", "30")
+		render.Status(r, http.StatusAccepted)
+		render.JSON(w, r, map[string]any{
+			"status":  "recalling",
+			"studies": uids,
+		})
+		return false
+	}
+	for _, done := range recalls {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+			return false
+		}
+	}
+	return true
+}
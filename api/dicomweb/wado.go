@@ -1,11 +1,12 @@
 package dicomweb
 
 import (
-	"bufio"
+	"bytes"
 	"context"
 	"dicom-store-api/database"
 	"dicom-store-api/fs"
 	"dicom-store-api/models"
+	"dicom-store-api/utils"
 	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
@@ -13,10 +14,13 @@ import (
 	"github.com/go-pg/pg"
 	"github.com/suyashkumar/dicom"
 	"github.com/suyashkumar/dicom/pkg/tag"
+	"io"
 	"mime/multipart"
 	"net/http"
 	"net/textproto"
 	"os"
+	"regexp"
+	"strconv"
 )
 
 type RequestType int
@@ -118,6 +122,8 @@ type WADOResource struct {
 	StudyStore    StudyStore
 	SeriesStore   SeriesStore
 	InstanceStore InstanceStore
+	// Tiering recalls NEARLINE studies before they are retrieved, nil without a cold tier
+	Tiering *Tiering
 }
 
 // NewWADOResource creates and returns a WADOResource.
@@ -130,20 +136,26 @@ func NewWADOResource(db *pg.DB, studyStore StudyStore, seriesStore SeriesStore,
 	}
 }
 
-// Writes a multipart response from a list of paths to dicom files
-func writeWADORSResponse(w http.ResponseWriter, r *http.Request, paths []string) error {
-	if len(paths) == 0 {
+// Writes a multipart response from the files of a list of instances
+func (rs *WADOResource) writeWADORSResponse(w http.ResponseWriter, r *http.Request, instances []*models.Instance) error {
+	if len(instances) == 0 {
 		render.Render(w, r, ErrNotFound)
 		return nil
 	}
+	if rs.Tiering != nil && !rs.Tiering.makeOnline(w, r, instances) {
+		return nil
+	}
+	if err := rs.touch(instances); err != nil {
+		log(r).Warn(err)
+	}
 
 	requestType := r.Context().Value(ctxRequestType).(RequestType)
 	switch requestType {
 	case requestTypeMetadata:
 		var responseData []any
-		for _, path := range paths {
+		for _, instance := range instances {
 			var formatted = map[string]any{}
-			dataset, _ := dicom.ParseFile(path, nil)
+			dataset, _ := parseStoredFile(instance)
 			for _, element := range dataset.Elements {
 				if element.ValueRepresentation == tag.VRPixelData {
 					continue
@@ -172,52 +184,34 @@ func writeWADORSResponse(w http.ResponseWriter, r *http.Request, paths []string)
 		partHeaders := textproto.MIMEHeader{}
 		partHeaders.Set("Content-Type", "application/dicom")
 
-		for _, path := range paths {
+		for _, instance := range instances {
 			partWriter, err := mw.CreatePart(partHeaders)
 			if err != nil {
 				return err
 			}
 
-			file, err := os.Open(path)
+			file, err := fs.OpenDicomFile(instance.Series.Study, instance.Series, instance)
 			if err != nil {
 				return err
 			}
-
-			scanner := bufio.NewScanner(file)
-			for scanner.Scan() {
-				if _, err := partWriter.Write(scanner.Bytes()); err != nil {
-					return err
-				}
-			}
-
-			if err := file.Close(); err != nil {
-				return err
-			}
-
-			if err := scanner.Err(); err != nil {
+			_, err = io.Copy(partWriter, file)
+			file.Close()
+			if err != nil {
 				return err
 			}
 		}
-	case requestWADOURI:
-		w.Header().Set("Content-Type", "application/dicom")
-		file, err := os.Open(paths[0])
-		if err != nil {
-			return err
-		}
-		buf := bufio.NewReader(file)
-		if _, err := buf.WriteTo(w); err != nil {
-			return err
-		}
-		if err := file.Close(); err != nil {
+		if err := mw.Close(); err != nil {
 			return err
 		}
+	case requestWADOURI:
+		return writeWADOURIResponse(w, r, instances[0])
 	}
 
 	return nil
 }
 
 func (rs *WADOResource) study(w http.ResponseWriter, r *http.Request) {
-	var paths []string
+	var instances []*models.Instance
 
 	study := r.Context().Value(ctxStudy).(*models.Study)
 	seriesList, err := rs.SeriesStore.FindBy(map[string]any{"StudyId": study.ID}, nil, nil)
@@ -232,13 +226,10 @@ func (rs *WADOResource) study(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		for _, instance := range instanceList {
-			path := fs.GetDicomPath(study, series, instance)
-			paths = append(paths, path)
-		}
+		instances = append(instances, instanceList...)
 	}
 
-	err = writeWADORSResponse(w, r, paths)
+	err = rs.writeWADORSResponse(w, r, instances)
 	if err != nil {
 		render.Render(w, r, ErrInternalServerError)
 		return
@@ -248,9 +239,6 @@ func (rs *WADOResource) study(w http.ResponseWriter, r *http.Request) {
 }
 
 func (rs *WADOResource) series(w http.ResponseWriter, r *http.Request) {
-	var paths []string
-
-	study := r.Context().Value(ctxStudy).(*models.Study)
 	series := r.Context().Value(ctxSeries).(*models.Series)
 
 	instanceList, err := rs.InstanceStore.FindBy(map[string]any{"SeriesId": series.ID}, nil, nil)
@@ -259,12 +247,7 @@ func (rs *WADOResource) series(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	for _, instance := range instanceList {
-		path := fs.GetDicomPath(study, series, instance)
-		paths = append(paths, path)
-	}
-
-	err = writeWADORSResponse(w, r, paths)
+	err = rs.writeWADORSResponse(w, r, instanceList)
 	if err != nil {
 		render.Render(w, r, ErrInternalServerError)
 		return
@@ -274,22 +257,47 @@ func (rs *WADOResource) series(w http.ResponseWriter, r *http.Request) {
 }
 
 func (rs *WADOResource) instance(w http.ResponseWriter, r *http.Request) {
-	var paths []string
+	instance := r.Context().Value(ctxInstance).(*models.Instance)
+
+	err := rs.writeWADORSResponse(w, r, []*models.Instance{instance})
+	if err != nil {
+		render.Render(w, r, ErrInternalServerError)
+		return
+	}
 
+	return
+}
+
+func (rs *WADOResource) thumbnail(w http.ResponseWriter, r *http.Request) {
 	study := r.Context().Value(ctxStudy).(*models.Study)
 	series := r.Context().Value(ctxSeries).(*models.Series)
 	instance := r.Context().Value(ctxInstance).(*models.Instance)
 
-	path := fs.GetDicomPath(study, series, instance)
-	paths = append(paths, path)
-
-	err := writeWADORSResponse(w, r, paths)
-	if err != nil {
+	path := fs.GetThumbnailPath(study, series, instance)
+	thumbnail, err := fs.ReadFile(path)
+	if os.IsNotExist(err) {
+		// instances stored synchronously get their thumbnail rendered on first request
+		var dataset dicom.Dataset
+		dataset, err = parseStoredFile(instance)
+		if err != nil {
+			render.Render(w, r, ErrInternalServerError)
+			return
+		}
+		thumbnail, err = utils.GenerateThumbnail(dataset, utils.ThumbnailSize)
+		if err != nil {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+		if err := fs.Save(path, thumbnail); err != nil {
+			log(r).Warn(err)
+		}
+	} else if err != nil {
 		render.Render(w, r, ErrInternalServerError)
 		return
 	}
 
-	return
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Write(thumbnail)
 }
 
 type WADOURIRequest struct {
@@ -373,13 +381,7 @@ func (rs *WADOResource) uri(w http.ResponseWriter, r *http.Request) {
 		render.Render(w, r, ErrNotFound)
 		return
 	}
-	instance := instanceList[0]
-
-	var paths []string
-	path := fs.GetDicomPath(study, series, instance)
-	paths = append(paths, path)
-
-	err = writeWADORSResponse(w, r, paths)
+	err = rs.writeWADORSResponse(w, r, instanceList)
 	if err != nil {
 		render.Render(w, r, ErrInternalServerError)
 		return
@@ -387,3 +389,107 @@ func (rs *WADOResource) uri(w http.ResponseWriter, r *http.Request) {
 
 	return
 }
+
+var byteRangePattern = regexp.MustCompile(`^bytes=(\d*)-(\d*)$`)
+
+// writeWADOURIResponse sends a single stored file, honouring a single byte range request.
+// Only complete files are verified against the recorded checksum.
+func writeWADOURIResponse(w http.ResponseWriter, r *http.Request, instance *models.Instance) error {
+	path := fs.GetDicomPath(instance.Series.Study, instance.Series, instance)
+	info, err := fs.StatStored(path)
+	if err != nil {
+		return err
+	}
+	// ranges refer to the file as received, compressed files are decompressed up to the range
+	size := info.Size
+	compressed := fs.IsCompressed(instance.FileCompression)
+	if compressed {
+		size = instance.FileSize
+	}
+
+	w.Header().Set("Content-Type", "application/dicom")
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	offset, length := int64(0), size
+	status := http.StatusOK
+	if match := byteRangePattern.FindStringSubmatch(r.Header.Get("Range")); match != nil {
+		first, firstErr := strconv.ParseInt(match[1], 10, 64)
+		last, lastErr := strconv.ParseInt(match[2], 10, 64)
+		switch {
+		case firstErr == nil && lastErr == nil && first <= last:
+			offset, length = first, last-first+1
+		case firstErr == nil && match[2] == "":
+			offset, length = first, size-first
+		case match[1] == "" && lastErr == nil:
+			offset, length = size-last, last
+		}
+		if offset < 0 {
+			offset, length = 0, size
+		}
+		if offset >= size {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return nil
+		}
+		if offset+length > size {
+			length = size - offset
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, size))
+		status = http.StatusPartialContent
+	}
+
+	var file io.ReadCloser
+	if status == http.StatusOK || compressed {
+		file, err = fs.OpenDicomFile(instance.Series.Study, instance.Series, instance)
+	} else {
+		file, err = fs.OpenStoredRange(path, offset, length)
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if status == http.StatusPartialContent && compressed {
+		if _, err = io.CopyN(io.Discard, file, offset); err != nil {
+			return err
+		}
+		reader = io.LimitReader(file, length)
+	}
+
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.WriteHeader(status)
+	_, err = io.Copy(w, reader)
+	return err
+}
+
+// touch records the retrieve of the studies of the instances for the tiering policy.
+func (rs *WADOResource) touch(instances []*models.Instance) error {
+	var studyIDs []int
+	seen := map[int]bool{}
+	for _, instance := range instances {
+		if id := instance.Series.Study.ID; !seen[id] {
+			seen[id] = true
+			studyIDs = append(studyIDs, id)
+		}
+	}
+	return rs.StudyStore.Touch(studyIDs, nil)
+}
+
+// parseStoredFile reads and parses the file of an instance from the storage.
+func parseStoredFile(instance *models.Instance) (dicom.Dataset, error) {
+	data, err := fs.ReadDicomFile(instance.Series.Study, instance.Series, instance)
+	if err != nil {
+		return dicom.Dataset{}, err
+	}
+	return parseDicomFile(data)
+}
+
+// parseDicomFile parses a Part 10 file, inflating it first if its transfer syntax is deflated.
+func parseDicomFile(data []byte) (dicom.Dataset, error) {
+	data, err := fs.InflateDicomFile(data, MaxUploadSize)
+	if err != nil {
+		return dicom.Dataset{}, err
+	}
+	return dicom.Parse(bytes.NewReader(data), int64(len(data)), nil)
+}
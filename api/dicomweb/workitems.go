@@ -0,0 +1,473 @@
+package dicomweb
+
+import (
+	"context"
+	"dicom-store-api/database"
+	"dicom-store-api/dicomwebclient"
+	"dicom-store-api/models"
+	"dicom-store-api/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/go-pg/pg"
+	"github.com/suyashkumar/dicom/pkg/tag"
+	"golang.org/x/net/websocket"
+
+	"dicom-store-api/worklist"
+)
+
+// UPS SOP classes of the workitems and of their event reports.
+const (
+	upsPushSOPClass  = "1.2.840.10008.5.1.4.34.6.1"
+	upsEventSOPClass = "1.2.840.10008.5.1.4.34.6.4"
+	// upsFilteredGlobalSubscription subscribes to the workitems matching a query, which is not supported.
+	upsFilteredGlobalSubscription = "1.2.840.10008.5.1.4.34.5.1"
+)
+
+// Keys of the UPS attributes that are not columns of the worklist items.
+const (
+	keyProcedureStepLabel     = "00741204"
+	keyStartDateTime          = "00404005"
+	keyInputReadinessState    = "00404041"
+	keyReferencedRequest      = "0040A370"
+	keyTransactionUID         = "00081195"
+	keyReasonForCancellation  = "00741238"
+	keyAffectedSOPClassUID    = "00000002"
+	keyAffectedSOPInstanceUID = "00001000"
+	keyEventTypeID            = "00001002"
+)
+
+// referencedRequestKeywords are the attributes of the order, in the ReferencedRequestSequence of a workitem.
+var referencedRequestKeywords = []string{"StudyInstanceUID", "AccessionNumber", "RequestedProcedureID", "RequestedProcedureDescription"}
+
+var (
+	errNoWorkitem           = errors.New("the request has no workitem")
+	errFilteredSubscription = errors.New("filtered global subscriptions are not supported")
+	errUnsupportedKey       = errors.New("unsupported matching key")
+)
+
+// WorkitemsResource is the UPS-RS worklist service: the worklist items as Unified Procedure Step workitems,
+// which performers claim and complete by changing their state, and whose events subscribers receive over
+// a WebSocket.
+type WorkitemsResource struct {
+	DB            *pg.DB
+	WorklistStore WorklistStore
+	Worklist      *worklist.Manager
+}
+
+// NewWorkitemsResource creates and returns a WorkitemsResource.
+func NewWorkitemsResource(db *pg.DB, worklistStore WorklistStore, manager *worklist.Manager) *WorkitemsResource {
+	return &WorkitemsResource{
+		DB:            db,
+		WorklistStore: worklistStore,
+		Worklist:      manager,
+	}
+}
+
+func (rs *WorkitemsResource) ctx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		item, err := rs.WorklistStore.GetByUID(chi.URLParam(r, "workitemUID"))
+		if err != nil {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+		ctx := context.WithValue(r.Context(), ctxWorkitem, item)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// create schedules the workitem of the request body, with the UID of the query string if it has one.
+func (rs *WorkitemsResource) create(w http.ResponseWriter, r *http.Request) {
+	attributes, err := readWorkitem(r)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	item := workitemFromAttributes(attributes)
+	if uid := r.URL.RawQuery; uid != "" && !strings.Contains(uid, "=") {
+		item.SOPInstanceUID = uid
+	}
+	if item.ProcedureStepState != "" && item.ProcedureStepState != models.WorklistStateScheduled {
+		render.Render(w, r, ErrInvalidRequest(worklist.ErrNotScheduled))
+		return
+	}
+
+	if err := rs.Worklist.Create(item); err != nil {
+		var validationErrors validation.Errors
+		var pgErr pg.Error
+		switch {
+		case errors.As(err, &validationErrors):
+			render.Render(w, r, ErrValidation(err, validationErrors))
+		case errors.As(err, &pgErr) && pgErr.IntegrityViolation():
+			render.Render(w, r, ErrConflict(errors.New("a workitem with this UID already exists")))
+		default:
+			log(r).Error(err)
+			render.Render(w, r, ErrInternalServerError)
+		}
+		return
+	}
+	w.Header().Set("Location", baseURL(r, "/workitems")+"/workitems/"+item.SOPInstanceUID)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// retrieve returns the workitem, without its transaction UID.
+func (rs *WorkitemsResource) retrieve(w http.ResponseWriter, r *http.Request) {
+	item := r.Context().Value(ctxWorkitem).(*models.WorklistItem)
+	render.JSON(w, r, []map[string]any{workitemAttributes(item)})
+}
+
+// search returns the workitems matching the query, which can match the attributes of the order in
+// ReferencedRequestSequence as well.
+func (rs *WorkitemsResource) search(w http.ResponseWriter, r *http.Request) {
+	requestData := getQIDORequest(r)
+	for key, values := range r.URL.Query() {
+		parts := strings.Split(key, ".")
+		if len(parts) != 2 {
+			continue
+		}
+		sequenceTag, err := utils.GetTagByNameOrCode(parts[0])
+		if err != nil || sequenceTag != tag.ReferencedRequestSequence {
+			continue
+		}
+		fieldTag, err := utils.GetTagByNameOrCode(parts[1])
+		if err != nil {
+			render.Render(w, r, ErrInvalidRequest(fmt.Errorf("%w %s", errUnsupportedKey, key)))
+			return
+		}
+		requestData.Filters[fieldTag] = append(requestData.Filters[fieldTag], strings.Split(values[0], ",")...)
+	}
+
+	items, err := rs.Search(requestData)
+	if errors.Is(err, errUnsupportedKey) {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if err != nil {
+		log(r).Error(err)
+		render.Render(w, r, ErrInternalServerError)
+		return
+	}
+	response := make([]map[string]any, len(items))
+	for i, item := range items {
+		response[i] = workitemAttributes(item)
+	}
+	render.JSON(w, r, response)
+}
+
+// Search returns the worklist items matching the filters of the request. ProcedureStepLabel matches the
+// ScheduledProcedureStepDescription and ScheduledProcedureStepStartDateTime the start date.
+func (rs *WorkitemsResource) Search(requestData *QIDORequest) ([]*models.WorklistItem, error) {
+	fields := map[string]any{}
+	columns := reflect.TypeOf(models.WorklistItem{})
+	for filterTag, values := range requestData.Filters {
+		if len(values) == 0 || (len(values) == 1 && (values[0] == "" || values[0] == "*")) {
+			continue
+		}
+		switch filterTag {
+		case tag.ProcedureStepLabel:
+			filterTag = tag.ScheduledProcedureStepDescription
+		case tag.ScheduledProcedureStepStartDateTime:
+			filterTag = tag.ScheduledProcedureStepStartDate
+			for i, value := range values {
+				values[i] = dateRange(value)
+			}
+		}
+		tagInfo, err := tag.Find(filterTag)
+		if err != nil {
+			return nil, err
+		}
+		if field, ok := columns.FieldByName(tagInfo.Name); !ok || field.Tag.Get("dicom") == "" {
+			return nil, fmt.Errorf("%w %s", errUnsupportedKey, tagInfo.Name)
+		}
+		// the items hold a single value of every attribute
+		tagInfo.VM = "1"
+		if len(values) == 1 {
+			fields[tagInfo.Name] = filterValue(tagInfo, values[0])
+		} else {
+			fields[tagInfo.Name] = values
+		}
+	}
+
+	options := &database.SelectQueryOptions{
+		Limit:   requestData.Limit,
+		Offset:  requestData.Offset,
+		OrderBy: "worklist_item.id",
+	}
+	return rs.WorklistStore.FindBy(fields, options, nil)
+}
+
+// changeState changes the state of the workitem to the ProcedureStepState of the request body, with the
+// transaction UID of its performer.
+func (rs *WorkitemsResource) changeState(w http.ResponseWriter, r *http.Request) {
+	item := r.Context().Value(ctxWorkitem).(*models.WorklistItem)
+	attributes, err := readWorkitem(r)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	err = rs.Worklist.ChangeState(item, attributes.String("00741000"), attributes.String(keyTransactionUID))
+	switch {
+	case err == nil:
+		log(r).WithField("workitem", item.SOPInstanceUID).Infof("workitem %s", strings.ToLower(item.ProcedureStepState))
+		w.WriteHeader(http.StatusOK)
+	case errors.Is(err, worklist.ErrTransactionUIDMissing):
+		render.Render(w, r, ErrInvalidRequest(err))
+	case errors.Is(err, worklist.ErrTransactionUIDWrong), errors.Is(err, worklist.ErrInvalidTransition),
+		errors.Is(err, worklist.ErrAlreadyInState):
+		render.Render(w, r, ErrConflict(err))
+	default:
+		log(r).Error(err)
+		render.Render(w, r, ErrInternalServerError)
+	}
+}
+
+// cancelRequest cancels a scheduled workitem, or asks the performer of a workitem in progress to.
+func (rs *WorkitemsResource) cancelRequest(w http.ResponseWriter, r *http.Request) {
+	item := r.Context().Value(ctxWorkitem).(*models.WorklistItem)
+	reason := ""
+	if attributes, err := readWorkitem(r); err == nil {
+		reason = attributes.String(keyReasonForCancellation)
+	}
+
+	err := rs.Worklist.RequestCancel(item, reason)
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusAccepted)
+	case errors.Is(err, worklist.ErrInvalidTransition):
+		render.Render(w, r, ErrConflict(err))
+	default:
+		log(r).Error(err)
+		render.Render(w, r, ErrInternalServerError)
+	}
+}
+
+// subscribe subscribes the AE title to the events of the workitem, or of all workitems with the global
+// subscription UID.
+func (rs *WorkitemsResource) subscribe(w http.ResponseWriter, r *http.Request) {
+	uid := chi.URLParam(r, "workitemUID")
+	deletionLock := r.URL.Query().Get("deletionlock") == "true"
+	var item *models.WorklistItem
+	switch uid {
+	case models.GlobalWorklistSubscription:
+	case upsFilteredGlobalSubscription:
+		render.Render(w, r, ErrInvalidRequest(errFilteredSubscription))
+		return
+	default:
+		var err error
+		if item, err = rs.WorklistStore.GetByUID(uid); err != nil {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+		if item.Final() {
+			render.Render(w, r, ErrConflict(worklist.ErrInvalidTransition))
+			return
+		}
+	}
+
+	if err := rs.Worklist.Subscribe(chi.URLParam(r, "aeTitle"), item, deletionLock); err != nil {
+		var validationErrors validation.Errors
+		if errors.As(err, &validationErrors) {
+			render.Render(w, r, ErrValidation(err, validationErrors))
+			return
+		}
+		log(r).Error(err)
+		render.Render(w, r, ErrInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (rs *WorkitemsResource) unsubscribe(w http.ResponseWriter, r *http.Request) {
+	if err := rs.Worklist.Unsubscribe(chi.URLParam(r, "aeTitle"), chi.URLParam(r, "workitemUID")); err != nil {
+		log(r).Error(err)
+		render.Render(w, r, ErrInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// suspend ends the global subscription of the AE title, which keeps its subscriptions to existing workitems.
+func (rs *WorkitemsResource) suspend(w http.ResponseWriter, r *http.Request) {
+	if chi.URLParam(r, "workitemUID") != models.GlobalWorklistSubscription {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if err := rs.Worklist.Suspend(chi.URLParam(r, "aeTitle")); err != nil {
+		log(r).Error(err)
+		render.Render(w, r, ErrInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// events sends the events of the workitems the AE title subscribed to over a WebSocket, as DICOM JSON event
+// reports, for as long as the connection stays open.
+func (rs *WorkitemsResource) events(w http.ResponseWriter, r *http.Request) {
+	aeTitle := chi.URLParam(r, "aeTitle")
+	server := websocket.Server{
+		// subscribers are not browsers, whatever their origin
+		Handshake: func(config *websocket.Config, r *http.Request) error { return nil },
+		Handler: func(conn *websocket.Conn) {
+			defer conn.Close()
+			events, stop := rs.Worklist.Listen(aeTitle)
+			defer stop()
+			// the subscriber sends nothing, reading tells when it goes away
+			go func() {
+				var message string
+				for websocket.Message.Receive(conn, &message) == nil {
+				}
+				stop()
+			}()
+
+			for event := range events {
+				if err := websocket.JSON.Send(conn, eventReport(event)); err != nil {
+					return
+				}
+			}
+		},
+	}
+	log(r).WithField("ae_title", aeTitle).Info("UPS event subscriber connected")
+	server.ServeHTTP(w, r)
+}
+
+// readWorkitem reads the DICOM JSON data set of the request body, alone or as the only one of an array.
+func readWorkitem(r *http.Request) (dicomwebclient.Attributes, error) {
+	var body json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	var attributes dicomwebclient.Attributes
+	if strings.HasPrefix(strings.TrimSpace(string(body)), "[") {
+		var list []dicomwebclient.Attributes
+		if err := json.Unmarshal(body, &list); err != nil {
+			return nil, err
+		}
+		if len(list) != 1 {
+			return nil, errNoWorkitem
+		}
+		attributes = list[0]
+	} else if err := json.Unmarshal(body, &attributes); err != nil {
+		return nil, err
+	}
+	if attributes == nil {
+		return nil, errNoWorkitem
+	}
+	return attributes, nil
+}
+
+// workitemFromAttributes returns the worklist item of a UPS data set, taking the order from its first
+// ReferencedRequestSequence item and the other attributes from the top level.
+func workitemFromAttributes(attributes dicomwebclient.Attributes) *models.WorklistItem {
+	item := &models.WorklistItem{}
+	value := reflect.ValueOf(item).Elem()
+	setFields := func(attributes dicomwebclient.Attributes) {
+		for i := 0; i < value.NumField(); i++ {
+			tagInfo, err := tag.FindByName(value.Type().Field(i).Tag.Get("dicom"))
+			if err != nil {
+				continue
+			}
+			if s := attributes.String(fmt.Sprintf("%04X%04X", tagInfo.Tag.Group, tagInfo.Tag.Element)); s != "" {
+				value.Field(i).SetString(s)
+			}
+		}
+	}
+	setFields(attributes)
+	if requests := attributes.Items(keyReferencedRequest); len(requests) > 0 {
+		setFields(requests[0])
+	}
+
+	if item.ScheduledProcedureStepDescription == "" {
+		item.ScheduledProcedureStepDescription = attributes.String(keyProcedureStepLabel)
+	}
+	if dateTime := attributes.String(keyStartDateTime); dateTime != "" && item.ScheduledProcedureStepStartDate == "" {
+		// the time zone offset of the date time is dropped
+		dateTime = strings.SplitN(strings.SplitN(dateTime, "+", 2)[0], "-", 2)[0]
+		if len(dateTime) >= 8 {
+			item.ScheduledProcedureStepStartDate, item.ScheduledProcedureStepStartTime = dateTime[:8], dateTime[8:]
+		}
+	}
+	return item
+}
+
+// workitemAttributes returns the UPS data set of a worklist item as DICOM JSON, the order in its
+// ReferencedRequestSequence.
+func workitemAttributes(item *models.WorklistItem) map[string]any {
+	attributes := map[string]any{
+		"00080016":             map[string]any{"vr": "UI", "Value": []string{upsPushSOPClass}},
+		keyProcedureStepLabel:  dicomJSONValue("LO", item.ScheduledProcedureStepDescription),
+		keyStartDateTime:       dicomJSONValue("DT", item.ScheduledProcedureStepStartDate+item.ScheduledProcedureStepStartTime),
+		keyInputReadinessState: dicomJSONValue("CS", "READY"),
+	}
+	request := map[string]any{}
+	value := reflect.ValueOf(item).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		tagInfo, err := tag.FindByName(value.Type().Field(i).Tag.Get("dicom"))
+		if err != nil {
+			continue
+		}
+		key := fmt.Sprintf("%04X%04X", tagInfo.Tag.Group, tagInfo.Tag.Element)
+		if contains(referencedRequestKeywords, tagInfo.Name) {
+			request[key] = dicomJSONValue(tagInfo.VR, value.Field(i).String())
+		} else {
+			attributes[key] = dicomJSONValue(tagInfo.VR, value.Field(i).String())
+		}
+	}
+	attributes[keyReferencedRequest] = map[string]any{"vr": "SQ", "Value": []any{request}}
+	return attributes
+}
+
+// eventReport returns the DICOM JSON event report of a UPS event.
+func eventReport(event *worklist.Event) map[string]any {
+	report := map[string]any{
+		keyAffectedSOPClassUID:    dicomJSONValue("UI", upsEventSOPClass),
+		keyAffectedSOPInstanceUID: dicomJSONValue("UI", event.WorkitemUID),
+		keyEventTypeID:            map[string]any{"vr": "US", "Value": []uint16{event.Type}},
+		"00741000":                dicomJSONValue("CS", event.State),
+	}
+	switch event.Type {
+	case worklist.EventStateReport:
+		report[keyInputReadinessState] = dicomJSONValue("CS", "READY")
+	case worklist.EventCancelRequested:
+		report[keyReasonForCancellation] = dicomJSONValue("LT", event.Reason)
+	}
+	return report
+}
+
+// dicomJSONValue returns the DICOM JSON attribute of a single value, without a value when empty.
+func dicomJSONValue(vr string, value string) map[string]any {
+	if value == "" {
+		return map[string]any{"vr": vr}
+	}
+	if vr == "PN" {
+		return map[string]any{"vr": vr, "Value": []any{map[string]string{"Alphabetic": value}}}
+	}
+	return map[string]any{"vr": vr, "Value": []string{value}}
+}
+
+// dateRange returns the date part of a date time or date time range.
+func dateRange(value string) string {
+	bounds := strings.SplitN(value, "-", 2)
+	for i, bound := range bounds {
+		if len(bound) > 8 {
+			bounds[i] = bound[:8]
+		}
+	}
+	return strings.Join(bounds, "-")
+}
+
+// baseURL returns the DICOMweb base url of the request to the path.
+func baseURL(r *http.Request, path string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + strings.TrimSuffix(strings.TrimSuffix(r.URL.Path, "/"), path)
+}
@@ -0,0 +1,246 @@
+package ris
+
+import (
+	"context"
+	"dicom-store-api/hl7"
+	"dicom-store-api/logging"
+	"dicom-store-api/utils"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-pg/pg"
+	"github.com/suyashkumar/dicom"
+)
+
+// nullValue is the HL7 value telling to delete a field, where an empty field leaves it unchanged.
+const nullValue = `""`
+
+var errPatientIDMissing = errors.New("PID-3 has no patient ID")
+
+// errStoredStudies rejects the updates and merges of patients with stored studies, whose files keep the
+// patient attributes they were received with.
+var errStoredStudies = errors.New("stored studies cannot be updated, correct them in the archive")
+
+// patientUpdate holds the demographics, by field name, to set on the open worklist items of a patient.
+type patientUpdate struct {
+	patientID string
+	fields    map[string]any
+}
+
+// updatePatient applies the demographics of an ADT A08 to the open worklist items of the patient.
+func (l *Listener) updatePatient(ctx context.Context, message *hl7.Message) error {
+	pid := message.Segment("PID")
+	if pid == nil {
+		return errors.New("the message has no PID segment")
+	}
+	if patientID := pid.Get(3, 1); patientID == "" || patientID == nullValue {
+		return errPatientIDMissing
+	}
+	fields := patientFields(pid)
+	if len(fields) == 0 {
+		return nil
+	}
+	patientID, err := l.coercePatient(pid, 3, fields)
+	if err != nil {
+		return err
+	}
+	return l.applyPatients([]patientUpdate{{patientID, fields}})
+}
+
+// mergePatient moves the open worklist items of the prior patient of each MRG segment of an ADT A40 to
+// the patient of the PID before it, with its demographics.
+func (l *Listener) mergePatient(ctx context.Context, message *hl7.Message) error {
+	var pid *hl7.Segment
+	var updates []patientUpdate
+	for _, segment := range message.Segments {
+		switch segment.Name {
+		case "PID":
+			pid = segment
+		case "MRG":
+			if pid == nil {
+				return errors.New("MRG segment without a PID segment before it")
+			}
+			patientID, priorID := pid.Get(3, 1), segment.Get(1, 1)
+			if patientID == "" || patientID == nullValue {
+				return errPatientIDMissing
+			}
+			if priorID == "" || priorID == nullValue {
+				return errors.New("MRG-1 has no prior patient ID")
+			}
+			fields := patientFields(pid)
+			var err error
+			if patientID, err = l.coercePatient(pid, 3, fields); err != nil {
+				return err
+			}
+			if priorID, err = l.coercePatient(segment, 1, nil); err != nil {
+				return err
+			}
+			fields["PatientID"] = patientID
+			updates = append(updates, patientUpdate{priorID, fields})
+		}
+	}
+	if len(updates) == 0 {
+		return errors.New("the message has no MRG segment")
+	}
+	return l.applyPatients(updates)
+}
+
+// applyPatients sets the fields of the open worklist items of the patients in a single transaction, unless
+// one of the patients has stored studies.
+func (l *Listener) applyPatients(updates []patientUpdate) error {
+	return l.DB.RunInTransaction(func(tx *pg.Tx) error {
+		for _, update := range updates {
+			studies, err := l.StudyStore.CountPatient(update.patientID, tx)
+			if err != nil {
+				return err
+			}
+			if studies > 0 {
+				return fmt.Errorf("patient %s has %d studies: %w", update.patientID, studies, errStoredStudies)
+			}
+		}
+		for _, update := range updates {
+			items, err := l.WorklistStore.UpdatePatient(update.patientID, update.fields, tx)
+			if err != nil {
+				return err
+			}
+			logger := logging.Logger.WithField("module", "hl7").WithField("patient_id", update.patientID)
+			if newID, ok := update.fields["PatientID"]; ok && newID != update.patientID {
+				logger = logger.WithField("merged_into", newID)
+			}
+			logger.Infof("patient updated in %d worklist items", items)
+		}
+		return nil
+	})
+}
+
+// coercePatient returns the patient ID of a CX field of the segment as the coercion rules of stored instances
+// change it, given its issuer, and coerces the demographics of the fields, keyed by attribute keyword, in place.
+func (l *Listener) coercePatient(segment *hl7.Segment, field int, fields map[string]any) (string, error) {
+	patientID := segment.Get(field, 1)
+	if l.Coercion == nil {
+		return patientID, nil
+	}
+	values := map[string]string{"PatientID": patientID}
+	if issuer := segment.Get(field, 4); issuer != nullValue {
+		values["IssuerOfPatientID"] = issuer
+	}
+	for keyword, value := range fields {
+		if value, ok := value.(string); ok {
+			values[keyword] = value
+		}
+	}
+
+	var dataset dicom.Dataset
+	for keyword, value := range values {
+		if value == "" {
+			continue
+		}
+		t, err := utils.GetTagByNameOrCode(keyword)
+		if err != nil {
+			return "", err
+		}
+		element, err := dicom.NewElement(t, []string{value})
+		if err != nil {
+			return "", err
+		}
+		dataset.Elements = append(dataset.Elements, element)
+	}
+	sort.Slice(dataset.Elements, func(i, j int) bool {
+		return dataset.Elements[i].Tag.Compare(dataset.Elements[j].Tag) < 0
+	})
+	if _, err := l.Coercion.Apply(&dataset); err != nil {
+		return "", err
+	}
+
+	coerced := func(keyword string) string {
+		t, _ := utils.GetTagByNameOrCode(keyword)
+		element, err := dataset.FindElementByTag(t)
+		if err != nil || element.Value.ValueType() != dicom.Strings {
+			return ""
+		}
+		return strings.Join(dicom.MustGetStrings(element.Value), `\`)
+	}
+	for keyword := range fields {
+		if _, ok := fields[keyword].(string); ok {
+			fields[keyword] = coerced(keyword)
+		}
+	}
+	if patientID = coerced("PatientID"); patientID == "" {
+		return "", errors.New("the coercion rules remove the patient ID")
+	}
+	return patientID, nil
+}
+
+// patientFields returns the demographics of the PID segment by WorklistItem field, leaving out the empty fields
+// and with an empty value for those deleted.
+func patientFields(pid *hl7.Segment) map[string]any {
+	fields := map[string]any{}
+	if value := pid.Field(5); value == nullValue {
+		fields["PatientName"] = ""
+	} else if value != "" {
+		fields["PatientName"] = personName(pid, 5, 1)
+	}
+	if value := pid.Get(7, 1); value == nullValue {
+		fields["PatientBirthDate"] = ""
+	} else if validDate(date(value)) {
+		fields["PatientBirthDate"] = date(value)
+	}
+	if value := pid.Get(8, 1); value != "" {
+		fields["PatientSex"] = patientSex(value)
+	}
+	return fields
+}
+
+// personName returns the DICOM person name of an XPN or XCN field, whose family name is the component at
+// offset: family^given^middle^suffix^prefix becomes family^given^middle^prefix^suffix.
+func personName(segment *hl7.Segment, field int, offset int) string {
+	components := segment.Components(field)
+	if len(components) < offset {
+		return ""
+	}
+	name := make([]string, 5)
+	copy(name, components[offset-1:])
+	name[3], name[4] = name[4], name[3]
+	for i, component := range name {
+		if component == nullValue {
+			component = ""
+		}
+		// an escaped component separator would split the DICOM name
+		name[i] = strings.ReplaceAll(component, "^", " ")
+	}
+	return strings.TrimRight(strings.Join(name, "^"), "^")
+}
+
+// date returns the date of an HL7 timestamp.
+func date(timestamp string) string {
+	if len(timestamp) < 8 {
+		return ""
+	}
+	return timestamp[:8]
+}
+
+// patientSex returns the DICOM value of an administrative sex, empty when unknown.
+func patientSex(sex string) string {
+	switch sex {
+	case "M", "F":
+		return sex
+	case "U", nullValue:
+		return ""
+	}
+	return "O"
+}
+
+// validDate reports whether the value is a DICOM date.
+func validDate(value string) bool {
+	if len(value) != 8 {
+		return false
+	}
+	for _, c := range value {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
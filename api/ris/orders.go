@@ -0,0 +1,284 @@
+package ris
+
+import (
+	"context"
+	"dicom-store-api/hl7"
+	"dicom-store-api/logging"
+	"dicom-store-api/models"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// orderGroup is an ORC segment with the OBR of the order and, in OMI messages, its timing and the IPC
+// segments of its procedure steps.
+type orderGroup struct {
+	orc  *hl7.Segment
+	tq1  *hl7.Segment
+	obr  *hl7.Segment
+	ipcs []*hl7.Segment
+}
+
+// order schedules, changes or cancels the worklist items of the orders of an ORM O01 or OMI O23, as their
+// ORC-1 order control tells: NW, XO and CA, OC or DC. The procedure steps are those of the IPC segments,
+// or else the attributes of the OBR segment as IHE Scheduled Workflow maps them.
+func (l *Listener) order(ctx context.Context, message *hl7.Message) error {
+	pid := message.Segment("PID")
+	if pid == nil {
+		return errors.New("the message has no PID segment")
+	}
+	if patientID := pid.Get(3, 1); patientID == "" || patientID == nullValue {
+		return errPatientIDMissing
+	}
+	groups := orderGroups(message)
+	if len(groups) == 0 {
+		return errors.New("the message has no ORC and OBR segments")
+	}
+
+	for _, group := range groups {
+		logger := logging.Logger.WithField("module", "hl7").WithField("control_id", message.ControlID())
+		control := group.orc.Get(1, 1)
+		for _, item := range group.workitems(message, pid) {
+			if err := l.coerceItem(pid, item); err != nil {
+				return err
+			}
+			logger := logger.WithField("accession_number", item.AccessionNumber).
+				WithField("scheduled_procedure_step_id", item.ScheduledProcedureStepID)
+			var err error
+			switch control {
+			case "NW":
+				err = l.schedule(item, logger)
+			case "XO":
+				err = l.reschedule(item, logger)
+			case "CA", "OC", "DC":
+				err = l.cancel(item, first(get(group.orc, 16, 2), "order canceled"), logger)
+			default:
+				logger.Infof("order control %s ignored", control)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// schedule creates the item, unless the order was already received.
+func (l *Listener) schedule(item *models.WorklistItem, logger logrus.FieldLogger) error {
+	existing, err := l.matchingItems(item)
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		logger.Info("procedure step already scheduled")
+		return nil
+	}
+	if err := l.Worklist.Create(item); err != nil {
+		return err
+	}
+	logger.WithField("workitem", item.SOPInstanceUID).Info("procedure step scheduled")
+	return nil
+}
+
+// reschedule changes the items of the order still scheduled, or schedules the item when there are none.
+func (l *Listener) reschedule(item *models.WorklistItem, logger logrus.FieldLogger) error {
+	existing, err := l.matchingItems(item)
+	if err != nil {
+		return err
+	}
+	if len(existing) == 0 {
+		return l.schedule(item, logger)
+	}
+	for _, old := range existing {
+		if old.ProcedureStepState != models.WorklistStateScheduled {
+			logger.WithField("workitem", old.SOPInstanceUID).Warnf("procedure step %s, not changed", strings.ToLower(old.ProcedureStepState))
+			continue
+		}
+		item.ID, item.CreatedAt, item.LinkedAt = old.ID, old.CreatedAt, old.LinkedAt
+		item.SOPInstanceUID, item.ProcedureStepState = old.SOPInstanceUID, old.ProcedureStepState
+		if item.ScheduledProcedureStepPriority == "" {
+			item.ScheduledProcedureStepPriority = old.ScheduledProcedureStepPriority
+		}
+		if err := l.Worklist.Update(item); err != nil {
+			return err
+		}
+		logger.WithField("workitem", item.SOPInstanceUID).Info("procedure step changed")
+	}
+	return nil
+}
+
+// cancel cancels the items of the order, or asks the performers of those in progress to.
+func (l *Listener) cancel(item *models.WorklistItem, reason string, logger logrus.FieldLogger) error {
+	existing, err := l.matchingItems(item)
+	if err != nil {
+		return err
+	}
+	for _, old := range existing {
+		if old.Final() {
+			continue
+		}
+		if err := l.Worklist.RequestCancel(old, reason); err != nil {
+			return err
+		}
+		logger.WithField("workitem", old.SOPInstanceUID).Info("procedure step cancellation requested")
+	}
+	return nil
+}
+
+// coerceItem coerces the patient of the item as that of the stored instances, for the updates and merges
+// of the patient to find it.
+func (l *Listener) coerceItem(pid *hl7.Segment, item *models.WorklistItem) error {
+	fields := map[string]any{
+		"PatientName":      item.PatientName,
+		"PatientBirthDate": item.PatientBirthDate,
+		"PatientSex":       item.PatientSex,
+	}
+	patientID, err := l.coercePatient(pid, 3, fields)
+	if err != nil {
+		return err
+	}
+	item.PatientID = patientID
+	item.PatientName = fields["PatientName"].(string)
+	item.PatientBirthDate = fields["PatientBirthDate"].(string)
+	item.PatientSex = fields["PatientSex"].(string)
+	return nil
+}
+
+// matchingItems returns the items with the accession number and scheduled procedure step ID of the item.
+func (l *Listener) matchingItems(item *models.WorklistItem) ([]*models.WorklistItem, error) {
+	if item.AccessionNumber == "" {
+		return nil, nil
+	}
+	fields := map[string]any{"AccessionNumber": item.AccessionNumber}
+	if item.ScheduledProcedureStepID != "" {
+		fields["ScheduledProcedureStepID"] = item.ScheduledProcedureStepID
+	}
+	return l.WorklistStore.FindBy(fields, nil, nil)
+}
+
+// orderGroups returns the orders of the message, an OBR following another within an ORC starting another.
+func orderGroups(message *hl7.Message) []*orderGroup {
+	var groups []*orderGroup
+	var current *orderGroup
+	for _, segment := range message.Segments {
+		switch segment.Name {
+		case "ORC":
+			current = &orderGroup{orc: segment}
+			groups = append(groups, current)
+		case "TQ1":
+			if current != nil && current.tq1 == nil {
+				current.tq1 = segment
+			}
+		case "OBR":
+			if current == nil {
+				continue
+			}
+			if current.obr != nil {
+				current = &orderGroup{orc: current.orc, tq1: current.tq1}
+				groups = append(groups, current)
+			}
+			current.obr = segment
+		case "IPC":
+			if current != nil {
+				current.ipcs = append(current.ipcs, segment)
+			}
+		}
+	}
+
+	orders := groups[:0]
+	for _, group := range groups {
+		if group.obr != nil {
+			orders = append(orders, group)
+		}
+	}
+	return orders
+}
+
+// workitems returns the worklist items of the procedure steps of the order.
+func (g *orderGroup) workitems(message *hl7.Message, pid *hl7.Segment) []*models.WorklistItem {
+	if len(g.ipcs) == 0 {
+		return []*models.WorklistItem{g.workitem(message, pid, nil)}
+	}
+	items := make([]*models.WorklistItem, len(g.ipcs))
+	for i, ipc := range g.ipcs {
+		items[i] = g.workitem(message, pid, ipc)
+	}
+	return items
+}
+
+func (g *orderGroup) workitem(message *hl7.Message, pid *hl7.Segment, ipc *hl7.Segment) *models.WorklistItem {
+	pv1, zds := message.Segment("PV1"), message.Segment("ZDS")
+	item := &models.WorklistItem{
+		PatientID:  pid.Get(3, 1),
+		PatientSex: patientSex(pid.Get(8, 1)),
+	}
+	if pid.Field(5) != nullValue {
+		item.PatientName = personName(pid, 5, 1)
+	}
+	if birthDate := date(pid.Get(7, 1)); validDate(birthDate) {
+		item.PatientBirthDate = birthDate
+	}
+	if pv1 != nil && get(pv1, 8, 2) != "" {
+		item.ReferringPhysicianName = personName(pv1, 8, 2)
+	} else if get(g.obr, 16, 2) != "" {
+		item.ReferringPhysicianName = personName(g.obr, 16, 2)
+	}
+
+	item.AccessionNumber = first(get(ipc, 1, 1), get(g.obr, 18, 1), get(g.obr, 3, 1), get(g.orc, 3, 1))
+	item.RequestedProcedureID = first(get(ipc, 2, 1), get(g.obr, 19, 1), item.AccessionNumber)
+	item.StudyInstanceUID = first(get(ipc, 3, 1), get(zds, 1, 1))
+	item.ScheduledProcedureStepID = first(get(ipc, 4, 1), get(g.obr, 20, 1), item.RequestedProcedureID)
+	item.Modality = first(get(ipc, 5, 1), get(g.obr, 24, 1))
+	item.ScheduledStationAETitle = get(ipc, 9, 1)
+	item.RequestedProcedureDescription = first(get(g.obr, 4, 2), get(g.obr, 4, 1))
+	item.ScheduledProcedureStepDescription = first(get(ipc, 6, 2), item.RequestedProcedureDescription)
+
+	start := first(get(g.tq1, 7, 1), get(g.obr, 27, 4), get(g.orc, 7, 4), get(g.obr, 36, 1))
+	item.ScheduledProcedureStepStartDate, item.ScheduledProcedureStepStartTime = dateTime(start)
+	item.ScheduledProcedureStepPriority = priority(first(get(g.tq1, 9, 1), get(g.obr, 27, 6), get(g.orc, 7, 6)))
+	return item
+}
+
+// get returns a component of a field of the segment, which may be nil, null values as empty.
+func get(segment *hl7.Segment, field int, component int) string {
+	if segment == nil {
+		return ""
+	}
+	if value := segment.Get(field, component); value != nullValue {
+		return value
+	}
+	return ""
+}
+
+func first(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// dateTime returns the date and time of an HL7 timestamp without its time zone, today when it has no date.
+func dateTime(timestamp string) (string, string) {
+	if i := strings.IndexAny(timestamp, "+-"); i >= 0 {
+		timestamp = timestamp[:i]
+	}
+	if !validDate(date(timestamp)) {
+		return time.Now().Format("20060102"), ""
+	}
+	return timestamp[:8], timestamp[8:]
+}
+
+// priority returns the DICOM priority of a TQ1 or TQ priority, empty for the default.
+func priority(value string) string {
+	switch value {
+	case "":
+		return ""
+	case "S", "A", "T":
+		return "HIGH"
+	}
+	return "MEDIUM"
+}
@@ -0,0 +1,93 @@
+// Package ris applies the HL7 v2 messages of the radiology information system: patient updates and merges
+// correct the demographics of the worklist items, and orders schedule worklist items.
+//
+// Patient IDs and demographics go through the coercion rules of stored instances first, so that they match
+// the stored values. Updates and merges of patients with stored studies are rejected: their files are sent
+// as received by every retrieve, C-MOVE, C-GET and route, and would no longer match the studies searched.
+package ris
+
+import (
+	"dicom-store-api/coercion"
+	"dicom-store-api/database"
+	"dicom-store-api/hl7"
+	"dicom-store-api/logging"
+	"dicom-store-api/models"
+	"dicom-store-api/worklist"
+	"strings"
+
+	"github.com/go-pg/pg"
+	"github.com/spf13/viper"
+)
+
+type StudyStore interface {
+	CountPatient(patientID string, tx *pg.Tx) (int, error)
+}
+type WorklistStore interface {
+	FindBy(fields map[string]any, options *database.SelectQueryOptions, tx *pg.Tx) ([]*models.WorklistItem, error)
+	UpdatePatient(patientID string, fields map[string]any, tx *pg.Tx) (int, error)
+}
+
+// Listener receives the HL7 messages of the RIS over MLLP and acknowledges them once applied.
+type Listener struct {
+	Server        *hl7.Server
+	Addr          string
+	DB            *pg.DB
+	StudyStore    StudyStore
+	WorklistStore WorklistStore
+	Worklist      *worklist.Manager
+	// Coercion is applied to the patient of each message, as it is to the datasets of stored instances.
+	Coercion *coercion.Engine
+}
+
+// NewListener returns a Listener on the address handling ADT A08 and A40, ORM O01 and OMI O23 messages.
+func NewListener(addr string, db *pg.DB, studyStore StudyStore, worklistStore WorklistStore, manager *worklist.Manager,
+	coercionEngine *coercion.Engine) *Listener {
+	l := &Listener{
+		Server:        hl7.NewServer(),
+		Addr:          addr,
+		DB:            db,
+		StudyStore:    studyStore,
+		WorklistStore: worklistStore,
+		Worklist:      manager,
+		Coercion:      coercionEngine,
+	}
+	l.Server.Handle("ADT^A08", l.updatePatient)
+	l.Server.Handle("ADT^A40", l.mergePatient)
+	l.Server.Handle("ORM^O01", l.order)
+	l.Server.Handle("OMI^O23", l.order)
+	return l
+}
+
+// NewListenerFromConfig returns a Listener on the configured port with the configured idle timeout.
+func NewListenerFromConfig(db *pg.DB, manager *worklist.Manager, coercionEngine *coercion.Engine) *Listener {
+	viper.SetDefault("hl7.port", "2575")
+	viper.SetDefault("hl7.idle_timeout", "5m")
+
+	addr := viper.GetString("hl7.port")
+	if !strings.Contains(addr, ":") {
+		addr = ":" + addr
+	}
+	l := NewListener(addr, db, database.NewStudyStore(db), database.NewWorklistStore(db), manager, coercionEngine)
+	l.Server.IdleTimeout = viper.GetDuration("hl7.idle_timeout")
+	return l
+}
+
+// ListenAndServe serves connections until Close.
+func (l *Listener) ListenAndServe() error {
+	logging.Logger.WithField("module", "hl7").Infof("listening on %s", l.Addr)
+	return l.Server.ListenAndServe(l.Addr)
+}
+
+// Start serves connections in the background.
+func (l *Listener) Start() {
+	go func() {
+		if err := l.ListenAndServe(); err != hl7.ErrServerClosed {
+			logging.Logger.WithField("module", "hl7").Error(err)
+		}
+	}()
+}
+
+// Close stops listening and closes the connections.
+func (l *Listener) Close() error {
+	return l.Server.Close()
+}
@@ -0,0 +1,71 @@
+package scp
+
+import (
+	"context"
+	"dicom-store-api/dimse"
+	"dicom-store-api/logging"
+	"dicom-store-api/models"
+
+	"github.com/suyashkumar/dicom/pkg/tag"
+)
+
+// actionRequestCommitment is the action type of storage commitment requests.
+const actionRequestCommitment uint16 = 1
+
+// commit records a storage commitment request and answers it at once, the result is reported later
+// with N-EVENT-REPORT.
+func (scp *SCP) commit(ctx context.Context, request *dimse.Request) {
+	logger := logging.Logger.WithField("module", "dimse").WithField("calling_ae", request.Association.CallingAETitle)
+	if scp.Commitment == nil {
+		request.RespondStatus(dimse.StatusProcessingFailure, "storage commitment is not available")
+		return
+	}
+	if request.Command.RequestedSOPClassUID != dimse.StorageCommitmentPushModel {
+		request.RespondStatus(dimse.StatusSOPClassNotSupported, "")
+		return
+	}
+	if request.Command.RequestedSOPInstanceUID != dimse.StorageCommitmentPushModelInstance {
+		request.RespondStatus(dimse.StatusNoSuchSOPInstance, "")
+		return
+	}
+	if request.Command.ActionTypeID != actionRequestCommitment {
+		request.RespondStatus(dimse.StatusNoSuchActionType, "")
+		return
+	}
+
+	data, err := dimse.DecodeDataSet(request.Data, request.TransferSyntax)
+	if err != nil {
+		request.RespondStatus(dimse.StatusInvalidArgumentValue, err.Error())
+		return
+	}
+	commitment := &models.StorageCommitment{
+		TransactionUID: data.String(tag.TransactionUID),
+		CallingAETitle: request.Association.CallingAETitle,
+	}
+	if element := data.Get(tag.ReferencedSOPSequence); element != nil {
+		for _, item := range element.Items {
+			commitment.Items = append(commitment.Items, &models.StorageCommitmentItem{
+				SOPClassUID:    item.String(tag.ReferencedSOPClassUID),
+				SOPInstanceUID: item.String(tag.ReferencedSOPInstanceUID),
+			})
+		}
+	}
+	if commitment.TransactionUID == "" || len(commitment.Items) == 0 {
+		request.RespondStatus(dimse.StatusInvalidArgumentValue, "missing transaction UID or referenced instances")
+		return
+	}
+	for _, item := range commitment.Items {
+		if item.SOPInstanceUID == "" {
+			request.RespondStatus(dimse.StatusInvalidArgumentValue, "referenced instance without UID")
+			return
+		}
+	}
+
+	if err := scp.Commitment.Submit(commitment, request.Association); err != nil {
+		logger.Errorf("storage commitment: %v", err)
+		request.RespondStatus(dimse.StatusProcessingFailure, "")
+		return
+	}
+	logger.WithField("transaction", commitment.TransactionUID).Infof("storage commitment of %d instances requested", len(commitment.Items))
+	request.Respond(&dimse.Command{Status: dimse.StatusSuccess, ActionTypeID: actionRequestCommitment}, nil)
+}
@@ -0,0 +1,282 @@
+package scp
+
+import (
+	"context"
+	"dicom-store-api/api/dicomweb"
+	"dicom-store-api/dimse"
+	"dicom-store-api/logging"
+	"dicom-store-api/models"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/suyashkumar/dicom/pkg/tag"
+)
+
+// QueryLevelPatient is the patient level of Patient Root queries, answered from the studies of the patients.
+const QueryLevelPatient = "PATIENT"
+
+// patientKeywords are the attributes of the patient level.
+var patientKeywords = []string{"PatientName", "PatientID", "PatientBirthDate", "PatientSex"}
+
+// uniqueKeys are returned at each level whether requested or not.
+var uniqueKeys = map[string]tag.Tag{
+	QueryLevelPatient:           tag.PatientID,
+	dicomweb.QueryLevelStudy:    tag.StudyInstanceUID,
+	dicomweb.QueryLevelSeries:   tag.SeriesInstanceUID,
+	dicomweb.QueryLevelInstance: tag.SOPInstanceUID,
+}
+
+// specificCharacterSet is the character set of the values returned, as they are stored in UTF-8.
+const specificCharacterSet = "ISO_IR 192"
+
+// find answers Patient Root and Study Root C-FIND requests with a pending response per match, with the keys
+// of the identifier received, through the same filters as QIDO. Modality Worklist requests go to findWorklist.
+func (scp *SCP) find(ctx context.Context, request *dimse.Request) {
+	if request.AbstractSyntax == dimse.ModalityWorklistFind {
+		scp.findWorklist(ctx, request)
+		return
+	}
+	logger := logging.Logger.WithField("module", "dimse").WithField("calling_ae", request.Association.CallingAETitle)
+
+	identifier, err := dimse.DecodeDataSet(request.Data, request.TransferSyntax)
+	if err != nil {
+		request.RespondStatus(dimse.StatusDataSetDoesNotMatchClass, err.Error())
+		return
+	}
+	level := identifier.String(tag.QueryRetrieveLevel)
+	if _, ok := uniqueKeys[level]; !ok || (level == QueryLevelPatient && request.AbstractSyntax != dimse.PatientRootQueryRetrieveFind) {
+		request.RespondStatus(dimse.StatusDataSetDoesNotMatchClass, fmt.Sprintf("invalid query level %q", level))
+		return
+	}
+
+	matches, err := scp.findMatches(level, identifier)
+	if err != nil {
+		logger.Errorf("C-FIND failed: %v", err)
+		request.RespondStatus(dimse.StatusUnableToProcess, err.Error())
+		return
+	}
+	logger.Infof("C-FIND at %s level with %d matches", level, len(matches))
+
+	status := dimse.StatusPending
+	if !scp.supportsKeys(level, identifier) {
+		status = dimse.StatusPendingWarning
+	}
+	for _, match := range matches {
+		select {
+		case <-ctx.Done():
+			request.RespondStatus(dimse.StatusCancel, "")
+			return
+		default:
+		}
+		data, err := scp.responseIdentifier(level, identifier, match).Encode(request.TransferSyntax)
+		if err != nil {
+			request.RespondStatus(dimse.StatusUnableToProcess, err.Error())
+			return
+		}
+		if err := request.Respond(&dimse.Command{Status: status}, data); err != nil {
+			return
+		}
+	}
+	request.RespondStatus(dimse.StatusSuccess, "")
+}
+
+// findMatches returns the attributes of the matches of the identifier at the level, by keyword.
+func (scp *SCP) findMatches(level string, identifier *dimse.DataSet) ([]map[string]string, error) {
+	searchLevel := level
+	if level == QueryLevelPatient {
+		searchLevel = dicomweb.QueryLevelStudy
+	}
+	keywords := levelKeywords(searchLevel)
+
+	requestData := &dicomweb.QIDORequest{
+		Limit:            scp.FindLimit,
+		IncludedFields:   map[tag.Tag]bool{},
+		IncludeAllFields: true,
+		Filters:          map[tag.Tag][]string{},
+	}
+	for _, element := range identifier.Elements {
+		tagInfo, err := tag.Find(element.Tag)
+		if err != nil || element.VR == "SQ" || !keywords[tagInfo.Name] {
+			continue
+		}
+		value := identifier.String(element.Tag)
+		if value == "" {
+			continue
+		}
+		if tagInfo.VR == "UI" {
+			requestData.Filters[element.Tag] = strings.Split(value, `\`)
+		} else {
+			requestData.Filters[element.Tag] = []string{value}
+		}
+	}
+
+	if level == QueryLevelPatient {
+		return scp.findPatients(requestData)
+	}
+	objects, err := scp.QIDO.Search(searchLevel, requestData)
+	if err != nil {
+		return nil, err
+	}
+	var matches []map[string]string
+	for _, object := range objects {
+		matches = append(matches, objectAttributes(object))
+	}
+	return matches, nil
+}
+
+// findPatients returns the attributes of the patients of the studies matching the request.
+func (scp *SCP) findPatients(requestData *dicomweb.QIDORequest) ([]map[string]string, error) {
+	patients, err := scp.QIDO.SearchPatients(requestData)
+	if err != nil {
+		return nil, err
+	}
+	var matches []map[string]string
+	for _, patient := range patients {
+		matches = append(matches, map[string]string{
+			"PatientName":                     patient.PatientName,
+			"PatientID":                       patient.PatientID,
+			"PatientBirthDate":                patient.PatientBirthDate,
+			"PatientSex":                      patient.PatientSex,
+			"NumberOfPatientRelatedStudies":   strconv.Itoa(patient.NumberOfPatientRelatedStudies),
+			"NumberOfPatientRelatedSeries":    strconv.Itoa(patient.NumberOfPatientRelatedSeries),
+			"NumberOfPatientRelatedInstances": strconv.Itoa(patient.NumberOfPatientRelatedInstances),
+		})
+	}
+	return matches, nil
+}
+
+// responseIdentifier returns the keys of the identifier with the values of the match.
+func (scp *SCP) responseIdentifier(level string, identifier *dimse.DataSet, match map[string]string) *dimse.DataSet {
+	response := &dimse.DataSet{}
+	for _, element := range identifier.Elements {
+		switch element.Tag {
+		case tag.QueryRetrieveLevel:
+			response.Set(element.Tag, level)
+			continue
+		case tag.SpecificCharacterSet:
+			response.Set(element.Tag, specificCharacterSet)
+			continue
+		case tag.RetrieveAETitle:
+			response.Set(element.Tag, scp.Server.AETitle)
+			continue
+		}
+
+		tagInfo, err := tag.Find(element.Tag)
+		value, ok := match[tagInfo.Name]
+		switch {
+		case err != nil || !ok:
+			if element.VR == "SQ" {
+				response.SetItems(element.Tag, nil)
+			} else {
+				response.SetValue(element.Tag, element.VR, nil)
+			}
+		case tagInfo.VR == "SQ":
+			response.SetItems(element.Tag, nil)
+		default:
+			response.Set(element.Tag, value)
+		}
+	}
+	uniqueKey := uniqueKeys[level]
+	if !response.Has(uniqueKey) {
+		tagInfo, _ := tag.Find(uniqueKey)
+		response.Set(uniqueKey, match[tagInfo.Name])
+	}
+	if !response.Has(tag.QueryRetrieveLevel) {
+		response.Set(tag.QueryRetrieveLevel, level)
+	}
+	return response
+}
+
+// supportsKeys reports whether every key of the identifier can be returned.
+func (scp *SCP) supportsKeys(level string, identifier *dimse.DataSet) bool {
+	keywords := levelKeywords(level)
+	for _, element := range identifier.Elements {
+		switch element.Tag {
+		case tag.QueryRetrieveLevel, tag.SpecificCharacterSet, tag.RetrieveAETitle:
+			continue
+		}
+		tagInfo, err := tag.Find(element.Tag)
+		if err != nil || !keywords[tagInfo.Name] {
+			return false
+		}
+	}
+	return true
+}
+
+// levelKeywords returns the keywords of the attributes of the level and the levels above.
+func levelKeywords(level string) map[string]bool {
+	keywords := map[string]bool{}
+	if level == QueryLevelPatient {
+		for _, keyword := range patientKeywords {
+			keywords[keyword] = true
+		}
+		keywords["NumberOfPatientRelatedStudies"] = true
+		keywords["NumberOfPatientRelatedSeries"] = true
+		keywords["NumberOfPatientRelatedInstances"] = true
+		return keywords
+	}
+
+	objects := []models.DicomObject{&models.Study{}}
+	switch level {
+	case dicomweb.QueryLevelSeries:
+		objects = append(objects, &models.Series{})
+	case dicomweb.QueryLevelInstance:
+		objects = append(objects, &models.Series{}, &models.Instance{})
+	}
+	for _, object := range objects {
+		reflection := reflect.TypeOf(object).Elem()
+		for i := 0; i < reflection.NumField(); i++ {
+			if keyword := reflection.Field(i).Tag.Get("dicom"); keyword != "" {
+				keywords[keyword] = true
+			}
+		}
+	}
+	return keywords
+}
+
+// objectAttributes returns the DICOM attributes of a study, series or instance and its parents by keyword,
+// multi-valued attributes joined with backslashes.
+func objectAttributes(object models.DicomObject) map[string]string {
+	attributes := map[string]string{}
+	for object != nil && !reflect.ValueOf(object).IsNil() {
+		value := reflect.ValueOf(object).Elem()
+		for i := 0; i < value.NumField(); i++ {
+			keyword := value.Type().Field(i).Tag.Get("dicom")
+			if keyword == "" {
+				continue
+			}
+			attributes[keyword] = attributeValue(keyword, value.Field(i).String())
+		}
+
+		switch parent := object.(type) {
+		case *models.Instance:
+			object = parent.Series
+		case *models.Series:
+			object = parent.Study
+		default:
+			object = nil
+		}
+	}
+	return attributes
+}
+
+func attributeValue(keyword string, value string) string {
+	if !strings.HasPrefix(value, "[") {
+		return value
+	}
+	if tagInfo, err := tag.FindByName(keyword); err != nil || tagInfo.VM == "1" {
+		return value
+	}
+	var values []any
+	if err := json.Unmarshal([]byte(value), &values); err != nil {
+		return value
+	}
+	var formatted []string
+	for _, v := range values {
+		formatted = append(formatted, fmt.Sprintf("%v", v))
+	}
+	return strings.Join(formatted, `\`)
+}
@@ -0,0 +1,251 @@
+package scp
+
+import (
+	"context"
+	"dicom-store-api/api/dicomweb"
+	"dicom-store-api/dimse"
+	"dicom-store-api/fs"
+	"dicom-store-api/logging"
+	"dicom-store-api/models"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/suyashkumar/dicom/pkg/tag"
+)
+
+// RemoteNodeStore resolves the destinations of C-MOVE requests.
+type RemoteNodeStore interface {
+	GetByAETitle(aeTitle string) (*models.RemoteNode, error)
+}
+
+// move answers C-MOVE requests by sending the matching instances to the destination AE over a new association.
+func (scp *SCP) move(ctx context.Context, request *dimse.Request) {
+	logger := logging.Logger.WithField("module", "dimse").
+		WithField("calling_ae", request.Association.CallingAETitle).
+		WithField("destination", request.Command.MoveDestination)
+
+	var node *models.RemoteNode
+	var err error
+	if scp.RemoteNodeStore != nil {
+		node, err = scp.RemoteNodeStore.GetByAETitle(request.Command.MoveDestination)
+	}
+	// nodes reached with DICOMweb only are no C-MOVE destinations
+	if node == nil || err != nil || node.Host == "" {
+		logger.Warn("C-MOVE to an unknown destination")
+		request.RespondStatus(dimse.StatusMoveDestinationUnknown, "")
+		return
+	}
+
+	instances, ok := scp.retrieveInstances(request, logger)
+	if !ok {
+		return
+	}
+	if len(instances) == 0 {
+		request.Respond(&dimse.Command{Status: dimse.StatusSuccess, SubOperations: &dimse.SubOperations{}}, nil)
+		return
+	}
+
+	// propose a presentation context for every SOP class and transfer syntax of the files to send
+	var contexts []*dimse.PresentationContext
+	proposed := map[string]bool{}
+	for _, instance := range instances {
+		meta, err := readFileMeta(instance)
+		if err != nil {
+			continue
+		}
+		if key := meta.SOPClassUID + "|" + meta.TransferSyntax; !proposed[key] && len(contexts) < 128 {
+			proposed[key] = true
+			contexts = append(contexts, &dimse.PresentationContext{
+				AbstractSyntax:   meta.SOPClassUID,
+				TransferSyntaxes: []string{meta.TransferSyntax},
+			})
+		}
+	}
+
+	var destination *dimse.Association
+	if len(contexts) > 0 {
+		destination, err = dimse.Dial(ctx, node.Addr(), scp.Server.AETitle, node.AETitle, contexts, nil)
+	}
+	if destination == nil {
+		logger.Warnf("C-MOVE sub-association failed: %v", err)
+		counts := &subOperations{failed: len(instances)}
+		request.Respond(&dimse.Command{Status: dimse.StatusOutOfResourcesSubOps, SubOperations: counts.command(), ErrorComment: fmt.Sprint(err)}, nil)
+		return
+	}
+	defer destination.Release()
+
+	scp.sendInstances(ctx, request, instances, destination, request.Association.CallingAETitle, request.Command.MessageID, logger)
+}
+
+// get answers C-GET requests by sending the matching instances over the same association, on the storage
+// presentation contexts the requestor proposed with the SCP role.
+func (scp *SCP) get(ctx context.Context, request *dimse.Request) {
+	logger := logging.Logger.WithField("module", "dimse").WithField("calling_ae", request.Association.CallingAETitle)
+
+	instances, ok := scp.retrieveInstances(request, logger)
+	if !ok {
+		return
+	}
+	scp.sendInstances(ctx, request, instances, request.Association, "", 0, logger)
+}
+
+// retrieveInstances returns the instances matching the unique keys of a C-MOVE or C-GET identifier.
+// It responds to the request itself when the identifier is invalid.
+func (scp *SCP) retrieveInstances(request *dimse.Request, logger logrus.FieldLogger) ([]*models.Instance, bool) {
+	identifier, err := dimse.DecodeDataSet(request.Data, request.TransferSyntax)
+	if err != nil {
+		request.RespondStatus(dimse.StatusDataSetDoesNotMatchClass, err.Error())
+		return nil, false
+	}
+	level := identifier.String(tag.QueryRetrieveLevel)
+	patientRoot := request.AbstractSyntax == dimse.PatientRootQueryRetrieveMove || request.AbstractSyntax == dimse.PatientRootQueryRetrieveGet
+	uniqueKey, ok := uniqueKeys[level]
+	if !ok || (level == QueryLevelPatient && !patientRoot) || identifier.String(uniqueKey) == "" {
+		request.RespondStatus(dimse.StatusDataSetDoesNotMatchClass, fmt.Sprintf("invalid query level %q or missing unique key", level))
+		return nil, false
+	}
+
+	requestData := &dicomweb.QIDORequest{
+		IncludedFields:   map[tag.Tag]bool{},
+		IncludeAllFields: true,
+		Filters:          map[tag.Tag][]string{},
+	}
+	for _, key := range []tag.Tag{tag.PatientID, tag.StudyInstanceUID, tag.SeriesInstanceUID, tag.SOPInstanceUID} {
+		if value := identifier.String(key); value != "" && value != "*" {
+			requestData.Filters[key] = strings.Split(value, `\`)
+		}
+		if key == uniqueKey {
+			break
+		}
+	}
+
+	objects, err := scp.QIDO.Search(dicomweb.QueryLevelInstance, requestData)
+	if err != nil {
+		logger.Errorf("resolving instances to retrieve: %v", err)
+		request.RespondStatus(dimse.StatusUnableToProcess, err.Error())
+		return nil, false
+	}
+	instances := make([]*models.Instance, len(objects))
+	for i, object := range objects {
+		instances[i] = object.(*models.Instance)
+	}
+	logger.Infof("retrieving %d instances at %s level", len(instances), level)
+	return instances, true
+}
+
+// sendInstances runs the C-STORE sub-operations of a C-MOVE or C-GET, with a pending response after each one,
+// until they are done or the request is cancelled.
+func (scp *SCP) sendInstances(ctx context.Context, request *dimse.Request, instances []*models.Instance,
+	destination *dimse.Association, originatorAETitle string, originatorMessageID uint16, logger logrus.FieldLogger) {
+	counts := &subOperations{remaining: len(instances)}
+	var failed []string
+
+	for i, instance := range instances {
+		select {
+		case <-ctx.Done():
+			logger.Infof("retrieve cancelled after %d of %d instances", i, len(instances))
+			for _, remaining := range instances[i:] {
+				failed = append(failed, remaining.SOPInstanceUID)
+			}
+			scp.respondRetrieve(request, dimse.StatusCancel, counts, failed)
+			return
+		default:
+		}
+
+		status, err := scp.storeSubOperation(destination, instance, originatorAETitle, originatorMessageID)
+		counts.remaining--
+		switch {
+		case err != nil || dimse.IsFailure(status):
+			if err == nil {
+				err = fmt.Errorf("status 0x%04X", status)
+			}
+			logger.WithField("instance", instance.SOPInstanceUID).Warnf("C-STORE sub-operation failed: %v", err)
+			counts.failed++
+			failed = append(failed, instance.SOPInstanceUID)
+		case status == dimse.StatusSuccess:
+			counts.completed++
+		default:
+			counts.warning++
+		}
+
+		if counts.remaining > 0 {
+			if err := request.Respond(&dimse.Command{Status: dimse.StatusPending, SubOperations: counts.command()}, nil); err != nil {
+				return
+			}
+		}
+	}
+
+	status := dimse.StatusSuccess
+	switch {
+	case counts.failed > 0 && counts.completed == 0 && counts.warning == 0:
+		status = dimse.StatusOutOfResourcesSubOps
+	case counts.failed > 0 || counts.warning > 0:
+		status = dimse.StatusWarning
+	}
+	scp.respondRetrieve(request, status, counts, failed)
+}
+
+// respondRetrieve sends the final response of a C-MOVE or C-GET, listing the instances not sent if any.
+func (scp *SCP) respondRetrieve(request *dimse.Request, status uint16, counts *subOperations, failed []string) {
+	response := &dimse.Command{Status: status, SubOperations: counts.command()}
+	if len(failed) == 0 {
+		request.Respond(response, nil)
+		return
+	}
+	identifier := &dimse.DataSet{}
+	identifier.Set(tag.FailedSOPInstanceUIDList, strings.Join(failed, `\`))
+	data, err := identifier.Encode(request.TransferSyntax)
+	if err != nil {
+		request.Respond(response, nil)
+		return
+	}
+	request.Respond(response, data)
+}
+
+// storeSubOperation sends the file of the instance and returns the status of the C-STORE response.
+func (scp *SCP) storeSubOperation(destination *dimse.Association, instance *models.Instance, originatorAETitle string,
+	originatorMessageID uint16) (uint16, error) {
+	data, err := fs.ReadDicomFile(instance.Series.Study, instance.Series, instance)
+	if err != nil {
+		return 0, err
+	}
+	response, err := destination.Store(context.Background(), data, originatorAETitle, originatorMessageID)
+	if err != nil {
+		return 0, err
+	}
+	return response.Status, nil
+}
+
+func readFileMeta(instance *models.Instance) (*dimse.FileMeta, error) {
+	file, err := fs.OpenDicomFile(instance.Series.Study, instance.Series, instance)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return dimse.ReadFileMeta(file)
+}
+
+// subOperations counts the sub-operations of a retrieve, which may be more than their responses can tell.
+type subOperations struct {
+	remaining, completed, failed, warning int
+}
+
+// command returns the counts of a response, capped to the range of their values.
+func (c *subOperations) command() *dimse.SubOperations {
+	return &dimse.SubOperations{
+		Remaining: count(c.remaining),
+		Completed: count(c.completed),
+		Failed:    count(c.failed),
+		Warning:   count(c.warning),
+	}
+}
+
+// count caps a number of sub-operations to the range of their counts.
+func count(n int) uint16 {
+	if n > math.MaxUint16 {
+		return math.MaxUint16
+	}
+	return uint16(n)
+}
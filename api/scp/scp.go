@@ -0,0 +1,146 @@
+// Package scp serves DICOM DIMSE services on top of the DICOMweb resources.
+package scp
+
+import (
+	"context"
+	"dicom-store-api/api/dicomweb"
+	"dicom-store-api/commitment"
+	"dicom-store-api/database"
+	"dicom-store-api/dimse"
+	"dicom-store-api/logging"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// SCP answers C-ECHO, stores the instances received with C-STORE like STOW requests, answers C-FIND
+// like QIDO requests and modality worklist queries, sends the instances requested with C-MOVE and C-GET and
+// takes storage commitment requests.
+type SCP struct {
+	Server *dimse.Server
+	Addr   string
+	STOW   *dicomweb.STOWResource
+	QIDO   *dicomweb.QIDOResource
+	// RemoteNodeStore resolves C-MOVE destinations, none are known without it.
+	RemoteNodeStore RemoteNodeStore
+	// FindLimit bounds the matches returned to a C-FIND request.
+	FindLimit int
+	// Workitems answers the modality worklist queries, which fail without it.
+	Workitems *dicomweb.WorkitemsResource
+	// Commitment reports the results of storage commitment requests, which fail without it.
+	Commitment *commitment.Reporter
+}
+
+// NewSCP returns an SCP for the AE title listening on the address.
+func NewSCP(aeTitle string, addr string, stow *dicomweb.STOWResource, qido *dicomweb.QIDOResource) *SCP {
+	scp := &SCP{
+		Server:    dimse.NewServer(aeTitle),
+		Addr:      addr,
+		STOW:      stow,
+		QIDO:      qido,
+		FindLimit: 1000,
+	}
+	scp.Server.Accept(dimse.IsStorageSOPClass, dimse.StorageTransferSyntaxes...)
+	scp.Server.Handle(dimse.CStoreRQ, scp.store)
+
+	scp.Server.Accept(func(abstractSyntax string) bool {
+		return abstractSyntax == dimse.PatientRootQueryRetrieveFind || abstractSyntax == dimse.StudyRootQueryRetrieveFind ||
+			abstractSyntax == dimse.ModalityWorklistFind
+	}, dimse.UncompressedTransferSyntaxes...)
+	scp.Server.Handle(dimse.CFindRQ, scp.find)
+
+	scp.Server.Accept(func(abstractSyntax string) bool {
+		switch abstractSyntax {
+		case dimse.PatientRootQueryRetrieveMove, dimse.StudyRootQueryRetrieveMove,
+			dimse.PatientRootQueryRetrieveGet, dimse.StudyRootQueryRetrieveGet:
+			return true
+		}
+		return false
+	}, dimse.UncompressedTransferSyntaxes...)
+	scp.Server.Handle(dimse.CMoveRQ, scp.move)
+	scp.Server.Handle(dimse.CGetRQ, scp.get)
+
+	scp.Server.Accept(func(abstractSyntax string) bool {
+		return abstractSyntax == dimse.StorageCommitmentPushModel
+	}, dimse.UncompressedTransferSyntaxes...)
+	scp.Server.Handle(dimse.NActionRQ, scp.commit)
+	return scp
+}
+
+// NewSCPFromConfig returns an SCP with the configured AE title, port, idle timeout and C-FIND limit.
+func NewSCPFromConfig(stow *dicomweb.STOWResource, qido *dicomweb.QIDOResource) (*SCP, error) {
+	viper.SetDefault("dimse.ae_title", "DICOM_STORE")
+	viper.SetDefault("dimse.port", "11112")
+	viper.SetDefault("dimse.idle_timeout", "5m")
+	viper.SetDefault("dimse.find_limit", 1000)
+
+	aeTitle := viper.GetString("dimse.ae_title")
+	if aeTitle == "" || len(aeTitle) > 16 || strings.TrimSpace(aeTitle) != aeTitle {
+		return nil, fmt.Errorf("invalid dimse.ae_title %q", aeTitle)
+	}
+	addr := viper.GetString("dimse.port")
+	if !strings.Contains(addr, ":") {
+		addr = ":" + addr
+	}
+
+	scp := NewSCP(aeTitle, addr, stow, qido)
+	scp.RemoteNodeStore = database.NewRemoteNodeStore(stow.DB)
+	scp.Server.IdleTimeout = viper.GetDuration("dimse.idle_timeout")
+	scp.FindLimit = viper.GetInt("dimse.find_limit")
+	scp.Workitems = dicomweb.NewWorkitemsResource(stow.DB, database.NewWorklistStore(stow.DB), stow.Worklist)
+	scp.Commitment = commitment.NewReporterFromConfig(stow.DB)
+	if err := scp.Commitment.Start(); err != nil {
+		return nil, err
+	}
+	return scp, nil
+}
+
+// ListenAndServe serves associations until Close.
+func (scp *SCP) ListenAndServe() error {
+	logging.Logger.WithField("module", "dimse").Infof("%s listening on %s", scp.Server.AETitle, scp.Addr)
+	return scp.Server.ListenAndServe(scp.Addr)
+}
+
+// Start serves associations in the background.
+func (scp *SCP) Start() {
+	go func() {
+		if err := scp.ListenAndServe(); err != dimse.ErrServerClosed {
+			logging.Logger.WithField("module", "dimse").Error(err)
+		}
+	}()
+}
+
+// Close stops listening and aborts the associations in progress.
+func (scp *SCP) Close() error {
+	return scp.Server.Close()
+}
+
+// store saves a received data set as a Part 10 file, through the same path as STOW.
+func (scp *SCP) store(ctx context.Context, request *dimse.Request) {
+	logger := logging.Logger.WithField("module", "dimse").
+		WithField("calling_ae", request.Association.CallingAETitle).
+		WithField("instance", request.Command.AffectedSOPInstanceUID)
+
+	meta := &dimse.FileMeta{
+		SOPClassUID:    request.Command.AffectedSOPClassUID,
+		SOPInstanceUID: request.Command.AffectedSOPInstanceUID,
+		TransferSyntax: request.TransferSyntax,
+	}
+	file := dimse.NewPart10File(meta, request.Association.CallingAETitle, request.Data)
+	if len(file) > dicomweb.MaxUploadSize {
+		request.RespondStatus(dimse.StatusOutOfResources, "data set too large")
+		return
+	}
+
+	instance, err := scp.STOW.Store(file)
+	if err != nil {
+		logger.Warnf("C-STORE failed: %v", err)
+		request.RespondStatus(dimse.StatusCannotUnderstand, err.Error())
+		return
+	}
+	if instance.SOPInstanceUID != meta.SOPInstanceUID {
+		logger.Warnf("stored as %s", instance.SOPInstanceUID)
+	}
+	request.RespondStatus(dimse.StatusSuccess, "")
+}
@@ -0,0 +1,202 @@
+package scp
+
+import (
+	"context"
+	"dicom-store-api/api/dicomweb"
+	"dicom-store-api/dimse"
+	"dicom-store-api/logging"
+	"dicom-store-api/models"
+	"strings"
+
+	"github.com/suyashkumar/dicom/pkg/tag"
+)
+
+// worklistKeywords are the attributes of the worklist items at the top level of an identifier.
+var worklistKeywords = map[string]bool{
+	"PatientName":                   true,
+	"PatientID":                     true,
+	"PatientBirthDate":              true,
+	"PatientSex":                    true,
+	"AccessionNumber":               true,
+	"ReferringPhysicianName":        true,
+	"StudyInstanceUID":              true,
+	"RequestedProcedureID":          true,
+	"RequestedProcedureDescription": true,
+}
+
+// stepKeywords are the attributes of the worklist items in the ScheduledProcedureStepSequence item.
+var stepKeywords = map[string]bool{
+	"ScheduledStationAETitle":           true,
+	"ScheduledProcedureStepStartDate":   true,
+	"ScheduledProcedureStepStartTime":   true,
+	"Modality":                          true,
+	"ScheduledProcedureStepDescription": true,
+	"ScheduledProcedureStepID":          true,
+	"ScheduledProcedureStepStatus":      true,
+}
+
+// stepStatuses are the ScheduledProcedureStepStatus of the items by procedure step state.
+var stepStatuses = map[string]string{
+	models.WorklistStateScheduled:  "SCHEDULED",
+	models.WorklistStateInProgress: "STARTED",
+	models.WorklistStateCompleted:  "COMPLETED",
+	models.WorklistStateCanceled:   "DISCONTINUED",
+}
+
+// findWorklist answers Modality Worklist C-FIND requests with the scheduled items and those in progress
+// matching the identifier, through the same filters as the UPS-RS search.
+func (scp *SCP) findWorklist(ctx context.Context, request *dimse.Request) {
+	logger := logging.Logger.WithField("module", "dimse").WithField("calling_ae", request.Association.CallingAETitle)
+	if scp.Workitems == nil {
+		request.RespondStatus(dimse.StatusUnableToProcess, "the worklist is not available")
+		return
+	}
+	identifier, err := dimse.DecodeDataSet(request.Data, request.TransferSyntax)
+	if err != nil {
+		request.RespondStatus(dimse.StatusDataSetDoesNotMatchClass, err.Error())
+		return
+	}
+
+	requestData := &dicomweb.QIDORequest{Limit: scp.FindLimit, Filters: map[tag.Tag][]string{}}
+	addWorklistFilters(requestData, identifier, worklistKeywords)
+	step := worklistStep(identifier)
+	if step != nil {
+		addWorklistFilters(requestData, step, stepKeywords)
+	}
+	if _, ok := requestData.Filters[tag.ProcedureStepState]; !ok {
+		requestData.Filters[tag.ProcedureStepState] = []string{models.WorklistStateScheduled, models.WorklistStateInProgress}
+	}
+
+	items, err := scp.Workitems.Search(requestData)
+	if err != nil {
+		logger.Errorf("worklist C-FIND failed: %v", err)
+		request.RespondStatus(dimse.StatusUnableToProcess, err.Error())
+		return
+	}
+	logger.Infof("worklist C-FIND with %d matches", len(items))
+
+	status := dimse.StatusPending
+	if !supportsWorklistKeys(identifier, worklistKeywords) || (step != nil && !supportsWorklistKeys(step, stepKeywords)) {
+		status = dimse.StatusPendingWarning
+	}
+	for _, item := range items {
+		select {
+		case <-ctx.Done():
+			request.RespondStatus(dimse.StatusCancel, "")
+			return
+		default:
+		}
+		data, err := worklistIdentifier(identifier, item).Encode(request.TransferSyntax)
+		if err != nil {
+			request.RespondStatus(dimse.StatusUnableToProcess, err.Error())
+			return
+		}
+		if err := request.Respond(&dimse.Command{Status: status}, data); err != nil {
+			return
+		}
+	}
+	request.RespondStatus(dimse.StatusSuccess, "")
+}
+
+// worklistStep returns the ScheduledProcedureStepSequence item of the identifier, or nil.
+func worklistStep(identifier *dimse.DataSet) *dimse.DataSet {
+	element := identifier.Get(tag.ScheduledProcedureStepSequence)
+	if element == nil {
+		return nil
+	}
+	if len(element.Items) == 0 {
+		return &dimse.DataSet{}
+	}
+	return element.Items[0]
+}
+
+// addWorklistFilters adds the matching keys of the data set among the keywords to the filters.
+func addWorklistFilters(requestData *dicomweb.QIDORequest, data *dimse.DataSet, keywords map[string]bool) {
+	for _, element := range data.Elements {
+		tagInfo, err := tag.Find(element.Tag)
+		if err != nil || element.VR == "SQ" || !keywords[tagInfo.Name] {
+			continue
+		}
+		value := data.String(element.Tag)
+		if value == "" {
+			continue
+		}
+		switch {
+		case element.Tag == tag.ScheduledProcedureStepStatus:
+			for state, status := range stepStatuses {
+				if status == value {
+					requestData.Filters[tag.ProcedureStepState] = []string{state}
+				}
+			}
+		case tagInfo.VR == "UI":
+			requestData.Filters[element.Tag] = strings.Split(value, `\`)
+		default:
+			requestData.Filters[element.Tag] = []string{value}
+		}
+	}
+}
+
+// supportsWorklistKeys reports whether every key of the data set can be returned.
+func supportsWorklistKeys(data *dimse.DataSet, keywords map[string]bool) bool {
+	for _, element := range data.Elements {
+		switch element.Tag {
+		case tag.SpecificCharacterSet, tag.ScheduledProcedureStepSequence:
+			continue
+		}
+		tagInfo, err := tag.Find(element.Tag)
+		if err != nil || !keywords[tagInfo.Name] {
+			return false
+		}
+	}
+	return true
+}
+
+// worklistIdentifier returns the keys of the identifier with the values of the item, and a
+// ScheduledProcedureStepSequence with the requested step keys, or all of them when its item is empty.
+func worklistIdentifier(identifier *dimse.DataSet, item *models.WorklistItem) *dimse.DataSet {
+	values := objectAttributes(item)
+	values["ScheduledProcedureStepStatus"] = stepStatuses[item.ProcedureStepState]
+
+	response := &dimse.DataSet{}
+	for _, element := range identifier.Elements {
+		switch element.Tag {
+		case tag.SpecificCharacterSet:
+			response.Set(element.Tag, specificCharacterSet)
+		case tag.ScheduledProcedureStepSequence:
+			step := worklistStep(identifier)
+			if len(step.Elements) == 0 {
+				step = &dimse.DataSet{}
+				for keyword := range stepKeywords {
+					tagInfo, _ := tag.FindByName(keyword)
+					step.Set(tagInfo.Tag, "")
+				}
+			}
+			response.SetItems(element.Tag, []*dimse.DataSet{worklistValues(step, stepKeywords, values)})
+		default:
+			setWorklistValue(response, element, worklistKeywords, values)
+		}
+	}
+	return response
+}
+
+// worklistValues returns the keys of the data set with the values.
+func worklistValues(data *dimse.DataSet, keywords map[string]bool, values map[string]string) *dimse.DataSet {
+	response := &dimse.DataSet{}
+	for _, element := range data.Elements {
+		setWorklistValue(response, element, keywords, values)
+	}
+	return response
+}
+
+// setWorklistValue sets the key of the element in the response, empty if it is not among the keywords.
+func setWorklistValue(response *dimse.DataSet, element *dimse.Element, keywords map[string]bool, values map[string]string) {
+	tagInfo, err := tag.Find(element.Tag)
+	switch {
+	case element.VR == "SQ":
+		response.SetItems(element.Tag, nil)
+	case err != nil || !keywords[tagInfo.Name]:
+		response.SetValue(element.Tag, element.VR, nil)
+	default:
+		response.Set(element.Tag, values[tagInfo.Name])
+	}
+}
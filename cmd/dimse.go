@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"dicom-store-api/api/dicomweb"
+	"dicom-store-api/api/scp"
+	"dicom-store-api/database"
+	"dicom-store-api/dimse"
+	"dicom-store-api/fs"
+	"dicom-store-api/logging"
+	"dicom-store-api/prefetch"
+	"dicom-store-api/routing"
+	"dicom-store-api/worklist"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// dimseCmd represents the dimse command
+var dimseCmd = &cobra.Command{
+	Use:   "dimse",
+	Short: "start the DICOM listener",
+	Long: `Starts a DICOM listener with the configured AE title and port that answers C-ECHO, stores the
+instances received with C-STORE the same way as STOW requests, answers C-FIND like QIDO requests and
+modality worklist queries from the scheduled worklist items, and sends instances to the known remote nodes
+with C-MOVE or back with C-GET. Storage commitment requests are verified against the stored checksums and
+reported asynchronously. Received instances are forwarded by the routing rules and the priors of new
+studies are fetched by the prefetch rules.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logging.NewLogger()
+
+		db, err := database.DBConn()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := fs.Configure(); err != nil {
+			log.Fatal(err)
+		}
+		stow, err := dicomweb.NewSTOWResourceFromConfig(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// the prefetched priors are ingested by the queue, started last like in the API
+		viper.SetDefault("ingest_workers", 4)
+		stow.Queue = dicomweb.NewIngestQueue(db, stow, database.NewJobStore(db), viper.GetInt("ingest_workers"))
+		if stow.Routing, err = routing.NewEngineFromConfig(db); err != nil {
+			log.Fatal(err)
+		}
+		if stow.Prefetch, err = prefetch.NewPrefetcherFromConfig(db, stow.Queue); err != nil {
+			log.Fatal(err)
+		}
+		stow.Worklist = worklist.NewManager(database.NewWorklistStore(db))
+		if err := stow.Queue.Start(); err != nil {
+			log.Fatal(err)
+		}
+		if err := stow.Routing.Start(); err != nil {
+			log.Fatal(err)
+		}
+		if err := stow.Prefetch.Start(); err != nil {
+			log.Fatal(err)
+		}
+		qido := dicomweb.NewQIDOResource(db, stow.StudyStore, stow.SeriesStore, stow.InstanceStore)
+		server, err := scp.NewSCPFromConfig(stow, qido)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		go func() {
+			quit := make(chan os.Signal, 1)
+			signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+			sig := <-quit
+			log.Println("Shutting down DICOM listener... Reason:", sig)
+			server.Close()
+		}()
+		if err := server.ListenAndServe(); err != dimse.ErrServerClosed {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(dimseCmd)
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"dicom-store-api/api/ris"
+	"dicom-store-api/coercion"
+	"dicom-store-api/database"
+	"dicom-store-api/hl7"
+	"dicom-store-api/logging"
+	"dicom-store-api/worklist"
+	"github.com/spf13/cobra"
+)
+
+var hl7SendAddr string
+
+// hl7Cmd represents the hl7 command
+var hl7Cmd = &cobra.Command{
+	Use:   "hl7",
+	Short: "start the HL7 listener",
+	Long: `Starts an HL7 v2 listener over MLLP on the configured port. ADT A08 and A40 messages correct the patient
+demographics of the worklist items and are rejected for patients with stored studies, ORM O01 and OMI O23
+orders schedule, change and cancel worklist items. Patients are coerced by the coercion rules first. Every
+message is acknowledged.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logging.NewLogger()
+
+		db, err := database.DBConn()
+		if err != nil {
+			log.Fatal(err)
+		}
+		coercionEngine, err := coercion.NewEngineFromConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+		listener := ris.NewListenerFromConfig(db, worklist.NewManager(database.NewWorklistStore(db)), coercionEngine)
+
+		go func() {
+			quit := make(chan os.Signal, 1)
+			signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+			sig := <-quit
+			log.Println("Shutting down HL7 listener... Reason:", sig)
+			listener.Close()
+		}()
+		if err := listener.ListenAndServe(); err != hl7.ErrServerClosed {
+			log.Fatal(err)
+		}
+	},
+}
+
+// hl7SendCmd represents the hl7 send command
+var hl7SendCmd = &cobra.Command{
+	Use:   "send <file>",
+	Short: "send an HL7 message",
+	Long:  `Sends the HL7 v2 message of a file over MLLP, to this listener by default, and prints the acknowledgment.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		ack, err := hl7.Send(ctx, hl7SendAddr, data)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(ack.Get("MSA", 1, 1), ack.Get("MSA", 3, 1))
+		if ack.Get("MSA", 1, 1) != hl7.AckAccept {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(hl7Cmd)
+	hl7Cmd.AddCommand(hl7SendCmd)
+
+	hl7SendCmd.Flags().StringVar(&hl7SendAddr, "addr", "localhost:2575", "address of the MLLP listener")
+}
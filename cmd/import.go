@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"dicom-store-api/api/dicomweb"
+	"dicom-store-api/database"
+	"dicom-store-api/fs"
+	"dicom-store-api/logging"
+	"dicom-store-api/maintenance"
+	"github.com/spf13/cobra"
+)
+
+var importWorkers int
+var importDryRun bool
+var importReport string
+
+// importCmd represents the import command
+var importCmd = &cobra.Command{
+	Use:   "import <directory|DICOMDIR>",
+	Short: "import a folder of DICOM files",
+	Long: `Recursively imports the DICOM files of a directory, or the files referenced by a DICOMDIR, the same way
+as STOW requests. Instances that are already stored are skipped. A summary report is written as JSON.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		logging.NewLogger()
+
+		db, err := database.DBConn()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := fs.Configure(); err != nil {
+			log.Fatal(err)
+		}
+		indexer, err := dicomweb.NewSTOWResourceFromConfig(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		report, err := maintenance.NewImporter(db, indexer, importWorkers, importDryRun).Run(ctx, args[0])
+		if report != nil {
+			data, _ := json.MarshalIndent(report, "", "  ")
+			os.Stdout.Write(append(data, '\n'))
+			if importReport != "" {
+				if err := os.WriteFile(importReport, data, 0644); err != nil {
+					log.Println(err)
+				}
+			}
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(importCmd)
+
+	importCmd.Flags().IntVar(&importWorkers, "workers", 4, "number of files imported in parallel")
+	importCmd.Flags().BoolVar(&importDryRun, "dry-run", false, "only report what would be imported")
+	importCmd.Flags().StringVar(&importReport, "report", "", "also write the summary report to this file")
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"dicom-store-api/api/dicomweb"
+	"dicom-store-api/database"
+	"dicom-store-api/fs"
+	"dicom-store-api/logging"
+	"dicom-store-api/maintenance"
+	"github.com/spf13/cobra"
+)
+
+var reindexWorkers int
+var reindexReset bool
+var reindexState string
+
+// reindexCmd represents the reindex command
+var reindexCmd = &cobra.Command{
+	Use:   "reindex",
+	Short: "rebuild the database index from the stored files",
+	Long: `Re-parses every stored instance file. Stored instances get their attributes updated from the file,
+files without an instance are indexed. An interrupted reindex resumes from its state file when run again.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logging.NewLogger()
+
+		db, err := database.DBConn()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := fs.Configure(); err != nil {
+			log.Fatal(err)
+		}
+		indexer, err := dicomweb.NewSTOWResourceFromConfig(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		state, err := maintenance.NewReindexer(db, indexer, reindexWorkers, reindexState).Run(ctx, reindexReset)
+		if state != nil {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			encoder.Encode(state)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(reindexCmd)
+
+	reindexCmd.Flags().IntVar(&reindexWorkers, "workers", 4, "number of files indexed in parallel")
+	reindexCmd.Flags().BoolVar(&reindexReset, "reset", false, "remove all studies, series and instances first. WARNING: trashed objects are indexed again!")
+	reindexCmd.Flags().StringVar(&reindexState, "state", "reindex.state", "file recording the progress for resuming")
+}
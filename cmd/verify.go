@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+
+	"dicom-store-api/api/dicomweb"
+	"dicom-store-api/database"
+	"dicom-store-api/fs"
+	"dicom-store-api/logging"
+	"dicom-store-api/maintenance"
+	"github.com/spf13/cobra"
+)
+
+var repair bool
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "check the database against the file storage",
+	Long: `Reports instances whose file is missing, files without an instance, files not matching their checksum
+and studies with wrong related series or instance counts as JSON. Exits with status 1 if anything was found.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logging.NewLogger()
+
+		db, err := database.DBConn()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := fs.Configure(); err != nil {
+			log.Fatal(err)
+		}
+		indexer, err := dicomweb.NewSTOWResourceFromConfig(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		report, err := maintenance.NewVerifier(db, indexer).Run(repair)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			log.Fatal(err)
+		}
+		if !report.Consistent() {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(verifyCmd)
+
+	verifyCmd.Flags().BoolVar(&repair, "repair", false, "index orphan files, recount studies and quarantine files failing the checksum")
+}
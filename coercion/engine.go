@@ -0,0 +1,216 @@
+package coercion
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/suyashkumar/dicom"
+	"github.com/suyashkumar/dicom/pkg/tag"
+)
+
+const ModifyingSystem = "dicom-store-api"
+
+// Engine applies the configured rules in order.
+type Engine struct {
+	rules []*Rule
+}
+
+// NewEngine validates the rules and returns an Engine applying them.
+func NewEngine(rules []*Rule) (*Engine, error) {
+	for i, rule := range rules {
+		if err := rule.compile(); err != nil {
+			return nil, fmt.Errorf("coercion rule %d: %w", i, err)
+		}
+	}
+	return &Engine{rules: rules}, nil
+}
+
+// NewEngineFromConfig returns an Engine with the rules of the coercion_rules config key.
+func NewEngineFromConfig() (*Engine, error) {
+	var rules []*Rule
+	if err := viper.UnmarshalKey("coercion_rules", &rules); err != nil {
+		return nil, err
+	}
+	return NewEngine(rules)
+}
+
+// Apply runs the rules against the dataset in place and returns the original values of the modified attributes.
+// Attributes that were absent before coercion, and those of removed private groups, are returned without a value.
+func (e *Engine) Apply(dataset *dicom.Dataset) ([]*dicom.Element, error) {
+	originals := map[tag.Tag]*dicom.Element{}
+	remember := func(t tag.Tag, element *dicom.Element) {
+		if _, ok := originals[t]; ok {
+			return
+		}
+		if element == nil {
+			element, _ = dicom.NewElement(t, []string{})
+			if element == nil {
+				return
+			}
+		}
+		originals[t] = element
+	}
+
+	for _, rule := range e.rules {
+		if rule.match != nil && !matches(dataset, *rule.match, rule.Match.Value) {
+			continue
+		}
+
+		if rule.Action == ActionRemovePrivateGroup {
+			elements := dataset.Elements[:0]
+			for _, element := range dataset.Elements {
+				if element.Tag.Group == rule.group {
+					remember(element.Tag, withoutValue(element))
+					continue
+				}
+				elements = append(elements, element)
+			}
+			dataset.Elements = elements
+			continue
+		}
+
+		index := -1
+		for i, element := range dataset.Elements {
+			if element.Tag == rule.tag {
+				index = i
+				break
+			}
+		}
+
+		if rule.Action == ActionRemove {
+			if index >= 0 {
+				remember(rule.tag, dataset.Elements[index])
+				dataset.Elements = append(dataset.Elements[:index], dataset.Elements[index+1:]...)
+			}
+			continue
+		}
+
+		var values []string
+		if index >= 0 {
+			if dataset.Elements[index].Value.ValueType() != dicom.Strings {
+				continue
+			}
+			values = dicom.MustGetStrings(dataset.Elements[index].Value)
+		}
+
+		newValues, ok := rule.transform(values, index >= 0)
+		if !ok {
+			continue
+		}
+
+		element, err := dicom.NewElement(rule.tag, newValues)
+		if err != nil {
+			return nil, err
+		}
+		if index >= 0 {
+			remember(rule.tag, dataset.Elements[index])
+			dataset.Elements[index] = element
+		} else {
+			remember(rule.tag, nil)
+			insertElement(dataset, element)
+		}
+	}
+
+	result := make([]*dicom.Element, 0, len(originals))
+	for _, element := range originals {
+		result = append(result, element)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Tag.Compare(result[j].Tag) < 0
+	})
+	return result, nil
+}
+
+// withoutValue returns an element with the tag and VR of the element and an empty value.
+func withoutValue(element *dicom.Element) *dicom.Element {
+	var data any
+	switch element.Value.ValueType() {
+	case dicom.Strings:
+		data = []string{}
+	case dicom.Ints:
+		data = []int{}
+	case dicom.Floats:
+		data = []float64{}
+	case dicom.Sequences:
+		data = [][]*dicom.Element{}
+	default:
+		data = []byte{}
+	}
+	value, err := dicom.NewValue(data)
+	if err != nil {
+		return nil
+	}
+	return &dicom.Element{
+		Tag:                    element.Tag,
+		ValueRepresentation:    element.ValueRepresentation,
+		RawValueRepresentation: element.RawValueRepresentation,
+		Value:                  value,
+	}
+}
+
+// AddOriginalAttributes records the modified attributes as a new item of the dataset's OriginalAttributesSequence.
+func AddOriginalAttributes(dataset *dicom.Dataset, modified []*dicom.Element, reason string) error {
+	item := make([]*dicom.Element, 0, 5)
+	values := []struct {
+		tag  tag.Tag
+		data any
+	}{
+		{tag.ModifiedAttributesSequence, [][]*dicom.Element{modified}},
+		{tag.AttributeModificationDateTime, []string{time.Now().Format("20060102150405")}},
+		{tag.ModifyingSystem, []string{ModifyingSystem}},
+		{tag.ReasonForTheAttributeModification, []string{reason}},
+	}
+	for _, value := range values {
+		element, err := dicom.NewElement(value.tag, value.data)
+		if err != nil {
+			return err
+		}
+		item = append(item, element)
+	}
+
+	existing, err := dataset.FindElementByTag(tag.OriginalAttributesSequence)
+	if err == nil && existing.Value.ValueType() == dicom.Sequences {
+		var items [][]*dicom.Element
+		for _, existingItem := range existing.Value.GetValue().([]*dicom.SequenceItemValue) {
+			items = append(items, existingItem.GetValue().([]*dicom.Element))
+		}
+		value, err := dicom.NewValue(append(items, item))
+		if err != nil {
+			return err
+		}
+		existing.Value = value
+		return nil
+	}
+
+	element, err := dicom.NewElement(tag.OriginalAttributesSequence, [][]*dicom.Element{item})
+	if err != nil {
+		return err
+	}
+	insertElement(dataset, element)
+	return nil
+}
+
+func matches(dataset *dicom.Dataset, t tag.Tag, value string) bool {
+	element, err := dataset.FindElementByTag(t)
+	if err != nil || element.Value.ValueType() != dicom.Strings {
+		return value == ""
+	}
+	for _, v := range dicom.MustGetStrings(element.Value) {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// insertElement keeps the top level elements in ascending tag order as required for encoding.
+func insertElement(dataset *dicom.Dataset, element *dicom.Element) {
+	index := sort.Search(len(dataset.Elements), func(i int) bool {
+		return dataset.Elements[i].Tag.Compare(element.Tag) >= 0
+	})
+	dataset.Elements = append(dataset.Elements, nil)
+	copy(dataset.Elements[index+1:], dataset.Elements[index:])
+	dataset.Elements[index] = element
+}
@@ -0,0 +1,125 @@
+// Package coercion implements attribute coercion and normalization rules applied to datasets at ingest.
+package coercion
+
+import (
+	"dicom-store-api/utils"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/suyashkumar/dicom/pkg/tag"
+)
+
+const (
+	ActionSet                = "set"
+	ActionDefault            = "default"
+	ActionPrefix             = "prefix"
+	ActionSuffix             = "suffix"
+	ActionTrim               = "trim"
+	ActionUppercase          = "uppercase"
+	ActionMap                = "map"
+	ActionRemove             = "remove"
+	ActionRemovePrivateGroup = "remove_private_group"
+)
+
+// Rule is a single coercion rule as configured in the coercion_rules list of config.yaml.
+type Rule struct {
+	Action  string    `mapstructure:"action"`
+	Tag     string    `mapstructure:"tag"`
+	Value   string    `mapstructure:"value"`
+	Group   string    `mapstructure:"group"`
+	Mapping []Mapping `mapstructure:"mapping"`
+	Match   *Match    `mapstructure:"match"`
+
+	tag   tag.Tag
+	group uint16
+	match *tag.Tag
+}
+
+// Mapping maps an attribute value to a replacement for the map action.
+type Mapping struct {
+	From string `mapstructure:"from"`
+	To   string `mapstructure:"to"`
+}
+
+// Match restricts a rule to datasets where the given attribute has the given value.
+type Match struct {
+	Tag   string `mapstructure:"tag"`
+	Value string `mapstructure:"value"`
+}
+
+func (r *Rule) compile() error {
+	switch r.Action {
+	case ActionRemovePrivateGroup:
+		group, err := strconv.ParseUint(r.Group, 16, 16)
+		if err != nil || !tag.IsPrivate(uint16(group)) {
+			return fmt.Errorf("invalid private group %q", r.Group)
+		}
+		r.group = uint16(group)
+	case ActionSet, ActionDefault, ActionPrefix, ActionSuffix, ActionTrim, ActionUppercase, ActionMap, ActionRemove:
+		ruleTag, err := utils.GetTagByNameOrCode(r.Tag)
+		if err != nil {
+			return fmt.Errorf("invalid tag %q", r.Tag)
+		}
+		r.tag = ruleTag
+	default:
+		return fmt.Errorf("unknown action %q", r.Action)
+	}
+
+	if r.Match != nil {
+		matchTag, err := utils.GetTagByNameOrCode(r.Match.Tag)
+		if err != nil {
+			return fmt.Errorf("invalid match tag %q", r.Match.Tag)
+		}
+		r.match = &matchTag
+	}
+	return nil
+}
+
+// transform returns the new values of a string attribute, or ok=false if the rule leaves it untouched.
+func (r *Rule) transform(values []string, present bool) (result []string, ok bool) {
+	switch r.Action {
+	case ActionSet:
+		if present && len(values) == 1 && values[0] == r.Value {
+			return nil, false
+		}
+		return []string{r.Value}, true
+	case ActionDefault:
+		if present && strings.TrimSpace(strings.Join(values, "")) != "" {
+			return nil, false
+		}
+		return []string{r.Value}, true
+	}
+
+	if !present {
+		return nil, false
+	}
+
+	result = make([]string, len(values))
+	for i, value := range values {
+		switch r.Action {
+		case ActionPrefix:
+			if !strings.HasPrefix(value, r.Value) {
+				value = r.Value + value
+			}
+		case ActionSuffix:
+			if !strings.HasSuffix(value, r.Value) {
+				value = value + r.Value
+			}
+		case ActionTrim:
+			value = strings.TrimSpace(value)
+		case ActionUppercase:
+			value = strings.ToUpper(value)
+		case ActionMap:
+			for _, mapping := range r.Mapping {
+				if strings.TrimSpace(value) == mapping.From {
+					value = mapping.To
+					break
+				}
+			}
+		}
+		result[i] = value
+		ok = ok || value != values[i]
+	}
+	return result, ok
+}
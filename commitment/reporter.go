@@ -0,0 +1,312 @@
+package commitment
+
+import (
+	"context"
+	"dicom-store-api/database"
+	"dicom-store-api/dimse"
+	"dicom-store-api/logging"
+	"dicom-store-api/models"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-pg/pg"
+	"github.com/spf13/viper"
+	"github.com/suyashkumar/dicom/pkg/tag"
+)
+
+// Event types of the N-EVENT-REPORT of a storage commitment result.
+const (
+	EventAllCommitted uint16 = 1
+	EventSomeFailed   uint16 = 2
+)
+
+// purgeInterval is how often reported requests past the retention are deleted.
+const purgeInterval = time.Hour
+
+// reportTimeout bounds the association and the N-EVENT-REPORT of a report.
+const reportTimeout = time.Minute
+
+var errUnknownNode = errors.New("the requesting AE is not a remote node with a host and port")
+
+type CommitmentStore interface {
+	Create(commitment *models.StorageCommitment) error
+	Update(commitment *models.StorageCommitment) error
+	ClaimNext() (*models.StorageCommitment, error)
+	ResetProcessing() (int, error)
+	PurgeReported(reportedBefore time.Time) (int, error)
+}
+type RemoteNodeStore interface {
+	GetByAETitle(aeTitle string) (*models.RemoteNode, error)
+}
+
+// Reporter verifies the storage commitment requests received and reports their results with N-EVENT-REPORT,
+// on the requesting association while it is open, or else on a new association to the remote node of the
+// requesting AE title. A failed report is retried after a backoff doubling with every attempt.
+type Reporter struct {
+	CommitmentStore CommitmentStore
+	InstanceStore   InstanceStore
+	RemoteNodeStore RemoteNodeStore
+	// AETitle is the calling AE title of the associations opened for reports.
+	AETitle      string
+	Workers      int
+	MaxAttempts  int
+	Backoff      time.Duration
+	MaxBackoff   time.Duration
+	Retention    time.Duration
+	PollInterval time.Duration
+
+	mutex        sync.Mutex
+	associations map[int]*dimse.Association
+	wake         chan struct{}
+}
+
+// NewReporter returns a Reporter with default settings.
+func NewReporter(commitmentStore CommitmentStore, instanceStore InstanceStore, remoteNodeStore RemoteNodeStore) *Reporter {
+	return &Reporter{
+		CommitmentStore: commitmentStore,
+		InstanceStore:   instanceStore,
+		RemoteNodeStore: remoteNodeStore,
+		AETitle:         "DICOM_STORE",
+		Workers:         1,
+		MaxAttempts:     5,
+		Backoff:         time.Minute,
+		MaxBackoff:      time.Hour,
+		Retention:       7 * 24 * time.Hour,
+		PollInterval:    5 * time.Second,
+		associations:    map[int]*dimse.Association{},
+		wake:            make(chan struct{}, 1),
+	}
+}
+
+// NewReporterFromConfig returns a Reporter with the settings of the commitment config key.
+func NewReporterFromConfig(db *pg.DB) *Reporter {
+	viper.SetDefault("dimse.ae_title", "DICOM_STORE")
+	viper.SetDefault("commitment.workers", 1)
+	viper.SetDefault("commitment.max_attempts", 5)
+	viper.SetDefault("commitment.backoff", "1m")
+	viper.SetDefault("commitment.max_backoff", "1h")
+	viper.SetDefault("commitment.retention", "168h")
+
+	r := NewReporter(database.NewCommitmentStore(db), database.NewInstanceStore(db), database.NewRemoteNodeStore(db))
+	r.AETitle = viper.GetString("dimse.ae_title")
+	r.Workers = viper.GetInt("commitment.workers")
+	r.MaxAttempts = viper.GetInt("commitment.max_attempts")
+	r.Backoff = viper.GetDuration("commitment.backoff")
+	r.MaxBackoff = viper.GetDuration("commitment.max_backoff")
+	r.Retention = viper.GetDuration("commitment.retention")
+	if r.Workers < 1 {
+		r.Workers = 1
+	}
+	if r.MaxAttempts < 1 {
+		r.MaxAttempts = 1
+	}
+	return r
+}
+
+// Start requeues the requests interrupted by a previous shutdown, starts the workers and purges the
+// reported requests past the retention.
+func (r *Reporter) Start() error {
+	logger := logging.Logger.WithField("module", "commitment")
+	count, err := r.CommitmentStore.ResetProcessing()
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		logger.Infof("resuming %d interrupted storage commitment reports", count)
+	}
+
+	for i := 0; i < r.Workers; i++ {
+		go r.work()
+	}
+	if r.Retention > 0 {
+		go func() {
+			for {
+				if _, err := r.CommitmentStore.PurgeReported(time.Now().Add(-r.Retention)); err != nil {
+					logger.Error(err)
+				}
+				time.Sleep(purgeInterval)
+			}
+		}()
+	}
+	return nil
+}
+
+// Submit records a storage commitment request received on the association and queues its report.
+func (r *Reporter) Submit(commitment *models.StorageCommitment, association *dimse.Association) error {
+	commitment.Status = models.CommitmentStatusPending
+	if err := r.CommitmentStore.Create(commitment); err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	r.associations[commitment.ID] = association
+	r.mutex.Unlock()
+
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (r *Reporter) work() {
+	logger := logging.Logger.WithField("module", "commitment")
+	for {
+		commitment, err := r.CommitmentStore.ClaimNext()
+		if err != nil {
+			logger.Error(err)
+		}
+		if commitment == nil {
+			select {
+			case <-r.wake:
+			case <-time.After(r.PollInterval):
+			}
+			continue
+		}
+		r.process(commitment)
+	}
+}
+
+// process verifies the instances of a request and reports the result, and records the outcome.
+func (r *Reporter) process(commitment *models.StorageCommitment) {
+	logger := logging.Logger.WithField("module", "commitment").
+		WithField("calling_ae", commitment.CallingAETitle).
+		WithField("transaction", commitment.TransactionUID)
+
+	committed, err := Verify(r.InstanceStore, commitment.Items)
+	if err == nil {
+		commitment.Committed, commitment.Failed = committed, len(commitment.Items)-committed
+		err = r.report(commitment)
+	}
+
+	commitment.Attempts++
+	switch {
+	case err == nil:
+		commitment.Status = models.CommitmentStatusReported
+		commitment.LastError = ""
+		logger.Infof("reported %d committed and %d failed instances", commitment.Committed, commitment.Failed)
+	case commitment.Attempts >= r.MaxAttempts:
+		commitment.Status = models.CommitmentStatusFailed
+		commitment.LastError = err.Error()
+		logger.Errorf("giving up after attempt %d: %v", commitment.Attempts, err)
+	default:
+		commitment.Status = models.CommitmentStatusPending
+		commitment.LastError = err.Error()
+		commitment.NextAttemptAt = time.Now().Add(r.backoff(commitment.Attempts))
+		logger.Warnf("attempt %d failed: %v", commitment.Attempts, err)
+	}
+	if commitment.Status != models.CommitmentStatusPending {
+		r.mutex.Lock()
+		delete(r.associations, commitment.ID)
+		r.mutex.Unlock()
+	}
+	if err := r.CommitmentStore.Update(commitment); err != nil {
+		logger.Error(err)
+	}
+}
+
+// report sends the result on the requesting association if it is still open, or else on a new one.
+func (r *Reporter) report(commitment *models.StorageCommitment) error {
+	r.mutex.Lock()
+	association := r.associations[commitment.ID]
+	r.mutex.Unlock()
+
+	if association != nil && association.HasContext(dimse.StorageCommitmentPushModel) {
+		select {
+		case <-association.Done():
+		default:
+			if err := r.sendReport(association, commitment); err == nil {
+				return nil
+			}
+		}
+	}
+
+	node, err := r.RemoteNodeStore.GetByAETitle(commitment.CallingAETitle)
+	if err != nil || node.Host == "" {
+		return errUnknownNode
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), reportTimeout)
+	defer cancel()
+	contexts := []*dimse.PresentationContext{{
+		AbstractSyntax:   dimse.StorageCommitmentPushModel,
+		TransferSyntaxes: dimse.UncompressedTransferSyntaxes,
+		SCPRole:          true,
+	}}
+	association, err = dimse.Dial(ctx, node.Addr(), r.AETitle, node.AETitle, contexts, &dimse.DialOptions{Timeout: reportTimeout})
+	if err != nil {
+		return err
+	}
+	defer association.Release()
+	return r.sendReport(association, commitment)
+}
+
+// sendReport sends the N-EVENT-REPORT of the result.
+func (r *Reporter) sendReport(association *dimse.Association, commitment *models.StorageCommitment) error {
+	transferSyntaxes := association.TransferSyntaxes(dimse.StorageCommitmentPushModel)
+	if len(transferSyntaxes) == 0 {
+		return fmt.Errorf("no presentation context accepted for storage commitment")
+	}
+	eventTypeID, data, err := EncodeResult(commitment, r.AETitle, transferSyntaxes[0])
+	if err != nil {
+		return err
+	}
+	command := &dimse.Command{
+		CommandField:           dimse.NEventReportRQ,
+		AffectedSOPClassUID:    dimse.StorageCommitmentPushModel,
+		AffectedSOPInstanceUID: dimse.StorageCommitmentPushModelInstance,
+		EventTypeID:            eventTypeID,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), reportTimeout)
+	defer cancel()
+	response, err := association.Request(ctx, dimse.StorageCommitmentPushModel, transferSyntaxes[0], command, data, nil)
+	if err != nil {
+		return err
+	}
+	if dimse.IsFailure(response.Command.Status) {
+		return fmt.Errorf("N-EVENT-REPORT failed with status 0x%04X", response.Command.Status)
+	}
+	return nil
+}
+
+// EncodeResult returns the event type and the data set reporting the result of the verified request.
+func EncodeResult(commitment *models.StorageCommitment, aeTitle string, transferSyntax string) (uint16, []byte, error) {
+	var committed, failed []*dimse.DataSet
+	for _, item := range commitment.Items {
+		reference := &dimse.DataSet{}
+		reference.Set(tag.ReferencedSOPClassUID, item.SOPClassUID)
+		reference.Set(tag.ReferencedSOPInstanceUID, item.SOPInstanceUID)
+		if item.FailureReason == 0 {
+			committed = append(committed, reference)
+		} else {
+			reference.SetUint16(tag.FailureReason, uint16(item.FailureReason))
+			failed = append(failed, reference)
+		}
+	}
+
+	result := &dimse.DataSet{}
+	result.Set(tag.TransactionUID, commitment.TransactionUID)
+	result.Set(tag.RetrieveAETitle, aeTitle)
+	if len(committed) > 0 {
+		result.SetItems(tag.ReferencedSOPSequence, committed)
+	}
+	eventTypeID := EventAllCommitted
+	if len(failed) > 0 {
+		result.SetItems(tag.FailedSOPSequence, failed)
+		eventTypeID = EventSomeFailed
+	}
+	data, err := result.Encode(transferSyntax)
+	return eventTypeID, data, err
+}
+
+// backoff returns the delay before the next attempt, doubling with every failed attempt up to MaxBackoff.
+func (r *Reporter) backoff(attempts int) time.Duration {
+	delay := r.Backoff
+	for i := 1; i < attempts && delay < r.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if r.MaxBackoff > 0 && delay > r.MaxBackoff {
+		delay = r.MaxBackoff
+	}
+	return delay
+}
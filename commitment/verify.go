@@ -0,0 +1,66 @@
+// Package commitment verifies that instances are safely archived, for the Storage Commitment Push Model
+// SOP class and the /api/commitment check, and reports the results of storage commitment requests.
+package commitment
+
+import (
+	"dicom-store-api/database"
+	"dicom-store-api/fs"
+	"dicom-store-api/models"
+
+	"github.com/go-pg/pg"
+)
+
+// Failure reasons of the instances that are not committed, PS3.4 section J.3.
+const (
+	FailureProcessing            uint16 = 0x0110
+	FailureNoSuchInstance        uint16 = 0x0112
+	FailureClassInstanceConflict uint16 = 0x0119
+)
+
+type InstanceStore interface {
+	FindBy(fields map[string]any, options *database.SelectQueryOptions, tx *pg.Tx) ([]*models.Instance, error)
+}
+
+// Verify sets the failure reason of every item, zero for the instances committed: stored, of the referenced
+// SOP class, with a file matching the recorded size and checksum. It returns the number of committed items.
+func Verify(instanceStore InstanceStore, items []*models.StorageCommitmentItem) (int, error) {
+	uids := make([]string, len(items))
+	for i, item := range items {
+		uids[i] = item.SOPInstanceUID
+	}
+	found, err := instanceStore.FindBy(map[string]any{"SOPInstanceUID": uids}, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	byUID := map[string]*models.Instance{}
+	for _, instance := range found {
+		byUID[instance.SOPInstanceUID] = instance
+	}
+
+	committed := 0
+	for _, item := range items {
+		item.FailureReason = int(verifyInstance(byUID[item.SOPInstanceUID], item.SOPClassUID))
+		if item.FailureReason == 0 {
+			committed++
+		}
+	}
+	return committed, nil
+}
+
+// verifyInstance returns the failure reason of the instance, zero if it is committed.
+func verifyInstance(instance *models.Instance, sopClassUID string) uint16 {
+	if instance == nil || instance.Series == nil || instance.Series.Study == nil {
+		return FailureNoSuchInstance
+	}
+	if sopClassUID != "" && instance.SOPClassUID != sopClassUID {
+		return FailureClassInstanceConflict
+	}
+	data, err := fs.ReadDicomPath(fs.GetDicomPath(instance.Series.Study, instance.Series, instance), instance.FileCompression)
+	if err != nil {
+		return FailureProcessing
+	}
+	if instance.FileSHA256 == "" || int64(len(data)) != instance.FileSize || fs.Checksum(data) != instance.FileSHA256 {
+		return FailureProcessing
+	}
+	return 0
+}
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"dicom-store-api/models"
+	"dicom-store-api/utils"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/go-pg/pg/orm"
+	"github.com/suyashkumar/dicom/pkg/tag"
+)
+
+// attributeAssignments returns the "column = ?n" assignments of the DICOM attribute fields of object with their
+// values as query params, and the UID column with its value. The UID field and the excluded fields are left out.
+func attributeAssignments(object models.DicomObject, exclude ...string) (string, []any, string, any) {
+	table := orm.GetTable(reflect.TypeOf(object).Elem())
+	value := reflect.ValueOf(object).Elem()
+	uidTagInfo, _ := tag.Find(object.GetObjectIdFieldTag())
+
+	var assignments []string
+	var params []any
+	var uidColumn string
+	var uid any
+	for _, field := range table.DataFields {
+		name := field.Field.Tag.Get("dicom")
+		if name == "" || contains(exclude, field.GoName) {
+			continue
+		}
+		if name == uidTagInfo.Name {
+			uidColumn, uid = string(field.Column), field.Value(value).Interface()
+			continue
+		}
+		// empty values are stored as NULL, as on insert
+		var param any
+		if fieldValue := field.Value(value); !fieldValue.IsZero() {
+			param = fieldValue.Interface()
+		}
+		assignments = append(assignments, fmt.Sprintf("%s = ?%d", field.Column, len(params)))
+		params = append(params, param)
+	}
+	return strings.Join(assignments, ", "), params, uidColumn, uid
+}
+
+// fieldAssignments returns the "column = ?n" assignments of the fields, named after the fields of model, with
+// their values as query params. Empty values are stored as NULL.
+func fieldAssignments(model any, fields map[string]any) (string, []any, error) {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		if _, ok := reflect.TypeOf(model).Elem().FieldByName(name); !ok {
+			return "", nil, fmt.Errorf("invalid field name: %s", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var assignments []string
+	var params []any
+	for _, name := range names {
+		param := fields[name]
+		if param == "" {
+			param = nil
+		}
+		assignments = append(assignments, fmt.Sprintf("%s = ?%d", utils.ToSnakeCase(name), len(params)))
+		params = append(params, param)
+	}
+	return strings.Join(assignments, ", "), params, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package database
+
+import (
+	"dicom-store-api/models"
+	"time"
+
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+)
+
+// CommitmentStore implements database operations for the storage commitment requests.
+type CommitmentStore struct {
+	db *pg.DB
+}
+
+// NewCommitmentStore returns a CommitmentStore implementation.
+func NewCommitmentStore(db *pg.DB) *CommitmentStore {
+	return &CommitmentStore{
+		db: db,
+	}
+}
+
+// Get gets a storage commitment by ID together with its items.
+func (store *CommitmentStore) Get(commitmentID int) (*models.StorageCommitment, error) {
+	commitment := &models.StorageCommitment{}
+	err := store.db.Model(commitment).
+		Where("storage_commitment.id = ?", commitmentID).
+		Relation("Items", func(q *orm.Query) (*orm.Query, error) {
+			return q.Order("id ASC"), nil
+		}).
+		Select()
+	return commitment, err
+}
+
+// List returns the storage commitments without their items, of the calling AE title if it is set.
+func (store *CommitmentStore) List(callingAETitle string, options *SelectQueryOptions) ([]*models.StorageCommitment, error) {
+	var result []*models.StorageCommitment
+	query := store.db.Model(&result)
+	if callingAETitle != "" {
+		query.Where("storage_commitment.calling_ae_title = ?", callingAETitle)
+	}
+	options.Apply(query)
+
+	err := query.Select()
+	return result, err
+}
+
+// Create creates a storage commitment with its items.
+func (store *CommitmentStore) Create(commitment *models.StorageCommitment) error {
+	return store.db.RunInTransaction(func(tx *pg.Tx) error {
+		if _, err := tx.Model(commitment).Insert(); err != nil {
+			return err
+		}
+		if len(commitment.Items) == 0 {
+			return nil
+		}
+		for _, item := range commitment.Items {
+			item.StorageCommitmentId = commitment.ID
+		}
+		_, err := tx.Model(&commitment.Items).Insert()
+		return err
+	})
+}
+
+// Update saves a storage commitment and the failure reasons of its items.
+func (store *CommitmentStore) Update(commitment *models.StorageCommitment) error {
+	return store.db.RunInTransaction(func(tx *pg.Tx) error {
+		if _, err := tx.Model(commitment).WherePK().Update(); err != nil {
+			return err
+		}
+		for _, item := range commitment.Items {
+			if _, err := tx.Model(item).Column("failure_reason").WherePK().Update(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ClaimNext marks the oldest pending storage commitment that is due as processing and returns it with
+// its items, or nil if there is none. Concurrent workers never claim the same one.
+func (store *CommitmentStore) ClaimNext() (*models.StorageCommitment, error) {
+	var commitments []*models.StorageCommitment
+	_, err := store.db.Query(&commitments, `
+		UPDATE storage_commitment SET status = ?0, updated_at = now()
+		WHERE id = (
+			SELECT id FROM storage_commitment
+			WHERE status = ?1 AND next_attempt_at <= now()
+			ORDER BY id LIMIT 1 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`, models.CommitmentStatusProcessing, models.CommitmentStatusPending)
+	if err != nil || len(commitments) == 0 {
+		return nil, err
+	}
+	commitment := commitments[0]
+	err = store.db.Model(&commitment.Items).
+		Where("storage_commitment_id = ?", commitment.ID).
+		Order("id ASC").
+		Select()
+	return commitment, err
+}
+
+// ResetProcessing puts the storage commitments left in processing by a previous run back in the queue.
+func (store *CommitmentStore) ResetProcessing() (int, error) {
+	result, err := store.db.Exec(`UPDATE storage_commitment SET status = ?, updated_at = now() WHERE status = ?`,
+		models.CommitmentStatusPending, models.CommitmentStatusProcessing)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// PurgeReported deletes the storage commitments reported before the time and returns their number.
+func (store *CommitmentStore) PurgeReported(reportedBefore time.Time) (int, error) {
+	result, err := store.db.Exec(`DELETE FROM storage_commitment WHERE status = ? AND updated_at < ?`,
+		models.CommitmentStatusReported, reportedBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+func (store *CommitmentStore) GetOrm(tx *pg.Tx) orm.DB {
+	if tx != nil {
+		return tx
+	} else {
+		return store.db
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/go-pg/pg"
 	"github.com/go-pg/pg/orm"
 	"reflect"
+	"time"
 )
 
 // InstanceStore implements database operations for instance management.
@@ -26,7 +27,7 @@ func (store *InstanceStore) FindBy(fields map[string]any, options *SelectQueryOp
 	tableName := (&models.Instance{}).GetTableName()
 
 	var result []*models.Instance
-	query := db.Model(&result)
+	query := db.Model(&result).Where(instanceNotRejected)
 	for fieldName, fieldValue := range fields {
 		structField := reflect.ValueOf(&models.Instance{}).Elem().FieldByName(fieldName)
 		if !structField.IsValid() {
@@ -34,16 +35,7 @@ func (store *InstanceStore) FindBy(fields map[string]any, options *SelectQueryOp
 		}
 		columnName := utils.ToSnakeCase(fieldName)
 
-		rt := reflect.TypeOf(fieldValue)
-		if rt.Kind() == reflect.Slice || rt.Kind() == reflect.Array {
-			var values []interface{}
-			for i := 0; i < reflect.ValueOf(fieldValue).Len(); i++ {
-				values = append(values, reflect.ValueOf(fieldValue).Index(i).Interface())
-			}
-			query.WhereIn(fmt.Sprintf("%s.%s IN (?)", tableName, columnName), values...)
-		} else {
-			query.Where(fmt.Sprintf("%s.%s = ?", tableName, columnName), fieldValue)
-		}
+		whereField(query, tableName+"."+columnName, fieldValue)
 	}
 	query.Relation("Series")
 	query.Relation("Series.Study")
@@ -59,7 +51,7 @@ func (store *InstanceStore) CountBy(fields map[string]any, tx *pg.Tx) (int, erro
 	tableName := (&models.Instance{}).GetTableName()
 
 	var count int
-	query := db.Model(&models.Instance{}).ColumnExpr("count(*)")
+	query := db.Model(&models.Instance{}).ColumnExpr("count(*)").Where(instanceNotRejected)
 	for fieldName, fieldValue := range fields {
 		structField := reflect.ValueOf(&models.Instance{}).Elem().FieldByName(fieldName)
 		if !structField.IsValid() {
@@ -67,16 +59,7 @@ func (store *InstanceStore) CountBy(fields map[string]any, tx *pg.Tx) (int, erro
 		}
 		columnName := utils.ToSnakeCase(fieldName)
 
-		rt := reflect.TypeOf(fieldValue)
-		if rt.Kind() == reflect.Slice || rt.Kind() == reflect.Array {
-			var values []interface{}
-			for i := 0; i < reflect.ValueOf(fieldValue).Len(); i++ {
-				values = append(values, reflect.ValueOf(fieldValue).Index(i).Interface())
-			}
-			query.WhereIn(fmt.Sprintf("%s.%s IN (?)", tableName, columnName), values...)
-		} else {
-			query.Where(fmt.Sprintf("%s.%s = ?", tableName, columnName), fieldValue)
-		}
+		whereField(query, tableName+"."+columnName, fieldValue)
 	}
 	_, err := query.SelectAndCount(&count)
 
@@ -107,6 +90,269 @@ func (store *InstanceStore) Create(instance *models.Instance, tx *pg.Tx) error {
 	return err
 }
 
+// Upsert creates the instance or, if one with the same UID exists, loads the stored row into it.
+// Concurrent upserts of the same UID wait for each other instead of failing on the unique constraint.
+// An instance in the trash is taken out of it.
+func (store *InstanceStore) Upsert(instance *models.Instance, tx *pg.Tx) error {
+	db := store.GetOrm(tx)
+	_, err := db.Model(instance).
+		OnConflict("(sop_instance_uid) DO UPDATE").
+		Set("updated_at = EXCLUDED.updated_at, deleted_at = NULL").
+		Returning("*").
+		Insert()
+	return err
+}
+
+// UpdateFile records the size, checksum, storage key, compression and tier of a replaced instance file.
+func (store *InstanceStore) UpdateFile(instance *models.Instance, tx *pg.Tx) error {
+	db := store.GetOrm(tx)
+	_, err := db.Model(instance).
+		Column("file_size", "file_sha256", "storage_path", "file_compression", "stored_size", "tier").
+		WherePK().
+		Update()
+	return err
+}
+
+// UpdateAttributes overwrites the stored DICOM attributes of the instance with the same UID, trashed or not,
+// from its file at storagePath. Size, checksum and compression are recorded if they were missing. Instances
+// recorded with a file at another key are not updated. It returns the number of updated rows.
+func (store *InstanceStore) UpdateAttributes(instance *models.Instance, storagePath string, tx *pg.Tx) (int, error) {
+	db := store.GetOrm(tx)
+	assignments, params, uidColumn, uid := attributeAssignments(instance)
+	n := len(params)
+	result, err := db.Exec(fmt.Sprintf(`
+		UPDATE instance SET %s,
+			file_size = coalesce(file_size, ?%d), file_sha256 = coalesce(file_sha256, ?%d), storage_path = ?%d,
+			file_compression = coalesce(file_compression, nullif(?%d, '')), stored_size = coalesce(stored_size, nullif(?%d, 0)), updated_at = now()
+		WHERE %s = ?%d AND coalesce(storage_path, ?%d) = ?%d`,
+		assignments, n, n+1, n+2, n+4, n+5, uidColumn, n+3, n+2, n+2),
+		append(params, instance.FileSize, instance.FileSHA256, storagePath, uid, instance.FileCompression, instance.StoredSize)...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// CountByStoragePath counts the instances, trashed ones included, whose file is stored at the key.
+// In the content layout identical files of several instances share a key.
+func (store *InstanceStore) CountByStoragePath(storagePath string, tx *pg.Tx) (int, error) {
+	db := store.GetOrm(tx)
+	var count int
+	_, err := db.QueryOne(pg.Scan(&count), `SELECT count(*) FROM instance WHERE storage_path = ?`, storagePath)
+	return count, err
+}
+
+// Exists reports whether an instance with the SOPInstanceUID was received, whether it is stored, in the trash
+// or rejected by a rejection note.
+func (store *InstanceStore) Exists(sopInstanceUID string, tx *pg.Tx) (bool, error) {
+	db := store.GetOrm(tx)
+	var exists bool
+	_, err := db.QueryOne(pg.Scan(&exists), `SELECT EXISTS (SELECT 1 FROM instance WHERE sop_instance_uid = ?0)
+		OR EXISTS (SELECT 1 FROM rejected_instance WHERE sop_instance_uid = ?0)`, sopInstanceUID)
+	return exists, err
+}
+
+// Delete moves the instance to the trash.
+func (store *InstanceStore) Delete(instance *models.Instance, deletedAt time.Time, tx *pg.Tx) error {
+	db := store.GetOrm(tx)
+	_, err := db.Exec(`UPDATE instance SET deleted_at = ?0 WHERE id = ?1 AND deleted_at IS NULL`, deletedAt, instance.ID)
+	if err != nil {
+		return err
+	}
+	instance.DeletedAt = pg.NullTime{Time: deletedAt}
+	return nil
+}
+
+// Restore takes the instance out of the trash. The parent series and study are restored as well if they are in the trash.
+func (store *InstanceStore) Restore(instance *models.Instance, tx *pg.Tx) error {
+	db := store.GetOrm(tx)
+	queries := []string{
+		`UPDATE instance SET deleted_at = NULL WHERE id = ?0`,
+		`UPDATE series SET deleted_at = NULL FROM instance WHERE series.id = instance.series_id AND instance.id = ?0`,
+		`UPDATE study SET deleted_at = NULL FROM series, instance
+			WHERE study.id = series.study_id AND series.id = instance.series_id AND instance.id = ?0`,
+	}
+	for _, q := range queries {
+		if _, err := db.Exec(q, instance.ID); err != nil {
+			return err
+		}
+	}
+	instance.DeletedAt = pg.NullTime{}
+	return nil
+}
+
+// Purge permanently deletes an instance from the trash.
+func (store *InstanceStore) Purge(instance *models.Instance, tx *pg.Tx) error {
+	db := store.GetOrm(tx)
+	_, err := db.Model(instance).WherePK().ForceDelete()
+	return err
+}
+
+// FindDeleted returns the instances that were deleted on their own, not along with their series,
+// optionally only those deleted before deletedBefore.
+func (store *InstanceStore) FindDeleted(deletedBefore time.Time, options *SelectQueryOptions, tx *pg.Tx) ([]*models.Instance, error) {
+	return store.findDeleted(tx, func(query *orm.Query) {
+		query.Where("instance.deleted_at IS DISTINCT FROM series.deleted_at")
+		if !deletedBefore.IsZero() {
+			query.Where("instance.deleted_at < ?", deletedBefore)
+		}
+		options.Apply(query)
+	})
+}
+
+// FindDeletedByStudy returns all instances in the trash that belong to the study.
+func (store *InstanceStore) FindDeletedByStudy(studyID int, tx *pg.Tx) ([]*models.Instance, error) {
+	return store.findDeleted(tx, func(query *orm.Query) {
+		query.Where("series.study_id = ?", studyID)
+	})
+}
+
+// FindDeletedBySeries returns all instances in the trash that belong to the series.
+func (store *InstanceStore) FindDeletedBySeries(seriesID int, tx *pg.Tx) ([]*models.Instance, error) {
+	return store.findDeleted(tx, func(query *orm.Query) {
+		query.Where("instance.series_id = ?", seriesID)
+	})
+}
+
+// GetDeleted gets an instance in the trash by its SOPInstanceUID.
+func (store *InstanceStore) GetDeleted(sopInstanceUID string, tx *pg.Tx) (*models.Instance, error) {
+	instances, err := store.findDeleted(tx, func(query *orm.Query) {
+		query.Where("instance.sop_instance_uid = ?", sopInstanceUID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(instances) == 0 {
+		return nil, pg.ErrNoRows
+	}
+	return instances[0], nil
+}
+
+// FindAfter returns up to limit instances with an ID above afterID in ID order with their series and study.
+// Trashed and rejected instances are included, so that the whole archive can be walked in batches.
+func (store *InstanceStore) FindAfter(afterID int, limit int, tx *pg.Tx) ([]*models.Instance, error) {
+	return store.findRaw(tx, `SELECT * FROM instance WHERE id > ? ORDER BY id LIMIT ?`, afterID, limit)
+}
+
+// FindToRecompress returns up to limit instances with an ID above afterID in ID order with their series and study,
+// whose file is in the hot tier and was not yet considered for compression or is stored with another compression.
+func (store *InstanceStore) FindToRecompress(compression string, afterID int, limit int, tx *pg.Tx) ([]*models.Instance, error) {
+	return store.findRaw(tx, `
+		SELECT * FROM instance
+		WHERE id > ? AND deleted_at IS NULL AND (file_compression IS NULL OR file_compression NOT IN (?, 'none'))
+			AND tier IS NULL
+		ORDER BY id LIMIT ?`, afterID, compression, limit)
+}
+
+// FindByStudyAndTier returns the instances of the study whose file is in the tier, trashed ones included,
+// with their series and study.
+func (store *InstanceStore) FindByStudyAndTier(studyID int, tier string, tx *pg.Tx) ([]*models.Instance, error) {
+	return store.findRaw(tx, `
+		SELECT instance.* FROM instance JOIN series ON series.id = instance.series_id
+		WHERE series.study_id = ? AND coalesce(instance.tier, ?) = ?
+		ORDER BY instance.id`, studyID, models.TierHot, tier)
+}
+
+// UpdateTier records the tier of the instance file, for all instances sharing its storage key.
+func (store *InstanceStore) UpdateTier(instance *models.Instance, tx *pg.Tx) error {
+	db := store.GetOrm(tx)
+	_, err := db.Exec(`UPDATE instance SET tier = nullif(?, ?) WHERE id = ? OR storage_path = ?`,
+		instance.Tier, models.TierHot, instance.ID, instance.StoragePath)
+	return err
+}
+
+// LockFile locks the instance row and reloads its file columns. It returns pg.ErrNoRows if the instance was deleted.
+func (store *InstanceStore) LockFile(instance *models.Instance, tx *pg.Tx) error {
+	db := store.GetOrm(tx)
+	_, err := db.QueryOne(instance, `
+		SELECT id, file_size, file_sha256, storage_path, file_compression, stored_size, tier
+		FROM instance WHERE id = ? AND deleted_at IS NULL FOR UPDATE`, instance.ID)
+	return err
+}
+
+// UpdateCompression records the compression of the instance file, for all instances sharing its storage key.
+func (store *InstanceStore) UpdateCompression(instance *models.Instance, tx *pg.Tx) error {
+	db := store.GetOrm(tx)
+	_, err := db.Exec(`
+		UPDATE instance SET file_size = ?, file_sha256 = ?, file_compression = ?, stored_size = ?
+		WHERE id = ? OR storage_path = ?`,
+		instance.FileSize, instance.FileSHA256, instance.FileCompression, instance.StoredSize, instance.ID, instance.StoragePath)
+	return err
+}
+
+func (store *InstanceStore) findRaw(tx *pg.Tx, query string, params ...any) ([]*models.Instance, error) {
+	db := store.GetOrm(tx)
+
+	var result []*models.Instance
+	if _, err := db.Query(&result, query, params...); err != nil || len(result) == 0 {
+		return result, err
+	}
+
+	var seriesIds []int
+	for _, instance := range result {
+		seriesIds = append(seriesIds, instance.SeriesId)
+	}
+	var seriesList []*models.Series
+	if _, err := db.Query(&seriesList, `SELECT * FROM series WHERE id IN (?)`, pg.In(seriesIds)); err != nil {
+		return nil, err
+	}
+	seriesById := map[int]*models.Series{}
+	for _, series := range seriesList {
+		seriesById[series.ID] = series
+	}
+	for _, instance := range result {
+		instance.Series = seriesById[instance.SeriesId]
+	}
+	return result, loadStudies(db, result)
+}
+
+// findDeleted selects instances in the trash with their series and study. The study is loaded separately because
+// a nested relation would only be joined for a series in the same soft delete state as the instance.
+func (store *InstanceStore) findDeleted(tx *pg.Tx, apply func(query *orm.Query)) ([]*models.Instance, error) {
+	db := store.GetOrm(tx)
+
+	var result []*models.Instance
+	query := db.Model(&result).Deleted().Relation("Series")
+	apply(query)
+	if err := query.Select(); err != nil || len(result) == 0 {
+		return result, err
+	}
+	return result, loadStudies(db, result)
+}
+
+// loadStudies sets the study of the series of the instances.
+func loadStudies(db orm.DB, instances []*models.Instance) error {
+	var studyIds []int
+	for _, instance := range instances {
+		studyIds = append(studyIds, instance.Series.StudyId)
+	}
+	var studies []*models.Study
+	if _, err := db.Query(&studies, `SELECT * FROM study WHERE id IN (?)`, pg.In(studyIds)); err != nil {
+		return err
+	}
+	studiesById := map[int]*models.Study{}
+	for _, study := range studies {
+		studiesById[study.ID] = study
+	}
+	for _, instance := range instances {
+		instance.Series.Study = studiesById[instance.Series.StudyId]
+	}
+	return nil
+}
+
+// FindRejected returns the stored instances referenced by the rejection note.
+func (store *InstanceStore) FindRejected(rejectionNoteID int, tx *pg.Tx) ([]*models.Instance, error) {
+	db := store.GetOrm(tx)
+
+	var result []*models.Instance
+	err := db.Model(&result).
+		Relation("Series").
+		Relation("Series.Study").
+		Where("instance.sop_instance_uid IN (SELECT sop_instance_uid FROM rejected_instance WHERE rejection_note_id = ?)", rejectionNoteID).
+		Select()
+	return result, err
+}
+
 func (store *InstanceStore) GetOrm(tx *pg.Tx) orm.DB {
 	if tx != nil {
 		return tx
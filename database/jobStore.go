@@ -0,0 +1,151 @@
+package database
+
+import (
+	"dicom-store-api/models"
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+)
+
+// JobStore implements database operations for ingest job management.
+type JobStore struct {
+	db *pg.DB
+}
+
+// NewJobStore returns a JobStore implementation.
+func NewJobStore(db *pg.DB) *JobStore {
+	return &JobStore{
+		db: db,
+	}
+}
+
+// Get gets a job by job ID together with its items.
+func (store *JobStore) Get(jobID int) (*models.Job, error) {
+	job := models.Job{ID: jobID}
+	err := store.db.Model(&job).
+		Where("job.id = ?", jobID).
+		Relation("Items", func(q *orm.Query) (*orm.Query, error) {
+			return q.Order("item_index ASC"), nil
+		}).
+		Select()
+
+	return &job, err
+}
+
+// Create creates a new job.
+func (store *JobStore) Create(job *models.Job, tx *pg.Tx) error {
+	db := store.GetOrm(tx)
+	_, err := db.Model(job).Insert()
+	return err
+}
+
+// CreateItem creates a new job item.
+func (store *JobStore) CreateItem(item *models.JobItem, tx *pg.Tx) error {
+	db := store.GetOrm(tx)
+	_, err := db.Model(item).Insert()
+	return err
+}
+
+// AddItem creates an item of a job still retrieving its files and counts it in the job total.
+func (store *JobStore) AddItem(item *models.JobItem) error {
+	return store.db.RunInTransaction(func(tx *pg.Tx) error {
+		if _, err := tx.Model(item).Insert(); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`UPDATE job SET total = total + 1, updated_at = now() WHERE id = ?`, item.JobId)
+		return err
+	})
+}
+
+// endRetrieveStatus is the status of a retrieve job once its downloads ended, with ?0 the error that stopped them.
+// The job is completed already if all its items were processed, and failed if it has none.
+const endRetrieveStatus = `CASE
+	WHEN total = 0 AND ?0 <> '' THEN ?1
+	WHEN completed + failed >= total THEN ?2
+	WHEN completed + failed > 0 THEN ?3
+	ELSE ?4 END`
+
+// EndRetrieve records the end of the downloads of a retrieve job, with the error that stopped them if any.
+func (store *JobStore) EndRetrieve(job *models.Job, retrieveError string) error {
+	_, err := store.db.QueryOne(job, `
+		UPDATE job SET status = `+endRetrieveStatus+`, error = nullif(?0, ''), updated_at = now()
+		WHERE id = ?5
+		RETURNING *`, retrieveError, models.JobStatusFailed, models.JobStatusCompleted, models.JobStatusProcessing,
+		models.JobStatusPending, job.ID)
+	return err
+}
+
+// EndInterruptedRetrieves ends the retrieve jobs whose downloads were interrupted by a previous shutdown.
+func (store *JobStore) EndInterruptedRetrieves() (int, error) {
+	result, err := store.db.Exec(`
+		UPDATE job SET status = `+endRetrieveStatus+`, error = ?0, updated_at = now()
+		WHERE status = ?5`, "retrieve interrupted by a shutdown", models.JobStatusFailed, models.JobStatusCompleted,
+		models.JobStatusProcessing, models.JobStatusPending, models.JobStatusRetrieving)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// ClaimNextItem marks the oldest pending item as processing and returns it, or nil if there is none.
+// Concurrent workers never claim the same item.
+func (store *JobStore) ClaimNextItem() (*models.JobItem, error) {
+	item := &models.JobItem{}
+	_, err := store.db.QueryOne(item, `
+		UPDATE job_item SET status = ?, updated_at = now()
+		WHERE id = (
+			SELECT id FROM job_item WHERE status = ? ORDER BY id LIMIT 1 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`, models.JobStatusProcessing, models.JobStatusPending)
+	if err == pg.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = store.db.Exec(`UPDATE job SET status = ?, updated_at = now() WHERE id = ? AND status = ?`,
+		models.JobStatusProcessing, item.JobId, models.JobStatusPending)
+	return item, err
+}
+
+// FinishItem saves the outcome of a processed item and updates the progress of its job.
+func (store *JobStore) FinishItem(item *models.JobItem) error {
+	return store.db.RunInTransaction(func(tx *pg.Tx) error {
+		if _, err := tx.Model(item).WherePK().Update(); err != nil {
+			return err
+		}
+
+		completed, failed := 0, 0
+		if item.Status == models.JobStatusCompleted {
+			completed = 1
+		} else {
+			failed = 1
+		}
+		_, err := tx.Exec(`
+			UPDATE job SET
+				completed = completed + ?0,
+				failed = failed + ?1,
+				status = CASE WHEN completed + failed + 1 >= total AND status <> ?4 THEN ?2 ELSE status END,
+				updated_at = now()
+			WHERE id = ?3`, completed, failed, models.JobStatusCompleted, item.JobId, models.JobStatusRetrieving)
+		return err
+	})
+}
+
+// ResetProcessingItems puts items left in processing by a previous run back in the queue.
+func (store *JobStore) ResetProcessingItems() (int, error) {
+	result, err := store.db.Exec(`UPDATE job_item SET status = ?, updated_at = now() WHERE status = ?`,
+		models.JobStatusPending, models.JobStatusProcessing)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+func (store *JobStore) GetOrm(tx *pg.Tx) orm.DB {
+	if tx != nil {
+		return tx
+	} else {
+		return store.db
+	}
+}
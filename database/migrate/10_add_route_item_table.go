@@ -0,0 +1,61 @@
+package migrate
+
+import (
+	"fmt"
+
+	"github.com/go-pg/migrations"
+)
+
+const routeItemTable = `
+CREATE TABLE route_item (
+id serial NOT NULL,
+created_at timestamp with time zone NOT NULL DEFAULT current_timestamp,
+updated_at timestamp with time zone NOT NULL DEFAULT current_timestamp,
+
+rule varchar(255),
+destination varchar(255) NOT NULL,
+study_instance_uid varchar(64),
+series_instance_uid varchar(64),
+sop_instance_uid varchar(64) NOT NULL,
+status varchar(16) NOT NULL,
+attempts int NOT NULL DEFAULT 0,
+next_attempt_at timestamp with time zone NOT NULL DEFAULT current_timestamp,
+last_error text,
+
+PRIMARY KEY (id)
+)`
+
+const routeItemStatusIndex = `
+CREATE INDEX route_item_status_idx ON route_item (status, next_attempt_at, id)
+`
+
+func init() {
+	up := []string{
+		routeItemTable,
+		routeItemStatusIndex,
+	}
+
+	down := []string{
+		`DROP TABLE route_item`,
+	}
+
+	migrations.Register(func(db migrations.DB) error {
+		fmt.Println("create route item table")
+		for _, q := range up {
+			_, err := db.Exec(q)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}, func(db migrations.DB) error {
+		fmt.Println("drop route item table")
+		for _, q := range down {
+			_, err := db.Exec(q)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
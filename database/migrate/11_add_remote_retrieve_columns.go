@@ -0,0 +1,42 @@
+package migrate
+
+import (
+	"fmt"
+
+	"github.com/go-pg/migrations"
+)
+
+func init() {
+	up := []string{
+		`ALTER TABLE remote_node ADD COLUMN url varchar(2047)`,
+		`ALTER TABLE remote_node ALTER COLUMN host DROP NOT NULL, ALTER COLUMN port DROP NOT NULL`,
+		`ALTER TABLE job ADD COLUMN error text`,
+	}
+
+	down := []string{
+		`ALTER TABLE job DROP COLUMN error`,
+		`DELETE FROM remote_node WHERE host IS NULL OR port IS NULL`,
+		`ALTER TABLE remote_node ALTER COLUMN host SET NOT NULL, ALTER COLUMN port SET NOT NULL`,
+		`ALTER TABLE remote_node DROP COLUMN url`,
+	}
+
+	migrations.Register(func(db migrations.DB) error {
+		fmt.Println("add remote retrieve columns")
+		for _, q := range up {
+			_, err := db.Exec(q)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}, func(db migrations.DB) error {
+		fmt.Println("drop remote retrieve columns")
+		for _, q := range down {
+			_, err := db.Exec(q)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,81 @@
+package migrate
+
+import (
+	"fmt"
+
+	"github.com/go-pg/migrations"
+)
+
+const prefetchTable = `
+CREATE TABLE prefetch (
+id serial NOT NULL,
+created_at timestamp with time zone NOT NULL DEFAULT current_timestamp,
+updated_at timestamp with time zone NOT NULL DEFAULT current_timestamp,
+
+study_instance_uid varchar(64) NOT NULL UNIQUE,
+patient_id varchar(64),
+rule varchar(255),
+status varchar(16) NOT NULL,
+error text,
+
+PRIMARY KEY (id)
+)`
+
+const prefetchPriorTable = `
+CREATE TABLE prefetch_prior (
+id serial NOT NULL,
+created_at timestamp with time zone NOT NULL DEFAULT current_timestamp,
+updated_at timestamp with time zone NOT NULL DEFAULT current_timestamp,
+
+prefetch_id int NOT NULL REFERENCES prefetch (id) ON DELETE CASCADE,
+ae_title varchar(16),
+study_instance_uid varchar(64) NOT NULL,
+study_date varchar(8),
+job_id int,
+error text,
+
+PRIMARY KEY (id)
+)`
+
+const prefetchPriorPrefetchIndex = `
+CREATE INDEX prefetch_prior_prefetch_id_idx ON prefetch_prior (prefetch_id)
+`
+
+// prefetchPriorStudyIndex looks up whether an arriving study is a fetched prior.
+const prefetchPriorStudyIndex = `
+CREATE INDEX prefetch_prior_study_instance_uid_idx ON prefetch_prior (study_instance_uid)
+`
+
+func init() {
+	up := []string{
+		prefetchTable,
+		prefetchPriorTable,
+		prefetchPriorPrefetchIndex,
+		prefetchPriorStudyIndex,
+	}
+
+	down := []string{
+		`DROP TABLE prefetch_prior`,
+		`DROP TABLE prefetch`,
+	}
+
+	migrations.Register(func(db migrations.DB) error {
+		fmt.Println("create prefetch tables")
+		for _, q := range up {
+			_, err := db.Exec(q)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}, func(db migrations.DB) error {
+		fmt.Println("drop prefetch tables")
+		for _, q := range down {
+			_, err := db.Exec(q)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
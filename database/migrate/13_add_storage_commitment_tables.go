@@ -0,0 +1,78 @@
+package migrate
+
+import (
+	"fmt"
+
+	"github.com/go-pg/migrations"
+)
+
+const storageCommitmentTable = `
+CREATE TABLE storage_commitment (
+id serial NOT NULL,
+created_at timestamp with time zone NOT NULL DEFAULT current_timestamp,
+updated_at timestamp with time zone NOT NULL DEFAULT current_timestamp,
+
+transaction_uid varchar(64) NOT NULL,
+calling_ae_title varchar(16) NOT NULL,
+status varchar(16) NOT NULL,
+attempts int NOT NULL DEFAULT 0,
+next_attempt_at timestamp with time zone NOT NULL DEFAULT current_timestamp,
+last_error text,
+committed int NOT NULL DEFAULT 0,
+failed int NOT NULL DEFAULT 0,
+
+PRIMARY KEY (id)
+)`
+
+const storageCommitmentItemTable = `
+CREATE TABLE storage_commitment_item (
+id serial NOT NULL,
+storage_commitment_id int NOT NULL REFERENCES storage_commitment (id) ON DELETE CASCADE,
+sop_class_uid varchar(64),
+sop_instance_uid varchar(64) NOT NULL,
+failure_reason int NOT NULL DEFAULT 0,
+
+PRIMARY KEY (id)
+)`
+
+const storageCommitmentStatusIndex = `
+CREATE INDEX storage_commitment_status_idx ON storage_commitment (status, next_attempt_at, id)
+`
+
+const storageCommitmentItemIndex = `
+CREATE INDEX storage_commitment_item_storage_commitment_id_idx ON storage_commitment_item (storage_commitment_id)
+`
+
+func init() {
+	up := []string{
+		storageCommitmentTable,
+		storageCommitmentItemTable,
+		storageCommitmentStatusIndex,
+		storageCommitmentItemIndex,
+	}
+
+	down := []string{
+		`DROP TABLE storage_commitment_item`,
+		`DROP TABLE storage_commitment`,
+	}
+
+	migrations.Register(func(db migrations.DB) error {
+		fmt.Println("create storage commitment tables")
+		for _, q := range up {
+			_, err := db.Exec(q)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}, func(db migrations.DB) error {
+		fmt.Println("drop storage commitment tables")
+		for _, q := range down {
+			_, err := db.Exec(q)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
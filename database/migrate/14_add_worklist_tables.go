@@ -0,0 +1,104 @@
+package migrate
+
+import (
+	"fmt"
+
+	"github.com/go-pg/migrations"
+)
+
+const worklistItemTable = `
+CREATE TABLE worklist_item (
+id serial NOT NULL,
+created_at timestamp with time zone NOT NULL DEFAULT current_timestamp,
+updated_at timestamp with time zone NOT NULL DEFAULT current_timestamp,
+transaction_uid varchar(64),
+linked_at timestamp with time zone,
+
+sop_instance_uid varchar(64) NOT NULL UNIQUE,
+procedure_step_state varchar(16) NOT NULL,
+patient_name varchar(320),
+patient_id varchar(64) NOT NULL,
+patient_birth_date varchar(8),
+patient_sex varchar(16),
+accession_number varchar(16),
+referring_physician_name varchar(320),
+study_instance_uid varchar(64),
+requested_procedure_id varchar(16),
+requested_procedure_description varchar(64),
+scheduled_procedure_step_id varchar(16),
+scheduled_procedure_step_description varchar(64),
+scheduled_station_ae_title varchar(16),
+modality varchar(16),
+scheduled_procedure_step_start_date varchar(8) NOT NULL,
+scheduled_procedure_step_start_time varchar(16),
+scheduled_procedure_step_priority varchar(16),
+
+PRIMARY KEY (id)
+)`
+
+// worklistItemDateIndex serves the worklist queries of a modality, which are by day.
+const worklistItemDateIndex = `
+CREATE INDEX worklist_item_start_date_idx ON worklist_item (scheduled_procedure_step_start_date, scheduled_station_ae_title)
+`
+
+const worklistItemPatientIndex = `
+CREATE INDEX worklist_item_patient_id_idx ON worklist_item (patient_id)
+`
+
+const worklistItemAccessionIndex = `
+CREATE INDEX worklist_item_accession_number_idx ON worklist_item (accession_number)
+`
+
+// worklistItemStepIndex links arriving series to their scheduled procedure step.
+const worklistItemStepIndex = `
+CREATE INDEX worklist_item_scheduled_procedure_step_id_idx ON worklist_item (scheduled_procedure_step_id)
+`
+
+const worklistSubscriptionTable = `
+CREATE TABLE worklist_subscription (
+id serial NOT NULL,
+created_at timestamp with time zone NOT NULL DEFAULT current_timestamp,
+
+ae_title varchar(16) NOT NULL,
+workitem_uid varchar(64) NOT NULL,
+deletion_lock boolean NOT NULL DEFAULT false,
+
+PRIMARY KEY (id),
+UNIQUE (workitem_uid, ae_title)
+)`
+
+func init() {
+	up := []string{
+		worklistItemTable,
+		worklistItemDateIndex,
+		worklistItemPatientIndex,
+		worklistItemAccessionIndex,
+		worklistItemStepIndex,
+		worklistSubscriptionTable,
+	}
+
+	down := []string{
+		`DROP TABLE worklist_subscription`,
+		`DROP TABLE worklist_item`,
+	}
+
+	migrations.Register(func(db migrations.DB) error {
+		fmt.Println("create worklist tables")
+		for _, q := range up {
+			_, err := db.Exec(q)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}, func(db migrations.DB) error {
+		fmt.Println("drop worklist tables")
+		for _, q := range down {
+			_, err := db.Exec(q)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
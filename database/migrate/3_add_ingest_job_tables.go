@@ -0,0 +1,74 @@
+package migrate
+
+import (
+	"fmt"
+
+	"github.com/go-pg/migrations"
+)
+
+const jobTable = `
+CREATE TABLE job (
+id serial NOT NULL,
+created_at timestamp with time zone NOT NULL DEFAULT current_timestamp,
+updated_at timestamp with time zone NOT NULL DEFAULT current_timestamp,
+
+status varchar(16) NOT NULL,
+total int NOT NULL DEFAULT 0,
+completed int NOT NULL DEFAULT 0,
+failed int NOT NULL DEFAULT 0,
+
+PRIMARY KEY (id)
+)`
+
+const jobItemTable = `
+CREATE TABLE job_item (
+id serial NOT NULL,
+created_at timestamp with time zone NOT NULL DEFAULT current_timestamp,
+updated_at timestamp with time zone NOT NULL DEFAULT current_timestamp,
+job_id int NOT NULL REFERENCES job (id) ON DELETE CASCADE,
+
+item_index int NOT NULL,
+status varchar(16) NOT NULL,
+spool_path varchar(2047) NOT NULL,
+sop_instance_uid varchar(64),
+error text,
+
+PRIMARY KEY (id)
+)`
+
+const jobItemStatusIndex = `
+CREATE INDEX job_item_status_idx ON job_item (status, id)
+`
+
+func init() {
+	up := []string{
+		jobTable,
+		jobItemTable,
+		jobItemStatusIndex,
+	}
+
+	down := []string{
+		`DROP TABLE job_item`,
+		`DROP TABLE job`,
+	}
+
+	migrations.Register(func(db migrations.DB) error {
+		fmt.Println("create ingest job tables")
+		for _, q := range up {
+			_, err := db.Exec(q)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}, func(db migrations.DB) error {
+		fmt.Println("drop ingest job tables")
+		for _, q := range down {
+			_, err := db.Exec(q)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,44 @@
+package migrate
+
+import (
+	"fmt"
+
+	"github.com/go-pg/migrations"
+)
+
+func init() {
+	up := []string{
+		`ALTER TABLE study ADD COLUMN deleted_at timestamp with time zone`,
+		`ALTER TABLE series ADD COLUMN deleted_at timestamp with time zone`,
+		`ALTER TABLE instance ADD COLUMN deleted_at timestamp with time zone`,
+		`CREATE INDEX study_deleted_at_idx ON study (deleted_at) WHERE deleted_at IS NOT NULL`,
+		`CREATE INDEX series_deleted_at_idx ON series (deleted_at) WHERE deleted_at IS NOT NULL`,
+		`CREATE INDEX instance_deleted_at_idx ON instance (deleted_at) WHERE deleted_at IS NOT NULL`,
+	}
+
+	down := []string{
+		`ALTER TABLE instance DROP COLUMN deleted_at`,
+		`ALTER TABLE series DROP COLUMN deleted_at`,
+		`ALTER TABLE study DROP COLUMN deleted_at`,
+	}
+
+	migrations.Register(func(db migrations.DB) error {
+		fmt.Println("add soft delete columns")
+		for _, q := range up {
+			_, err := db.Exec(q)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}, func(db migrations.DB) error {
+		fmt.Println("drop soft delete columns")
+		for _, q := range down {
+			_, err := db.Exec(q)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
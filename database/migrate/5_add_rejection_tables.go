@@ -0,0 +1,86 @@
+package migrate
+
+import (
+	"fmt"
+
+	"github.com/go-pg/migrations"
+)
+
+const rejectionNoteTable = `
+CREATE TABLE rejection_note (
+id serial NOT NULL,
+created_at timestamp with time zone NOT NULL DEFAULT current_timestamp,
+updated_at timestamp with time zone NOT NULL DEFAULT current_timestamp,
+
+study_instance_uid varchar(64) NOT NULL,
+series_instance_uid varchar(64) NOT NULL,
+sop_instance_uid varchar(64) NOT NULL UNIQUE,
+code_value varchar(16) NOT NULL,
+code_meaning varchar(64),
+
+PRIMARY KEY (id)
+)`
+
+const rejectedInstanceTable = `
+CREATE TABLE rejected_instance (
+id serial NOT NULL,
+created_at timestamp with time zone NOT NULL DEFAULT current_timestamp,
+rejection_note_id int NOT NULL REFERENCES rejection_note (id) ON DELETE CASCADE,
+
+study_instance_uid varchar(64) NOT NULL,
+series_instance_uid varchar(64) NOT NULL,
+sop_class_uid varchar(64),
+sop_instance_uid varchar(64) NOT NULL UNIQUE,
+
+PRIMARY KEY (id)
+)`
+
+const rejectedInstanceNoteIndex = `
+CREATE INDEX rejected_instance_rejection_note_id_idx ON rejected_instance (rejection_note_id)
+`
+
+// The visibility checks of rejected objects look up the instances of a series and the series of a study.
+const seriesStudyIndex = `
+CREATE INDEX series_study_id_idx ON series (study_id)
+`
+
+const instanceSeriesIndex = `
+CREATE INDEX instance_series_id_idx ON instance (series_id)
+`
+
+func init() {
+	up := []string{
+		rejectionNoteTable,
+		rejectedInstanceTable,
+		rejectedInstanceNoteIndex,
+		seriesStudyIndex,
+		instanceSeriesIndex,
+	}
+
+	down := []string{
+		`DROP INDEX instance_series_id_idx`,
+		`DROP INDEX series_study_id_idx`,
+		`DROP TABLE rejected_instance`,
+		`DROP TABLE rejection_note`,
+	}
+
+	migrations.Register(func(db migrations.DB) error {
+		fmt.Println("create rejection note tables")
+		for _, q := range up {
+			_, err := db.Exec(q)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}, func(db migrations.DB) error {
+		fmt.Println("drop rejection note tables")
+		for _, q := range down {
+			_, err := db.Exec(q)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
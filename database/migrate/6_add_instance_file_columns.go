@@ -0,0 +1,41 @@
+package migrate
+
+import (
+	"fmt"
+
+	"github.com/go-pg/migrations"
+)
+
+func init() {
+	up := []string{
+		`ALTER TABLE instance ADD COLUMN file_size bigint`,
+		`ALTER TABLE instance ADD COLUMN file_sha256 varchar(64)`,
+		`ALTER TABLE instance ADD COLUMN storage_path varchar(1024)`,
+	}
+
+	down := []string{
+		`ALTER TABLE instance DROP COLUMN storage_path`,
+		`ALTER TABLE instance DROP COLUMN file_sha256`,
+		`ALTER TABLE instance DROP COLUMN file_size`,
+	}
+
+	migrations.Register(func(db migrations.DB) error {
+		fmt.Println("add instance file columns")
+		for _, q := range up {
+			_, err := db.Exec(q)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}, func(db migrations.DB) error {
+		fmt.Println("drop instance file columns")
+		for _, q := range down {
+			_, err := db.Exec(q)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,39 @@
+package migrate
+
+import (
+	"fmt"
+
+	"github.com/go-pg/migrations"
+)
+
+func init() {
+	up := []string{
+		`ALTER TABLE instance ADD COLUMN file_compression varchar(16)`,
+		`ALTER TABLE instance ADD COLUMN stored_size bigint`,
+	}
+
+	down := []string{
+		`ALTER TABLE instance DROP COLUMN stored_size`,
+		`ALTER TABLE instance DROP COLUMN file_compression`,
+	}
+
+	migrations.Register(func(db migrations.DB) error {
+		fmt.Println("add instance compression columns")
+		for _, q := range up {
+			_, err := db.Exec(q)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}, func(db migrations.DB) error {
+		fmt.Println("drop instance compression columns")
+		for _, q := range down {
+			_, err := db.Exec(q)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,40 @@
+package migrate
+
+import (
+	"fmt"
+
+	"github.com/go-pg/migrations"
+)
+
+func init() {
+	up := []string{
+		`ALTER TABLE instance ADD COLUMN tier varchar(8)`,
+		`ALTER TABLE study ADD COLUMN last_accessed_at timestamptz`,
+		`UPDATE study SET instance_availability = 'ONLINE'`,
+	}
+
+	down := []string{
+		`ALTER TABLE study DROP COLUMN last_accessed_at`,
+		`ALTER TABLE instance DROP COLUMN tier`,
+	}
+
+	migrations.Register(func(db migrations.DB) error {
+		fmt.Println("add tiering columns")
+		for _, q := range up {
+			_, err := db.Exec(q)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}, func(db migrations.DB) error {
+		fmt.Println("drop tiering columns")
+		for _, q := range down {
+			_, err := db.Exec(q)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,51 @@
+package migrate
+
+import (
+	"fmt"
+
+	"github.com/go-pg/migrations"
+)
+
+const remoteNodeTable = `
+CREATE TABLE remote_node (
+id serial NOT NULL,
+created_at timestamp with time zone NOT NULL DEFAULT current_timestamp,
+updated_at timestamp with time zone NOT NULL DEFAULT current_timestamp,
+
+ae_title varchar(16) NOT NULL UNIQUE,
+host varchar(255) NOT NULL,
+port int NOT NULL,
+description text,
+
+PRIMARY KEY (id)
+)`
+
+func init() {
+	up := []string{
+		remoteNodeTable,
+	}
+
+	down := []string{
+		`DROP TABLE remote_node`,
+	}
+
+	migrations.Register(func(db migrations.DB) error {
+		fmt.Println("create remote node table")
+		for _, q := range up {
+			_, err := db.Exec(q)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}, func(db migrations.DB) error {
+		fmt.Println("drop remote node table")
+		for _, q := range down {
+			_, err := db.Exec(q)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,105 @@
+package database
+
+import (
+	"dicom-store-api/models"
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+)
+
+// PrefetchStore implements database operations for the prefetch of prior studies.
+type PrefetchStore struct {
+	db *pg.DB
+}
+
+// NewPrefetchStore returns a PrefetchStore implementation.
+func NewPrefetchStore(db *pg.DB) *PrefetchStore {
+	return &PrefetchStore{
+		db: db,
+	}
+}
+
+// Get gets a prefetch by ID together with its priors.
+func (store *PrefetchStore) Get(prefetchID int) (*models.Prefetch, error) {
+	prefetch := &models.Prefetch{}
+	err := store.db.Model(prefetch).
+		Where("prefetch.id = ?", prefetchID).
+		Relation("Priors", func(q *orm.Query) (*orm.Query, error) {
+			return q.Order("id ASC"), nil
+		}).
+		Select()
+	return prefetch, err
+}
+
+// List returns the prefetches with their priors, of the patient if patientID is set.
+func (store *PrefetchStore) List(patientID string, options *SelectQueryOptions) ([]*models.Prefetch, error) {
+	var result []*models.Prefetch
+	query := store.db.Model(&result).
+		Relation("Priors", func(q *orm.Query) (*orm.Query, error) {
+			return q.Order("id ASC"), nil
+		})
+	if patientID != "" {
+		query.Where("prefetch.patient_id = ?", patientID)
+	}
+	options.Apply(query)
+
+	err := query.Select()
+	return result, err
+}
+
+// Start creates the prefetch of a study, unless the study had one already or is itself a fetched prior.
+// It reports whether the prefetch was created.
+func (store *PrefetchStore) Start(prefetch *models.Prefetch) (bool, error) {
+	if err := prefetch.BeforeInsert(store.db); err != nil {
+		return false, err
+	}
+	result, err := store.db.QueryOne(prefetch, `
+		INSERT INTO prefetch (created_at, updated_at, study_instance_uid, patient_id, rule, status)
+		SELECT now(), now(), ?0, ?1, ?2, ?3
+		WHERE NOT EXISTS (SELECT 1 FROM prefetch_prior WHERE study_instance_uid = ?0)
+		ON CONFLICT (study_instance_uid) DO NOTHING
+		RETURNING *`, prefetch.StudyInstanceUID, prefetch.PatientID, prefetch.Rule, prefetch.Status)
+	if err == pg.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return result.RowsReturned() == 1, nil
+}
+
+// Update saves a prefetch.
+func (store *PrefetchStore) Update(prefetch *models.Prefetch) error {
+	_, err := store.db.Model(prefetch).WherePK().Update()
+	return err
+}
+
+// CreatePrior records a prior of a prefetch.
+func (store *PrefetchStore) CreatePrior(prior *models.PrefetchPrior) error {
+	_, err := store.db.Model(prior).Insert()
+	return err
+}
+
+// UpdatePrior saves a prior.
+func (store *PrefetchStore) UpdatePrior(prior *models.PrefetchPrior) error {
+	_, err := store.db.Model(prior).WherePK().Update()
+	return err
+}
+
+// EndInterrupted fails the prefetches left searching by a previous run and returns their number.
+func (store *PrefetchStore) EndInterrupted() (int, error) {
+	result, err := store.db.Exec(`
+		UPDATE prefetch SET status = ?0, error = 'interrupted', updated_at = now() WHERE status = ?1`,
+		models.PrefetchStatusFailed, models.PrefetchStatusSearching)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+func (store *PrefetchStore) GetOrm(tx *pg.Tx) orm.DB {
+	if tx != nil {
+		return tx
+	} else {
+		return store.db
+	}
+}
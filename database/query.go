@@ -1,6 +1,12 @@
 package database
 
-import "github.com/go-pg/pg/orm"
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-pg/pg/orm"
+)
 
 type SelectQueryOptions struct {
 	Limit          int
@@ -31,3 +37,59 @@ func (s *SelectQueryOptions) Apply(q *orm.Query) *orm.Query {
 
 	return q
 }
+
+// Wildcard matches a field against a pattern where * matches any sequence of characters and ? a single one.
+type Wildcard string
+
+// Range matches a date or time field between two values, either of which may be empty for an open range.
+// The upper bound is compared at its own precision, so that a time range up to 1300 includes 130059.
+type Range struct {
+	From string
+	To   string
+}
+
+// whereField adds the condition matching column to a FindBy or CountBy field value: a single value, a list of
+// values, a Wildcard or a Range.
+func whereField(query *orm.Query, column string, fieldValue any) {
+	switch value := fieldValue.(type) {
+	case Wildcard:
+		query.Where(fmt.Sprintf("%s LIKE ?", column), wildcardPattern(string(value)))
+	case Range:
+		if value.From != "" {
+			query.Where(fmt.Sprintf("%s >= ?", column), value.From)
+		}
+		if value.To != "" {
+			query.Where(fmt.Sprintf("left(%s, ?) <= ?", column), len(value.To), value.To)
+		}
+	default:
+		rt := reflect.TypeOf(fieldValue)
+		if rt.Kind() == reflect.Slice || rt.Kind() == reflect.Array {
+			var values []interface{}
+			for i := 0; i < reflect.ValueOf(fieldValue).Len(); i++ {
+				values = append(values, reflect.ValueOf(fieldValue).Index(i).Interface())
+			}
+			query.WhereIn(fmt.Sprintf("%s IN (?)", column), values...)
+		} else {
+			query.Where(fmt.Sprintf("%s = ?", column), fieldValue)
+		}
+	}
+}
+
+// wildcardPattern translates a wildcard pattern to a LIKE pattern.
+func wildcardPattern(pattern string) string {
+	var b strings.Builder
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteRune('%')
+		case '?':
+			b.WriteRune('_')
+		case '%', '_', '\\':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"dicom-store-api/models"
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+)
+
+// Conditions hiding instances referenced by a rejection note, and series and studies left without
+// any other instance, from the study, series and instance queries.
+const (
+	instanceNotRejected = `NOT EXISTS (SELECT 1 FROM rejected_instance WHERE rejected_instance.sop_instance_uid = instance.sop_instance_uid)`
+	seriesNotRejected   = `EXISTS (SELECT 1 FROM instance WHERE instance.series_id = series.id AND instance.deleted_at IS NULL AND ` + instanceNotRejected + `)`
+	studyNotRejected    = `EXISTS (SELECT 1 FROM series JOIN instance ON instance.series_id = series.id
+		WHERE series.study_id = study.id AND instance.deleted_at IS NULL AND ` + instanceNotRejected + `)`
+)
+
+// RejectionStore implements database operations for IOCM rejection notes.
+type RejectionStore struct {
+	db *pg.DB
+}
+
+// NewRejectionStore returns a RejectionStore implementation.
+func NewRejectionStore(db *pg.DB) *RejectionStore {
+	return &RejectionStore{
+		db: db,
+	}
+}
+
+// Get gets a rejection note with the instances it references.
+func (store *RejectionStore) Get(rejectionNoteID int) (*models.RejectionNote, error) {
+	note := &models.RejectionNote{ID: rejectionNoteID}
+	err := store.db.Model(note).
+		Relation("Instances", func(q *orm.Query) (*orm.Query, error) {
+			return q.Order("rejected_instance.id ASC"), nil
+		}).
+		WherePK().
+		Select()
+	return note, err
+}
+
+// FindBy returns the rejection notes, filtered by code value if it is set.
+func (store *RejectionStore) FindBy(codeValue string, options *SelectQueryOptions) ([]*models.RejectionNote, error) {
+	var result []*models.RejectionNote
+	query := store.db.Model(&result)
+	if codeValue != "" {
+		query.Where("rejection_note.code_value = ?", codeValue)
+	}
+	options.Apply(query)
+
+	err := query.Select()
+	return result, err
+}
+
+// Upsert creates the rejection note or, if the same document was received before, loads the stored row into it.
+func (store *RejectionStore) Upsert(note *models.RejectionNote, tx *pg.Tx) error {
+	db := store.GetOrm(tx)
+	_, err := db.Model(note).
+		OnConflict("(sop_instance_uid) DO UPDATE").
+		Set("updated_at = EXCLUDED.updated_at").
+		Returning("*").
+		Insert()
+	return err
+}
+
+// AddInstances records the instances rejected by the note. An instance rejected before keeps its first rejection.
+func (store *RejectionStore) AddInstances(note *models.RejectionNote, instances []*models.RejectedInstance, tx *pg.Tx) error {
+	if len(instances) == 0 {
+		return nil
+	}
+	for _, instance := range instances {
+		instance.RejectionNoteId = note.ID
+	}
+	db := store.GetOrm(tx)
+	_, err := db.Model(&instances).
+		OnConflict("(sop_instance_uid) DO NOTHING").
+		Insert()
+	return err
+}
+
+func (store *RejectionStore) GetOrm(tx *pg.Tx) orm.DB {
+	if tx != nil {
+		return tx
+	} else {
+		return store.db
+	}
+}
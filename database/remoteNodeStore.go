@@ -0,0 +1,62 @@
+package database
+
+import (
+	"dicom-store-api/models"
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+)
+
+// RemoteNodeStore implements database operations for the known remote application entities.
+type RemoteNodeStore struct {
+	db *pg.DB
+}
+
+// NewRemoteNodeStore returns a RemoteNodeStore implementation.
+func NewRemoteNodeStore(db *pg.DB) *RemoteNodeStore {
+	return &RemoteNodeStore{
+		db: db,
+	}
+}
+
+// List returns the remote nodes ordered by AE title.
+func (store *RemoteNodeStore) List() ([]*models.RemoteNode, error) {
+	var result []*models.RemoteNode
+	err := store.db.Model(&result).Order("ae_title ASC").Select()
+	return result, err
+}
+
+// GetByAETitle gets a remote node by AE title.
+func (store *RemoteNodeStore) GetByAETitle(aeTitle string) (*models.RemoteNode, error) {
+	node := &models.RemoteNode{}
+	err := store.db.Model(node).Where("ae_title = ?", aeTitle).Select()
+	return node, err
+}
+
+// Create creates a new remote node.
+func (store *RemoteNodeStore) Create(node *models.RemoteNode, tx *pg.Tx) error {
+	db := store.GetOrm(tx)
+	_, err := db.Model(node).Insert()
+	return err
+}
+
+// Update updates a remote node.
+func (store *RemoteNodeStore) Update(node *models.RemoteNode, tx *pg.Tx) error {
+	db := store.GetOrm(tx)
+	_, err := db.Model(node).WherePK().Update()
+	return err
+}
+
+// Delete deletes a remote node.
+func (store *RemoteNodeStore) Delete(node *models.RemoteNode, tx *pg.Tx) error {
+	db := store.GetOrm(tx)
+	_, err := db.Model(node).WherePK().Delete()
+	return err
+}
+
+func (store *RemoteNodeStore) GetOrm(tx *pg.Tx) orm.DB {
+	if tx != nil {
+		return tx
+	} else {
+		return store.db
+	}
+}
@@ -0,0 +1,151 @@
+package database
+
+import (
+	"dicom-store-api/models"
+	"time"
+
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+)
+
+// RouteCount is the number of routing queue items of a destination in a status. Pending items that
+// failed before are counted apart as retrying.
+type RouteCount struct {
+	Destination string
+	Status      string
+	Retrying    bool
+	Count       int
+}
+
+// RouteStore implements database operations for the routing queue.
+type RouteStore struct {
+	db *pg.DB
+}
+
+// NewRouteStore returns a RouteStore implementation.
+func NewRouteStore(db *pg.DB) *RouteStore {
+	return &RouteStore{
+		db: db,
+	}
+}
+
+// Get gets a routing queue item by ID.
+func (store *RouteStore) Get(itemID int) (*models.RouteItem, error) {
+	item := &models.RouteItem{ID: itemID}
+	err := store.db.Model(item).WherePK().Select()
+	return item, err
+}
+
+// Create queues new items.
+func (store *RouteStore) Create(items []*models.RouteItem, tx *pg.Tx) error {
+	if len(items) == 0 {
+		return nil
+	}
+	db := store.GetOrm(tx)
+	_, err := db.Model(&items).Insert()
+	return err
+}
+
+// Update saves an item.
+func (store *RouteStore) Update(item *models.RouteItem) error {
+	_, err := store.db.Model(item).WherePK().Update()
+	return err
+}
+
+// Delete deletes an item.
+func (store *RouteStore) Delete(item *models.RouteItem) error {
+	_, err := store.db.Model(item).WherePK().Delete()
+	return err
+}
+
+// ClaimNextItems marks up to limit pending items that are due, all for the destination of the oldest one,
+// as processing and returns them. Concurrent workers never claim the same item.
+func (store *RouteStore) ClaimNextItems(limit int) ([]*models.RouteItem, error) {
+	var items []*models.RouteItem
+	_, err := store.db.Query(&items, `
+		UPDATE route_item SET status = ?0, updated_at = now()
+		WHERE id IN (
+			SELECT id FROM route_item
+			WHERE status = ?1 AND next_attempt_at <= now() AND destination = (
+				SELECT destination FROM route_item WHERE status = ?1 AND next_attempt_at <= now()
+				ORDER BY id LIMIT 1
+			)
+			ORDER BY id LIMIT ?2 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`, models.RouteStatusProcessing, models.RouteStatusPending, limit)
+	return items, err
+}
+
+// ResetProcessingItems puts items left in processing by a previous run back in the queue.
+func (store *RouteStore) ResetProcessingItems() (int, error) {
+	result, err := store.db.Exec(`UPDATE route_item SET status = ?, updated_at = now() WHERE status = ?`,
+		models.RouteStatusPending, models.RouteStatusProcessing)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// CountByDestination returns the number of items of every destination by status.
+func (store *RouteStore) CountByDestination() ([]*RouteCount, error) {
+	var result []*RouteCount
+	_, err := store.db.Query(&result, `
+		SELECT destination, status, attempts > 0 AS retrying, count(*) AS count
+		FROM route_item GROUP BY destination, status, attempts > 0
+		ORDER BY destination, status`)
+	return result, err
+}
+
+// FindLastErrors returns the most recently failed item of every destination.
+func (store *RouteStore) FindLastErrors() ([]*models.RouteItem, error) {
+	var result []*models.RouteItem
+	_, err := store.db.Query(&result, `
+		SELECT DISTINCT ON (destination) * FROM route_item
+		WHERE last_error IS NOT NULL
+		ORDER BY destination, updated_at DESC`)
+	return result, err
+}
+
+// FindDead returns the items whose attempts are exhausted, filtered by destination if it is set.
+func (store *RouteStore) FindDead(destination string, options *SelectQueryOptions) ([]*models.RouteItem, error) {
+	var result []*models.RouteItem
+	query := store.db.Model(&result).Where("route_item.status = ?", models.RouteStatusDead)
+	if destination != "" {
+		query.Where("route_item.destination = ?", destination)
+	}
+	options.Apply(query)
+
+	err := query.Select()
+	return result, err
+}
+
+// RequeueDead puts the dead items back in the queue with their attempts reset, filtered by destination
+// if it is set, and returns their number.
+func (store *RouteStore) RequeueDead(destination string) (int, error) {
+	result, err := store.db.Exec(`
+		UPDATE route_item SET status = ?0, attempts = 0, next_attempt_at = now(), updated_at = now()
+		WHERE status = ?1 AND (?2 = '' OR destination = ?2)`,
+		models.RouteStatusPending, models.RouteStatusDead, destination)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// PurgeCompleted deletes the items completed before the time and returns their number.
+func (store *RouteStore) PurgeCompleted(completedBefore time.Time) (int, error) {
+	result, err := store.db.Exec(`DELETE FROM route_item WHERE status = ? AND updated_at < ?`,
+		models.RouteStatusCompleted, completedBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+func (store *RouteStore) GetOrm(tx *pg.Tx) orm.DB {
+	if tx != nil {
+		return tx
+	} else {
+		return store.db
+	}
+}
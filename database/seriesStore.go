@@ -7,6 +7,7 @@ import (
 	"github.com/go-pg/pg"
 	"github.com/go-pg/pg/orm"
 	"reflect"
+	"time"
 )
 
 // SeriesStore implements database operations for series management.
@@ -26,7 +27,7 @@ func (store *SeriesStore) FindBy(fields map[string]any, options *SelectQueryOpti
 	tableName := (&models.Series{}).GetTableName()
 
 	var result []*models.Series
-	query := db.Model(&result)
+	query := db.Model(&result).Where(seriesNotRejected)
 	for fieldName, fieldValue := range fields {
 		structField := reflect.ValueOf(&models.Series{}).Elem().FieldByName(fieldName)
 		if !structField.IsValid() {
@@ -34,16 +35,7 @@ func (store *SeriesStore) FindBy(fields map[string]any, options *SelectQueryOpti
 		}
 		columnName := utils.ToSnakeCase(fieldName)
 
-		rt := reflect.TypeOf(fieldValue)
-		if rt.Kind() == reflect.Slice || rt.Kind() == reflect.Array {
-			var values []interface{}
-			for i := 0; i < reflect.ValueOf(fieldValue).Len(); i++ {
-				values = append(values, reflect.ValueOf(fieldValue).Index(i).Interface())
-			}
-			query.WhereIn(fmt.Sprintf("%s.%s IN (?)", tableName, columnName), values...)
-		} else {
-			query.Where(fmt.Sprintf("%s.%s = ?", tableName, columnName), fieldValue)
-		}
+		whereField(query, tableName+"."+columnName, fieldValue)
 	}
 	query.Relation("Study")
 	options.Apply(query)
@@ -58,7 +50,7 @@ func (store *SeriesStore) CountBy(fields map[string]any, tx *pg.Tx) (int, error)
 	tableName := (&models.Series{}).GetTableName()
 
 	var count int
-	query := db.Model(&models.Series{}).ColumnExpr("count(*)")
+	query := db.Model(&models.Series{}).ColumnExpr("count(*)").Where(seriesNotRejected)
 	for fieldName, fieldValue := range fields {
 		structField := reflect.ValueOf(&models.Series{}).Elem().FieldByName(fieldName)
 		if !structField.IsValid() {
@@ -66,16 +58,7 @@ func (store *SeriesStore) CountBy(fields map[string]any, tx *pg.Tx) (int, error)
 		}
 		columnName := utils.ToSnakeCase(fieldName)
 
-		rt := reflect.TypeOf(fieldValue)
-		if rt.Kind() == reflect.Slice || rt.Kind() == reflect.Array {
-			var values []interface{}
-			for i := 0; i < reflect.ValueOf(fieldValue).Len(); i++ {
-				values = append(values, reflect.ValueOf(fieldValue).Index(i).Interface())
-			}
-			query.WhereIn(fmt.Sprintf("%s.%s IN (?)", tableName, columnName), values...)
-		} else {
-			query.Where(fmt.Sprintf("%s.%s = ?", tableName, columnName), fieldValue)
-		}
+		whereField(query, tableName+"."+columnName, fieldValue)
 	}
 
 	_, err := query.SelectAndCount(&count)
@@ -106,6 +89,105 @@ func (store *SeriesStore) Create(series *models.Series, tx *pg.Tx) error {
 	return err
 }
 
+// Upsert creates the series or, if one with the same UID exists, loads the stored row into it.
+// Concurrent upserts of the same UID wait for each other instead of failing on the unique constraint.
+// A series in the trash is taken out of it.
+func (store *SeriesStore) Upsert(series *models.Series, tx *pg.Tx) error {
+	db := store.GetOrm(tx)
+	_, err := db.Model(series).
+		OnConflict("(series_instance_uid) DO UPDATE").
+		Set("updated_at = EXCLUDED.updated_at, deleted_at = NULL").
+		Returning("*").
+		Insert()
+	return err
+}
+
+// UpdateAttributes overwrites the stored DICOM attributes of the series with the same UID, trashed or not.
+// It returns the number of updated rows.
+func (store *SeriesStore) UpdateAttributes(series *models.Series, tx *pg.Tx) (int, error) {
+	db := store.GetOrm(tx)
+	assignments, params, uidColumn, uid := attributeAssignments(series)
+	result, err := db.Exec(fmt.Sprintf(`UPDATE series SET %s, updated_at = now() WHERE %s = ?%d`, assignments, uidColumn, len(params)),
+		append(params, uid)...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// Delete moves the series with its instances to the trash, all stamped with deletedAt.
+// Instances that were already in the trash keep their own time.
+func (store *SeriesStore) Delete(series *models.Series, deletedAt time.Time, tx *pg.Tx) error {
+	db := store.GetOrm(tx)
+	queries := []string{
+		`UPDATE instance SET deleted_at = ?0 WHERE series_id = ?1 AND deleted_at IS NULL`,
+		`UPDATE series SET deleted_at = ?0 WHERE id = ?1 AND deleted_at IS NULL`,
+	}
+	for _, q := range queries {
+		if _, err := db.Exec(q, deletedAt, series.ID); err != nil {
+			return err
+		}
+	}
+	series.DeletedAt = pg.NullTime{Time: deletedAt}
+	return nil
+}
+
+// Restore takes the series out of the trash together with the instances deleted along with it.
+// The parent study is restored as well if it is in the trash.
+func (store *SeriesStore) Restore(series *models.Series, tx *pg.Tx) error {
+	db := store.GetOrm(tx)
+	queries := []string{
+		`UPDATE instance SET deleted_at = NULL FROM series
+			WHERE instance.series_id = series.id AND series.id = ?0 AND instance.deleted_at = series.deleted_at`,
+		`UPDATE series SET deleted_at = NULL WHERE id = ?0`,
+		`UPDATE study SET deleted_at = NULL FROM series WHERE study.id = series.study_id AND series.id = ?0`,
+	}
+	for _, q := range queries {
+		if _, err := db.Exec(q, series.ID); err != nil {
+			return err
+		}
+	}
+	series.DeletedAt = pg.NullTime{}
+	return nil
+}
+
+// Purge permanently deletes a series from the trash. Its instances are removed by the foreign key cascade.
+func (store *SeriesStore) Purge(series *models.Series, tx *pg.Tx) error {
+	db := store.GetOrm(tx)
+	_, err := db.Model(series).WherePK().ForceDelete()
+	return err
+}
+
+// FindDeleted returns the series that were deleted on their own, not along with their study,
+// optionally only those deleted before deletedBefore.
+func (store *SeriesStore) FindDeleted(deletedBefore time.Time, options *SelectQueryOptions, tx *pg.Tx) ([]*models.Series, error) {
+	db := store.GetOrm(tx)
+
+	var result []*models.Series
+	query := db.Model(&result).Deleted().
+		Relation("Study").
+		Where("series.deleted_at IS DISTINCT FROM study.deleted_at")
+	if !deletedBefore.IsZero() {
+		query.Where("series.deleted_at < ?", deletedBefore)
+	}
+	options.Apply(query)
+
+	err := query.Select()
+	return result, err
+}
+
+// GetDeleted gets a series in the trash by its SeriesInstanceUID.
+func (store *SeriesStore) GetDeleted(seriesInstanceUID string, tx *pg.Tx) (*models.Series, error) {
+	db := store.GetOrm(tx)
+
+	series := &models.Series{}
+	err := db.Model(series).Deleted().
+		Relation("Study").
+		Where("series.series_instance_uid = ?", seriesInstanceUID).
+		Select()
+	return series, err
+}
+
 func (store *SeriesStore) GetOrm(tx *pg.Tx) orm.DB {
 	if tx != nil {
 		return tx
@@ -7,6 +7,7 @@ import (
 	"github.com/go-pg/pg"
 	"github.com/go-pg/pg/orm"
 	"reflect"
+	"time"
 )
 
 // StudyStore implements database operations for study management.
@@ -26,7 +27,7 @@ func (store *StudyStore) FindBy(fields map[string]any, options *SelectQueryOptio
 	tableName := (&models.Study{}).GetTableName()
 
 	var result []*models.Study
-	query := db.Model(&result)
+	query := db.Model(&result).Where(studyNotRejected)
 	for fieldName, fieldValue := range fields {
 		structField := reflect.ValueOf(&models.Study{}).Elem().FieldByName(fieldName)
 		if !structField.IsValid() {
@@ -34,16 +35,7 @@ func (store *StudyStore) FindBy(fields map[string]any, options *SelectQueryOptio
 		}
 		columnName := utils.ToSnakeCase(fieldName)
 
-		rt := reflect.TypeOf(fieldValue)
-		if rt.Kind() == reflect.Slice || rt.Kind() == reflect.Array {
-			var values []interface{}
-			for i := 0; i < reflect.ValueOf(fieldValue).Len(); i++ {
-				values = append(values, reflect.ValueOf(fieldValue).Index(i).Interface())
-			}
-			query.WhereIn(fmt.Sprintf("%s.%s IN (?)", tableName, columnName), values...)
-		} else {
-			query.Where(fmt.Sprintf("%s.%s = ?", tableName, columnName), fieldValue)
-		}
+		whereField(query, tableName+"."+columnName, fieldValue)
 	}
 	options.Apply(query)
 
@@ -56,7 +48,7 @@ func (store *StudyStore) CountBy(fields map[string]any, tx *pg.Tx) (int, error)
 	tableName := (&models.Study{}).GetTableName()
 
 	var result int
-	query := db.Model(&models.Study{}).ColumnExpr("count(*)")
+	query := db.Model(&models.Study{}).ColumnExpr("count(*)").Where(studyNotRejected)
 	for fieldName, fieldValue := range fields {
 		structField := reflect.ValueOf(&models.Study{}).Elem().FieldByName(fieldName)
 		if !structField.IsValid() {
@@ -64,22 +56,57 @@ func (store *StudyStore) CountBy(fields map[string]any, tx *pg.Tx) (int, error)
 		}
 		columnName := utils.ToSnakeCase(fieldName)
 
-		rt := reflect.TypeOf(fieldValue)
-		if rt.Kind() == reflect.Slice || rt.Kind() == reflect.Array {
-			var values []interface{}
-			for i := 0; i < reflect.ValueOf(fieldValue).Len(); i++ {
-				values = append(values, reflect.ValueOf(fieldValue).Index(i).Interface())
-			}
-			query.WhereIn(fmt.Sprintf("%s.%s IN (?)", tableName, columnName), values...)
-		} else {
-			query.Where(fmt.Sprintf("%s.%s = ?", tableName, columnName), fieldValue)
-		}
+		whereField(query, tableName+"."+columnName, fieldValue)
 	}
 
 	_, err := query.SelectAndCount(&result)
 	return result, err
 }
 
+// Patient is a patient of the stored studies with the number of its studies, series and instances.
+type Patient struct {
+	PatientName                     string
+	PatientID                       string
+	PatientBirthDate                string
+	PatientSex                      string
+	NumberOfPatientRelatedStudies   int
+	NumberOfPatientRelatedSeries    int
+	NumberOfPatientRelatedInstances int
+}
+
+// FindPatients returns the patients of the studies matching the fields, with the demographics of their first
+// study, in the order of their first study. The options limit the patients, not the studies counted.
+func (store *StudyStore) FindPatients(fields map[string]any, options *SelectQueryOptions, tx *pg.Tx) ([]*Patient, error) {
+	db := store.GetOrm(tx)
+	tableName := (&models.Study{}).GetTableName()
+
+	var result []*Patient
+	query := db.Model(&models.Study{}).
+		ColumnExpr("study.patient_id").
+		ColumnExpr("(array_agg(study.patient_name ORDER BY study.id))[1] AS patient_name").
+		ColumnExpr("(array_agg(study.patient_birth_date ORDER BY study.id))[1] AS patient_birth_date").
+		ColumnExpr("(array_agg(study.patient_sex ORDER BY study.id))[1] AS patient_sex").
+		ColumnExpr("count(*) AS number_of_patient_related_studies").
+		ColumnExpr("coalesce(sum(nullif(study.number_of_study_related_series, '')::int), 0) AS number_of_patient_related_series").
+		ColumnExpr("coalesce(sum(nullif(study.number_of_study_related_instances, '')::int), 0) AS number_of_patient_related_instances").
+		Where(studyNotRejected).
+		Group("study.patient_id").
+		OrderExpr("min(study.id)")
+	for fieldName, fieldValue := range fields {
+		structField := reflect.ValueOf(&models.Study{}).Elem().FieldByName(fieldName)
+		if !structField.IsValid() {
+			return nil, fmt.Errorf("invalid field name: %s", fieldName)
+		}
+		columnName := utils.ToSnakeCase(fieldName)
+
+		whereField(query, tableName+"."+columnName, fieldValue)
+	}
+	options.Apply(query)
+
+	err := query.Select(&result)
+	return result, err
+}
+
 // Get gets a study by study ID.
 func (store *StudyStore) Get(studyID int) (*models.Study, error) {
 	study := models.Study{ID: studyID}
@@ -104,6 +131,236 @@ func (store *StudyStore) Create(study *models.Study, tx *pg.Tx) error {
 	return err
 }
 
+// Upsert creates the study or, if one with the same UID exists, loads the stored row into it.
+// Concurrent upserts of the same UID wait for each other instead of failing on the unique constraint.
+// A study in the trash is taken out of it.
+func (store *StudyStore) Upsert(study *models.Study, tx *pg.Tx) error {
+	db := store.GetOrm(tx)
+	_, err := db.Model(study).
+		OnConflict("(study_instance_uid) DO UPDATE").
+		Set("updated_at = EXCLUDED.updated_at, deleted_at = NULL").
+		Returning("*").
+		Insert()
+	return err
+}
+
+// UpdateAttributes overwrites the stored DICOM attributes of the study with the same UID, trashed or not,
+// except those computed from its series and instances. It returns the number of updated rows.
+func (store *StudyStore) UpdateAttributes(study *models.Study, tx *pg.Tx) (int, error) {
+	db := store.GetOrm(tx)
+	assignments, params, uidColumn, uid := attributeAssignments(study,
+		"ModalitiesInStudy", "NumberOfStudyRelatedSeries", "NumberOfStudyRelatedInstances", "InstanceAvailability", "RetrieveURL")
+	result, err := db.Exec(fmt.Sprintf(`UPDATE study SET %s, updated_at = now() WHERE %s = ?%d`, assignments, uidColumn, len(params)),
+		append(params, uid)...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// CountPatient returns the number of studies of the patient, trashed or not.
+func (store *StudyStore) CountPatient(patientID string, tx *pg.Tx) (int, error) {
+	db := store.GetOrm(tx)
+	var count int
+	_, err := db.QueryOne(pg.Scan(&count), `SELECT count(*) FROM study WHERE patient_id = ?`, patientID)
+	return count, err
+}
+
+// DeleteAll removes every study, series and instance row for a reindex from scratch. Files are not touched.
+func (store *StudyStore) DeleteAll(tx *pg.Tx) error {
+	db := store.GetOrm(tx)
+	_, err := db.Exec(`TRUNCATE instance, series, study`)
+	return err
+}
+
+// studyRelatedSeriesQuery and studyRelatedInstancesQuery count the visible series and instances of the study row.
+const (
+	studyRelatedSeriesQuery = `
+		SELECT count(*) FROM series
+		WHERE series.study_id = study.id AND series.deleted_at IS NULL AND ` + seriesNotRejected
+	studyRelatedInstancesQuery = `
+		SELECT count(*) FROM instance JOIN series ON series.id = instance.series_id
+		WHERE series.study_id = study.id AND series.deleted_at IS NULL AND instance.deleted_at IS NULL AND ` + instanceNotRejected
+)
+
+// updateComputedFieldsQuery recounts the visible series and instances and the modalities of the studies matching the condition,
+// which are NEARLINE if a visible instance is in the cold tier.
+const updateComputedFieldsQuery = `
+	UPDATE study SET
+		number_of_study_related_series = (` + studyRelatedSeriesQuery + `)::text,
+		number_of_study_related_instances = (` + studyRelatedInstancesQuery + `)::text,
+		modalities_in_study = (
+			SELECT coalesce(json_agg(DISTINCT series.modality) FILTER (WHERE series.modality IS NOT NULL), '[]')
+			FROM series WHERE series.study_id = study.id AND series.deleted_at IS NULL AND ` + seriesNotRejected + `
+		)::text,
+		instance_availability = CASE WHEN EXISTS (
+			SELECT 1 FROM instance JOIN series ON series.id = instance.series_id
+			WHERE series.study_id = study.id AND series.deleted_at IS NULL AND instance.deleted_at IS NULL
+				AND instance.tier = '` + models.TierCold + `'
+		) THEN '` + models.AvailabilityNearline + `' ELSE '` + models.AvailabilityOnline + `' END,
+		updated_at = now()
+	WHERE %s
+	RETURNING *`
+
+// StudyCounts compares the related series and instances recorded on a study with the actual numbers.
+type StudyCounts struct {
+	StudyInstanceUID              string `json:"study_instance_uid"`
+	NumberOfStudyRelatedSeries    string `json:"number_of_study_related_series"`
+	NumberOfStudyRelatedInstances string `json:"number_of_study_related_instances"`
+	ActualSeries                  int    `json:"actual_series"`
+	ActualInstances               int    `json:"actual_instances"`
+}
+
+// FindWrongCounts returns the studies whose recorded related series or instances differ from the actual numbers.
+func (store *StudyStore) FindWrongCounts(tx *pg.Tx) ([]*StudyCounts, error) {
+	db := store.GetOrm(tx)
+
+	var result []*StudyCounts
+	_, err := db.Query(&result, `
+		SELECT * FROM (
+			SELECT study_instance_uid, number_of_study_related_series, number_of_study_related_instances,
+				(`+studyRelatedSeriesQuery+`) AS actual_series,
+				(`+studyRelatedInstancesQuery+`) AS actual_instances
+			FROM study WHERE deleted_at IS NULL
+		) counts
+		WHERE number_of_study_related_series IS DISTINCT FROM actual_series::text
+			OR number_of_study_related_instances IS DISTINCT FROM actual_instances::text
+		ORDER BY study_instance_uid`)
+	return result, err
+}
+
+// UpdateComputedFields recounts the related series and instances and the modalities of the study.
+// The study row stays locked until the transaction ends, so concurrent updates of one study are serialized.
+func (store *StudyStore) UpdateComputedFields(study *models.Study, tx *pg.Tx) error {
+	db := store.GetOrm(tx)
+	_, err := db.QueryOne(study, fmt.Sprintf(updateComputedFieldsQuery, "id = ?"), study.ID)
+	return err
+}
+
+// UpdateComputedFieldsByUID recounts the studies with the given StudyInstanceUIDs. UIDs of studies that are not stored are ignored.
+func (store *StudyStore) UpdateComputedFieldsByUID(studyInstanceUIDs []string, tx *pg.Tx) error {
+	if len(studyInstanceUIDs) == 0 {
+		return nil
+	}
+	db := store.GetOrm(tx)
+	_, err := db.Exec(fmt.Sprintf(updateComputedFieldsQuery, "study_instance_uid IN (?)"), pg.In(studyInstanceUIDs))
+	return err
+}
+
+// Delete moves the study with its series and instances to the trash, all stamped with deletedAt.
+// Series and instances that were already in the trash keep their own time.
+func (store *StudyStore) Delete(study *models.Study, deletedAt time.Time, tx *pg.Tx) error {
+	db := store.GetOrm(tx)
+	queries := []string{
+		`UPDATE instance SET deleted_at = ?0 FROM series
+			WHERE instance.series_id = series.id AND series.study_id = ?1 AND instance.deleted_at IS NULL`,
+		`UPDATE series SET deleted_at = ?0 WHERE study_id = ?1 AND deleted_at IS NULL`,
+		`UPDATE study SET deleted_at = ?0 WHERE id = ?1 AND deleted_at IS NULL`,
+	}
+	for _, q := range queries {
+		if _, err := db.Exec(q, deletedAt, study.ID); err != nil {
+			return err
+		}
+	}
+	study.DeletedAt = pg.NullTime{Time: deletedAt}
+	return nil
+}
+
+// Restore takes the study out of the trash together with the series and instances deleted along with it.
+func (store *StudyStore) Restore(study *models.Study, tx *pg.Tx) error {
+	db := store.GetOrm(tx)
+	queries := []string{
+		`UPDATE instance SET deleted_at = NULL FROM series, study
+			WHERE instance.series_id = series.id AND series.study_id = study.id AND study.id = ?0
+			AND instance.deleted_at = study.deleted_at`,
+		`UPDATE series SET deleted_at = NULL FROM study
+			WHERE series.study_id = study.id AND study.id = ?0 AND series.deleted_at = study.deleted_at`,
+		`UPDATE study SET deleted_at = NULL WHERE id = ?0`,
+	}
+	for _, q := range queries {
+		if _, err := db.Exec(q, study.ID); err != nil {
+			return err
+		}
+	}
+	study.DeletedAt = pg.NullTime{}
+	return nil
+}
+
+// Purge permanently deletes a study from the trash. Its series and instances are removed by the foreign key cascade.
+func (store *StudyStore) Purge(study *models.Study, tx *pg.Tx) error {
+	db := store.GetOrm(tx)
+	_, err := db.Model(study).WherePK().ForceDelete()
+	return err
+}
+
+// FindDeleted returns the studies in the trash, or only those deleted before deletedBefore if it is set.
+func (store *StudyStore) FindDeleted(deletedBefore time.Time, options *SelectQueryOptions, tx *pg.Tx) ([]*models.Study, error) {
+	db := store.GetOrm(tx)
+
+	var result []*models.Study
+	query := db.Model(&result).Deleted()
+	if !deletedBefore.IsZero() {
+		query.Where("study.deleted_at < ?", deletedBefore)
+	}
+	options.Apply(query)
+
+	err := query.Select()
+	return result, err
+}
+
+// GetDeleted gets a study in the trash by its StudyInstanceUID.
+func (store *StudyStore) GetDeleted(studyInstanceUID string, tx *pg.Tx) (*models.Study, error) {
+	db := store.GetOrm(tx)
+
+	study := &models.Study{}
+	err := db.Model(study).Deleted().Where("study.study_instance_uid = ?", studyInstanceUID).Select()
+	return study, err
+}
+
+// Touch records that the files of the studies were retrieved. Accesses within a minute of the last one are not recorded again.
+func (store *StudyStore) Touch(studyIDs []int, tx *pg.Tx) error {
+	if len(studyIDs) == 0 {
+		return nil
+	}
+	db := store.GetOrm(tx)
+	_, err := db.Exec(`
+		UPDATE study SET last_accessed_at = now()
+		WHERE id IN (?) AND (last_accessed_at IS NULL OR last_accessed_at < now() - interval '1 minute')`, pg.In(studyIDs))
+	return err
+}
+
+// FindToArchive returns up to limit studies with files in the hot tier whose StudyDate, or arrival if it has none,
+// is before studiedBefore and that were last retrieved, or arrived, before accessedBefore. Zero times match all studies.
+func (store *StudyStore) FindToArchive(studiedBefore time.Time, accessedBefore time.Time, limit int, tx *pg.Tx) ([]*models.Study, error) {
+	db := store.GetOrm(tx)
+	if studiedBefore.IsZero() {
+		studiedBefore = time.Now()
+	}
+	if accessedBefore.IsZero() {
+		accessedBefore = time.Now()
+	}
+
+	var result []*models.Study
+	_, err := db.Query(&result, `
+		SELECT * FROM study
+		WHERE deleted_at IS NULL
+			AND coalesce(study_date, to_char(created_at, 'YYYYMMDD')) < ?
+			AND coalesce(last_accessed_at, created_at) < ?
+			AND EXISTS (
+				SELECT 1 FROM instance JOIN series ON series.id = instance.series_id
+				WHERE series.study_id = study.id AND coalesce(instance.tier, ?) = ?
+			)
+		ORDER BY coalesce(last_accessed_at, created_at) LIMIT ?`,
+		studiedBefore.Format("20060102"), accessedBefore, models.TierHot, models.TierHot, limit)
+	return result, err
+}
+
+// Lock locks the study row until the transaction ends. Writers lock the study before its series and instances.
+func (store *StudyStore) Lock(study *models.Study, tx *pg.Tx) error {
+	_, err := tx.QueryOne(study, "SELECT * FROM study WHERE id = ? FOR UPDATE", study.ID)
+	return err
+}
+
 func (store *StudyStore) GetOrm(tx *pg.Tx) orm.DB {
 	if tx != nil {
 		return tx
@@ -0,0 +1,194 @@
+package database
+
+import (
+	"dicom-store-api/models"
+	"dicom-store-api/utils"
+	"fmt"
+	"reflect"
+
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+)
+
+// WorklistStore implements database operations for the worklist items and the subscriptions to them.
+type WorklistStore struct {
+	db *pg.DB
+}
+
+// NewWorklistStore returns a WorklistStore implementation.
+func NewWorklistStore(db *pg.DB) *WorklistStore {
+	return &WorklistStore{
+		db: db,
+	}
+}
+
+// Get gets a worklist item by ID.
+func (store *WorklistStore) Get(itemID int) (*models.WorklistItem, error) {
+	item := &models.WorklistItem{ID: itemID}
+	err := store.db.Model(item).WherePK().Select()
+	return item, err
+}
+
+// GetByUID gets a worklist item by its workitem UID.
+func (store *WorklistStore) GetByUID(sopInstanceUID string) (*models.WorklistItem, error) {
+	item := &models.WorklistItem{}
+	err := store.db.Model(item).Where("sop_instance_uid = ?", sopInstanceUID).Select()
+	return item, err
+}
+
+// FindBy returns the worklist items matching the fields, named after the WorklistItem fields.
+func (store *WorklistStore) FindBy(fields map[string]any, options *SelectQueryOptions, tx *pg.Tx) ([]*models.WorklistItem, error) {
+	db := store.GetOrm(tx)
+	tableName := (&models.WorklistItem{}).GetTableName()
+
+	var result []*models.WorklistItem
+	query := db.Model(&result)
+	for fieldName, fieldValue := range fields {
+		structField := reflect.ValueOf(&models.WorklistItem{}).Elem().FieldByName(fieldName)
+		if !structField.IsValid() {
+			return nil, fmt.Errorf("invalid field name: %s", fieldName)
+		}
+		whereField(query, tableName+"."+utils.ToSnakeCase(fieldName), fieldValue)
+	}
+	options.Apply(query)
+
+	err := query.Select()
+	return result, err
+}
+
+// Create creates a worklist item, subscribed to by the AE titles with a global subscription.
+func (store *WorklistStore) Create(item *models.WorklistItem) error {
+	return store.db.RunInTransaction(func(tx *pg.Tx) error {
+		if _, err := tx.Model(item).Insert(); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`
+			INSERT INTO worklist_subscription (created_at, ae_title, workitem_uid, deletion_lock)
+			SELECT now(), ae_title, ?, deletion_lock FROM worklist_subscription WHERE workitem_uid = ?
+			ON CONFLICT DO NOTHING`, item.SOPInstanceUID, models.GlobalWorklistSubscription)
+		return err
+	})
+}
+
+// Update updates a worklist item.
+func (store *WorklistStore) Update(item *models.WorklistItem, tx *pg.Tx) error {
+	db := store.GetOrm(tx)
+	_, err := db.Model(item).WherePK().Update()
+	return err
+}
+
+// UpdateState saves the state and transaction UID of a worklist item if it is still in the state from,
+// and reports whether it was.
+func (store *WorklistStore) UpdateState(item *models.WorklistItem, from string) (bool, error) {
+	result, err := store.db.Model(item).
+		Column("procedure_step_state", "transaction_uid", "updated_at").
+		WherePK().
+		Where("procedure_step_state = ?", from).
+		Update()
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() == 1, nil
+}
+
+// Link links the worklist items not linked yet of the scheduled procedure step, and of the requested
+// procedure when set, to the study performing them, and returns them. Items scheduled for another study
+// are left alone.
+func (store *WorklistStore) Link(studyInstanceUID string, scheduledProcedureStepID string, requestedProcedureID string) ([]*models.WorklistItem, error) {
+	var result []*models.WorklistItem
+	_, err := store.db.Query(&result, `
+		UPDATE worklist_item SET linked_at = now(), updated_at = now(),
+			study_instance_uid = coalesce(nullif(study_instance_uid, ''), ?0)
+		WHERE scheduled_procedure_step_id = ?1 AND (?2 = '' OR requested_procedure_id = ?2)
+			AND linked_at IS NULL AND coalesce(study_instance_uid, '') IN ('', ?0)
+		RETURNING *`, studyInstanceUID, scheduledProcedureStepID, requestedProcedureID)
+	return result, err
+}
+
+// UpdatePatient sets the fields, named after the WorklistItem fields, of the worklist items of the patient
+// that are not final, and returns their number.
+func (store *WorklistStore) UpdatePatient(patientID string, fields map[string]any, tx *pg.Tx) (int, error) {
+	db := store.GetOrm(tx)
+	assignments, params, err := fieldAssignments(&models.WorklistItem{}, fields)
+	if err != nil {
+		return 0, err
+	}
+	n := len(params)
+	result, err := db.Exec(fmt.Sprintf(`UPDATE worklist_item SET %s, updated_at = now()
+		WHERE patient_id = ?%d AND procedure_step_state IN (?%d, ?%d)`, assignments, n, n+1, n+2),
+		append(params, patientID, models.WorklistStateScheduled, models.WorklistStateInProgress)...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// Subscribe creates or updates a subscription. A global subscription also subscribes the AE title to
+// every worklist item that is not final.
+func (store *WorklistStore) Subscribe(subscription *models.WorklistSubscription) error {
+	return store.db.RunInTransaction(func(tx *pg.Tx) error {
+		_, err := tx.Model(subscription).
+			OnConflict("(workitem_uid, ae_title) DO UPDATE").
+			Set("deletion_lock = EXCLUDED.deletion_lock").
+			Insert()
+		if err != nil || subscription.WorkitemUID != models.GlobalWorklistSubscription {
+			return err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO worklist_subscription (created_at, ae_title, workitem_uid, deletion_lock)
+			SELECT now(), ?, sop_instance_uid, ? FROM worklist_item WHERE procedure_step_state IN (?, ?)
+			ON CONFLICT (workitem_uid, ae_title) DO UPDATE SET deletion_lock = EXCLUDED.deletion_lock`,
+			subscription.AETitle, subscription.DeletionLock, models.WorklistStateScheduled, models.WorklistStateInProgress)
+		return err
+	})
+}
+
+// Unsubscribe deletes the subscription of the AE title to a worklist item, or all of its subscriptions
+// for the global subscription UID, and returns their number.
+func (store *WorklistStore) Unsubscribe(aeTitle string, workitemUID string) (int, error) {
+	query := store.db.Model(&models.WorklistSubscription{}).Where("ae_title = ?", aeTitle)
+	if workitemUID != models.GlobalWorklistSubscription {
+		query.Where("workitem_uid = ?", workitemUID)
+	}
+	result, err := query.Delete()
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// Suspend deletes the global subscription of the AE title, keeping its subscriptions to the existing items.
+func (store *WorklistStore) Suspend(aeTitle string) (int, error) {
+	result, err := store.db.Model(&models.WorklistSubscription{}).
+		Where("ae_title = ?", aeTitle).
+		Where("workitem_uid = ?", models.GlobalWorklistSubscription).
+		Delete()
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// Subscribers returns the subscriptions to a worklist item.
+func (store *WorklistStore) Subscribers(workitemUID string) ([]*models.WorklistSubscription, error) {
+	var result []*models.WorklistSubscription
+	err := store.db.Model(&result).Where("workitem_uid = ?", workitemUID).Order("id ASC").Select()
+	return result, err
+}
+
+// EndSubscriptions deletes the subscriptions to a final worklist item without a deletion lock.
+func (store *WorklistStore) EndSubscriptions(workitemUID string) error {
+	_, err := store.db.Model(&models.WorklistSubscription{}).
+		Where("workitem_uid = ?", workitemUID).
+		Where("NOT deletion_lock").
+		Delete()
+	return err
+}
+
+func (store *WorklistStore) GetOrm(tx *pg.Tx) orm.DB {
+	if tx != nil {
+		return tx
+	} else {
+		return store.db
+	}
+}
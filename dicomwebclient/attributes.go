@@ -0,0 +1,61 @@
+package dicomwebclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Attribute is an attribute of a DICOM JSON object.
+type Attribute struct {
+	VR    string            `json:"vr"`
+	Value []json.RawMessage `json:"Value,omitempty"`
+}
+
+// Attributes is a DICOM JSON object, its attributes keyed by tag such as 0020000D.
+type Attributes map[string]*Attribute
+
+// String returns the values of the attribute joined with backslashes, person names by their alphabetic
+// representation, or an empty string.
+func (a Attributes) String(key string) string {
+	attribute := a[key]
+	if attribute == nil {
+		return ""
+	}
+	var values []string
+	for _, raw := range attribute.Value {
+		var value any
+		if err := json.Unmarshal(raw, &value); err != nil {
+			continue
+		}
+		switch v := value.(type) {
+		case string:
+			values = append(values, v)
+		case map[string]any:
+			if name, ok := v["Alphabetic"].(string); ok {
+				values = append(values, name)
+			}
+		case nil:
+			values = append(values, "")
+		default:
+			values = append(values, fmt.Sprint(v))
+		}
+	}
+	return strings.Join(values, `\`)
+}
+
+// Items returns the items of the sequence attribute.
+func (a Attributes) Items(key string) []Attributes {
+	attribute := a[key]
+	if attribute == nil {
+		return nil
+	}
+	var items []Attributes
+	for _, raw := range attribute.Value {
+		var item Attributes
+		if err := json.Unmarshal(raw, &item); err == nil {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,268 @@
+// Package dicomwebclient queries, retrieves from and stores to remote DICOMweb servers with QIDO-RS, WADO-RS
+// and STOW-RS.
+package dicomwebclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+)
+
+// DefaultMaxSize is the default bound of a file retrieved and of a search response.
+const DefaultMaxSize = 128 << 20
+
+// ErrTooLarge is returned for a file or search response larger than the MaxSize of the client.
+var ErrTooLarge = errors.New("DICOMweb response exceeds the max size")
+
+// StatusError is returned for a response with an unexpected status.
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return "DICOMweb server answered " + e.Status
+}
+
+// Client sends requests to the DICOMweb server at BaseURL, such as https://pacs/dicomweb.
+type Client struct {
+	BaseURL string
+	// Header is added to every request, for credentials for instance.
+	Header     http.Header
+	HTTPClient *http.Client
+	// MaxSize bounds every file retrieved and every search response, in bytes.
+	MaxSize int64
+}
+
+// NewClient returns a Client for the server at the base URL.
+func NewClient(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		Header:     http.Header{},
+		HTTPClient: http.DefaultClient,
+		MaxSize:    DefaultMaxSize,
+	}
+}
+
+// SearchStudies runs a QIDO-RS study query with the query parameters, such as PatientID or limit.
+func (c *Client) SearchStudies(ctx context.Context, query url.Values) ([]Attributes, error) {
+	return c.search(ctx, "/studies", query)
+}
+
+// SearchSeries runs a QIDO-RS series query, within the study if its UID is not empty.
+func (c *Client) SearchSeries(ctx context.Context, studyUID string, query url.Values) ([]Attributes, error) {
+	if studyUID == "" {
+		return c.search(ctx, "/series", query)
+	}
+	return c.search(ctx, "/studies/"+url.PathEscape(studyUID)+"/series", query)
+}
+
+// SearchInstances runs a QIDO-RS instance query, within the series if the study and series UIDs are not empty.
+func (c *Client) SearchInstances(ctx context.Context, studyUID string, seriesUID string, query url.Values) ([]Attributes, error) {
+	if studyUID == "" || seriesUID == "" {
+		return c.search(ctx, "/instances", query)
+	}
+	return c.search(ctx, "/studies/"+url.PathEscape(studyUID)+"/series/"+url.PathEscape(seriesUID)+"/instances", query)
+}
+
+// RetrieveStudyMetadata returns the metadata of the instances of a study.
+func (c *Client) RetrieveStudyMetadata(ctx context.Context, studyUID string) ([]Attributes, error) {
+	return c.search(ctx, "/studies/"+url.PathEscape(studyUID)+"/metadata", nil)
+}
+
+// RetrieveStudy calls onFile with every Part 10 file of a study, as it is received.
+func (c *Client) RetrieveStudy(ctx context.Context, studyUID string, onFile func([]byte) error) error {
+	return c.retrieve(ctx, "/studies/"+url.PathEscape(studyUID), onFile)
+}
+
+// RetrieveSeries calls onFile with every Part 10 file of a series, as it is received.
+func (c *Client) RetrieveSeries(ctx context.Context, studyUID string, seriesUID string, onFile func([]byte) error) error {
+	return c.retrieve(ctx, "/studies/"+url.PathEscape(studyUID)+"/series/"+url.PathEscape(seriesUID), onFile)
+}
+
+// RetrieveInstance returns the Part 10 file of an instance.
+func (c *Client) RetrieveInstance(ctx context.Context, studyUID string, seriesUID string, instanceUID string) ([]byte, error) {
+	var file []byte
+	err := c.retrieve(ctx, "/studies/"+url.PathEscape(studyUID)+"/series/"+url.PathEscape(seriesUID)+
+		"/instances/"+url.PathEscape(instanceUID), func(data []byte) error {
+		if file == nil {
+			file = data
+		}
+		return nil
+	})
+	if err == nil && file == nil {
+		err = fmt.Errorf("empty response")
+	}
+	return file, err
+}
+
+// StoreResponse lists the instances a STOW-RS request failed to store, with their FailureReason.
+type StoreResponse struct {
+	Failed map[string]string
+}
+
+// Store sends Part 10 files in a single STOW-RS request. A 409 response is not an error, the instances
+// it did not store are listed in the response.
+func (c *Client) Store(ctx context.Context, files [][]byte) (*StoreResponse, error) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for _, file := range files {
+		part, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/dicom"}})
+		if err != nil {
+			return nil, err
+		}
+		part.Write(file)
+	}
+	writer.Close()
+
+	request, err := c.newRequest(ctx, http.MethodPost, "/studies", nil, body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", fmt.Sprintf(`multipart/related; type="application/dicom"; boundary=%s`, writer.Boundary()))
+	request.Header.Set("Accept", "application/dicom+json")
+
+	response, err := c.HTTPClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	result := &StoreResponse{Failed: map[string]string{}}
+	if response.StatusCode == http.StatusConflict {
+		var attributes Attributes
+		if err := json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(&attributes); err != nil {
+			return nil, &StatusError{StatusCode: response.StatusCode, Status: response.Status}
+		}
+		for _, item := range attributes.Items("00081198") {
+			result.Failed[item.String("00081155")] = item.String("00081197")
+		}
+		if len(result.Failed) == 0 {
+			return nil, &StatusError{StatusCode: response.StatusCode, Status: response.Status}
+		}
+		return result, nil
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, &StatusError{StatusCode: response.StatusCode, Status: response.Status}
+	}
+	return result, nil
+}
+
+func (c *Client) search(ctx context.Context, path string, query url.Values) ([]Attributes, error) {
+	request, err := c.newRequest(ctx, http.MethodGet, path, query, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/dicom+json")
+
+	response, err := c.HTTPClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNoContent {
+		return []Attributes{}, nil
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, &StatusError{StatusCode: response.StatusCode, Status: response.Status}
+	}
+
+	body, err := c.readAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	var result []Attributes
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("decoding DICOM JSON response: %w", err)
+	}
+	if result == nil {
+		result = []Attributes{}
+	}
+	return result, nil
+}
+
+func (c *Client) retrieve(ctx context.Context, path string, onFile func([]byte) error) error {
+	request, err := c.newRequest(ctx, http.MethodGet, path, nil, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", `multipart/related; type="application/dicom"`)
+
+	response, err := c.HTTPClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return &StatusError{StatusCode: response.StatusCode, Status: response.Status}
+	}
+
+	mediaType, params, err := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+	if mediaType == "application/dicom" {
+		data, err := c.readAll(response.Body)
+		if err != nil {
+			return err
+		}
+		return onFile(data)
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return fmt.Errorf("unexpected response type %s", mediaType)
+	}
+
+	reader := multipart.NewReader(response.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		data, err := c.readAll(part)
+		if err != nil {
+			return err
+		}
+		if err := onFile(data); err != nil {
+			return err
+		}
+	}
+}
+
+// readAll reads a file or search response, failing with ErrTooLarge beyond MaxSize.
+func (c *Client) readAll(reader io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(reader, c.MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > c.MaxSize {
+		return nil, ErrTooLarge
+	}
+	return data, nil
+}
+
+func (c *Client) newRequest(ctx context.Context, method string, path string, query url.Values, body io.Reader) (*http.Request, error) {
+	target := c.BaseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	request, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range c.Header {
+		request.Header[name] = values
+	}
+	return request, nil
+}
@@ -0,0 +1,329 @@
+package dicomwebclient_test
+
+import (
+	"context"
+	"dicom-store-api/api/dicomweb"
+	"dicom-store-api/database"
+	"dicom-store-api/dicomwebclient"
+	"dicom-store-api/fs"
+	"dicom-store-api/logging"
+	"dicom-store-api/models"
+	"dicom-store-api/utils"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-pg/pg"
+	"github.com/suyashkumar/dicom"
+	"github.com/suyashkumar/dicom/pkg/tag"
+)
+
+const (
+	studyUID  = "1.2.826.0.1.3680043.2.1125.1"
+	seriesUID = "1.2.826.0.1.3680043.2.1125.1.1"
+)
+
+var instanceUIDs = []string{"1.2.826.0.1.3680043.2.1125.1.1.1", "1.2.826.0.1.3680043.2.1125.1.1.2"}
+
+// archive holds the studies, series and instances the stores return.
+type archive struct {
+	studies   []*models.Study
+	series    []*models.Series
+	instances []*models.Instance
+}
+
+type studyStore struct {
+	dicomweb.StudyStore
+	*archive
+}
+
+func (s studyStore) FindBy(fields map[string]any, options *database.SelectQueryOptions, tx *pg.Tx) ([]*models.Study, error) {
+	var result []*models.Study
+	for _, study := range s.studies {
+		if matches(study, fields) {
+			result = append(result, study)
+		}
+	}
+	return result, nil
+}
+
+func (s studyStore) Touch(studyIDs []int, tx *pg.Tx) error {
+	return nil
+}
+
+type seriesStore struct {
+	dicomweb.SeriesStore
+	*archive
+}
+
+func (s seriesStore) FindBy(fields map[string]any, options *database.SelectQueryOptions, tx *pg.Tx) ([]*models.Series, error) {
+	var result []*models.Series
+	for _, series := range s.series {
+		if matches(series, fields) {
+			result = append(result, series)
+		}
+	}
+	return result, nil
+}
+
+type instanceStore struct {
+	dicomweb.InstanceStore
+	*archive
+}
+
+func (s instanceStore) FindBy(fields map[string]any, options *database.SelectQueryOptions, tx *pg.Tx) ([]*models.Instance, error) {
+	var result []*models.Instance
+	for _, instance := range s.instances {
+		if matches(instance, fields) {
+			result = append(result, instance)
+		}
+	}
+	return result, nil
+}
+
+// matches reports whether the object has the values of the store fields, ranges matching any value.
+func matches(object any, fields map[string]any) bool {
+	value := reflect.ValueOf(object).Elem()
+	for name, want := range fields {
+		field := value.FieldByName(name)
+		if !field.IsValid() {
+			return false
+		}
+		got := fmt.Sprint(field.Interface())
+		switch want := want.(type) {
+		case database.Range:
+		case database.Wildcard:
+			if ok, _ := path.Match(string(want), got); !ok {
+				return false
+			}
+		default:
+			values := reflect.ValueOf(want)
+			if values.Kind() != reflect.Slice {
+				values = reflect.ValueOf([]any{want})
+			}
+			found := false
+			for i := 0; i < values.Len(); i++ {
+				found = found || fmt.Sprint(values.Index(i).Interface()) == got
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// newFile returns a Part 10 file of a CT image without pixel data.
+func newFile(t *testing.T, sopInstanceUID string) []byte {
+	t.Helper()
+	values := []struct {
+		tag   tag.Tag
+		value []string
+	}{
+		{tag.MediaStorageSOPClassUID, []string{"1.2.840.10008.5.1.4.1.1.2"}},
+		{tag.MediaStorageSOPInstanceUID, []string{sopInstanceUID}},
+		{tag.TransferSyntaxUID, []string{"1.2.840.10008.1.2.1"}},
+		{tag.SOPClassUID, []string{"1.2.840.10008.5.1.4.1.1.2"}},
+		{tag.SOPInstanceUID, []string{sopInstanceUID}},
+		{tag.PatientName, []string{"Doe^Jane"}},
+		{tag.PatientID, []string{"PAT1"}},
+		{tag.StudyInstanceUID, []string{studyUID}},
+		{tag.SeriesInstanceUID, []string{seriesUID}},
+		{tag.Modality, []string{"CT"}},
+	}
+	var dataset dicom.Dataset
+	for _, v := range values {
+		element, err := dicom.NewElement(v.tag, v.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dataset.Elements = append(dataset.Elements, element)
+	}
+	data, err := utils.WriteDatasetToBytes(dataset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// newServer serves the DICOMweb router over a study of two instances, whose files are returned by UID.
+func newServer(t *testing.T) (*httptest.Server, map[string][]byte) {
+	t.Helper()
+	logging.NewLogger()
+	previous := fs.GetStorage()
+	fs.SetStorage(fs.NewMemoryStorage())
+	t.Cleanup(func() { fs.SetStorage(previous) })
+
+	study := &models.Study{ID: 1, StudyInstanceUID: studyUID, PatientID: "PAT1", PatientName: "Doe^Jane"}
+	series := &models.Series{ID: 1, StudyId: 1, Study: study, SeriesInstanceUID: seriesUID, Modality: "CT"}
+	a := &archive{studies: []*models.Study{study}, series: []*models.Series{series}}
+	files := map[string][]byte{}
+	for i, uid := range instanceUIDs {
+		instance := &models.Instance{ID: i + 1, SeriesId: 1, Series: series, SOPInstanceUID: uid,
+			SOPClassUID: "1.2.840.10008.5.1.4.1.1.2"}
+		files[uid] = newFile(t, uid)
+		if err := fs.SaveDicomFile(study, series, instance, files[uid]); err != nil {
+			t.Fatal(err)
+		}
+		a.instances = append(a.instances, instance)
+	}
+
+	studies, seriesList, instances := studyStore{archive: a}, seriesStore{archive: a}, instanceStore{archive: a}
+	api := &dicomweb.API{
+		QIDO: dicomweb.NewQIDOResource(nil, studies, seriesList, instances),
+		WADO: dicomweb.NewWADOResource(nil, studies, seriesList, instances),
+	}
+	router := chi.NewRouter()
+	router.Use(logging.NewStructuredLogger(logging.Logger))
+	router.Mount("/dicomweb", api.Router())
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server, files
+}
+
+func TestSearch(t *testing.T) {
+	server, _ := newServer(t)
+	client := dicomwebclient.NewClient(server.URL + "/dicomweb/")
+	ctx := context.Background()
+
+	studies, err := client.SearchStudies(ctx, url.Values{"PatientID": {"PAT1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(studies) != 1 || studies[0].String("0020000D") != studyUID {
+		t.Fatalf("studies = %v", studies)
+	}
+
+	studies, err = client.SearchStudies(ctx, url.Values{"PatientID": {"OTHER"}})
+	if err != nil || len(studies) != 0 {
+		t.Fatalf("studies of another patient = %v, %v", studies, err)
+	}
+
+	series, err := client.SearchSeries(ctx, studyUID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(series) != 1 || series[0].String("0020000E") != seriesUID || series[0].String("00080060") != "CT" {
+		t.Fatalf("series = %v", series)
+	}
+
+	instances, err := client.SearchInstances(ctx, studyUID, seriesUID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != len(instanceUIDs) {
+		t.Fatalf("got %d instances, want %d", len(instances), len(instanceUIDs))
+	}
+}
+
+func TestSearchStatusError(t *testing.T) {
+	server, _ := newServer(t)
+	client := dicomwebclient.NewClient(server.URL + "/dicomweb")
+
+	_, err := client.SearchSeries(context.Background(), "1.2.3.4", nil)
+	var statusErr *dicomwebclient.StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("err = %v, want a 404 status error", err)
+	}
+}
+
+func TestRetrieve(t *testing.T) {
+	server, files := newServer(t)
+	client := dicomwebclient.NewClient(server.URL + "/dicomweb")
+	ctx := context.Background()
+
+	var received [][]byte
+	err := client.RetrieveStudy(ctx, studyUID, func(data []byte) error {
+		received = append(received, data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != len(instanceUIDs) {
+		t.Fatalf("got %d files, want %d", len(received), len(instanceUIDs))
+	}
+	for i, uid := range instanceUIDs {
+		if string(received[i]) != string(files[uid]) {
+			t.Errorf("file %d differs from the stored file", i)
+		}
+	}
+
+	file, err := client.RetrieveInstance(ctx, studyUID, seriesUID, instanceUIDs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(file) != string(files[instanceUIDs[1]]) {
+		t.Error("instance differs from the stored file")
+	}
+
+	metadata, err := client.RetrieveStudyMetadata(ctx, studyUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metadata) != len(instanceUIDs) || metadata[0].String("00080018") == "" {
+		t.Fatalf("metadata = %v", metadata)
+	}
+}
+
+func TestRetrieveOnFileError(t *testing.T) {
+	server, _ := newServer(t)
+	client := dicomwebclient.NewClient(server.URL + "/dicomweb")
+
+	stop := errors.New("stop")
+	calls := 0
+	err := client.RetrieveSeries(context.Background(), studyUID, seriesUID, func(data []byte) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Fatalf("err = %v after %d files, want the error of the first file", err, calls)
+	}
+}
+
+func TestMaxSize(t *testing.T) {
+	server, files := newServer(t)
+	client := dicomwebclient.NewClient(server.URL + "/dicomweb")
+	client.MaxSize = int64(len(files[instanceUIDs[0]]) - 1)
+	ctx := context.Background()
+
+	err := client.RetrieveStudy(ctx, studyUID, func(data []byte) error { return nil })
+	if !errors.Is(err, dicomwebclient.ErrTooLarge) {
+		t.Fatalf("retrieve err = %v, want ErrTooLarge", err)
+	}
+
+	client.MaxSize = 16
+	if _, err := client.SearchStudies(ctx, nil); !errors.Is(err, dicomwebclient.ErrTooLarge) {
+		t.Fatalf("search err = %v, want ErrTooLarge", err)
+	}
+}
+
+func TestStoreConflict(t *testing.T) {
+	// the failures of a 409 are those of the FailedSOPSequence, as the STOW-RS response lists them
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/dicomweb/studies" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/dicom+json")
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintf(w, `{"00081198": {"vr": "SQ", "Value": [{"00081155": {"vr": "UI", "Value": [%q]},
+			"00081197": {"vr": "US", "Value": [272]}}]}}`, instanceUIDs[0])
+	}))
+	defer server.Close()
+	client := dicomwebclient.NewClient(server.URL + "/dicomweb")
+
+	response, err := client.Store(context.Background(), [][]byte{newFile(t, instanceUIDs[0])})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Failed[instanceUIDs[0]] != "272" {
+		t.Fatalf("failed = %v", response.Failed)
+	}
+}
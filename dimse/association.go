@@ -0,0 +1,503 @@
+package dimse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"dicom-store-api/logging"
+)
+
+// DefaultMaxPDULength is the largest P-DATA-TF PDU announced to peers.
+const DefaultMaxPDULength = 1 << 17
+
+// maxFragment bounds the PDVs sent to peers that accept PDUs of any length.
+const maxFragment = 1 << 20
+
+const writeTimeout = time.Minute
+
+// ErrAborted is returned for requests on an association that was aborted or closed by the peer.
+var ErrAborted = errors.New("association aborted")
+
+// Message is a DIMSE command with its data set, if any, in the transfer syntax of its presentation context.
+type Message struct {
+	ContextID      byte
+	AbstractSyntax string
+	TransferSyntax string
+	Command        *Command
+	Data           []byte
+}
+
+// HandlerFunc serves a request received on an association. It answers with Request.Respond, several times for
+// services with pending responses, and stops early once ctx is done, which a C-CANCEL or the end of the
+// association triggers. Requests of an association are served one after the other.
+type HandlerFunc func(ctx context.Context, request *Request)
+
+// Request is a request received on an association.
+type Request struct {
+	*Message
+	Association *Association
+}
+
+// Respond sends a response to the request. The command field, the message ID responded to and the affected
+// SOP class and instance are filled in from the request unless set.
+func (r *Request) Respond(response *Command, data []byte) error {
+	response.CommandField = r.Command.CommandField | responseBit
+	response.MessageIDBeingRespondedTo = r.Command.MessageID
+	if response.AffectedSOPClassUID == "" {
+		response.AffectedSOPClassUID = r.Command.AffectedSOPClassUID
+		if response.AffectedSOPClassUID == "" {
+			response.AffectedSOPClassUID = r.Command.RequestedSOPClassUID
+		}
+	}
+	if response.AffectedSOPInstanceUID == "" {
+		response.AffectedSOPInstanceUID = r.Command.AffectedSOPInstanceUID
+		if response.AffectedSOPInstanceUID == "" {
+			response.AffectedSOPInstanceUID = r.Command.RequestedSOPInstanceUID
+		}
+	}
+	return r.Association.send(r.ContextID, response, data)
+}
+
+// RespondStatus sends a response with the status and an optional error comment and no data set.
+func (r *Request) RespondStatus(status uint16, errorComment string) error {
+	return r.Respond(&Command{Status: status, ErrorComment: errorComment}, nil)
+}
+
+// Association is an association accepted by a Server or requested with Dial.
+type Association struct {
+	CallingAETitle string
+	CalledAETitle  string
+	// RemoteAddr is the network address of the peer.
+	RemoteAddr string
+
+	conn             net.Conn
+	contexts         map[byte]*presentationContext
+	roles            []*roleSelection
+	peerMaxPDULength uint32
+	idleTimeout      time.Duration
+	handlers         map[uint16]HandlerFunc
+
+	writeMutex sync.Mutex
+	messageID  uint32
+	busy       int32
+
+	mutex            sync.Mutex
+	responses        map[uint16]chan *Message
+	cancels          map[uint16]context.CancelFunc
+	requests         chan *Message
+	released         chan struct{}
+	releaseRequested bool
+	done             chan struct{}
+	err              error
+}
+
+func newAssociation(conn net.Conn, callingAETitle string, calledAETitle string) *Association {
+	return &Association{
+		CallingAETitle: callingAETitle,
+		CalledAETitle:  calledAETitle,
+		RemoteAddr:     conn.RemoteAddr().String(),
+		conn:           conn,
+		contexts:       map[byte]*presentationContext{},
+		handlers:       map[uint16]HandlerFunc{},
+		responses:      map[uint16]chan *Message{},
+		cancels:        map[uint16]context.CancelFunc{},
+		requests:       make(chan *Message, 16),
+		released:       make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+}
+
+// Done returns a channel closed when the association has ended.
+func (a *Association) Done() <-chan struct{} {
+	return a.done
+}
+
+// Err returns why the association ended, nil after a release.
+func (a *Association) Err() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.err
+}
+
+// HasContext reports whether a presentation context for the abstract syntax was accepted.
+func (a *Association) HasContext(abstractSyntax string) bool {
+	_, err := a.context(abstractSyntax, "")
+	return err == nil
+}
+
+// TransferSyntaxes returns the transfer syntaxes accepted for the abstract syntax.
+func (a *Association) TransferSyntaxes(abstractSyntax string) []string {
+	var transferSyntaxes []string
+	for _, context := range a.contexts {
+		if context.AbstractSyntax == abstractSyntax && !contains(transferSyntaxes, context.TransferSyntaxes[0]) {
+			transferSyntaxes = append(transferSyntaxes, context.TransferSyntaxes[0])
+		}
+	}
+	return transferSyntaxes
+}
+
+// context returns an accepted presentation context for the abstract syntax, in the transfer syntax if not empty.
+func (a *Association) context(abstractSyntax string, transferSyntax string) (*presentationContext, error) {
+	var found *presentationContext
+	for _, context := range a.contexts {
+		if context.AbstractSyntax != abstractSyntax {
+			continue
+		}
+		if transferSyntax == "" || context.TransferSyntaxes[0] == transferSyntax {
+			if found == nil || context.ID < found.ID {
+				found = context
+			}
+		}
+	}
+	if found == nil {
+		if transferSyntax != "" {
+			return nil, fmt.Errorf("no presentation context accepted for %s in %s", abstractSyntax, transferSyntax)
+		}
+		return nil, fmt.Errorf("no presentation context accepted for %s", abstractSyntax)
+	}
+	return found, nil
+}
+
+// Request sends a request on a presentation context for its abstract syntax and waits for the final response.
+// Pending responses are passed to onPending, if set. When ctx is done a C-CANCEL is sent and the final
+// response is still awaited, as the peer answers the cancel with it.
+func (a *Association) Request(ctx context.Context, abstractSyntax string, transferSyntax string, command *Command, data []byte,
+	onPending func(response *Message) error) (*Message, error) {
+	context, err := a.context(abstractSyntax, transferSyntax)
+	if err != nil {
+		return nil, err
+	}
+
+	command.MessageID = uint16(atomic.AddUint32(&a.messageID, 1))
+	responses := make(chan *Message, 16)
+	a.mutex.Lock()
+	a.responses[command.MessageID] = responses
+	a.mutex.Unlock()
+	defer func() {
+		a.mutex.Lock()
+		delete(a.responses, command.MessageID)
+		a.mutex.Unlock()
+	}()
+
+	a.setBusy(1)
+	defer a.setBusy(-1)
+	if err := a.send(context.ID, command, data); err != nil {
+		return nil, err
+	}
+
+	cancelled := ctx.Done()
+	for {
+		select {
+		case response := <-responses:
+			if !IsPending(response.Command.Status) {
+				return response, nil
+			}
+			if onPending != nil {
+				if err := onPending(response); err != nil {
+					return nil, err
+				}
+			}
+		case <-cancelled:
+			cancelled = nil
+			cancel := &Command{CommandField: CCancelRQ, MessageIDBeingRespondedTo: command.MessageID}
+			if err := a.send(context.ID, cancel, nil); err != nil {
+				return nil, err
+			}
+		case <-a.done:
+			select {
+			case response := <-responses:
+				if !IsPending(response.Command.Status) {
+					return response, nil
+				}
+			default:
+			}
+			if err := a.Err(); err != nil {
+				return nil, err
+			}
+			return nil, ErrAborted
+		}
+	}
+}
+
+// Echo sends a C-ECHO request.
+func (a *Association) Echo(ctx context.Context) error {
+	response, err := a.Request(ctx, VerificationSOPClass, "", &Command{CommandField: CEchoRQ, AffectedSOPClassUID: VerificationSOPClass}, nil, nil)
+	if err != nil {
+		return err
+	}
+	if response.Command.Status != StatusSuccess {
+		return fmt.Errorf("C-ECHO failed with status 0x%04X", response.Command.Status)
+	}
+	return nil
+}
+
+// Store sends a C-STORE request for a Part 10 file, in its transfer syntax. It returns the response status.
+func (a *Association) Store(ctx context.Context, file []byte, moveOriginatorAETitle string, moveOriginatorMessageID uint16) (*Command, error) {
+	meta, dataSet, err := ParsePart10File(file)
+	if err != nil {
+		return nil, err
+	}
+	command := &Command{
+		CommandField:            CStoreRQ,
+		AffectedSOPClassUID:     meta.SOPClassUID,
+		AffectedSOPInstanceUID:  meta.SOPInstanceUID,
+		Priority:                PriorityMedium,
+		MoveOriginatorAETitle:   moveOriginatorAETitle,
+		MoveOriginatorMessageID: moveOriginatorMessageID,
+	}
+	response, err := a.Request(ctx, meta.SOPClassUID, meta.TransferSyntax, command, dataSet, nil)
+	if err != nil {
+		return nil, err
+	}
+	return response.Command, nil
+}
+
+// send writes a command and its data set, fragmented to the maximum PDU length of the peer.
+func (a *Association) send(contextID byte, command *Command, data []byte) error {
+	a.writeMutex.Lock()
+	defer a.writeMutex.Unlock()
+
+	command.HasDataSet = data != nil
+	a.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if err := a.writeFragments(contextID, true, command.encode()); err != nil {
+		return err
+	}
+	if data != nil {
+		return a.writeFragments(contextID, false, data)
+	}
+	return nil
+}
+
+func (a *Association) writeFragments(contextID byte, command bool, data []byte) error {
+	size := maxFragment
+	if a.peerMaxPDULength > 6 && int(a.peerMaxPDULength)-6 < size {
+		size = int(a.peerMaxPDULength) - 6
+	}
+	for {
+		n := len(data)
+		if n > size {
+			n = size
+		}
+		fragment := &pdv{ContextID: contextID, Command: command, Last: n == len(data), Data: data[:n]}
+		if err := writePDU(a.conn, pduPDataTF, encodePDV(fragment)); err != nil {
+			return err
+		}
+		data = data[n:]
+		if fragment.Last {
+			return nil
+		}
+	}
+}
+
+// setBusy counts the requests in progress. The idle timeout only applies to an association without any.
+func (a *Association) setBusy(delta int32) {
+	if atomic.AddInt32(&a.busy, delta) == 0 && a.idleTimeout > 0 {
+		a.conn.SetReadDeadline(time.Now().Add(a.idleTimeout))
+	} else {
+		a.conn.SetReadDeadline(time.Time{})
+	}
+}
+
+// readLoop assembles the messages received and passes responses to the waiting requests, cancels to
+// the requests being served and requests to serve.
+func (a *Association) readLoop() {
+	defer func() {
+		a.mutex.Lock()
+		for _, cancel := range a.cancels {
+			cancel()
+		}
+		a.mutex.Unlock()
+		close(a.requests)
+		close(a.done)
+	}()
+
+	var command *Command
+	var commandData, data []byte
+	for {
+		p, err := readPDU(a.conn)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				a.abort(errors.New("idle timeout"))
+			} else {
+				a.fail(err)
+			}
+			return
+		}
+
+		switch p.Type {
+		case pduPDataTF:
+			pdvs, err := decodePDataTF(p.Data)
+			if err != nil {
+				a.abort(err)
+				return
+			}
+			for _, fragment := range pdvs {
+				context := a.contexts[fragment.ContextID]
+				if context == nil {
+					a.abort(fmt.Errorf("PDV for presentation context %d that was not accepted", fragment.ContextID))
+					return
+				}
+				if fragment.Command {
+					commandData = append(commandData, fragment.Data...)
+					if !fragment.Last {
+						continue
+					}
+					if command, err = decodeCommand(commandData); err != nil {
+						a.abort(err)
+						return
+					}
+					commandData = nil
+					if command.HasDataSet {
+						continue
+					}
+				} else {
+					if command == nil {
+						a.abort(errors.New("data set without a command"))
+						return
+					}
+					data = append(data, fragment.Data...)
+					if !fragment.Last {
+						continue
+					}
+				}
+
+				message := &Message{
+					ContextID:      fragment.ContextID,
+					AbstractSyntax: context.AbstractSyntax,
+					TransferSyntax: context.TransferSyntaxes[0],
+					Command:        command,
+					Data:           data,
+				}
+				command, data = nil, nil
+				if err := a.dispatch(message); err != nil {
+					a.abort(err)
+					return
+				}
+			}
+		case pduReleaseRQ:
+			a.mutex.Lock()
+			a.releaseRequested = true
+			a.mutex.Unlock()
+			return
+		case pduReleaseRP:
+			close(a.released)
+			return
+		case pduAbort:
+			a.fail(ErrAborted)
+			return
+		default:
+			a.abort(fmt.Errorf("unexpected PDU type 0x%02X", p.Type))
+			return
+		}
+	}
+}
+
+func (a *Association) dispatch(message *Message) error {
+	command := message.Command
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	switch {
+	case command.IsResponse():
+		if responses, ok := a.responses[command.MessageIDBeingRespondedTo]; ok {
+			responses <- message
+		}
+	case command.CommandField == CCancelRQ:
+		if cancel, ok := a.cancels[command.MessageIDBeingRespondedTo]; ok {
+			cancel()
+		}
+	default:
+		select {
+		case a.requests <- message:
+		default:
+			return errors.New("too many outstanding requests")
+		}
+	}
+	return nil
+}
+
+// serve handles the requests received until the peer releases the association or it ends otherwise.
+func (a *Association) serve() {
+	logger := logging.Logger.WithField("module", "dimse").WithField("calling_ae", a.CallingAETitle)
+	for message := range a.requests {
+		ctx, cancel := context.WithCancel(context.Background())
+		a.mutex.Lock()
+		a.cancels[message.Command.MessageID] = cancel
+		a.mutex.Unlock()
+
+		a.setBusy(1)
+		request := &Request{Message: message, Association: a}
+		if handler, ok := a.handlers[message.Command.CommandField]; ok {
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						logger.Errorf("panic serving %s: %v", message.Command, r)
+						request.RespondStatus(StatusProcessingFailure, "")
+					}
+				}()
+				handler(ctx, request)
+			}()
+		} else {
+			logger.Warnf("unsupported %s", message.Command)
+			request.RespondStatus(StatusUnrecognizedOperation, "")
+		}
+		a.setBusy(-1)
+
+		a.mutex.Lock()
+		delete(a.cancels, message.Command.MessageID)
+		a.mutex.Unlock()
+		cancel()
+	}
+}
+
+// Release releases the association and closes its connection.
+func (a *Association) Release() error {
+	defer a.conn.Close()
+	a.writeMutex.Lock()
+	a.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	err := writePDU(a.conn, pduReleaseRQ, make([]byte, 4))
+	a.writeMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-a.released:
+		return nil
+	case <-a.done:
+		return a.Err()
+	case <-time.After(writeTimeout):
+		return errors.New("no reply to the release request")
+	}
+}
+
+// Abort aborts the association and closes its connection.
+func (a *Association) Abort() {
+	a.abort(nil)
+}
+
+func (a *Association) abort(err error) {
+	a.writeMutex.Lock()
+	a.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	writePDU(a.conn, pduAbort, make([]byte, 4))
+	a.writeMutex.Unlock()
+	if err == nil {
+		err = ErrAborted
+	}
+	a.fail(err)
+}
+
+func (a *Association) fail(err error) {
+	a.mutex.Lock()
+	if a.err == nil {
+		a.err = err
+	}
+	a.mutex.Unlock()
+	a.conn.Close()
+}
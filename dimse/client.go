@@ -0,0 +1,121 @@
+package dimse
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// PresentationContext proposes an abstract syntax in transfer syntaxes when requesting an association.
+type PresentationContext struct {
+	AbstractSyntax   string
+	TransferSyntaxes []string
+	// SCPRole proposes that this side takes the SCP role for the SOP class, as a C-GET requestor does for storage.
+	SCPRole bool
+}
+
+// DialOptions are the optional settings of an association requested with Dial.
+type DialOptions struct {
+	// Timeout bounds the connection and the association negotiation, 30 seconds if zero.
+	Timeout time.Duration
+	// Handlers serve the requests the peer sends on the association, such as the C-STORE sub-operations of a C-GET.
+	Handlers map[uint16]HandlerFunc
+}
+
+// Dial requests an association with the peer at the TCP address.
+func Dial(ctx context.Context, addr string, callingAETitle string, calledAETitle string,
+	contexts []*PresentationContext, options *DialOptions) (*Association, error) {
+	if options == nil {
+		options = &DialOptions{}
+	}
+	if len(contexts) == 0 || len(contexts) > 128 {
+		return nil, fmt.Errorf("%d presentation contexts proposed, between 1 and 128 are allowed", len(contexts))
+	}
+	timeout := options.Timeout
+	if timeout == 0 {
+		timeout = associateTimeout
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	rq := &associate{
+		CalledAETitle:             calledAETitle,
+		CallingAETitle:            callingAETitle,
+		MaxPDULength:              DefaultMaxPDULength,
+		ImplementationClassUID:    ImplementationClassUID,
+		ImplementationVersionName: ImplementationVersionName,
+	}
+	proposed := map[byte]*PresentationContext{}
+	for i, context := range contexts {
+		id := byte(2*i + 1)
+		proposed[id] = context
+		rq.PresentationContexts = append(rq.PresentationContexts, &presentationContext{
+			ID:               id,
+			AbstractSyntax:   context.AbstractSyntax,
+			TransferSyntaxes: context.TransferSyntaxes,
+		})
+		if context.SCPRole {
+			rq.RoleSelections = append(rq.RoleSelections, &roleSelection{SOPClassUID: context.AbstractSyntax, SCP: true})
+		}
+	}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	if err := writePDU(conn, pduAssociateRQ, rq.encode(false)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	p, err := readPDU(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	switch p.Type {
+	case pduAssociateAC:
+	case pduAssociateRJ:
+		conn.Close()
+		if len(p.Data) >= 4 {
+			return nil, fmt.Errorf("association rejected by %s, result %d, source %d, reason %d", calledAETitle, p.Data[1], p.Data[2], p.Data[3])
+		}
+		return nil, fmt.Errorf("association rejected by %s", calledAETitle)
+	default:
+		conn.Close()
+		return nil, fmt.Errorf("association with %s aborted", calledAETitle)
+	}
+	ac, err := decodeAssociate(p.Data)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	a := newAssociation(conn, callingAETitle, calledAETitle)
+	a.peerMaxPDULength = ac.MaxPDULength
+	a.roles = ac.RoleSelections
+	for commandField, handler := range options.Handlers {
+		a.handlers[commandField] = handler
+	}
+	for _, accepted := range ac.PresentationContexts {
+		context, ok := proposed[accepted.ID]
+		if !ok || accepted.Result != contextAccepted || len(accepted.TransferSyntaxes) != 1 {
+			continue
+		}
+		a.contexts[accepted.ID] = &presentationContext{
+			ID:               accepted.ID,
+			AbstractSyntax:   context.AbstractSyntax,
+			TransferSyntaxes: accepted.TransferSyntaxes,
+		}
+	}
+
+	go a.readLoop()
+	go a.serve()
+	if len(a.contexts) == 0 {
+		a.Release()
+		return nil, fmt.Errorf("no presentation context accepted by %s", calledAETitle)
+	}
+	return a, nil
+}
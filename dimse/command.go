@@ -0,0 +1,293 @@
+package dimse
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Command fields of DIMSE requests, PS3.7 section E.1. Responses have the high bit set.
+const (
+	CStoreRQ       uint16 = 0x0001
+	CGetRQ         uint16 = 0x0010
+	CFindRQ        uint16 = 0x0020
+	CMoveRQ        uint16 = 0x0021
+	CEchoRQ        uint16 = 0x0030
+	NEventReportRQ uint16 = 0x0100
+	NGetRQ         uint16 = 0x0110
+	NSetRQ         uint16 = 0x0120
+	NActionRQ      uint16 = 0x0130
+	NCreateRQ      uint16 = 0x0140
+	NDeleteRQ      uint16 = 0x0150
+	CCancelRQ      uint16 = 0x0FFF
+
+	responseBit uint16 = 0x8000
+)
+
+// Priorities of C-STORE, C-FIND, C-GET and C-MOVE requests.
+const (
+	PriorityMedium uint16 = 0x0000
+	PriorityHigh   uint16 = 0x0001
+	PriorityLow    uint16 = 0x0002
+)
+
+const (
+	commandGroup    uint16 = 0x0000
+	noDataSet       uint16 = 0x0101
+	dataSetPresent  uint16 = 0x0000
+	maxErrorComment        = 64
+)
+
+// Elements of the command set.
+const (
+	tagCommandGroupLength        = 0x0000
+	tagAffectedSOPClassUID       = 0x0002
+	tagRequestedSOPClassUID      = 0x0003
+	tagCommandField              = 0x0100
+	tagMessageID                 = 0x0110
+	tagMessageIDBeingRespondedTo = 0x0120
+	tagMoveDestination           = 0x0600
+	tagPriority                  = 0x0700
+	tagCommandDataSetType        = 0x0800
+	tagStatus                    = 0x0900
+	tagErrorComment              = 0x0902
+	tagAffectedSOPInstanceUID    = 0x1000
+	tagRequestedSOPInstanceUID   = 0x1001
+	tagEventTypeID               = 0x1002
+	tagActionTypeID              = 0x1008
+	tagNumberOfRemainingSubOps   = 0x1020
+	tagNumberOfCompletedSubOps   = 0x1021
+	tagNumberOfFailedSubOps      = 0x1022
+	tagNumberOfWarningSubOps     = 0x1023
+	tagMoveOriginatorAETitle     = 0x1030
+	tagMoveOriginatorMessageID   = 0x1031
+)
+
+// SubOperations counts the C-STORE sub-operations of a C-GET or C-MOVE.
+type SubOperations struct {
+	Remaining uint16
+	Completed uint16
+	Failed    uint16
+	Warning   uint16
+}
+
+// Command is a DIMSE command set. Only the elements that apply to its command field are encoded.
+type Command struct {
+	CommandField              uint16
+	MessageID                 uint16
+	MessageIDBeingRespondedTo uint16
+	AffectedSOPClassUID       string
+	AffectedSOPInstanceUID    string
+	RequestedSOPClassUID      string
+	RequestedSOPInstanceUID   string
+	Priority                  uint16
+	HasDataSet                bool
+	Status                    uint16
+	ErrorComment              string
+	MoveDestination           string
+	MoveOriginatorAETitle     string
+	MoveOriginatorMessageID   uint16
+	EventTypeID               uint16
+	ActionTypeID              uint16
+	SubOperations             *SubOperations
+}
+
+// IsResponse reports whether the command is a response.
+func (c *Command) IsResponse() bool {
+	return c.CommandField&responseBit != 0
+}
+
+func (c *Command) String() string {
+	if c.IsResponse() {
+		return fmt.Sprintf("%s response to %d with status 0x%04X", commandName(c.CommandField&^responseBit), c.MessageIDBeingRespondedTo, c.Status)
+	}
+	return fmt.Sprintf("%s request %d", commandName(c.CommandField), c.MessageID)
+}
+
+func commandName(commandField uint16) string {
+	switch commandField {
+	case CStoreRQ:
+		return "C-STORE"
+	case CGetRQ:
+		return "C-GET"
+	case CFindRQ:
+		return "C-FIND"
+	case CMoveRQ:
+		return "C-MOVE"
+	case CEchoRQ:
+		return "C-ECHO"
+	case NEventReportRQ:
+		return "N-EVENT-REPORT"
+	case NGetRQ:
+		return "N-GET"
+	case NSetRQ:
+		return "N-SET"
+	case NActionRQ:
+		return "N-ACTION"
+	case NCreateRQ:
+		return "N-CREATE"
+	case NDeleteRQ:
+		return "N-DELETE"
+	case CCancelRQ:
+		return "C-CANCEL"
+	}
+	return fmt.Sprintf("command 0x%04X", commandField)
+}
+
+// encode writes the command set in Implicit VR Little Endian, as PS3.7 requires.
+func (c *Command) encode() []byte {
+	var b []byte
+	uid := func(element uint16, value string) {
+		if value != "" {
+			b = appendImplicitElement(b, commandGroup, element, padValue(value, 0))
+		}
+	}
+	us := func(element uint16, value uint16) {
+		b = appendImplicitElement(b, commandGroup, element, []byte{byte(value), byte(value >> 8)})
+	}
+
+	uid(tagAffectedSOPClassUID, c.AffectedSOPClassUID)
+	uid(tagRequestedSOPClassUID, c.RequestedSOPClassUID)
+	us(tagCommandField, c.CommandField)
+	if c.IsResponse() || c.CommandField == CCancelRQ {
+		us(tagMessageIDBeingRespondedTo, c.MessageIDBeingRespondedTo)
+	} else {
+		us(tagMessageID, c.MessageID)
+	}
+	if c.MoveDestination != "" {
+		b = appendImplicitElement(b, commandGroup, tagMoveDestination, []byte(aeTitleField(c.MoveDestination)))
+	}
+	switch c.CommandField {
+	case CStoreRQ, CFindRQ, CGetRQ, CMoveRQ:
+		us(tagPriority, c.Priority)
+	}
+	if c.HasDataSet {
+		us(tagCommandDataSetType, dataSetPresent)
+	} else {
+		us(tagCommandDataSetType, noDataSet)
+	}
+	if c.IsResponse() {
+		us(tagStatus, c.Status)
+	}
+	if c.ErrorComment != "" {
+		comment := c.ErrorComment
+		if len(comment) > maxErrorComment {
+			comment = comment[:maxErrorComment]
+		}
+		b = appendImplicitElement(b, commandGroup, tagErrorComment, padValue(comment, ' '))
+	}
+	uid(tagAffectedSOPInstanceUID, c.AffectedSOPInstanceUID)
+	uid(tagRequestedSOPInstanceUID, c.RequestedSOPInstanceUID)
+	switch c.CommandField {
+	case NEventReportRQ, NEventReportRQ | responseBit:
+		us(tagEventTypeID, c.EventTypeID)
+	case NActionRQ, NActionRQ | responseBit:
+		us(tagActionTypeID, c.ActionTypeID)
+	}
+	if c.SubOperations != nil {
+		if c.Status == StatusPending {
+			us(tagNumberOfRemainingSubOps, c.SubOperations.Remaining)
+		}
+		us(tagNumberOfCompletedSubOps, c.SubOperations.Completed)
+		us(tagNumberOfFailedSubOps, c.SubOperations.Failed)
+		us(tagNumberOfWarningSubOps, c.SubOperations.Warning)
+	}
+	if c.MoveOriginatorAETitle != "" {
+		b = appendImplicitElement(b, commandGroup, tagMoveOriginatorAETitle, []byte(aeTitleField(c.MoveOriginatorAETitle)))
+		us(tagMoveOriginatorMessageID, c.MoveOriginatorMessageID)
+	}
+
+	length := make([]byte, 4)
+	binary.LittleEndian.PutUint32(length, uint32(len(b)))
+	return append(appendImplicitElement(nil, commandGroup, tagCommandGroupLength, length), b...)
+}
+
+func decodeCommand(data []byte) (*Command, error) {
+	c := &Command{}
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, fmt.Errorf("malformed command set")
+		}
+		group := binary.LittleEndian.Uint16(data)
+		element := binary.LittleEndian.Uint16(data[2:])
+		length := binary.LittleEndian.Uint32(data[4:])
+		if group != commandGroup || uint64(length) > uint64(len(data)-8) {
+			return nil, fmt.Errorf("malformed command set")
+		}
+		value := data[8 : 8+length]
+		data = data[8+length:]
+
+		us := func() uint16 {
+			if len(value) < 2 {
+				return 0
+			}
+			return binary.LittleEndian.Uint16(value)
+		}
+		subOperations := func() *SubOperations {
+			if c.SubOperations == nil {
+				c.SubOperations = &SubOperations{}
+			}
+			return c.SubOperations
+		}
+		switch element {
+		case tagAffectedSOPClassUID:
+			c.AffectedSOPClassUID = trimUID(value)
+		case tagRequestedSOPClassUID:
+			c.RequestedSOPClassUID = trimUID(value)
+		case tagCommandField:
+			c.CommandField = us()
+		case tagMessageID:
+			c.MessageID = us()
+		case tagMessageIDBeingRespondedTo:
+			c.MessageIDBeingRespondedTo = us()
+		case tagMoveDestination:
+			c.MoveDestination = strings.TrimSpace(string(value))
+		case tagPriority:
+			c.Priority = us()
+		case tagCommandDataSetType:
+			c.HasDataSet = us() != noDataSet
+		case tagStatus:
+			c.Status = us()
+		case tagErrorComment:
+			c.ErrorComment = strings.TrimSpace(string(value))
+		case tagAffectedSOPInstanceUID:
+			c.AffectedSOPInstanceUID = trimUID(value)
+		case tagRequestedSOPInstanceUID:
+			c.RequestedSOPInstanceUID = trimUID(value)
+		case tagEventTypeID:
+			c.EventTypeID = us()
+		case tagActionTypeID:
+			c.ActionTypeID = us()
+		case tagNumberOfRemainingSubOps:
+			subOperations().Remaining = us()
+		case tagNumberOfCompletedSubOps:
+			subOperations().Completed = us()
+		case tagNumberOfFailedSubOps:
+			subOperations().Failed = us()
+		case tagNumberOfWarningSubOps:
+			subOperations().Warning = us()
+		case tagMoveOriginatorAETitle:
+			c.MoveOriginatorAETitle = strings.TrimSpace(string(value))
+		case tagMoveOriginatorMessageID:
+			c.MoveOriginatorMessageID = us()
+		}
+	}
+	return c, nil
+}
+
+func appendImplicitElement(b []byte, group uint16, element uint16, value []byte) []byte {
+	header := make([]byte, 8)
+	binary.LittleEndian.PutUint16(header, group)
+	binary.LittleEndian.PutUint16(header[2:], element)
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(value)))
+	return append(append(b, header...), value...)
+}
+
+// padValue pads a value to an even length, UIDs with a zero byte and text with a space.
+func padValue(value string, padding byte) []byte {
+	b := []byte(value)
+	if len(b)%2 != 0 {
+		b = append(b, padding)
+	}
+	return b
+}
@@ -0,0 +1,312 @@
+package dimse
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/suyashkumar/dicom/pkg/tag"
+)
+
+const undefinedLength = 0xFFFFFFFF
+
+// Item and delimitation tags of sequences.
+var (
+	itemTag                 = tag.Tag{Group: 0xFFFE, Element: 0xE000}
+	itemDelimitationTag     = tag.Tag{Group: 0xFFFE, Element: 0xE00D}
+	sequenceDelimitationTag = tag.Tag{Group: 0xFFFE, Element: 0xE0DD}
+)
+
+var errMalformedDataSet = errors.New("malformed data set")
+
+// Element is an element of a DataSet, with its raw value or, for a sequence, its items.
+type Element struct {
+	Tag   tag.Tag
+	VR    string
+	Value []byte
+	Items []*DataSet
+}
+
+// DataSet is a small data set such as the identifier of a query, kept in the order of its elements.
+type DataSet struct {
+	Elements []*Element
+}
+
+// Get returns the element with the tag or nil.
+func (d *DataSet) Get(t tag.Tag) *Element {
+	for _, element := range d.Elements {
+		if element.Tag == t {
+			return element
+		}
+	}
+	return nil
+}
+
+// Has reports whether the data set has an element with the tag.
+func (d *DataSet) Has(t tag.Tag) bool {
+	return d.Get(t) != nil
+}
+
+// String returns the value of the element with the tag, without padding, or an empty string.
+func (d *DataSet) String(t tag.Tag) string {
+	element := d.Get(t)
+	if element == nil || element.Items != nil {
+		return ""
+	}
+	return strings.TrimRight(string(element.Value), "\x00 ")
+}
+
+// Uint16 returns the value of the US element with the tag.
+func (d *DataSet) Uint16(t tag.Tag) (uint16, bool) {
+	element := d.Get(t)
+	if element == nil || len(element.Value) < 2 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint16(element.Value), true
+}
+
+// Set sets the text value of the element with the tag, with the VR of the dictionary, and returns the element.
+func (d *DataSet) Set(t tag.Tag, value string) *Element {
+	padding := byte(' ')
+	vr := dictionaryVR(t)
+	if vr == "UI" {
+		padding = 0
+	}
+	return d.SetValue(t, vr, padValue(value, padding))
+}
+
+// SetUint16 sets the US element with the tag.
+func (d *DataSet) SetUint16(t tag.Tag, value uint16) *Element {
+	return d.SetValue(t, "US", []byte{byte(value), byte(value >> 8)})
+}
+
+// SetValue sets the raw value of the element with the tag and returns the element.
+func (d *DataSet) SetValue(t tag.Tag, vr string, value []byte) *Element {
+	element := d.Get(t)
+	if element == nil {
+		element = &Element{Tag: t}
+		d.Elements = append(d.Elements, element)
+	}
+	element.VR, element.Value, element.Items = vr, value, nil
+	return element
+}
+
+// SetItems sets the sequence with the tag and returns its element.
+func (d *DataSet) SetItems(t tag.Tag, items []*DataSet) *Element {
+	element := d.SetValue(t, "SQ", nil)
+	element.Items = items
+	if element.Items == nil {
+		element.Items = []*DataSet{}
+	}
+	return element
+}
+
+// Remove removes the element with the tag.
+func (d *DataSet) Remove(t tag.Tag) {
+	for i, element := range d.Elements {
+		if element.Tag == t {
+			d.Elements = append(d.Elements[:i], d.Elements[i+1:]...)
+			return
+		}
+	}
+}
+
+// DecodeDataSet decodes a data set in Implicit or Explicit VR Little Endian.
+func DecodeDataSet(data []byte, transferSyntax string) (*DataSet, error) {
+	explicit, err := isExplicitVR(transferSyntax)
+	if err != nil {
+		return nil, err
+	}
+	d, rest, err := decodeElements(data, explicit, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errMalformedDataSet
+	}
+	return d, nil
+}
+
+// Encode encodes the data set in Implicit or Explicit VR Little Endian, its elements sorted by tag.
+func (d *DataSet) Encode(transferSyntax string) ([]byte, error) {
+	explicit, err := isExplicitVR(transferSyntax)
+	if err != nil {
+		return nil, err
+	}
+	return d.appendTo(nil, explicit), nil
+}
+
+func isExplicitVR(transferSyntax string) (bool, error) {
+	switch transferSyntax {
+	case ImplicitVRLittleEndian:
+		return false, nil
+	case ExplicitVRLittleEndian:
+		return true, nil
+	}
+	return false, fmt.Errorf("data sets in %s are not supported", transferSyntax)
+}
+
+// decodeElements decodes elements until the end of data or, inside an item of undefined length,
+// its delimitation item. It returns the data following the item.
+func decodeElements(data []byte, explicit bool, inItem bool) (*DataSet, []byte, error) {
+	d := &DataSet{}
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, nil, errMalformedDataSet
+		}
+		t := tag.Tag{Group: binary.LittleEndian.Uint16(data), Element: binary.LittleEndian.Uint16(data[2:])}
+		if t == itemDelimitationTag {
+			if !inItem {
+				return nil, nil, errMalformedDataSet
+			}
+			return d, data[8:], nil
+		}
+
+		var vr string
+		var length uint32
+		if explicit {
+			vr = string(data[4:6])
+			if isLongVR(vr) {
+				if len(data) < 12 {
+					return nil, nil, errMalformedDataSet
+				}
+				length = binary.LittleEndian.Uint32(data[8:])
+				data = data[12:]
+			} else {
+				length = uint32(binary.LittleEndian.Uint16(data[6:]))
+				data = data[8:]
+			}
+		} else {
+			vr = dictionaryVR(t)
+			length = binary.LittleEndian.Uint32(data[4:])
+			data = data[8:]
+		}
+
+		element := &Element{Tag: t, VR: vr}
+		if vr == "SQ" || (vr == "UN" && length == undefinedLength) {
+			element.VR = "SQ"
+			items, rest, err := decodeItems(data, length, explicit)
+			if err != nil {
+				return nil, nil, err
+			}
+			element.Items, data = items, rest
+		} else {
+			if length == undefinedLength || uint64(length) > uint64(len(data)) {
+				return nil, nil, errMalformedDataSet
+			}
+			element.Value, data = data[:length], data[length:]
+		}
+		d.Elements = append(d.Elements, element)
+	}
+	if inItem {
+		return nil, nil, errMalformedDataSet
+	}
+	return d, nil, nil
+}
+
+func decodeItems(data []byte, length uint32, explicit bool) ([]*DataSet, []byte, error) {
+	var rest []byte
+	if length != undefinedLength {
+		if uint64(length) > uint64(len(data)) {
+			return nil, nil, errMalformedDataSet
+		}
+		data, rest = data[:length], data[length:]
+	}
+
+	items := []*DataSet{}
+	for {
+		if len(data) == 0 && length != undefinedLength {
+			return items, rest, nil
+		}
+		if len(data) < 8 {
+			return nil, nil, errMalformedDataSet
+		}
+		t := tag.Tag{Group: binary.LittleEndian.Uint16(data), Element: binary.LittleEndian.Uint16(data[2:])}
+		itemLength := binary.LittleEndian.Uint32(data[4:])
+		data = data[8:]
+		switch t {
+		case sequenceDelimitationTag:
+			if length != undefinedLength {
+				return nil, nil, errMalformedDataSet
+			}
+			return items, data, nil
+		case itemTag:
+		default:
+			return nil, nil, errMalformedDataSet
+		}
+
+		var item *DataSet
+		var err error
+		if itemLength == undefinedLength {
+			item, data, err = decodeElements(data, explicit, true)
+		} else {
+			if uint64(itemLength) > uint64(len(data)) {
+				return nil, nil, errMalformedDataSet
+			}
+			item, _, err = decodeElements(data[:itemLength], explicit, false)
+			data = data[itemLength:]
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		items = append(items, item)
+	}
+}
+
+// appendTo encodes the elements in tag order, sequences and items with undefined lengths.
+func (d *DataSet) appendTo(b []byte, explicit bool) []byte {
+	elements := append([]*Element(nil), d.Elements...)
+	for i := 1; i < len(elements); i++ {
+		for j := i; j > 0 && elements[j].Tag.Compare(elements[j-1].Tag) < 0; j-- {
+			elements[j], elements[j-1] = elements[j-1], elements[j]
+		}
+	}
+
+	for _, element := range elements {
+		length := uint32(len(element.Value))
+		if element.VR == "SQ" {
+			length = undefinedLength
+		}
+		b = appendElementHeader(b, element.Tag, element.VR, length, explicit)
+		if element.VR != "SQ" {
+			b = append(b, element.Value...)
+			continue
+		}
+		for _, item := range element.Items {
+			b = appendElementHeader(b, itemTag, "", undefinedLength, false)
+			b = item.appendTo(b, explicit)
+			b = appendElementHeader(b, itemDelimitationTag, "", 0, false)
+		}
+		b = appendElementHeader(b, sequenceDelimitationTag, "", 0, false)
+	}
+	return b
+}
+
+func appendElementHeader(b []byte, t tag.Tag, vr string, length uint32, explicit bool) []byte {
+	header := make([]byte, 12)
+	binary.LittleEndian.PutUint16(header, t.Group)
+	binary.LittleEndian.PutUint16(header[2:], t.Element)
+	switch {
+	case !explicit:
+		binary.LittleEndian.PutUint32(header[4:], length)
+		return append(b, header[:8]...)
+	case isLongVR(vr):
+		copy(header[4:], vr)
+		binary.LittleEndian.PutUint32(header[8:], length)
+		return append(b, header...)
+	default:
+		copy(header[4:], vr)
+		binary.LittleEndian.PutUint16(header[6:], uint16(length))
+		return append(b, header[:8]...)
+	}
+}
+
+// dictionaryVR returns the VR of the tag in the data dictionary, UN if it is unknown.
+func dictionaryVR(t tag.Tag) string {
+	info, err := tag.Find(t)
+	if err != nil || len(info.VR) != 2 {
+		return "UN"
+	}
+	return info.VR
+}
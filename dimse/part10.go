@@ -0,0 +1,140 @@
+package dimse
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+)
+
+// ImplementationClassUID and ImplementationVersionName identify this implementation in associations and files.
+const (
+	ImplementationClassUID    = "2.25.213736375457483696019754180469561055379"
+	ImplementationVersionName = "DICOM_STORE_API"
+)
+
+var errNotPart10 = errors.New("not a DICOM Part 10 file")
+
+// FileMeta is the file meta information of a Part 10 file.
+type FileMeta struct {
+	SOPClassUID    string
+	SOPInstanceUID string
+	TransferSyntax string
+}
+
+// NewPart10File returns a Part 10 file of a data set received in the transfer syntax, with the calling AE title
+// recorded as its source.
+func NewPart10File(meta *FileMeta, sourceAETitle string, dataSet []byte) []byte {
+	var elements []byte
+	elements = appendMetaElement(elements, 0x0001, "OB", []byte{0, 1})
+	elements = appendMetaElement(elements, 0x0002, "UI", padValue(meta.SOPClassUID, 0))
+	elements = appendMetaElement(elements, 0x0003, "UI", padValue(meta.SOPInstanceUID, 0))
+	elements = appendMetaElement(elements, 0x0010, "UI", padValue(meta.TransferSyntax, 0))
+	elements = appendMetaElement(elements, 0x0012, "UI", padValue(ImplementationClassUID, 0))
+	elements = appendMetaElement(elements, 0x0013, "SH", padValue(ImplementationVersionName, ' '))
+	if sourceAETitle != "" {
+		elements = appendMetaElement(elements, 0x0016, "AE", padValue(sourceAETitle, ' '))
+	}
+
+	groupLength := make([]byte, 4)
+	binary.LittleEndian.PutUint32(groupLength, uint32(len(elements)))
+
+	file := make([]byte, 128, 128+4+12+len(elements)+len(dataSet))
+	file = append(file, "DICM"...)
+	file = appendMetaElement(file, 0x0000, "UL", groupLength)
+	file = append(file, elements...)
+	return append(file, dataSet...)
+}
+
+// ParsePart10File returns the file meta information of a Part 10 file and the data set following it.
+func ParsePart10File(file []byte) (*FileMeta, []byte, error) {
+	if len(file) < 132 || string(file[128:132]) != "DICM" {
+		return nil, nil, errNotPart10
+	}
+	meta := &FileMeta{}
+	position := 132
+	for position+8 <= len(file) && binary.LittleEndian.Uint16(file[position:]) == 0x0002 {
+		element := binary.LittleEndian.Uint16(file[position+2:])
+		vr := string(file[position+4 : position+6])
+		headerLength, valueLength := 8, int(binary.LittleEndian.Uint16(file[position+6:]))
+		if isLongVR(vr) {
+			if position+12 > len(file) {
+				return nil, nil, errNotPart10
+			}
+			headerLength, valueLength = 12, int(binary.LittleEndian.Uint32(file[position+8:]))
+		}
+		end := position + headerLength + valueLength
+		if valueLength < 0 || end > len(file) {
+			return nil, nil, errNotPart10
+		}
+		value := strings.TrimRight(string(file[position+headerLength:end]), "\x00 ")
+		switch element {
+		case 0x0002:
+			meta.SOPClassUID = value
+		case 0x0003:
+			meta.SOPInstanceUID = value
+		case 0x0010:
+			meta.TransferSyntax = value
+		}
+		position = end
+	}
+	if meta.TransferSyntax == "" {
+		return nil, nil, errNotPart10
+	}
+	return meta, file[position:], nil
+}
+
+// ReadFileMeta reads the file meta information at the start of a Part 10 file, without reading the data set.
+func ReadFileMeta(r io.Reader) (*FileMeta, error) {
+	file := make([]byte, 132, 1024)
+	if _, err := io.ReadFull(r, file); err != nil {
+		return nil, errNotPart10
+	}
+	header := make([]byte, 12)
+	for {
+		if _, err := io.ReadFull(r, header[:8]); err != nil || binary.LittleEndian.Uint16(header) != 0x0002 {
+			break
+		}
+		headerLength, valueLength := 8, int(binary.LittleEndian.Uint16(header[6:]))
+		if isLongVR(string(header[4:6])) {
+			if _, err := io.ReadFull(r, header[8:]); err != nil {
+				return nil, errNotPart10
+			}
+			headerLength, valueLength = 12, int(binary.LittleEndian.Uint32(header[8:]))
+		}
+		if valueLength > 1<<16 {
+			return nil, errNotPart10
+		}
+		value := make([]byte, valueLength)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return nil, errNotPart10
+		}
+		file = append(append(file, header[:headerLength]...), value...)
+	}
+	meta, _, err := ParsePart10File(file)
+	return meta, err
+}
+
+func appendMetaElement(b []byte, element uint16, vr string, value []byte) []byte {
+	header := make([]byte, 4, 12)
+	binary.LittleEndian.PutUint16(header, 0x0002)
+	binary.LittleEndian.PutUint16(header[2:], element)
+	header = append(header, vr...)
+	if isLongVR(vr) {
+		header = append(header, 0, 0, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(header[8:], uint32(len(value)))
+	} else {
+		header = append(header, 0, 0)
+		binary.LittleEndian.PutUint16(header[6:], uint16(len(value)))
+	}
+	return append(append(b, header...), value...)
+}
+
+// isLongVR reports whether elements of the VR have a 4 byte length in explicit VR encodings.
+func isLongVR(vr string) bool {
+	switch vr {
+	case "OB", "OD", "OF", "OL", "OV", "OW", "SQ", "SV", "UC", "UN", "UR", "UT", "UV":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,304 @@
+package dimse
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// PDU types of the upper layer protocol, PS3.8 section 9.3.
+const (
+	pduAssociateRQ = 0x01
+	pduAssociateAC = 0x02
+	pduAssociateRJ = 0x03
+	pduPDataTF     = 0x04
+	pduReleaseRQ   = 0x05
+	pduReleaseRP   = 0x06
+	pduAbort       = 0x07
+)
+
+// Item types of the variable fields of A-ASSOCIATE PDUs.
+const (
+	itemApplicationContext    = 0x10
+	itemPresentationContextRQ = 0x20
+	itemPresentationContextAC = 0x21
+	itemAbstractSyntax        = 0x30
+	itemTransferSyntax        = 0x40
+	itemUserInformation       = 0x50
+	itemMaxLength             = 0x51
+	itemImplementationClass   = 0x52
+	itemRoleSelection         = 0x54
+	itemImplementationVersion = 0x55
+)
+
+// Results of presentation context negotiation.
+const (
+	contextAccepted                  = 0
+	contextUserRejection             = 1
+	contextNoReason                  = 2
+	contextAbstractSyntaxUnsupported = 3
+	contextTransferSyntaxUnsupported = 4
+)
+
+// Reasons of A-ASSOCIATE-RJ PDUs sent by the service user.
+const (
+	rejectPermanent              = 1
+	rejectSourceServiceUser      = 1
+	rejectNoReason               = 1
+	rejectCallingAENotRecognized = 3
+	rejectCalledAENotRecognized  = 7
+)
+
+const applicationContextName = "1.2.840.10008.3.1.1.1"
+
+// maxPDUSize bounds the PDUs accepted from peers, whatever they announce.
+const maxPDUSize = 64 << 20
+
+var errMalformedPDU = errors.New("malformed PDU")
+
+type pdu struct {
+	Type byte
+	Data []byte
+}
+
+func readPDU(r io.Reader) (*pdu, error) {
+	header := make([]byte, 6)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[2:])
+	if length > maxPDUSize {
+		return nil, fmt.Errorf("PDU of %d bytes exceeds the limit of %d", length, maxPDUSize)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return &pdu{Type: header[0], Data: data}, nil
+}
+
+func writePDU(w io.Writer, pduType byte, data []byte) error {
+	header := make([]byte, 6, 6+len(data))
+	header[0] = pduType
+	binary.BigEndian.PutUint32(header[2:], uint32(len(data)))
+	_, err := w.Write(append(header, data...))
+	return err
+}
+
+// presentationContext is a proposed or accepted pairing of an abstract syntax with transfer syntaxes.
+// An accepted context has its Result set and a single transfer syntax.
+type presentationContext struct {
+	ID               byte
+	AbstractSyntax   string
+	TransferSyntaxes []string
+	Result           byte
+}
+
+// roleSelection proposes or accepts the SCU and SCP roles for a SOP class, as C-GET requires for storage.
+type roleSelection struct {
+	SOPClassUID string
+	SCU         bool
+	SCP         bool
+}
+
+// associate is the content of an A-ASSOCIATE-RQ or A-ASSOCIATE-AC PDU.
+type associate struct {
+	CalledAETitle             string
+	CallingAETitle            string
+	PresentationContexts      []*presentationContext
+	MaxPDULength              uint32
+	ImplementationClassUID    string
+	ImplementationVersionName string
+	RoleSelections            []*roleSelection
+}
+
+func (a *associate) encode(accept bool) []byte {
+	var b bytes.Buffer
+	b.Write([]byte{0, 1, 0, 0})
+	b.WriteString(aeTitleField(a.CalledAETitle))
+	b.WriteString(aeTitleField(a.CallingAETitle))
+	b.Write(make([]byte, 32))
+	writeItem(&b, itemApplicationContext, []byte(applicationContextName))
+
+	for _, context := range a.PresentationContexts {
+		var c bytes.Buffer
+		if accept {
+			c.Write([]byte{context.ID, 0, context.Result, 0})
+		} else {
+			c.Write([]byte{context.ID, 0, 0, 0})
+			writeItem(&c, itemAbstractSyntax, []byte(context.AbstractSyntax))
+		}
+		for _, transferSyntax := range context.TransferSyntaxes {
+			writeItem(&c, itemTransferSyntax, []byte(transferSyntax))
+		}
+		if accept {
+			writeItem(&b, itemPresentationContextAC, c.Bytes())
+		} else {
+			writeItem(&b, itemPresentationContextRQ, c.Bytes())
+		}
+	}
+
+	var u bytes.Buffer
+	maxLength := make([]byte, 4)
+	binary.BigEndian.PutUint32(maxLength, a.MaxPDULength)
+	writeItem(&u, itemMaxLength, maxLength)
+	writeItem(&u, itemImplementationClass, []byte(a.ImplementationClassUID))
+	for _, role := range a.RoleSelections {
+		var r bytes.Buffer
+		binary.Write(&r, binary.BigEndian, uint16(len(role.SOPClassUID)))
+		r.WriteString(role.SOPClassUID)
+		r.Write([]byte{boolByte(role.SCU), boolByte(role.SCP)})
+		writeItem(&u, itemRoleSelection, r.Bytes())
+	}
+	if a.ImplementationVersionName != "" {
+		writeItem(&u, itemImplementationVersion, []byte(a.ImplementationVersionName))
+	}
+	writeItem(&b, itemUserInformation, u.Bytes())
+	return b.Bytes()
+}
+
+func decodeAssociate(data []byte) (*associate, error) {
+	if len(data) < 68 {
+		return nil, errMalformedPDU
+	}
+	a := &associate{
+		CalledAETitle:  strings.TrimSpace(string(data[4:20])),
+		CallingAETitle: strings.TrimSpace(string(data[20:36])),
+	}
+	err := readItems(data[68:], func(itemType byte, value []byte) error {
+		switch itemType {
+		case itemPresentationContextRQ, itemPresentationContextAC:
+			if len(value) < 4 {
+				return errMalformedPDU
+			}
+			context := &presentationContext{ID: value[0], Result: value[2]}
+			a.PresentationContexts = append(a.PresentationContexts, context)
+			return readItems(value[4:], func(itemType byte, value []byte) error {
+				switch itemType {
+				case itemAbstractSyntax:
+					context.AbstractSyntax = trimUID(value)
+				case itemTransferSyntax:
+					context.TransferSyntaxes = append(context.TransferSyntaxes, trimUID(value))
+				}
+				return nil
+			})
+		case itemUserInformation:
+			return readItems(value, func(itemType byte, value []byte) error {
+				switch itemType {
+				case itemMaxLength:
+					if len(value) != 4 {
+						return errMalformedPDU
+					}
+					a.MaxPDULength = binary.BigEndian.Uint32(value)
+				case itemImplementationClass:
+					a.ImplementationClassUID = trimUID(value)
+				case itemImplementationVersion:
+					a.ImplementationVersionName = strings.TrimSpace(string(value))
+				case itemRoleSelection:
+					if len(value) < 2 {
+						return errMalformedPDU
+					}
+					length := int(binary.BigEndian.Uint16(value))
+					if len(value) != length+4 {
+						return errMalformedPDU
+					}
+					a.RoleSelections = append(a.RoleSelections, &roleSelection{
+						SOPClassUID: trimUID(value[2 : 2+length]),
+						SCU:         value[2+length] == 1,
+						SCP:         value[3+length] == 1,
+					})
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return a, err
+}
+
+// pdv is a fragment of a command set or data set in a P-DATA-TF PDU.
+type pdv struct {
+	ContextID byte
+	Command   bool
+	Last      bool
+	Data      []byte
+}
+
+func encodePDV(p *pdv) []byte {
+	b := make([]byte, 6, 6+len(p.Data))
+	binary.BigEndian.PutUint32(b, uint32(len(p.Data)+2))
+	b[4] = p.ContextID
+	if p.Command {
+		b[5] |= 0x01
+	}
+	if p.Last {
+		b[5] |= 0x02
+	}
+	return append(b, p.Data...)
+}
+
+func decodePDataTF(data []byte) ([]*pdv, error) {
+	var pdvs []*pdv
+	for len(data) > 0 {
+		if len(data) < 6 {
+			return nil, errMalformedPDU
+		}
+		length := binary.BigEndian.Uint32(data)
+		if length < 2 || uint64(length) > uint64(len(data)-4) {
+			return nil, errMalformedPDU
+		}
+		pdvs = append(pdvs, &pdv{
+			ContextID: data[4],
+			Command:   data[5]&0x01 != 0,
+			Last:      data[5]&0x02 != 0,
+			Data:      data[6 : 4+length],
+		})
+		data = data[4+length:]
+	}
+	return pdvs, nil
+}
+
+func writeItem(b *bytes.Buffer, itemType byte, value []byte) {
+	b.Write([]byte{itemType, 0})
+	binary.Write(b, binary.BigEndian, uint16(len(value)))
+	b.Write(value)
+}
+
+func readItems(data []byte, read func(itemType byte, value []byte) error) error {
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return errMalformedPDU
+		}
+		length := int(binary.BigEndian.Uint16(data[2:]))
+		if len(data) < 4+length {
+			return errMalformedPDU
+		}
+		if err := read(data[0], data[4:4+length]); err != nil {
+			return err
+		}
+		data = data[4+length:]
+	}
+	return nil
+}
+
+// aeTitleField pads or truncates an AE title to its 16 byte field.
+func aeTitleField(aeTitle string) string {
+	if len(aeTitle) > 16 {
+		return aeTitle[:16]
+	}
+	return aeTitle + strings.Repeat(" ", 16-len(aeTitle))
+}
+
+func trimUID(value []byte) string {
+	return strings.TrimRight(string(value), "\x00 ")
+}
+
+func boolByte(value bool) byte {
+	if value {
+		return 1
+	}
+	return 0
+}
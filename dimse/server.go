@@ -0,0 +1,237 @@
+package dimse
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"dicom-store-api/logging"
+)
+
+// associateTimeout bounds the wait for the A-ASSOCIATE-RQ of a new connection.
+const associateTimeout = 30 * time.Second
+
+// ErrServerClosed is returned by Serve after Close.
+var ErrServerClosed = errors.New("dimse: server closed")
+
+type acceptRule struct {
+	match            func(abstractSyntax string) bool
+	transferSyntaxes []string
+}
+
+// Server accepts associations addressed to its AE title and serves their requests.
+type Server struct {
+	AETitle string
+	// IdleTimeout aborts associations without any activity for that long, if not zero.
+	IdleTimeout time.Duration
+	// AcceptCallingAETitle, if set, decides whether associations from a calling AE title and address are accepted.
+	AcceptCallingAETitle func(callingAETitle string, remoteAddr net.Addr) bool
+
+	rules    []acceptRule
+	handlers map[uint16]HandlerFunc
+
+	mutex        sync.Mutex
+	listener     net.Listener
+	associations map[*Association]struct{}
+	closed       bool
+}
+
+// NewServer returns a server for the AE title that accepts the Verification SOP class and answers C-ECHO.
+func NewServer(aeTitle string) *Server {
+	s := &Server{
+		AETitle:      aeTitle,
+		handlers:     map[uint16]HandlerFunc{},
+		associations: map[*Association]struct{}{},
+	}
+	s.Accept(func(abstractSyntax string) bool { return abstractSyntax == VerificationSOPClass }, UncompressedTransferSyntaxes...)
+	s.Handle(CEchoRQ, func(ctx context.Context, request *Request) {
+		request.RespondStatus(StatusSuccess, "")
+	})
+	return s
+}
+
+// Accept accepts presentation contexts for the abstract syntaxes matched, in the first proposed transfer syntax
+// among those given.
+func (s *Server) Accept(match func(abstractSyntax string) bool, transferSyntaxes ...string) {
+	s.rules = append(s.rules, acceptRule{match: match, transferSyntaxes: transferSyntaxes})
+}
+
+// Handle sets the handler of the requests with the command field.
+func (s *Server) Handle(commandField uint16, handler HandlerFunc) {
+	s.handlers[commandField] = handler
+}
+
+// ListenAndServe listens on the TCP address and serves the associations requested.
+func (s *Server) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve serves the associations requested on the listener until Close.
+func (s *Server) Serve(listener net.Listener) error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		listener.Close()
+		return ErrServerClosed
+	}
+	s.listener = listener
+	s.mutex.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.mutex.Lock()
+			closed := s.closed
+			s.mutex.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops listening and aborts the associations in progress.
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
+	for association := range s.associations {
+		go association.Abort()
+	}
+	if s.listener != nil {
+		return s.listener.Close()
+	}
+	return nil
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	logger := logging.Logger.WithField("module", "dimse").WithField("remote_addr", conn.RemoteAddr().String())
+
+	conn.SetReadDeadline(time.Now().Add(associateTimeout))
+	p, err := readPDU(conn)
+	if err != nil {
+		logger.Warnf("reading association request: %v", err)
+		return
+	}
+	if p.Type != pduAssociateRQ {
+		writePDU(conn, pduAbort, make([]byte, 4))
+		return
+	}
+	rq, err := decodeAssociate(p.Data)
+	if err != nil {
+		logger.Warnf("decoding association request: %v", err)
+		writePDU(conn, pduAbort, make([]byte, 4))
+		return
+	}
+	logger = logger.WithField("calling_ae", rq.CallingAETitle)
+
+	if rq.CalledAETitle != s.AETitle {
+		logger.Warnf("rejecting association called %q", rq.CalledAETitle)
+		writePDU(conn, pduAssociateRJ, []byte{0, rejectPermanent, rejectSourceServiceUser, rejectCalledAENotRecognized})
+		return
+	}
+	if s.AcceptCallingAETitle != nil && !s.AcceptCallingAETitle(rq.CallingAETitle, conn.RemoteAddr()) {
+		logger.Warn("rejecting association from unknown calling AE title")
+		writePDU(conn, pduAssociateRJ, []byte{0, rejectPermanent, rejectSourceServiceUser, rejectCallingAENotRecognized})
+		return
+	}
+
+	a := newAssociation(conn, rq.CallingAETitle, rq.CalledAETitle)
+	a.peerMaxPDULength = rq.MaxPDULength
+	a.idleTimeout = s.IdleTimeout
+	a.handlers = s.handlers
+
+	ac := &associate{
+		CalledAETitle:             rq.CalledAETitle,
+		CallingAETitle:            rq.CallingAETitle,
+		MaxPDULength:              DefaultMaxPDULength,
+		ImplementationClassUID:    ImplementationClassUID,
+		ImplementationVersionName: ImplementationVersionName,
+	}
+	for _, proposed := range rq.PresentationContexts {
+		accepted := s.negotiate(proposed)
+		ac.PresentationContexts = append(ac.PresentationContexts, accepted)
+		if accepted.Result == contextAccepted {
+			accepted.AbstractSyntax = proposed.AbstractSyntax
+			a.contexts[accepted.ID] = accepted
+		}
+	}
+	for _, role := range rq.RoleSelections {
+		if a.HasContext(role.SOPClassUID) {
+			ac.RoleSelections = append(ac.RoleSelections, role)
+			a.roles = append(a.roles, role)
+		}
+	}
+
+	if err := writePDU(conn, pduAssociateAC, ac.encode(true)); err != nil {
+		logger.Warnf("accepting association: %v", err)
+		return
+	}
+	logger.Infof("association accepted with %d of %d presentation contexts", len(a.contexts), len(rq.PresentationContexts))
+
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		a.Abort()
+		return
+	}
+	s.associations[a] = struct{}{}
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.associations, a)
+		s.mutex.Unlock()
+	}()
+
+	a.setBusy(0)
+	go a.readLoop()
+	a.serve()
+
+	a.mutex.Lock()
+	releaseRequested := a.releaseRequested
+	a.mutex.Unlock()
+	if releaseRequested {
+		a.writeMutex.Lock()
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		writePDU(conn, pduReleaseRP, make([]byte, 4))
+		a.writeMutex.Unlock()
+		logger.Info("association released")
+	} else if err := a.Err(); err != nil && err != ErrAborted {
+		logger.Warnf("association ended: %v", err)
+	}
+}
+
+// negotiate returns the result of a proposed presentation context, with the transfer syntax accepted.
+func (s *Server) negotiate(proposed *presentationContext) *presentationContext {
+	result := &presentationContext{ID: proposed.ID, Result: contextAbstractSyntaxUnsupported}
+	for _, rule := range s.rules {
+		if !rule.match(proposed.AbstractSyntax) {
+			continue
+		}
+		result.Result = contextTransferSyntaxUnsupported
+		for _, transferSyntax := range proposed.TransferSyntaxes {
+			if contains(rule.transferSyntaxes, transferSyntax) {
+				result.Result = contextAccepted
+				result.TransferSyntaxes = []string{transferSyntax}
+				return result
+			}
+		}
+	}
+	// The transfer syntax sub-item is still required, and ignored, when a context is rejected.
+	result.TransferSyntaxes = []string{ImplicitVRLittleEndian}
+	return result
+}
@@ -0,0 +1,313 @@
+package dimse
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"dicom-store-api/logging"
+)
+
+const (
+	serverAETitle = "STORE_SCP"
+	clientAETitle = "MODALITY"
+)
+
+func TestMain(m *testing.M) {
+	logging.NewLogger()
+	os.Exit(m.Run())
+}
+
+// stored is a C-STORE request received by a test server.
+type stored struct {
+	association *Association
+	message     *Message
+}
+
+// newTestServer serves the server on a loopback listener and returns its address.
+func newTestServer(t *testing.T, s *Server) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(listener) }()
+	t.Cleanup(func() {
+		s.Close()
+		if err := <-served; err != ErrServerClosed {
+			t.Errorf("serve = %v, want ErrServerClosed", err)
+		}
+	})
+	return listener.Addr().String()
+}
+
+// newStoreServer returns a server accepting CT images in Explicit VR Little Endian, which passes the C-STORE
+// requests received to the channel and answers them with success.
+func newStoreServer(t *testing.T) (string, chan stored) {
+	received := make(chan stored, 1)
+	s := NewServer(serverAETitle)
+	s.Accept(func(abstractSyntax string) bool { return abstractSyntax == CTImageStorage }, ExplicitVRLittleEndian)
+	s.Handle(CStoreRQ, func(ctx context.Context, request *Request) {
+		received <- stored{request.Association, request.Message}
+		request.RespondStatus(StatusSuccess, "")
+	})
+	return newTestServer(t, s), received
+}
+
+func dial(t *testing.T, addr string, calledAETitle string, contexts ...*PresentationContext) (*Association, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return Dial(ctx, addr, clientAETitle, calledAETitle, contexts, &DialOptions{Timeout: 5 * time.Second})
+}
+
+func verification() *PresentationContext {
+	return &PresentationContext{AbstractSyntax: VerificationSOPClass, TransferSyntaxes: []string{ImplicitVRLittleEndian}}
+}
+
+// waitDone waits for the association to end and returns why.
+func waitDone(t *testing.T, a *Association) error {
+	t.Helper()
+	select {
+	case <-a.Done():
+		return a.Err()
+	case <-time.After(5 * time.Second):
+		t.Fatal("association not ended")
+		return nil
+	}
+}
+
+func TestEcho(t *testing.T) {
+	addr := newTestServer(t, NewServer(serverAETitle))
+
+	a, err := dial(t, addr, serverAETitle, verification())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.CalledAETitle != serverAETitle || !a.HasContext(VerificationSOPClass) {
+		t.Fatalf("association = %+v", a)
+	}
+	if err := a.Echo(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Release(); err != nil {
+		t.Fatalf("release = %v", err)
+	}
+}
+
+func TestAssociationRejected(t *testing.T) {
+	s := NewServer(serverAETitle)
+	s.AcceptCallingAETitle = func(callingAETitle string, remoteAddr net.Addr) bool {
+		return callingAETitle == clientAETitle
+	}
+	addr := newTestServer(t, s)
+
+	_, err := dial(t, addr, "OTHER_SCP", verification())
+	if err == nil || !strings.Contains(err.Error(), "reason 7") {
+		t.Errorf("unknown called AE title err = %v, want a rejection with reason 7", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = Dial(ctx, addr, "UNKNOWN", serverAETitle, []*PresentationContext{verification()}, nil)
+	if err == nil || !strings.Contains(err.Error(), "reason 3") {
+		t.Errorf("unknown calling AE title err = %v, want a rejection with reason 3", err)
+	}
+
+	_, err = dial(t, addr, serverAETitle, &PresentationContext{AbstractSyntax: CTImageStorage, TransferSyntaxes: []string{ExplicitVRLittleEndian}})
+	if err == nil || !strings.Contains(err.Error(), "no presentation context accepted") {
+		t.Errorf("unsupported abstract syntax err = %v", err)
+	}
+}
+
+func TestNegotiation(t *testing.T) {
+	addr, _ := newStoreServer(t)
+
+	a, err := dial(t, addr, serverAETitle,
+		verification(),
+		&PresentationContext{AbstractSyntax: CTImageStorage, TransferSyntaxes: []string{JPEGBaseline}},
+		&PresentationContext{AbstractSyntax: CTImageStorage, TransferSyntaxes: []string{ImplicitVRLittleEndian, ExplicitVRLittleEndian}},
+		&PresentationContext{AbstractSyntax: MRImageStorage, TransferSyntaxes: []string{ExplicitVRLittleEndian}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Release()
+	if got := a.TransferSyntaxes(CTImageStorage); len(got) != 1 || got[0] != ExplicitVRLittleEndian {
+		t.Errorf("CT transfer syntaxes = %v, want Explicit VR Little Endian only", got)
+	}
+	if a.HasContext(MRImageStorage) {
+		t.Error("MR context accepted")
+	}
+}
+
+func TestStore(t *testing.T) {
+	addr, received := newStoreServer(t)
+	a, err := dial(t, addr, serverAETitle, &PresentationContext{AbstractSyntax: CTImageStorage, TransferSyntaxes: []string{ExplicitVRLittleEndian}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the data set is larger than the maximum PDU length, so it is sent in several P-DATA-TF PDUs
+	dataSet := bytes.Repeat([]byte("0123456789abcdef"), 3*DefaultMaxPDULength/16+5)
+	meta := &FileMeta{SOPClassUID: CTImageStorage, SOPInstanceUID: "1.2.3.4.5", TransferSyntax: ExplicitVRLittleEndian}
+	response, err := a.Store(context.Background(), NewPart10File(meta, "", dataSet), "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Status != StatusSuccess || response.AffectedSOPInstanceUID != meta.SOPInstanceUID {
+		t.Errorf("response = %v", response)
+	}
+
+	request := <-received
+	if request.association.CallingAETitle != clientAETitle {
+		t.Errorf("calling AE title = %q", request.association.CallingAETitle)
+	}
+	command := request.message.Command
+	if command.AffectedSOPClassUID != CTImageStorage || command.AffectedSOPInstanceUID != meta.SOPInstanceUID {
+		t.Errorf("command = %v", command)
+	}
+	if request.message.TransferSyntax != ExplicitVRLittleEndian || !bytes.Equal(request.message.Data, dataSet) {
+		t.Errorf("received %d bytes in %s, want the %d bytes sent", len(request.message.Data), request.message.TransferSyntax, len(dataSet))
+	}
+
+	if err := a.Release(); err != nil {
+		t.Fatalf("release = %v", err)
+	}
+	if err := waitDone(t, request.association); err != nil {
+		t.Errorf("server association ended with %v after a release", err)
+	}
+}
+
+func TestStoreFragmented(t *testing.T) {
+	addr, received := newStoreServer(t)
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	rq := &associate{
+		CalledAETitle:  serverAETitle,
+		CallingAETitle: clientAETitle,
+		MaxPDULength:   DefaultMaxPDULength,
+		PresentationContexts: []*presentationContext{
+			{ID: 1, AbstractSyntax: CTImageStorage, TransferSyntaxes: []string{ExplicitVRLittleEndian}},
+		},
+	}
+	if err := writePDU(conn, pduAssociateRQ, rq.encode(false)); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := readPDU(conn); err != nil || p.Type != pduAssociateAC {
+		t.Fatalf("association response = %v, %v", p, err)
+	}
+
+	// the command is split over two PDUs, the second of which starts the data set, which ends in two PDVs of a PDU
+	command := (&Command{CommandField: CStoreRQ, MessageID: 7, AffectedSOPClassUID: CTImageStorage,
+		AffectedSOPInstanceUID: "1.2.3.4.6", HasDataSet: true}).encode()
+	dataSet := []byte("fragmented data set of a C-STORE request")
+	pdus := [][]*pdv{
+		{{ContextID: 1, Command: true, Data: command[:10]}},
+		{{ContextID: 1, Command: true, Last: true, Data: command[10:]}, {ContextID: 1, Data: dataSet[:5]}},
+		{{ContextID: 1, Data: dataSet[5:20]}},
+		{{ContextID: 1, Data: dataSet[20:30]}, {ContextID: 1, Last: true, Data: dataSet[30:]}},
+	}
+	for _, fragments := range pdus {
+		var data []byte
+		for _, fragment := range fragments {
+			data = append(data, encodePDV(fragment)...)
+		}
+		if err := writePDU(conn, pduPDataTF, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	request := <-received
+	if request.message.Command.MessageID != 7 || !bytes.Equal(request.message.Data, dataSet) {
+		t.Errorf("received %v with %q", request.message.Command, request.message.Data)
+	}
+	p, err := readPDU(conn)
+	if err != nil || p.Type != pduPDataTF {
+		t.Fatalf("response = %v, %v", p, err)
+	}
+	pdvs, err := decodePDataTF(p.Data)
+	if err != nil || len(pdvs) != 1 || !pdvs[0].Command || !pdvs[0].Last {
+		t.Fatalf("response PDVs = %v, %v", pdvs, err)
+	}
+	response, err := decodeCommand(pdvs[0].Data)
+	if err != nil || response.CommandField != CStoreRQ|responseBit || response.MessageIDBeingRespondedTo != 7 || response.Status != StatusSuccess {
+		t.Fatalf("response = %v, %v", response, err)
+	}
+
+	if err := writePDU(conn, pduReleaseRQ, make([]byte, 4)); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := readPDU(conn); err != nil || p.Type != pduReleaseRP {
+		t.Fatalf("release response = %v, %v", p, err)
+	}
+}
+
+func TestAbort(t *testing.T) {
+	started := make(chan *Association, 1)
+	cancelled := make(chan struct{})
+	s := NewServer(serverAETitle)
+	s.Accept(func(abstractSyntax string) bool { return abstractSyntax == CTImageStorage }, ExplicitVRLittleEndian)
+	s.Handle(CStoreRQ, func(ctx context.Context, request *Request) {
+		started <- request.Association
+		<-ctx.Done()
+		close(cancelled)
+	})
+	addr := newTestServer(t, s)
+
+	a, err := dial(t, addr, serverAETitle, &PresentationContext{AbstractSyntax: CTImageStorage, TransferSyntaxes: []string{ExplicitVRLittleEndian}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := &FileMeta{SOPClassUID: CTImageStorage, SOPInstanceUID: "1.2.3.4.7", TransferSyntax: ExplicitVRLittleEndian}
+	stored := make(chan error, 1)
+	go func() {
+		_, err := a.Store(context.Background(), NewPart10File(meta, "", []byte("data")), "", 0)
+		stored <- err
+	}()
+
+	server := <-started
+	a.Abort()
+	if err := <-stored; !errors.Is(err, ErrAborted) {
+		t.Errorf("store on an aborted association = %v, want ErrAborted", err)
+	}
+	if err := waitDone(t, server); !errors.Is(err, ErrAborted) {
+		t.Errorf("server association ended with %v, want ErrAborted", err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Error("handler not cancelled by the abort")
+	}
+}
+
+func TestServerClose(t *testing.T) {
+	s := NewServer(serverAETitle)
+	addr := newTestServer(t, s)
+
+	a, err := dial(t, addr, serverAETitle, verification())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Echo(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if err := waitDone(t, a); !errors.Is(err, ErrAborted) {
+		t.Errorf("association ended with %v after the server closed, want ErrAborted", err)
+	}
+	if _, err := dial(t, addr, serverAETitle, verification()); err == nil {
+		t.Error("association accepted after the server closed")
+	}
+}
@@ -0,0 +1,38 @@
+package dimse
+
+// Status codes of DIMSE responses, PS3.7 annex C and the service classes of PS3.4.
+const (
+	StatusSuccess                  uint16 = 0x0000
+	StatusPending                  uint16 = 0xFF00
+	StatusPendingWarning           uint16 = 0xFF01
+	StatusCancel                   uint16 = 0xFE00
+	StatusWarning                  uint16 = 0xB000
+	StatusProcessingFailure        uint16 = 0x0110
+	StatusNoSuchSOPInstance        uint16 = 0x0112
+	StatusInvalidArgumentValue     uint16 = 0x0115
+	StatusSOPClassNotSupported     uint16 = 0x0122
+	StatusNoSuchActionType         uint16 = 0x0123
+	StatusUnrecognizedOperation    uint16 = 0x0211
+	StatusOutOfResources           uint16 = 0xA700
+	StatusOutOfResourcesSubOps     uint16 = 0xA702
+	StatusMoveDestinationUnknown   uint16 = 0xA801
+	StatusDataSetDoesNotMatchClass uint16 = 0xA900
+	StatusCannotUnderstand         uint16 = 0xC000
+	StatusUnableToProcess          uint16 = 0xC001
+)
+
+// IsPending reports whether the status announces further responses.
+func IsPending(status uint16) bool {
+	return status == StatusPending || status == StatusPendingWarning
+}
+
+// IsFailure reports whether the status is neither success, warning, pending nor cancel.
+func IsFailure(status uint16) bool {
+	switch {
+	case status == StatusSuccess, IsPending(status), status == StatusCancel:
+		return false
+	case status&0xF000 == 0xB000, status == 0x0001, status == 0x0107, status == 0x0116:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,136 @@
+package dimse
+
+import "strings"
+
+// Transfer syntaxes.
+const (
+	ImplicitVRLittleEndian         = "1.2.840.10008.1.2"
+	ExplicitVRLittleEndian         = "1.2.840.10008.1.2.1"
+	DeflatedExplicitVRLittleEndian = "1.2.840.10008.1.2.1.99"
+	ExplicitVRBigEndian            = "1.2.840.10008.1.2.2"
+	JPEGBaseline                   = "1.2.840.10008.1.2.4.50"
+	JPEGExtended                   = "1.2.840.10008.1.2.4.51"
+	JPEGLossless                   = "1.2.840.10008.1.2.4.57"
+	JPEGLosslessSV1                = "1.2.840.10008.1.2.4.70"
+	JPEGLSLossless                 = "1.2.840.10008.1.2.4.80"
+	JPEGLSNearLossless             = "1.2.840.10008.1.2.4.81"
+	JPEG2000Lossless               = "1.2.840.10008.1.2.4.90"
+	JPEG2000                       = "1.2.840.10008.1.2.4.91"
+	MPEG2                          = "1.2.840.10008.1.2.4.100"
+	MPEG4                          = "1.2.840.10008.1.2.4.102"
+	RLELossless                    = "1.2.840.10008.1.2.5"
+)
+
+// StorageTransferSyntaxes are accepted for storage, uncompressed ones first. Data sets are stored as received.
+var StorageTransferSyntaxes = []string{
+	ExplicitVRLittleEndian,
+	ImplicitVRLittleEndian,
+	DeflatedExplicitVRLittleEndian,
+	JPEGBaseline,
+	JPEGExtended,
+	JPEGLossless,
+	JPEGLosslessSV1,
+	JPEGLSLossless,
+	JPEGLSNearLossless,
+	JPEG2000Lossless,
+	JPEG2000,
+	MPEG2,
+	MPEG4,
+	RLELossless,
+}
+
+// UncompressedTransferSyntaxes are used for C-FIND identifiers and other non-storage services.
+var UncompressedTransferSyntaxes = []string{ExplicitVRLittleEndian, ImplicitVRLittleEndian}
+
+// SOP classes that are not storage SOP classes.
+const (
+	VerificationSOPClass = "1.2.840.10008.1.1"
+	storageSOPClassRoot  = "1.2.840.10008.5.1.4.1.1."
+
+	PatientRootQueryRetrieveFind = "1.2.840.10008.5.1.4.1.2.1.1"
+	PatientRootQueryRetrieveMove = "1.2.840.10008.5.1.4.1.2.1.2"
+	PatientRootQueryRetrieveGet  = "1.2.840.10008.5.1.4.1.2.1.3"
+	StudyRootQueryRetrieveFind   = "1.2.840.10008.5.1.4.1.2.2.1"
+	StudyRootQueryRetrieveMove   = "1.2.840.10008.5.1.4.1.2.2.2"
+	StudyRootQueryRetrieveGet    = "1.2.840.10008.5.1.4.1.2.2.3"
+
+	ModalityWorklistFind = "1.2.840.10008.5.1.4.31"
+
+	StorageCommitmentPushModel = "1.2.840.10008.1.20.1"
+	// StorageCommitmentPushModelInstance is the well-known SOP instance of storage commitment requests.
+	StorageCommitmentPushModelInstance = "1.2.840.10008.1.20.1.1"
+)
+
+// Common storage SOP classes, proposed when this side takes the storage SCP role of a C-GET.
+const (
+	ComputedRadiographyImageStorage        = "1.2.840.10008.5.1.4.1.1.1"
+	DigitalXRayImageStorage                = "1.2.840.10008.5.1.4.1.1.1.1"
+	DigitalMammographyImageStorage         = "1.2.840.10008.5.1.4.1.1.1.2"
+	CTImageStorage                         = "1.2.840.10008.5.1.4.1.1.2"
+	EnhancedCTImageStorage                 = "1.2.840.10008.5.1.4.1.1.2.1"
+	UltrasoundMultiFrameImageStorage       = "1.2.840.10008.5.1.4.1.1.3.1"
+	MRImageStorage                         = "1.2.840.10008.5.1.4.1.1.4"
+	EnhancedMRImageStorage                 = "1.2.840.10008.5.1.4.1.1.4.1"
+	UltrasoundImageStorage                 = "1.2.840.10008.5.1.4.1.1.6.1"
+	SecondaryCaptureImageStorage           = "1.2.840.10008.5.1.4.1.1.7"
+	XRayAngiographicImageStorage           = "1.2.840.10008.5.1.4.1.1.12.1"
+	XRayRadiofluoroscopicImageStorage      = "1.2.840.10008.5.1.4.1.1.12.2"
+	BreastTomosynthesisImageStorage        = "1.2.840.10008.5.1.4.1.1.13.1.3"
+	GrayscaleSoftcopyPresentationState     = "1.2.840.10008.5.1.4.1.1.11.1"
+	NuclearMedicineImageStorage            = "1.2.840.10008.5.1.4.1.1.20"
+	VLPhotographicImageStorage             = "1.2.840.10008.5.1.4.1.1.77.1.4"
+	BasicTextSRStorage                     = "1.2.840.10008.5.1.4.1.1.88.11"
+	EnhancedSRStorage                      = "1.2.840.10008.5.1.4.1.1.88.22"
+	ComprehensiveSRStorage                 = "1.2.840.10008.5.1.4.1.1.88.33"
+	KeyObjectSelectionDocumentStorage      = "1.2.840.10008.5.1.4.1.1.88.59"
+	EncapsulatedPDFStorage                 = "1.2.840.10008.5.1.4.1.1.104.1"
+	PositronEmissionTomographyImageStorage = "1.2.840.10008.5.1.4.1.1.128"
+	RTImageStorage                         = "1.2.840.10008.5.1.4.1.1.481.1"
+	RTDoseStorage                          = "1.2.840.10008.5.1.4.1.1.481.2"
+	RTStructureSetStorage                  = "1.2.840.10008.5.1.4.1.1.481.3"
+	RTPlanStorage                          = "1.2.840.10008.5.1.4.1.1.481.5"
+)
+
+// CommonStorageSOPClasses lists the common storage SOP classes.
+var CommonStorageSOPClasses = []string{
+	ComputedRadiographyImageStorage,
+	DigitalXRayImageStorage,
+	DigitalMammographyImageStorage,
+	CTImageStorage,
+	EnhancedCTImageStorage,
+	UltrasoundMultiFrameImageStorage,
+	MRImageStorage,
+	EnhancedMRImageStorage,
+	UltrasoundImageStorage,
+	SecondaryCaptureImageStorage,
+	XRayAngiographicImageStorage,
+	XRayRadiofluoroscopicImageStorage,
+	BreastTomosynthesisImageStorage,
+	GrayscaleSoftcopyPresentationState,
+	NuclearMedicineImageStorage,
+	VLPhotographicImageStorage,
+	BasicTextSRStorage,
+	EnhancedSRStorage,
+	ComprehensiveSRStorage,
+	KeyObjectSelectionDocumentStorage,
+	EncapsulatedPDFStorage,
+	PositronEmissionTomographyImageStorage,
+	RTImageStorage,
+	RTDoseStorage,
+	RTStructureSetStorage,
+	RTPlanStorage,
+}
+
+// IsStorageSOPClass reports whether the SOP class is one of the standard storage SOP classes.
+func IsStorageSOPClass(sopClassUID string) bool {
+	return strings.HasPrefix(sopClassUID, storageSOPClassRoot)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,294 @@
+package fs
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressions of instance files at rest. Files are decompressed transparently when read.
+const (
+	// CompressionNone marks files that were considered for compression but are kept as received.
+	CompressionNone = "none"
+	// CompressionDeflate stores Explicit VR Little Endian files as Deflated Explicit VR Little Endian,
+	// which keeps them valid DICOM files.
+	CompressionDeflate = "deflate"
+	// CompressionZstd wraps the whole file in a zstd frame.
+	CompressionZstd = "zstd"
+)
+
+const (
+	ExplicitVRLittleEndian         = "1.2.840.10008.1.2.1"
+	DeflatedExplicitVRLittleEndian = "1.2.840.10008.1.2.1.99"
+)
+
+var compression = CompressionNone
+
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// maxDecodedSize bounds the size of a decompressed file, far above that of the files a request may store.
+const maxDecodedSize = 1 << 30
+
+var zstdEncoder, _ = zstd.NewWriter(nil)
+var zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecodedSize))
+
+var errNotPart10 = errors.New("not a DICOM Part 10 file")
+
+// ErrDecodedSize is returned for compressed data that inflates beyond the limit.
+var ErrDecodedSize = errors.New("decompressed file exceeds the size limit")
+
+// GetCompression returns the configured compression.
+func GetCompression() string {
+	return compression
+}
+
+// IsCompressed reports whether files recorded with the compression are stored compressed.
+func IsCompressed(compression string) bool {
+	return compression == CompressionDeflate || compression == CompressionZstd
+}
+
+// DetectCompression returns the compression of a stored file, empty if it is not compressed. It guesses from
+// the content, for files no instance records the compression of.
+func DetectCompression(data []byte) string {
+	if bytes.HasPrefix(data, zstdMagic) {
+		return CompressionZstd
+	}
+	if header, err := peekMetaHeader(bufio.NewReaderSize(bytes.NewReader(data), 64<<10)); err == nil &&
+		header.transferSyntax() == DeflatedExplicitVRLittleEndian {
+		return CompressionDeflate
+	}
+	return ""
+}
+
+// EncodeDicomFile compresses a Part 10 file with the configured compression for storing it. It returns the data
+// to store and the compression applied, which is empty if compression is off and CompressionNone if the file does
+// not qualify, e.g. for deflate because of its transfer syntax, or would not get smaller.
+func EncodeDicomFile(data []byte) ([]byte, string, error) {
+	var encoded []byte
+	switch compression {
+	case CompressionDeflate:
+		var err error
+		if encoded, err = deflateDicomFile(data); err != nil {
+			return data, CompressionNone, nil
+		}
+	case CompressionZstd:
+		encoded = zstdEncoder.EncodeAll(data, make([]byte, 0, len(data)/2))
+	default:
+		return data, "", nil
+	}
+
+	if len(encoded) >= len(data) {
+		return data, CompressionNone, nil
+	}
+	// only keep the compressed file if the original comes back byte for byte, so that checksums hold
+	decoded, err := DecodeDicomFile(encoded, compression)
+	if err != nil || !bytes.Equal(decoded, data) {
+		return data, CompressionNone, nil
+	}
+	return encoded, compression, nil
+}
+
+// DecodeDicomFile returns a stored file decompressed with the compression recorded for it. Files stored as
+// received, whatever their transfer syntax, are returned as they are.
+func DecodeDicomFile(data []byte, compression string) ([]byte, error) {
+	switch compression {
+	case CompressionZstd:
+		decoded, err := zstdDecoder.DecodeAll(data, make([]byte, 0, len(data)*2))
+		if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+			return nil, ErrDecodedSize
+		}
+		return decoded, err
+	case CompressionDeflate:
+		return InflateDicomFile(data, maxDecodedSize)
+	}
+	return data, nil
+}
+
+// InflateDicomFile returns a Deflated Explicit VR Little Endian file inflated to Explicit VR Little Endian,
+// failing with ErrDecodedSize beyond limit bytes. Other files are returned as they are.
+func InflateDicomFile(data []byte, limit int) ([]byte, error) {
+	reader, err := inflateReader(bufio.NewReaderSize(bytes.NewReader(data), 64<<10), limit)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
+
+// decodeReadCloser wraps a stored file in a reader decompressing it with the recorded compression.
+func decodeReadCloser(file io.ReadCloser, compression string) (io.ReadCloser, error) {
+	switch compression {
+	case CompressionZstd:
+		decoder, err := zstd.NewReader(file, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(maxDecodedSize))
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &readCloser{Reader: decoder, close: func() error {
+			decoder.Close()
+			return file.Close()
+		}}, nil
+	case CompressionDeflate:
+		reader, err := inflateReader(bufio.NewReaderSize(file, 64<<10), maxDecodedSize)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &readCloser{Reader: reader, close: file.Close}, nil
+	}
+	return file, nil
+}
+
+// inflateReader inflates a Deflated Explicit VR Little Endian file behind its file meta information,
+// which is rewritten to Explicit VR Little Endian. Other files are passed through.
+func inflateReader(buffered *bufio.Reader, limit int) (io.Reader, error) {
+	header, err := peekMetaHeader(buffered)
+	if err != nil || header.transferSyntax() != DeflatedExplicitVRLittleEndian {
+		return buffered, nil
+	}
+	if _, err := buffered.Discard(len(header.data)); err != nil {
+		return nil, err
+	}
+	meta := header.withTransferSyntax(ExplicitVRLittleEndian)
+	inflated := &limitedReader{Reader: flate.NewReader(buffered), remaining: int64(limit - len(meta))}
+	return io.MultiReader(bytes.NewReader(meta), inflated), nil
+}
+
+func deflateDicomFile(data []byte) ([]byte, error) {
+	header, err := peekMetaHeader(bufio.NewReaderSize(bytes.NewReader(data), 64<<10))
+	if err != nil {
+		return nil, err
+	}
+	if header.transferSyntax() != ExplicitVRLittleEndian {
+		return nil, errors.New("only Explicit VR Little Endian files can be deflated")
+	}
+
+	buffer := bytes.NewBuffer(header.withTransferSyntax(DeflatedExplicitVRLittleEndian))
+	writer, err := flate.NewWriter(buffer, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = writer.Write(data[len(header.data):]); err != nil {
+		return nil, err
+	}
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// metaHeader is the preamble, prefix and file meta information group of a Part 10 file.
+type metaHeader struct {
+	data []byte
+	// offsets of the values of FileMetaInformationGroupLength and TransferSyntaxUID, -1 if absent
+	groupLengthValue    int
+	transferSyntaxValue int
+	transferSyntaxEnd   int
+}
+
+// peekMetaHeader reads the file meta information without consuming it. It is always encoded in Explicit VR Little Endian.
+func peekMetaHeader(reader *bufio.Reader) (*metaHeader, error) {
+	prefix, err := reader.Peek(132)
+	if err != nil || string(prefix[128:]) != "DICM" {
+		return nil, errNotPart10
+	}
+
+	header := &metaHeader{groupLengthValue: -1, transferSyntaxValue: -1}
+	position := 132
+	for {
+		element, err := reader.Peek(position + 8)
+		if err != nil || binary.LittleEndian.Uint16(element[position:]) != 0x0002 {
+			break
+		}
+		elementNumber := binary.LittleEndian.Uint16(element[position+2:])
+		headerLength, valueLength := 8, int(binary.LittleEndian.Uint16(element[position+6:]))
+		switch string(element[position+4 : position+6]) {
+		case "OB", "OD", "OF", "OL", "OV", "OW", "SQ", "SV", "UC", "UN", "UR", "UT", "UV":
+			if element, err = reader.Peek(position + 12); err != nil {
+				return nil, errNotPart10
+			}
+			headerLength, valueLength = 12, int(binary.LittleEndian.Uint32(element[position+8:]))
+		}
+		end := position + headerLength + valueLength
+		if valueLength < 0 || end > reader.Size() {
+			return nil, errNotPart10
+		}
+
+		switch elementNumber {
+		case 0x0000:
+			header.groupLengthValue = position + headerLength
+		case 0x0010:
+			header.transferSyntaxValue, header.transferSyntaxEnd = position+headerLength, end
+		}
+		position = end
+	}
+
+	if header.data, err = reader.Peek(position); err != nil {
+		return nil, errNotPart10
+	}
+	header.data = append([]byte(nil), header.data...)
+	return header, nil
+}
+
+func (h *metaHeader) transferSyntax() string {
+	if h.transferSyntaxValue < 0 {
+		return ""
+	}
+	return strings.TrimRight(string(h.data[h.transferSyntaxValue:h.transferSyntaxEnd]), "\x00 ")
+}
+
+// withTransferSyntax returns the header with another TransferSyntaxUID and the group length adjusted.
+func (h *metaHeader) withTransferSyntax(transferSyntax string) []byte {
+	value := []byte(transferSyntax)
+	if len(value)%2 != 0 {
+		value = append(value, 0)
+	}
+
+	result := make([]byte, 0, len(h.data)+len(value))
+	result = append(result, h.data[:h.transferSyntaxValue-2]...)
+	result = append(result, byte(len(value)), byte(len(value)>>8))
+	result = append(result, value...)
+	result = append(result, h.data[h.transferSyntaxEnd:]...)
+
+	if h.groupLengthValue >= 0 {
+		groupLength := binary.LittleEndian.Uint32(h.data[h.groupLengthValue:])
+		groupLength = uint32(int(groupLength) + len(result) - len(h.data))
+		binary.LittleEndian.PutUint32(result[h.groupLengthValue:], groupLength)
+	}
+	return result
+}
+
+// limitedReader fails with ErrDecodedSize once more than remaining bytes are read.
+type limitedReader struct {
+	io.Reader
+	remaining int64
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if r.remaining < 0 {
+		return 0, ErrDecodedSize
+	}
+	if int64(len(p)) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+	n, err := r.Reader.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		return 0, ErrDecodedSize
+	}
+	return n, err
+}
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r *readCloser) Close() error {
+	return r.close()
+}
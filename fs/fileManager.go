@@ -1,14 +1,12 @@
 package fs
 
 import (
-	"bytes"
 	"crypto/sha1"
 	"dicom-store-api/models"
 	"encoding/hex"
+	"fmt"
 	"github.com/suyashkumar/dicom/pkg/tag"
-	"io"
-	"os"
-	"path/filepath"
+	"path"
 	"reflect"
 	"strings"
 )
@@ -17,34 +15,98 @@ const ROOT = "./"
 const UPLOADS_DIR = "uploads"
 const DICOM_PREFIX = "dicom"
 const DICOM_EXT = ".dcm"
+const SPOOL_PREFIX = "spool"
+const THUMBNAIL_EXT = ".jpg"
+const QUARANTINE_PREFIX = "quarantine"
 
-func Save(filepath string, data []byte) error {
+// GetDicomPath returns the storage key of the instance file, as recorded at ingest. Instances stored
+// before keys were recorded live in the uid layout.
+func GetDicomPath(study *models.Study, series *models.Series, instance *models.Instance) string {
+	if instance.StoragePath != "" {
+		return instance.StoragePath
+	}
+	return getUIDPath(study, series, instance)
+}
+
+func getUIDPath(study *models.Study, series *models.Series, instance *models.Instance) string {
+	studyId := getDicomObjectPathString(study)
+	seriesId := getDicomObjectPathString(series)
+	instanceId := getDicomObjectPathString(instance)
+
+	return path.Join(DICOM_PREFIX, studyId, seriesId, instanceId+DICOM_EXT)
+}
+
+// GetThumbnailPath returns the storage key of the rendered thumbnail stored next to the instance file.
+func GetThumbnailPath(study *models.Study, series *models.Series, instance *models.Instance) string {
+	return getThumbnailPath(GetDicomPath(study, series, instance))
+}
+
+func getThumbnailPath(dicomPath string) string {
+	return strings.TrimSuffix(dicomPath, DICOM_EXT) + THUMBNAIL_EXT
+}
+
+// GetSpoolPath returns the storage key where an uploaded file of an asynchronous job waits for processing.
+func GetSpoolPath(jobID int, index int) string {
+	return path.Join(SPOOL_PREFIX, fmt.Sprintf("%d", jobID), fmt.Sprintf("%d%s", index, DICOM_EXT))
+}
+
+// RemoveDicomFile removes the instance file and its thumbnail.
+func RemoveDicomFile(study *models.Study, series *models.Series, instance *models.Instance) error {
+	return RemoveDicomPath(GetDicomPath(study, series, instance))
+}
 
-	dirpath := filepath[:len(filepath)-len(filepath[strings.LastIndex(filepath, string(os.PathSeparator))+1:])]
-	if _, err := os.Stat(dirpath); os.IsNotExist(err) {
-		err := os.MkdirAll(dirpath, os.ModePerm)
-		if err != nil {
+// RemoveDicomPath removes the file at the storage key from both tiers and its thumbnail.
+func RemoveDicomPath(dicomPath string) error {
+	for _, key := range []string{dicomPath, getThumbnailPath(dicomPath)} {
+		if err := Remove(key); err != nil {
 			return err
 		}
 	}
+	return RemoveColdDicomPath(dicomPath)
+}
 
-	out, err := os.Create(filepath)
-	if err != nil {
-		return err
-	}
-	defer out.Close()
-
-	r := bytes.NewReader(data)
-	_, err = io.Copy(out, r)
-	return err
+// RemoveThumbnail removes the thumbnail rendered from the file at the storage key.
+func RemoveThumbnail(dicomPath string) error {
+	return Remove(getThumbnailPath(dicomPath))
 }
 
-func GetDicomPath(study *models.Study, series *models.Series, instance *models.Instance) string {
-	studyId := getDicomObjectPathString(study)
-	seriesId := getDicomObjectPathString(series)
-	instanceId := getDicomObjectPathString(instance)
+// ListDicomFiles returns the instance files in the storage, in either layout and either tier.
+// A file in both tiers, as left by an interrupted move, is listed once.
+func ListDicomFiles() ([]*ObjectInfo, error) {
+	var files []*ObjectInfo
+	listed := map[string]bool{}
+	for _, s := range []Storage{storage, coldStorage} {
+		if s == nil {
+			continue
+		}
+		for _, prefix := range []string{DICOM_PREFIX, CONTENT_PREFIX} {
+			objects, err := s.List(prefix + "/")
+			if err != nil {
+				return nil, err
+			}
+			for _, object := range objects {
+				if strings.HasSuffix(object.Key, DICOM_EXT) && !listed[object.Key] {
+					listed[object.Key] = true
+					files = append(files, object)
+				}
+			}
+		}
+	}
+	return files, nil
+}
 
-	return ROOT + filepath.Join(UPLOADS_DIR, DICOM_PREFIX, studyId, seriesId, instanceId+DICOM_EXT)
+// Quarantine moves the file at the storage key below QUARANTINE_PREFIX and removes its thumbnail.
+// It returns the new key.
+func Quarantine(dicomPath string) (string, error) {
+	data, err := ReadStoredFile(dicomPath)
+	if err != nil {
+		return "", err
+	}
+	quarantinePath := path.Join(QUARANTINE_PREFIX, dicomPath)
+	if err = Save(quarantinePath, data); err != nil {
+		return "", err
+	}
+	return quarantinePath, RemoveDicomPath(dicomPath)
 }
 
 func getDicomObjectPathString(object models.DicomObject) string {
@@ -0,0 +1,193 @@
+package fs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"dicom-store-api/logging"
+	"dicom-store-api/models"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"path"
+
+	"github.com/spf13/viper"
+)
+
+// Layouts of instance files in the storage.
+const (
+	// LayoutUID stores files at sha1(StudyInstanceUID)/sha1(SeriesInstanceUID)/sha1(SOPInstanceUID).dcm.
+	LayoutUID = "uid"
+	// LayoutContent stores files by their SHA-256, so an identical resend is written only once.
+	LayoutContent = "content"
+)
+
+// Actions taken when a file read from the storage does not match the recorded checksum.
+const (
+	VerifyOff  = "off"
+	VerifyWarn = "warn"
+	VerifyFail = "fail"
+)
+
+const CONTENT_PREFIX = "sha256"
+
+// ErrChecksumMismatch is returned for files failing verification when the verify action is fail.
+var ErrChecksumMismatch = errors.New("file does not match the recorded checksum")
+
+var layout = LayoutUID
+var verifyAction = VerifyWarn
+
+// Configure sets up the storages, the file layout, the verify action and the compression from the storage config key.
+func Configure() error {
+	viper.SetDefault("storage.layout", LayoutUID)
+	viper.SetDefault("storage.verify", VerifyWarn)
+	viper.SetDefault("storage.compression", CompressionNone)
+
+	s, err := NewStorageFromConfig()
+	if err != nil {
+		return err
+	}
+	cold, err := newColdStorageFromConfig()
+	if err != nil {
+		return err
+	}
+
+	switch viper.GetString("storage.layout") {
+	case LayoutUID, LayoutContent:
+	default:
+		return fmt.Errorf("unknown storage layout %q", viper.GetString("storage.layout"))
+	}
+	switch viper.GetString("storage.verify") {
+	case VerifyOff, VerifyWarn, VerifyFail:
+	default:
+		return fmt.Errorf("unknown storage verify action %q", viper.GetString("storage.verify"))
+	}
+
+	switch viper.GetString("storage.compression") {
+	case CompressionNone, CompressionDeflate, CompressionZstd:
+	default:
+		return fmt.Errorf("unknown storage compression %q", viper.GetString("storage.compression"))
+	}
+
+	SetStorage(s)
+	SetColdStorage(cold)
+	layout = viper.GetString("storage.layout")
+	verifyAction = viper.GetString("storage.verify")
+	compression = viper.GetString("storage.compression")
+	return nil
+}
+
+// Checksum returns the hex encoded SHA-256 of data.
+func Checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// NewDicomPath returns the storage key for a new file of the instance in the configured layout.
+// The content layout requires the checksum of the instance to be set.
+func NewDicomPath(study *models.Study, series *models.Series, instance *models.Instance) string {
+	if layout == LayoutContent && len(instance.FileSHA256) > 4 {
+		sum := instance.FileSHA256
+		return path.Join(CONTENT_PREFIX, sum[:2], sum[2:4], sum+DICOM_EXT)
+	}
+	return getUIDPath(study, series, instance)
+}
+
+// SaveDicomFile writes the file of the instance to its storage key. In the content layout an object
+// that already holds the same content is left alone.
+func SaveDicomFile(study *models.Study, series *models.Series, instance *models.Instance, data []byte) error {
+	key := GetDicomPath(study, series, instance)
+	if layout == LayoutContent {
+		if info, err := Stat(key); err == nil && info.Size == int64(len(data)) {
+			return nil
+		}
+	}
+	return Save(key, data)
+}
+
+// OpenDicomFile opens the file of the instance, decompressed, and verifies it against the recorded size and checksum.
+// With the warn action the file is streamed and a mismatch is logged once it has been read completely,
+// with the fail action it is read into memory first and ErrChecksumMismatch is returned.
+func OpenDicomFile(study *models.Study, series *models.Series, instance *models.Instance) (io.ReadCloser, error) {
+	key := GetDicomPath(study, series, instance)
+	file, err := openStored(key)
+	if err != nil {
+		return nil, err
+	}
+	if file, err = decodeReadCloser(file, instance.FileCompression); err != nil || instance.FileSHA256 == "" || verifyAction == VerifyOff {
+		return file, err
+	}
+
+	if verifyAction == VerifyFail {
+		defer file.Close()
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return nil, err
+		}
+		if err = verify(instance, int64(len(data)), Checksum(data)); err != nil {
+			logChecksumMismatch(key, instance, err)
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	return &verifyingReader{ReadCloser: file, key: key, instance: instance, hash: sha256.New()}, nil
+}
+
+// ReadDicomFile reads the whole file of the instance, verified as by OpenDicomFile.
+func ReadDicomFile(study *models.Study, series *models.Series, instance *models.Instance) ([]byte, error) {
+	file, err := OpenDicomFile(study, series, instance)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// ReadDicomPath reads the file at the storage key from either tier, decompressed with the compression
+// recorded for it but not verified.
+func ReadDicomPath(key string, compression string) ([]byte, error) {
+	data, err := ReadStoredFile(key)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeDicomFile(data, compression)
+}
+
+func verify(instance *models.Instance, size int64, checksum string) error {
+	if size != instance.FileSize || checksum != instance.FileSHA256 {
+		return fmt.Errorf("%w: got %d bytes with sha256 %s, recorded %d bytes with sha256 %s",
+			ErrChecksumMismatch, size, checksum, instance.FileSize, instance.FileSHA256)
+	}
+	return nil
+}
+
+func logChecksumMismatch(key string, instance *models.Instance, err error) {
+	logging.Logger.WithField("module", "storage").
+		WithField("key", key).
+		WithField("instance", instance.SOPInstanceUID).
+		Error(err)
+}
+
+type verifyingReader struct {
+	io.ReadCloser
+	key      string
+	instance *models.Instance
+	hash     hash.Hash
+	size     int64
+	done     bool
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+	r.size += int64(n)
+	if err == io.EOF && !r.done {
+		r.done = true
+		if verifyErr := verify(r.instance, r.size, hex.EncodeToString(r.hash.Sum(nil))); verifyErr != nil {
+			logChecksumMismatch(r.key, r.instance, verifyErr)
+		}
+	}
+	return n, err
+}
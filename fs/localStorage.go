@@ -0,0 +1,162 @@
+package fs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStorage stores objects as files below a root directory.
+type LocalStorage struct {
+	root string
+}
+
+// NewLocalStorage returns a LocalStorage rooted at root.
+func NewLocalStorage(root string) *LocalStorage {
+	return &LocalStorage{root: filepath.Clean(root)}
+}
+
+func (s *LocalStorage) Put(key string, data io.Reader, size int64) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	// write next to the target and rename, so readers never see a partially written file
+	out, err := os.CreateTemp(filepath.Dir(path), ".tmp-"+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, data); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return err
+	}
+	if err = out.Close(); err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	if err = os.Rename(out.Name(), path); err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	return nil
+}
+
+func (s *LocalStorage) Get(key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (s *LocalStorage) Stat(key string) (*ObjectInfo, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s *LocalStorage) GetRange(key string, offset int64, length int64) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return file, nil
+	}
+	return &limitedReadCloser{Reader: io.LimitReader(file, length), Closer: file}, nil
+}
+
+// Delete removes the file and prunes the directories left empty up to the root.
+func (s *LocalStorage) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for dirpath := filepath.Dir(path); dirpath != s.root && dirpath != "."; dirpath = filepath.Dir(dirpath) {
+		if entries, err := os.ReadDir(dirpath); err != nil || len(entries) > 0 {
+			break
+		}
+		if err := os.Remove(dirpath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *LocalStorage) List(prefix string) ([]*ObjectInfo, error) {
+	// walk the deepest directory covering the prefix instead of the whole root
+	dir := s.root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		var err error
+		if dir, err = s.path(prefix[:i]); err != nil {
+			return nil, err
+		}
+	}
+
+	var objects []*ObjectInfo
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".tmp-") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, &ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	return objects, err
+}
+
+// path maps a key to a file below the root, rejecting keys that would escape it.
+func (s *LocalStorage) path(key string) (string, error) {
+	path := filepath.Join(s.root, filepath.FromSlash(key))
+	rel, err := filepath.Rel(s.root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return "", &os.PathError{Op: "open", Path: key, Err: os.ErrInvalid}
+	}
+	return path, nil
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,100 @@
+package fs
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStorage keeps objects in memory. It is meant for tests and throwaway instances.
+type MemoryStorage struct {
+	mutex   sync.RWMutex
+	objects map[string]*memoryObject
+}
+
+type memoryObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemoryStorage returns an empty MemoryStorage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{objects: map[string]*memoryObject{}}
+}
+
+func (s *MemoryStorage) Put(key string, data io.Reader, size int64) error {
+	buffer := bytes.NewBuffer(make([]byte, 0, size))
+	if _, err := io.Copy(buffer, data); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.objects[key] = &memoryObject{data: buffer.Bytes(), modTime: time.Now()}
+	return nil
+}
+
+func (s *MemoryStorage) Get(key string) (io.ReadCloser, error) {
+	return s.GetRange(key, 0, -1)
+}
+
+func (s *MemoryStorage) Stat(key string) (*ObjectInfo, error) {
+	object, err := s.get("stat", key)
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectInfo{Key: key, Size: int64(len(object.data)), ModTime: object.modTime}, nil
+}
+
+func (s *MemoryStorage) GetRange(key string, offset int64, length int64) (io.ReadCloser, error) {
+	object, err := s.get("open", key)
+	if err != nil {
+		return nil, err
+	}
+	data := object.data
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	data = data[offset:]
+	if length >= 0 && length < int64(len(data)) {
+		data = data[:length]
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *MemoryStorage) Delete(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.objects, key)
+	return nil
+}
+
+func (s *MemoryStorage) List(prefix string) ([]*ObjectInfo, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var objects []*ObjectInfo
+	for key, object := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, &ObjectInfo{Key: key, Size: int64(len(object.data)), ModTime: object.modTime})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+	return objects, nil
+}
+
+func (s *MemoryStorage) get(op string, key string) (*memoryObject, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	object, ok := s.objects[key]
+	if !ok {
+		return nil, &os.PathError{Op: op, Path: key, Err: os.ErrNotExist}
+	}
+	return object, nil
+}
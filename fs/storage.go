@@ -0,0 +1,114 @@
+package fs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	StorageTypeLocal  = "local"
+	StorageTypeS3     = "s3"
+	StorageTypeMemory = "memory"
+)
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage stores objects under slash separated keys. Implementations report a missing object
+// with an error for which os.IsNotExist returns true. Deleting a missing object is not an error.
+type Storage interface {
+	Put(key string, data io.Reader, size int64) error
+	Get(key string) (io.ReadCloser, error)
+	Stat(key string) (*ObjectInfo, error)
+	// GetRange reads length bytes starting at offset, or up to the end of the object if length is negative.
+	GetRange(key string, offset int64, length int64) (io.ReadCloser, error)
+	Delete(key string) error
+	// List returns the objects whose key starts with prefix.
+	List(prefix string) ([]*ObjectInfo, error)
+}
+
+var storage Storage = NewLocalStorage(ROOT + UPLOADS_DIR)
+
+// GetStorage returns the storage all files are read from and written to.
+func GetStorage() Storage {
+	return storage
+}
+
+// SetStorage replaces the storage all files are read from and written to.
+func SetStorage(s Storage) {
+	storage = s
+}
+
+// NewStorageFromConfig returns the storage selected by the storage config key.
+func NewStorageFromConfig() (Storage, error) {
+	viper.SetDefault("storage.type", StorageTypeLocal)
+	viper.SetDefault("storage.root", ROOT+UPLOADS_DIR)
+	return newStorageFromConfig("storage")
+}
+
+// newStorageFromConfig returns the storage described by the type, root and s3 keys below the config key.
+func newStorageFromConfig(key string) (Storage, error) {
+	viper.SetDefault(key+".s3.region", "us-east-1")
+
+	switch storageType := viper.GetString(key + ".type"); storageType {
+	case StorageTypeLocal:
+		return NewLocalStorage(viper.GetString(key + ".root")), nil
+	case StorageTypeS3:
+		return NewS3Storage(S3Config{
+			Endpoint:  viper.GetString(key + ".s3.endpoint"),
+			Region:    viper.GetString(key + ".s3.region"),
+			Bucket:    viper.GetString(key + ".s3.bucket"),
+			Prefix:    viper.GetString(key + ".s3.prefix"),
+			AccessKey: viper.GetString(key + ".s3.access_key"),
+			SecretKey: viper.GetString(key + ".s3.secret_key"),
+			PathStyle: viper.GetBool(key + ".s3.path_style"),
+		})
+	case StorageTypeMemory:
+		return NewMemoryStorage(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage type %q", storageType)
+	}
+}
+
+// Save writes data to the object at key.
+func Save(key string, data []byte) error {
+	return storage.Put(key, bytes.NewReader(data), int64(len(data)))
+}
+
+// Open opens the object at key for reading.
+func Open(key string) (io.ReadCloser, error) {
+	return storage.Get(key)
+}
+
+// OpenRange opens length bytes of the object at key starting at offset.
+func OpenRange(key string, offset int64, length int64) (io.ReadCloser, error) {
+	return storage.GetRange(key, offset, length)
+}
+
+// Stat returns the size and modification time of the object at key.
+func Stat(key string) (*ObjectInfo, error) {
+	return storage.Stat(key)
+}
+
+// ReadFile reads the whole object at key.
+func ReadFile(key string) ([]byte, error) {
+	reader, err := storage.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// Remove deletes the object at key.
+func Remove(key string) error {
+	return storage.Delete(key)
+}
@@ -0,0 +1,279 @@
+package fs
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// s3StandIn serves the subset of the S3 API S3Storage uses, path-style, for a single bucket. Listings are
+// paginated by pageSize keys to exercise continuation tokens.
+type s3StandIn struct {
+	bucket   string
+	pageSize int
+
+	mutex   sync.Mutex
+	objects map[string][]byte
+	modTime map[string]time.Time
+}
+
+func newS3StandIn(t *testing.T, bucket string) *httptest.Server {
+	standIn := &s3StandIn{bucket: bucket, pageSize: 2, objects: map[string][]byte{}, modTime: map[string]time.Time{}}
+	server := httptest.NewServer(standIn)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (s *s3StandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), s3Algorithm+" Credential=access/") ||
+		r.Header.Get("X-Amz-Date") == "" || r.Header.Get("X-Amz-Content-Sha256") == "" {
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, "/"+s.bucket+"/") {
+		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/"+s.bucket+"/")
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	switch {
+	case key == "" && r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		s.list(w, r)
+	case r.Method == http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil || int64(len(data)) != r.ContentLength {
+			http.Error(w, "IncompleteBody", http.StatusBadRequest)
+			return
+		}
+		s.objects[key], s.modTime[key] = data, time.Now()
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, ok := s.objects[key]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		http.ServeContent(w, r, key, s.modTime[key], bytes.NewReader(data))
+	case r.Method == http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "MethodNotAllowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *s3StandIn) list(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	var keys []string
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	start, _ := strconv.Atoi(r.URL.Query().Get("continuation-token"))
+	if start > len(keys) {
+		start = len(keys)
+	}
+	end := start + s.pageSize
+	if end > len(keys) {
+		end = len(keys)
+	}
+
+	type content struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Contents              []content
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+	}{}
+	for _, key := range keys[start:end] {
+		result.Contents = append(result.Contents, content{Key: key, Size: int64(len(s.objects[key])), LastModified: s.modTime[key]})
+	}
+	if end < len(keys) {
+		result.IsTruncated, result.NextContinuationToken = true, strconv.Itoa(end)
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+// storageBackends returns an empty storage of every kind.
+func storageBackends(t *testing.T) map[string]Storage {
+	server := newS3StandIn(t, "dicom")
+	s3, err := NewS3Storage(S3Config{
+		Endpoint:  server.URL,
+		Region:    "us-east-1",
+		Bucket:    "dicom",
+		Prefix:    "archive",
+		AccessKey: "access",
+		SecretKey: "secret",
+		PathStyle: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]Storage{
+		StorageTypeMemory: NewMemoryStorage(),
+		StorageTypeLocal:  NewLocalStorage(t.TempDir()),
+		StorageTypeS3:     s3,
+	}
+}
+
+func put(t *testing.T, storage Storage, key string, data string) {
+	t.Helper()
+	if err := storage.Put(key, strings.NewReader(data), int64(len(data))); err != nil {
+		t.Fatalf("put %s: %v", key, err)
+	}
+}
+
+func readAll(t *testing.T, reader io.ReadCloser, err error) string {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func keys(objects []*ObjectInfo) []string {
+	var result []string
+	for _, object := range objects {
+		result = append(result, object.Key)
+	}
+	return result
+}
+
+func TestStorageConformance(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, storage Storage)
+	}{
+		{"put and get", func(t *testing.T, storage Storage) {
+			put(t, storage, "studies/1/a.dcm", "first")
+			put(t, storage, "studies/1/a.dcm", "replaced")
+			reader, err := storage.Get("studies/1/a.dcm")
+			if got := readAll(t, reader, err); got != "replaced" {
+				t.Errorf("get = %q, want the last content put", got)
+			}
+		}},
+		{"get missing", func(t *testing.T, storage Storage) {
+			if _, err := storage.Get("studies/missing.dcm"); !os.IsNotExist(err) {
+				t.Errorf("get err = %v, want a not exist error", err)
+			}
+		}},
+		{"stat", func(t *testing.T, storage Storage) {
+			before := time.Now().Add(-time.Minute)
+			put(t, storage, "studies/1/a.dcm", "0123456789")
+			info, err := storage.Stat("studies/1/a.dcm")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Key != "studies/1/a.dcm" || info.Size != 10 || info.ModTime.Before(before) {
+				t.Errorf("stat = %+v", info)
+			}
+			if _, err := storage.Stat("studies/1/missing.dcm"); !os.IsNotExist(err) {
+				t.Errorf("stat err = %v, want a not exist error", err)
+			}
+		}},
+		{"get range", func(t *testing.T, storage Storage) {
+			put(t, storage, "a.dcm", "0123456789")
+			ranges := []struct {
+				offset, length int64
+				want           string
+			}{
+				{0, 4, "0123"},
+				{3, 4, "3456"},
+				{6, -1, "6789"},
+				{0, -1, "0123456789"},
+				{8, 10, "89"},
+				{4, 0, ""},
+			}
+			for _, r := range ranges {
+				reader, err := storage.GetRange("a.dcm", r.offset, r.length)
+				if got := readAll(t, reader, err); got != r.want {
+					t.Errorf("range %d+%d = %q, want %q", r.offset, r.length, got, r.want)
+				}
+			}
+			if _, err := storage.GetRange("missing.dcm", 2, 2); !os.IsNotExist(err) {
+				t.Errorf("range err = %v, want a not exist error", err)
+			}
+		}},
+		{"delete", func(t *testing.T, storage Storage) {
+			put(t, storage, "studies/1/a.dcm", "a")
+			put(t, storage, "studies/1/b.dcm", "b")
+			if err := storage.Delete("studies/1/a.dcm"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := storage.Stat("studies/1/a.dcm"); !os.IsNotExist(err) {
+				t.Errorf("stat after delete err = %v, want a not exist error", err)
+			}
+			if _, err := storage.Stat("studies/1/b.dcm"); err != nil {
+				t.Errorf("sibling deleted: %v", err)
+			}
+			if err := storage.Delete("studies/1/a.dcm"); err != nil {
+				t.Errorf("deleting a missing object: %v", err)
+			}
+		}},
+		{"list", func(t *testing.T, storage Storage) {
+			for _, key := range []string{"studies/2/b.dcm", "studies/1/a.dcm", "studies/10/c.dcm", "trash/d.dcm", "studiesx.dcm"} {
+				put(t, storage, key, key)
+			}
+			objects, err := storage.List("studies/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := keys(objects)
+			sort.Strings(got)
+			want := []string{"studies/1/a.dcm", "studies/10/c.dcm", "studies/2/b.dcm"}
+			if strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("list = %v, want %v", got, want)
+			}
+			for _, object := range objects {
+				if object.Size != int64(len(object.Key)) {
+					t.Errorf("size of %s = %d", object.Key, object.Size)
+				}
+			}
+
+			objects, err = storage.List("studies/1")
+			if got := keys(objects); err != nil || len(got) != 2 {
+				t.Errorf("list of a partial name = %v, %v, want studies/1 and studies/10", got, err)
+			}
+			objects, err = storage.List("")
+			if got := keys(objects); err != nil || len(got) != 5 {
+				t.Errorf("list all = %v, %v", got, err)
+			}
+			objects, err = storage.List("missing/")
+			if err != nil || len(objects) != 0 {
+				t.Errorf("list of a missing prefix = %v, %v", keys(objects), err)
+			}
+		}},
+	}
+
+	for _, test := range tests {
+		for name, storage := range storageBackends(t) {
+			storage := storage
+			t.Run(name+"/"+test.name, func(t *testing.T) {
+				test.run(t, storage)
+			})
+		}
+	}
+}
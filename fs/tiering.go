@@ -0,0 +1,125 @@
+package fs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/viper"
+)
+
+// coldStorage holds the instance files moved to the cold tier under the same keys, nil without a cold tier.
+var coldStorage Storage
+
+// GetColdStorage returns the storage of the cold tier, or nil if none is configured.
+func GetColdStorage() Storage {
+	return coldStorage
+}
+
+// SetColdStorage replaces the storage of the cold tier.
+func SetColdStorage(s Storage) {
+	coldStorage = s
+}
+
+// newColdStorageFromConfig returns the storage selected by the storage.cold config key, or nil if it is not set.
+func newColdStorageFromConfig() (Storage, error) {
+	if viper.GetString("storage.cold.type") == "" {
+		return nil, nil
+	}
+	return newStorageFromConfig("storage.cold")
+}
+
+// ArchiveDicomPath copies the file at the storage key to the cold tier. The hot copy is kept until
+// RemoveHotDicomPath, so that it can be removed once the move is recorded.
+func ArchiveDicomPath(key string) error {
+	if coldStorage == nil {
+		return fmt.Errorf("no cold storage configured")
+	}
+	return copyObject(storage, coldStorage, key)
+}
+
+// RecallDicomPath copies the file at the storage key from the cold tier back to the hot one.
+// The cold copy is kept until RemoveColdDicomPath.
+func RecallDicomPath(key string) error {
+	if coldStorage == nil {
+		return fmt.Errorf("no cold storage configured")
+	}
+	return copyObject(coldStorage, storage, key)
+}
+
+// RemoveHotDicomPath removes the hot copy of an archived file. Its thumbnail stays in the hot tier.
+func RemoveHotDicomPath(key string) error {
+	return storage.Delete(key)
+}
+
+// RemoveColdDicomPath removes the cold copy of a recalled file.
+func RemoveColdDicomPath(key string) error {
+	if coldStorage == nil {
+		return nil
+	}
+	return coldStorage.Delete(key)
+}
+
+// copyObject copies the object at key and checks the size of the copy before the source may be removed.
+func copyObject(from Storage, to Storage, key string) error {
+	reader, err := from.Get(key)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		return err
+	}
+	if err = to.Put(key, bytes.NewReader(data), int64(len(data))); err != nil {
+		return err
+	}
+	info, err := to.Stat(key)
+	if err != nil {
+		return err
+	}
+	if info.Size != int64(len(data)) {
+		return fmt.Errorf("copy of %s has %d bytes instead of %d", key, info.Size, len(data))
+	}
+	return nil
+}
+
+// openStored opens the object at key in the hot tier, or in the cold tier if it is not in the hot one.
+func openStored(key string) (io.ReadCloser, error) {
+	reader, err := storage.Get(key)
+	if err != nil && os.IsNotExist(err) && coldStorage != nil {
+		return coldStorage.Get(key)
+	}
+	return reader, err
+}
+
+// ReadStoredFile reads the whole object at key from the hot tier, or from the cold tier if it is not in the hot one.
+func ReadStoredFile(key string) ([]byte, error) {
+	reader, err := openStored(key)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// StatStored returns the size and modification time of the object at key in the hot tier, or in the cold tier
+// if it is not in the hot one.
+func StatStored(key string) (*ObjectInfo, error) {
+	info, err := storage.Stat(key)
+	if err != nil && os.IsNotExist(err) && coldStorage != nil {
+		return coldStorage.Stat(key)
+	}
+	return info, err
+}
+
+// OpenStoredRange opens length bytes of the object at key starting at offset in the hot tier, or in the cold tier
+// if it is not in the hot one.
+func OpenStoredRange(key string, offset int64, length int64) (io.ReadCloser, error) {
+	reader, err := storage.GetRange(key, offset, length)
+	if err != nil && os.IsNotExist(err) && coldStorage != nil {
+		return coldStorage.GetRange(key, offset, length)
+	}
+	return reader, err
+}
@@ -25,7 +25,11 @@ require (
 	mellium.im/sasl v0.2.1 // indirect
 )
 
-require github.com/suyashkumar/dicom v1.0.5
+require (
+	github.com/klauspost/compress v1.15.9
+	github.com/suyashkumar/dicom v1.0.5
+	golang.org/x/net v0.0.0-20210405180319-a5a99cb37ef4
+)
 
 require (
 	github.com/fsnotify/fsnotify v1.4.9 // indirect
@@ -0,0 +1,300 @@
+// Package hl7 parses and builds HL7 v2 messages and exchanges them over MLLP.
+package hl7
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Acknowledgment codes of MSA-1.
+const (
+	AckAccept = "AA"
+	AckError  = "AE"
+	AckReject = "AR"
+)
+
+// timestampLayout is the layout of the HL7 timestamps the messages built here carry.
+const timestampLayout = "20060102150405"
+
+var errMalformedMessage = errors.New("hl7: malformed message")
+
+// Delimiters are the separators of a message, from its MSH segment.
+type Delimiters struct {
+	Field        byte
+	Component    byte
+	Repetition   byte
+	Escape       byte
+	Subcomponent byte
+}
+
+// DefaultDelimiters are the recommended delimiters, |^~\&.
+var DefaultDelimiters = Delimiters{Field: '|', Component: '^', Repetition: '~', Escape: '\\', Subcomponent: '&'}
+
+// Segment is a segment of a message, its fields kept escaped. Fields are numbered from 1 as in the standard,
+// so that MSH-1 is the field separator and MSH-2 the encoding characters.
+type Segment struct {
+	Name   string
+	Fields []string
+
+	delimiters *Delimiters
+}
+
+// Message is a parsed HL7 v2 message.
+type Message struct {
+	Segments   []*Segment
+	Delimiters Delimiters
+}
+
+// Parse parses a message whose segments are terminated by carriage returns, or line feeds. The text of an
+// 8859/1 message, as MSH-18 tells, is converted to UTF-8.
+func Parse(data []byte) (*Message, error) {
+	text := strings.TrimLeft(string(data), "\r\n")
+	if len(text) < 8 || !strings.HasPrefix(text, "MSH") {
+		return nil, fmt.Errorf("%w: it does not start with MSH", errMalformedMessage)
+	}
+	m := &Message{Delimiters: Delimiters{
+		Field:        text[3],
+		Component:    text[4],
+		Repetition:   text[5],
+		Escape:       text[6],
+		Subcomponent: text[7],
+	}}
+	if text[7] == m.Delimiters.Field {
+		// only the first four encoding characters are given
+		m.Delimiters.Subcomponent = DefaultDelimiters.Subcomponent
+	}
+
+	text = strings.NewReplacer("\r\n", "\r", "\n", "\r").Replace(text)
+	for _, line := range strings.Split(text, "\r") {
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, string(m.Delimiters.Field))
+		if len(fields[0]) != 3 {
+			return nil, fmt.Errorf("%w: invalid segment %q", errMalformedMessage, fields[0])
+		}
+		segment := &Segment{Name: fields[0], delimiters: &m.Delimiters}
+		if segment.Name == "MSH" {
+			segment.Fields = append([]string{string(m.Delimiters.Field)}, fields[1:]...)
+		} else {
+			segment.Fields = fields[1:]
+		}
+		m.Segments = append(m.Segments, segment)
+	}
+
+	if charset := m.Get("MSH", 18, 1); charset == "8859/1" && !utf8.ValidString(text) {
+		for _, segment := range m.Segments {
+			for i, field := range segment.Fields {
+				segment.Fields[i] = latin1ToUTF8(field)
+			}
+		}
+	}
+	return m, nil
+}
+
+// Segment returns the first segment with the name, or nil.
+func (m *Message) Segment(name string) *Segment {
+	for _, segment := range m.Segments {
+		if segment.Name == name {
+			return segment
+		}
+	}
+	return nil
+}
+
+// Get returns a component of a field of the first segment with the name, unescaped, or an empty string.
+func (m *Message) Get(segmentName string, field int, component int) string {
+	segment := m.Segment(segmentName)
+	if segment == nil {
+		return ""
+	}
+	return segment.Get(field, component)
+}
+
+// Type returns the message type and trigger event of MSH-9, such as ADT^A08.
+func (m *Message) Type() string {
+	return m.Get("MSH", 9, 1) + "^" + m.Get("MSH", 9, 2)
+}
+
+// ControlID returns the message control ID of MSH-10.
+func (m *Message) ControlID() string {
+	return m.Get("MSH", 10, 1)
+}
+
+// Field returns the first repetition of a field, still escaped, or an empty string.
+func (s *Segment) Field(field int) string {
+	if field < 1 || field > len(s.Fields) {
+		return ""
+	}
+	if s.Name == "MSH" && field <= 2 {
+		return s.Fields[field-1]
+	}
+	return strings.SplitN(s.Fields[field-1], string(s.delimiters.Repetition), 2)[0]
+}
+
+// Get returns the first subcomponent of a component of the first repetition of a field, unescaped, or an
+// empty string. The null value "" is returned as is, to tell a value to delete from an absent one.
+func (s *Segment) Get(field int, component int) string {
+	value := s.Field(field)
+	if s.Name == "MSH" && field <= 2 {
+		return value
+	}
+	components := strings.Split(value, string(s.delimiters.Component))
+	if component < 1 || component > len(components) {
+		return ""
+	}
+	subcomponent := strings.SplitN(components[component-1], string(s.delimiters.Subcomponent), 2)[0]
+	return Unescape(subcomponent, s.delimiters)
+}
+
+// Components returns the components of the first repetition of a field, unescaped.
+func (s *Segment) Components(field int) []string {
+	value := s.Field(field)
+	if value == "" {
+		return nil
+	}
+	components := strings.Split(value, string(s.delimiters.Component))
+	for i, component := range components {
+		components[i] = Unescape(strings.SplitN(component, string(s.delimiters.Subcomponent), 2)[0], s.delimiters)
+	}
+	return components
+}
+
+// Unescape replaces the escape sequences of a value with the characters they stand for. Highlighting is
+// dropped, line breaks become line feeds and unknown sequences are kept.
+func Unescape(value string, delimiters *Delimiters) string {
+	escape := string(delimiters.Escape)
+	if !strings.Contains(value, escape) {
+		return value
+	}
+	var b strings.Builder
+	for {
+		start := strings.Index(value, escape)
+		if start < 0 {
+			b.WriteString(value)
+			return b.String()
+		}
+		end := strings.Index(value[start+1:], escape)
+		if end < 0 {
+			b.WriteString(value)
+			return b.String()
+		}
+		b.WriteString(value[:start])
+		sequence := value[start+1 : start+1+end]
+		value = value[start+end+2:]
+
+		switch {
+		case sequence == "F":
+			b.WriteByte(delimiters.Field)
+		case sequence == "S":
+			b.WriteByte(delimiters.Component)
+		case sequence == "R":
+			b.WriteByte(delimiters.Repetition)
+		case sequence == "E":
+			b.WriteByte(delimiters.Escape)
+		case sequence == "T":
+			b.WriteByte(delimiters.Subcomponent)
+		case sequence == "H" || sequence == "N":
+		case sequence == ".br":
+			b.WriteByte('\n')
+		case strings.HasPrefix(sequence, "X") && len(sequence)%2 == 1:
+			decoded, ok := decodeHex(sequence[1:])
+			if !ok {
+				b.WriteString(escape + sequence + escape)
+				continue
+			}
+			b.WriteString(decoded)
+		default:
+			b.WriteString(escape + sequence + escape)
+		}
+	}
+}
+
+// Escape escapes the delimiters in a value, and line breaks.
+func Escape(value string, delimiters *Delimiters) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch c {
+		case delimiters.Escape:
+			b.WriteString(string(delimiters.Escape) + "E" + string(delimiters.Escape))
+		case delimiters.Field:
+			b.WriteString(string(delimiters.Escape) + "F" + string(delimiters.Escape))
+		case delimiters.Component:
+			b.WriteString(string(delimiters.Escape) + "S" + string(delimiters.Escape))
+		case delimiters.Repetition:
+			b.WriteString(string(delimiters.Escape) + "R" + string(delimiters.Escape))
+		case delimiters.Subcomponent:
+			b.WriteString(string(delimiters.Escape) + "T" + string(delimiters.Escape))
+		case '\r', '\n':
+			b.WriteString(string(delimiters.Escape) + ".br" + string(delimiters.Escape))
+			if c == '\r' && i+1 < len(value) && value[i+1] == '\n' {
+				i++
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func decodeHex(hex string) (string, bool) {
+	decoded := make([]byte, 0, len(hex)/2)
+	for i := 0; i+1 < len(hex); i += 2 {
+		c, err := strconv.ParseUint(hex[i:i+2], 16, 8)
+		if err != nil {
+			return "", false
+		}
+		decoded = append(decoded, byte(c))
+	}
+	return string(decoded), true
+}
+
+func latin1ToUTF8(value string) string {
+	runes := make([]rune, len(value))
+	for i := 0; i < len(value); i++ {
+		runes[i] = rune(value[i])
+	}
+	return string(runes)
+}
+
+// Ack returns the acknowledgment of the message with the code, and the text of an error if any. The text of
+// an accepted message is a warning, also given by an ERR segment of severity W. A message that could not be
+// parsed, nil, is acknowledged with the default delimiters.
+func Ack(m *Message, code string, text string) []byte {
+	if m == nil {
+		m = &Message{Delimiters: DefaultDelimiters}
+	}
+	d := &m.Delimiters
+	msh := m.Segment("MSH")
+	if msh == nil {
+		msh = &Segment{Name: "MSH", Fields: []string{string(d.Field), "^~\\&"}, delimiters: d}
+	}
+	version := msh.Field(12)
+	if version == "" {
+		version = "2.5"
+	}
+	messageType := "ACK"
+	if trigger := msh.Get(9, 2); trigger != "" {
+		messageType = strings.Join([]string{"ACK", Escape(trigger, d), "ACK"}, string(d.Component))
+	}
+
+	field := string(d.Field)
+	segments := []string{
+		strings.Join([]string{"MSH", msh.Field(2),
+			msh.Field(5), msh.Field(6), msh.Field(3), msh.Field(4),
+			time.Now().Format(timestampLayout), "", messageType,
+			"ACK" + strconv.FormatInt(time.Now().UnixNano(), 36), msh.Field(11), version}, field),
+		strings.Join([]string{"MSA", code, msh.Field(10), Escape(text, d)}, field),
+	}
+	if code == AckAccept && text != "" {
+		// ERR-3 0 is the message accepted code of table 0357, ERR-8 the user message
+		errorCode := strings.Join([]string{"0", "Message accepted", "HL70357"}, string(d.Component))
+		segments = append(segments, strings.Join([]string{"ERR", "", "", errorCode, "W", "", "", "", Escape(text, d)}, field))
+	}
+	return []byte(strings.Join(segments, "\r") + "\r")
+}
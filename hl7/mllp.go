@@ -0,0 +1,281 @@
+package hl7
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"dicom-store-api/logging"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MLLP frame delimiters.
+const (
+	startBlock     = 0x0b
+	endBlock       = 0x1c
+	carriageReturn = 0x0d
+)
+
+const (
+	// maxMessageLength bounds the messages read, well above the size of ADT and order messages.
+	maxMessageLength = 16 << 20
+	writeTimeout     = 30 * time.Second
+)
+
+// ErrServerClosed is returned by Serve after Close.
+var ErrServerClosed = errors.New("hl7: server closed")
+
+var errConnectionClosed = errors.New("hl7: connection closed")
+
+// ErrUnsupportedMessage is returned by a handler for a message it does not process, which is rejected.
+var ErrUnsupportedMessage = errors.New("unsupported message")
+
+// Warning is returned by a handler for a message it processed only in part. The message is accepted with
+// the text of the warning.
+type Warning struct {
+	Text string
+}
+
+func (w *Warning) Error() string {
+	return w.Text
+}
+
+// HandlerFunc processes a message. The message is acknowledged with AA when it returns nil or a *Warning,
+// AR for an ErrUnsupportedMessage and AE with the text of any other error.
+type HandlerFunc func(ctx context.Context, message *Message) error
+
+// Server receives the messages sent over MLLP connections and acknowledges each of them once handled.
+type Server struct {
+	// IdleTimeout closes connections without any message for that long, if not zero.
+	IdleTimeout time.Duration
+
+	handlers map[string]HandlerFunc
+
+	mutex    sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+}
+
+// NewServer returns a server rejecting every message until handlers are set.
+func NewServer() *Server {
+	return &Server{
+		handlers: map[string]HandlerFunc{},
+		conns:    map[net.Conn]struct{}{},
+	}
+}
+
+// Handle sets the handler of the messages of the type and trigger event, such as ADT^A08.
+func (s *Server) Handle(messageType string, handler HandlerFunc) {
+	s.handlers[messageType] = handler
+}
+
+// ListenAndServe listens on the TCP address and serves the connections.
+func (s *Server) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve serves the connections accepted on the listener until Close.
+func (s *Server) Serve(listener net.Listener) error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		listener.Close()
+		return ErrServerClosed
+	}
+	s.listener = listener
+	s.mutex.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.mutex.Lock()
+			closed := s.closed
+			s.mutex.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops listening and closes the connections.
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	if s.listener != nil {
+		return s.listener.Close()
+	}
+	return nil
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	logger := logging.Logger.WithField("module", "hl7").WithField("remote_addr", conn.RemoteAddr().String())
+
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return
+	}
+	s.conns[conn] = struct{}{}
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.conns, conn)
+		s.mutex.Unlock()
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reader := bufio.NewReader(conn)
+	for {
+		if s.IdleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(s.IdleTimeout))
+		}
+		data, err := readFrame(reader)
+		if err != nil {
+			var netErr net.Error
+			if err != errConnectionClosed && !(errors.As(err, &netErr) && netErr.Timeout()) {
+				logger.Warnf("reading message: %v", err)
+			}
+			return
+		}
+
+		ack := s.handle(ctx, data, logger)
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if err := writeFrame(conn, ack); err != nil {
+			logger.Warnf("sending acknowledgment: %v", err)
+			return
+		}
+	}
+}
+
+// handle processes a message and returns its acknowledgment.
+func (s *Server) handle(ctx context.Context, data []byte, logger logrus.FieldLogger) []byte {
+	message, err := Parse(data)
+	if err != nil {
+		logger.Warn(err)
+		return Ack(nil, AckReject, err.Error())
+	}
+	logger = logger.WithField("message_type", message.Type()).WithField("control_id", message.ControlID())
+
+	handler, ok := s.handlers[message.Type()]
+	if !ok {
+		logger.Warn("rejecting unsupported message")
+		return Ack(message, AckReject, fmt.Sprintf("%s: %s", ErrUnsupportedMessage, message.Type()))
+	}
+	var warning *Warning
+	switch err := callHandler(ctx, handler, message); {
+	case err == nil:
+		logger.Info("message processed")
+		return Ack(message, AckAccept, "")
+	case errors.As(err, &warning):
+		logger.Warnf("message processed in part: %v", warning)
+		return Ack(message, AckAccept, warning.Text)
+	case errors.Is(err, ErrUnsupportedMessage):
+		logger.Warn(err)
+		return Ack(message, AckReject, err.Error())
+	default:
+		logger.Error(err)
+		return Ack(message, AckError, err.Error())
+	}
+}
+
+// callHandler returns the panic of a handler as an error, the message is answered and the connection kept.
+func callHandler(ctx context.Context, handler HandlerFunc, message *Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic processing the message: %v", r)
+		}
+	}()
+	return handler(ctx, message)
+}
+
+// readFrame reads the message of an MLLP frame, skipping anything before its start block.
+func readFrame(reader *bufio.Reader) ([]byte, error) {
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return nil, errConnectionClosed
+			}
+			return nil, err
+		}
+		if b == startBlock {
+			break
+		}
+	}
+
+	var data []byte
+	for {
+		chunk, err := reader.ReadSlice(endBlock)
+		data = append(data, chunk...)
+		if err == bufio.ErrBufferFull {
+			if len(data) > maxMessageLength {
+				return nil, fmt.Errorf("message longer than %d bytes", maxMessageLength)
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		break
+	}
+	if b, err := reader.ReadByte(); err != nil || b != carriageReturn {
+		return nil, errors.New("frame not ended with a carriage return")
+	}
+	return data[:len(data)-1], nil
+}
+
+func writeFrame(conn net.Conn, data []byte) error {
+	frame := make([]byte, 0, len(data)+3)
+	frame = append(frame, startBlock)
+	frame = append(frame, data...)
+	frame = append(frame, endBlock, carriageReturn)
+	_, err := conn.Write(frame)
+	return err
+}
+
+// Send sends a message to the MLLP listener at the TCP address and returns its acknowledgment.
+func Send(ctx context.Context, addr string, data []byte) (*Message, error) {
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if err := writeFrame(conn, data); err != nil {
+		return nil, err
+	}
+	ack, err := readFrame(bufio.NewReader(conn))
+	if err != nil {
+		return nil, err
+	}
+	return Parse(ack)
+}
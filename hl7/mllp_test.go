@@ -0,0 +1,215 @@
+package hl7
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"dicom-store-api/logging"
+)
+
+func TestMain(m *testing.M) {
+	logging.NewLogger()
+	os.Exit(m.Run())
+}
+
+// newTestServer serves the server on a loopback listener and returns its address.
+func newTestServer(t *testing.T, s *Server) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(listener) }()
+	t.Cleanup(func() {
+		s.Close()
+		if err := <-served; err != ErrServerClosed {
+			t.Errorf("serve = %v, want ErrServerClosed", err)
+		}
+	})
+	return listener.Addr().String()
+}
+
+func send(t *testing.T, addr string, message string) *Message {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ack, err := Send(ctx, addr, []byte(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ack
+}
+
+const adtA08 = "MSH|^~\\&|RIS|HOSP|PACS|HOSP|20240102030405||ADT^A08|MSG0001|P|2.5\r" +
+	"EVN|A08|20240102030405\r" +
+	"PID|1||PAT1^^^HOSP||Doe^Jane||19800101|F\r"
+
+func TestAck(t *testing.T) {
+	s := NewServer()
+	s.Handle("ADT^A08", func(ctx context.Context, message *Message) error { return nil })
+	s.Handle("ADT^A40", func(ctx context.Context, message *Message) error {
+		return errors.New("prior patient PAT0|PAT9 not found")
+	})
+	s.Handle("ORM^O01", func(ctx context.Context, message *Message) error {
+		return &Warning{Text: "studies updated, files unchanged"}
+	})
+	s.Handle("ADT^A04", func(ctx context.Context, message *Message) error {
+		return ErrUnsupportedMessage
+	})
+	s.Handle("ADT^A03", func(ctx context.Context, message *Message) error {
+		panic("nil segment")
+	})
+	addr := newTestServer(t, s)
+
+	tests := []struct {
+		messageType string
+		code        string
+		text        string
+	}{
+		{"ADT^A08", AckAccept, ""},
+		{"ADT^A40", AckError, "prior patient PAT0|PAT9 not found"},
+		{"ORM^O01", AckAccept, "studies updated, files unchanged"},
+		{"ADT^A04", AckReject, ErrUnsupportedMessage.Error()},
+		{"ADT^A03", AckError, "panic processing the message: nil segment"},
+		{"ADT^A31", AckReject, "unsupported message: ADT^A31"},
+	}
+	for _, test := range tests {
+		message := strings.Replace(adtA08, "ADT^A08", test.messageType, 1)
+		ack := send(t, addr, message)
+		if ack.Type() != "ACK^"+strings.Split(test.messageType, "^")[1] || ack.Get("MSA", 2, 1) != "MSG0001" {
+			t.Errorf("%s: ACK type %s for control ID %s", test.messageType, ack.Type(), ack.Get("MSA", 2, 1))
+		}
+		if code, text := ack.Get("MSA", 1, 1), ack.Get("MSA", 3, 1); code != test.code || text != test.text {
+			t.Errorf("%s: MSA %s %q, want %s %q", test.messageType, code, text, test.code, test.text)
+		}
+		warning := ack.Segment("ERR") != nil
+		if want := test.code == AckAccept && test.text != ""; warning != want ||
+			(warning && (ack.Get("ERR", 4, 1) != "W" || ack.Get("ERR", 8, 1) != test.text)) {
+			t.Errorf("%s: ERR segment %v", test.messageType, ack.Segment("ERR"))
+		}
+		if ack.Get("MSH", 3, 1) != "PACS" || ack.Get("MSH", 5, 1) != "RIS" {
+			t.Errorf("%s: ACK from %s to %s, want from PACS to RIS", test.messageType, ack.Get("MSH", 3, 1), ack.Get("MSH", 5, 1))
+		}
+	}
+
+	ack := send(t, addr, "not an HL7 message")
+	if ack.Get("MSA", 1, 1) != AckReject || !strings.Contains(ack.Get("MSA", 3, 1), "does not start with MSH") {
+		t.Errorf("malformed message: MSA %s %q", ack.Get("MSA", 1, 1), ack.Get("MSA", 3, 1))
+	}
+}
+
+func TestEscapes(t *testing.T) {
+	received := make(chan *Message, 1)
+	s := NewServer()
+	s.Handle("ADT^A08", func(ctx context.Context, message *Message) error {
+		received <- message
+		return errors.New(message.Get("PID", 5, 1))
+	})
+	addr := newTestServer(t, s)
+
+	// the message uses other delimiters than the default ones, which the ACK keeps
+	message := "MSH#*~!$#RIS#HOSP#PACS#HOSP#20240102030405##ADT*A08#MSG0002#P#2.5\r" +
+		"PID#1##PAT2*!F!*!S!*HOSP$X##O!E!Brien!F!!S!!T!!R!!X41!!.br!end!H!!Z!*Mary\r"
+	ack := send(t, addr, message)
+
+	m := <-received
+	want := "O!Brien#*$~A\nend!Z!"
+	if got := m.Get("PID", 5, 1); got != want {
+		t.Errorf("family name = %q, want %q", got, want)
+	}
+	if got := m.Get("PID", 3, 1); got != "PAT2" || m.Get("PID", 3, 2) != "#" || m.Get("PID", 3, 4) != "HOSP" {
+		t.Errorf("PID-3 = %q %q %q", got, m.Get("PID", 3, 2), m.Get("PID", 3, 4))
+	}
+	if got := m.Segment("PID").Components(5); len(got) != 2 || got[1] != "Mary" {
+		t.Errorf("name components = %q", got)
+	}
+
+	if ack.Delimiters.Field != '#' || ack.Delimiters.Component != '*' || ack.Delimiters.Escape != '!' {
+		t.Errorf("ACK delimiters = %+v", ack.Delimiters)
+	}
+	if got := ack.Get("MSA", 3, 1); got != want {
+		t.Errorf("ACK text = %q, want the error text %q", got, want)
+	}
+	if got := ack.Type(); got != "ACK^A08" {
+		t.Errorf("ACK type = %q", got)
+	}
+}
+
+func TestSegmentSeparators(t *testing.T) {
+	received := make(chan *Message, 3)
+	s := NewServer()
+	s.Handle("ADT^A08", func(ctx context.Context, message *Message) error {
+		received <- message
+		return nil
+	})
+	addr := newTestServer(t, s)
+
+	for _, separator := range []string{"\r", "\n", "\r\n"} {
+		send(t, addr, "\r\n"+strings.ReplaceAll(adtA08, "\r", separator)+separator)
+		m := <-received
+		var names []string
+		for _, segment := range m.Segments {
+			names = append(names, segment.Name)
+		}
+		if strings.Join(names, ",") != "MSH,EVN,PID" || m.Get("PID", 5, 2) != "Jane" || m.Get("PID", 8, 1) != "F" {
+			t.Errorf("separator %q: segments %v, PID %v", separator, names, m.Segment("PID"))
+		}
+	}
+}
+
+func TestFrames(t *testing.T) {
+	received := make(chan string, 3)
+	s := NewServer()
+	s.Handle("ADT^A08", func(ctx context.Context, message *Message) error {
+		received <- message.ControlID()
+		return nil
+	})
+	addr := newTestServer(t, s)
+
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	// noise before the start block is skipped and a frame may arrive in several writes
+	frame := "\x0b" + strings.Replace(adtA08, "MSG0001", "MSG0003", 1) + "\x1c\r"
+	for _, chunk := range []string{"noise\r\n", frame[:20], frame[20 : len(frame)-1], frame[len(frame)-1:]} {
+		if _, err := conn.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// messages sent one after the other on a connection are acknowledged in order
+	for _, controlID := range []string{"MSG0004", "MSG0005"} {
+		if err := writeFrame(conn, []byte(strings.Replace(adtA08, "MSG0001", controlID, 1))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, controlID := range []string{"MSG0003", "MSG0004", "MSG0005"} {
+		data, err := readFrame(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ack, err := Parse(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ack.Get("MSA", 1, 1) != AckAccept || ack.Get("MSA", 2, 1) != controlID {
+			t.Errorf("ACK %s for %s, want AA for %s", ack.Get("MSA", 1, 1), ack.Get("MSA", 2, 1), controlID)
+		}
+		if got := <-received; got != controlID {
+			t.Errorf("handled %s, want %s", got, controlID)
+		}
+	}
+}
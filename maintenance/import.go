@@ -0,0 +1,282 @@
+package maintenance
+
+import (
+	"context"
+	"dicom-store-api/database"
+	"dicom-store-api/logging"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-pg/pg"
+	"github.com/suyashkumar/dicom"
+	"github.com/suyashkumar/dicom/pkg/tag"
+)
+
+const DICOMDIR = "DICOMDIR"
+
+// ImportFailure is a DICOM file that could not be imported.
+type ImportFailure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// ImportReport summarizes an import. In a dry run Imported counts the files that would have been imported.
+type ImportReport struct {
+	Source   string           `json:"source"`
+	DryRun   bool             `json:"dry_run"`
+	Files    int              `json:"files"`
+	Imported int              `json:"imported"`
+	Skipped  int              `json:"skipped"`
+	NotDicom int              `json:"not_dicom"`
+	Failed   int              `json:"failed"`
+	Bytes    int64            `json:"bytes"`
+	Duration string           `json:"duration"`
+	Failures []*ImportFailure `json:"failures"`
+}
+
+// Importer ingests the DICOM files of a directory tree or a DICOMDIR the same way as STOW requests.
+type Importer struct {
+	InstanceStore    InstanceStore
+	Indexer          Indexer
+	Workers          int
+	DryRun           bool
+	ProgressInterval time.Duration
+}
+
+// NewImporter returns an Importer storing files with the indexer.
+func NewImporter(db *pg.DB, indexer Indexer, workers int, dryRun bool) *Importer {
+	return &Importer{
+		InstanceStore:    database.NewInstanceStore(db),
+		Indexer:          indexer,
+		Workers:          workers,
+		DryRun:           dryRun,
+		ProgressInterval: 10 * time.Second,
+	}
+}
+
+type importResult struct {
+	path    string
+	outcome int
+	size    int64
+	err     error
+}
+
+const outcomeNotDicom = outcomeFailed + 1
+
+var errNotDicom = errors.New("not a DICOM Part 10 file")
+var errNoSOPInstanceUID = errors.New("file meta information has no MediaStorageSOPInstanceUID")
+
+// Run imports the files below source, or those referenced by source if it is a DICOMDIR. Instances that are
+// already stored, in the trash or not, are skipped. Files that are not Part 10 files are counted but not reported
+// as failures. When ctx is cancelled the files being imported are finished and the report covers what was done.
+func (im *Importer) Run(ctx context.Context, source string) (*ImportReport, error) {
+	logger := logging.Logger.WithField("module", "import")
+	started := time.Now()
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(chan string)
+	walkErr := make(chan error, 1)
+	go func() {
+		defer close(paths)
+		send := func(path string) error {
+			select {
+			case paths <- path:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if info.IsDir() {
+			walkErr <- walkFiles(source, send)
+		} else {
+			walkErr <- walkDicomdir(source, send)
+		}
+	}()
+
+	results := make(chan importResult)
+	workers := im.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				results <- im.importFile(path)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	report := &ImportReport{Source: source, DryRun: im.DryRun, Failures: []*ImportFailure{}}
+	lastProgress := time.Now()
+	for result := range results {
+		report.Files++
+		switch result.outcome {
+		case outcomeCreated:
+			report.Imported++
+			report.Bytes += result.size
+		case outcomeSkipped:
+			report.Skipped++
+		case outcomeNotDicom:
+			report.NotDicom++
+		default:
+			report.Failed++
+			report.Failures = append(report.Failures, &ImportFailure{Path: result.path, Error: result.err.Error()})
+			logger.WithField("path", result.path).Warn(result.err)
+		}
+
+		if time.Since(lastProgress) >= im.ProgressInterval {
+			lastProgress = time.Now()
+			logger.Infof("%d files, %d imported, %d skipped, %d not DICOM, %d failed",
+				report.Files, report.Imported, report.Skipped, report.NotDicom, report.Failed)
+		}
+	}
+	report.Duration = time.Since(started).Round(time.Second).String()
+
+	if err := <-walkErr; err != nil && !errors.Is(err, ctx.Err()) {
+		return report, err
+	}
+	return report, ctx.Err()
+}
+
+// importFile checks the file meta information and stores the file unless its instance is stored already.
+func (im *Importer) importFile(path string) importResult {
+	result := importResult{path: path}
+
+	sopInstanceUID, size, err := readSOPInstanceUID(path)
+	if errors.Is(err, errNotDicom) {
+		result.outcome = outcomeNotDicom
+		return result
+	}
+	if err == nil && sopInstanceUID == "" {
+		err = errNoSOPInstanceUID
+	}
+	if err != nil {
+		result.outcome, result.err = outcomeFailed, err
+		return result
+	}
+	result.size = size
+
+	stored, err := isStored(im.InstanceStore, sopInstanceUID)
+	if err != nil {
+		result.outcome, result.err = outcomeFailed, err
+		return result
+	}
+	if stored {
+		result.outcome = outcomeSkipped
+		return result
+	}
+
+	if !im.DryRun {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			_, err = im.Indexer.Store(data)
+		}
+		if err != nil {
+			result.outcome, result.err = outcomeFailed, err
+			return result
+		}
+	}
+	result.outcome = outcomeCreated
+	return result
+}
+
+// readSOPInstanceUID reads the file meta information only, failing for files that are not Part 10 files.
+func readSOPInstanceUID(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", 0, err
+	}
+
+	// the parser accepts files without the preamble, so the magic is checked first
+	preamble := make([]byte, 132)
+	if _, err := io.ReadFull(file, preamble); err != nil || string(preamble[128:]) != "DICM" {
+		return "", 0, errNotDicom
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", 0, err
+	}
+
+	parser, err := dicom.NewParser(file, info.Size(), nil)
+	if err != nil {
+		return "", 0, fmt.Errorf("%w: %v", errNotDicom, err)
+	}
+	metadata := parser.GetMetadata()
+	element, err := metadata.FindElementByTag(tag.MediaStorageSOPInstanceUID)
+	if err != nil || element.Value.ValueType() != dicom.Strings {
+		return "", info.Size(), nil
+	}
+	values := dicom.MustGetStrings(element.Value)
+	if len(values) == 0 {
+		return "", info.Size(), nil
+	}
+	return strings.TrimRight(values[0], "\x00 "), info.Size(), nil
+}
+
+// walkFiles calls send for every regular file below root except DICOMDIR files. Unreadable directories are skipped.
+func walkFiles(root string, send func(path string) error) error {
+	return filepath.WalkDir(root, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			logging.Logger.WithField("module", "import").WithField("path", path).Warn(err)
+			return nil
+		}
+		if !entry.Type().IsRegular() || strings.EqualFold(entry.Name(), DICOMDIR) {
+			return nil
+		}
+		return send(path)
+	})
+}
+
+// walkDicomdir calls send for every file referenced by a record of the DICOMDIR.
+func walkDicomdir(dicomdir string, send func(path string) error) error {
+	dataset, err := dicom.ParseFile(dicomdir, nil)
+	if err != nil {
+		return err
+	}
+	records, err := dataset.FindElementByTag(tag.DirectoryRecordSequence)
+	if err != nil {
+		return err
+	}
+	items, ok := records.Value.GetValue().([]*dicom.SequenceItemValue)
+	if !ok {
+		return errors.New("DICOMDIR has no directory records")
+	}
+
+	root := filepath.Dir(dicomdir)
+	for _, item := range items {
+		for _, element := range item.GetValue().([]*dicom.Element) {
+			if element.Tag != tag.ReferencedFileID || element.Value.ValueType() != dicom.Strings {
+				continue
+			}
+			components := []string{root}
+			for _, component := range dicom.MustGetStrings(element.Value) {
+				components = append(components, strings.TrimSpace(component))
+			}
+			if err := send(filepath.Join(components...)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
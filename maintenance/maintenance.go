@@ -0,0 +1,29 @@
+// Package maintenance implements offline checks and repairs of the index and the file storage.
+package maintenance
+
+import (
+	"dicom-store-api/database"
+	"dicom-store-api/models"
+
+	"github.com/go-pg/pg"
+)
+
+type StudyStore interface {
+	FindWrongCounts(tx *pg.Tx) ([]*database.StudyCounts, error)
+	UpdateComputedFieldsByUID(studyInstanceUIDs []string, tx *pg.Tx) error
+	UpdateAttributes(s *models.Study, tx *pg.Tx) (int, error)
+	DeleteAll(tx *pg.Tx) error
+}
+type SeriesStore interface {
+	UpdateAttributes(s *models.Series, tx *pg.Tx) (int, error)
+}
+type InstanceStore interface {
+	FindAfter(afterID int, limit int, tx *pg.Tx) ([]*models.Instance, error)
+	Exists(sopInstanceUID string, tx *pg.Tx) (bool, error)
+	UpdateAttributes(s *models.Instance, storagePath string, tx *pg.Tx) (int, error)
+}
+
+// Indexer stores a Part 10 file the way a STOW request does.
+type Indexer interface {
+	Store(fileBytes []byte) (*models.Instance, error)
+}
@@ -0,0 +1,283 @@
+package maintenance
+
+import (
+	"bytes"
+	"context"
+	"dicom-store-api/database"
+	"dicom-store-api/fs"
+	"dicom-store-api/logging"
+	"dicom-store-api/models"
+	"dicom-store-api/utils"
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-pg/pg"
+	"github.com/suyashkumar/dicom"
+)
+
+// Outcomes of indexing a single file.
+const (
+	outcomeCreated = iota
+	outcomeUpdated
+	outcomeSkipped
+	outcomeFailed
+)
+
+// ReindexState is the progress of a reindex. It is saved while the reindex runs, so that an
+// interrupted reindex resumes after the last checkpoint instead of starting over.
+type ReindexState struct {
+	// Checkpoint is the key of the last file up to which all files have been processed, in key order.
+	Checkpoint string `json:"checkpoint"`
+	Files      int    `json:"files"`
+	Processed  int    `json:"processed"`
+	Created    int    `json:"created"`
+	Updated    int    `json:"updated"`
+	Skipped    int    `json:"skipped"`
+	Failed     int    `json:"failed"`
+}
+
+// Reindexer rebuilds the study, series and instance rows from the files in the storage.
+type Reindexer struct {
+	DB               *pg.DB
+	StudyStore       StudyStore
+	SeriesStore      SeriesStore
+	InstanceStore    InstanceStore
+	Indexer          Indexer
+	Workers          int
+	StatePath        string
+	ProgressInterval time.Duration
+}
+
+// NewReindexer returns a Reindexer indexing new files with the indexer and saving its state to statePath.
+func NewReindexer(db *pg.DB, indexer Indexer, workers int, statePath string) *Reindexer {
+	return &Reindexer{
+		DB:               db,
+		StudyStore:       database.NewStudyStore(db),
+		SeriesStore:      database.NewSeriesStore(db),
+		InstanceStore:    database.NewInstanceStore(db),
+		Indexer:          indexer,
+		Workers:          workers,
+		StatePath:        statePath,
+		ProgressInterval: 10 * time.Second,
+	}
+}
+
+type reindexResult struct {
+	index   int
+	outcome int
+}
+
+// Run re-parses every instance file. Stored instances get their attributes overwritten from the file,
+// files of instances that are not stored are indexed like a STOW request. With reset all rows are
+// removed first, unless a saved state is resumed. When ctx is cancelled the files being processed
+// are finished, the state is saved and ctx.Err() is returned. The state file is removed once done.
+func (r *Reindexer) Run(ctx context.Context, reset bool) (*ReindexState, error) {
+	logger := logging.Logger.WithField("module", "reindex")
+
+	state, err := r.loadState()
+	if err != nil {
+		return nil, err
+	}
+	if state != nil {
+		logger.Infof("resuming after %s", state.Checkpoint)
+	} else {
+		state = &ReindexState{}
+		if reset {
+			if err := r.StudyStore.DeleteAll(nil); err != nil {
+				return nil, err
+			}
+			if err := r.saveState(state); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	files, err := fs.ListDicomFiles()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(files))
+	for _, file := range files {
+		keys = append(keys, file.Key)
+	}
+	sort.Strings(keys)
+	if state.Checkpoint != "" {
+		keys = keys[sort.Search(len(keys), func(i int) bool { return keys[i] > state.Checkpoint }):]
+	}
+	state.Files = state.Processed + len(keys)
+
+	jobs := make(chan int)
+	results := make(chan reindexResult)
+	go func() {
+		defer close(jobs)
+		for i := range keys {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	workers := r.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				outcome, err := r.index(keys[i])
+				if err != nil {
+					logger.WithField("key", keys[i]).Warn(err)
+				}
+				results <- reindexResult{index: i, outcome: outcome}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Results arrive out of order, the checkpoint only advances over a gapless run of processed files.
+	outcomes := make([]int, len(keys))
+	processed := make([]bool, len(keys))
+	next := 0
+	lastProgress := time.Now()
+	for result := range results {
+		processed[result.index] = true
+		outcomes[result.index] = result.outcome
+		for next < len(keys) && processed[next] {
+			state.count(outcomes[next])
+			state.Checkpoint = keys[next]
+			next++
+		}
+
+		if time.Since(lastProgress) >= r.ProgressInterval {
+			lastProgress = time.Now()
+			logger.Infof("%d/%d files, %d created, %d updated, %d skipped, %d failed",
+				state.Processed, state.Files, state.Created, state.Updated, state.Skipped, state.Failed)
+			if err := r.saveState(state); err != nil {
+				logger.Error(err)
+			}
+		}
+	}
+
+	if ctx.Err() != nil {
+		if err := r.saveState(state); err != nil {
+			return state, err
+		}
+		return state, ctx.Err()
+	}
+	if err := os.Remove(r.StatePath); err != nil && !os.IsNotExist(err) {
+		return state, err
+	}
+	return state, nil
+}
+
+func (state *ReindexState) count(outcome int) {
+	state.Processed++
+	switch outcome {
+	case outcomeCreated:
+		state.Created++
+	case outcomeUpdated:
+		state.Updated++
+	case outcomeSkipped:
+		state.Skipped++
+	default:
+		state.Failed++
+	}
+}
+
+// index updates the rows of the instance in the file at key from it, or indexes the file if the instance is not stored.
+func (r *Reindexer) index(key string) (int, error) {
+	stored, err := fs.ReadStoredFile(key)
+	if err != nil {
+		return outcomeFailed, err
+	}
+	data, err := fs.DecodeDicomFile(stored, fs.DetectCompression(stored))
+	if err != nil {
+		return outcomeFailed, err
+	}
+	dataset, err := dicom.Parse(bytes.NewReader(data), int64(len(data)), nil)
+	if err != nil {
+		return outcomeFailed, err
+	}
+
+	study := &models.Study{}
+	utils.ExtractDicomObjectFromDataset(dataset, study)
+	series := &models.Series{}
+	utils.ExtractDicomObjectFromDataset(dataset, series)
+	instance := &models.Instance{
+		FileSize:        int64(len(data)),
+		FileSHA256:      fs.Checksum(data),
+		FileCompression: fs.DetectCompression(stored),
+		StoredSize:      int64(len(stored)),
+	}
+	utils.ExtractDicomObjectFromDataset(dataset, instance)
+
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return outcomeFailed, err
+	}
+	defer tx.Rollback()
+
+	updated, err := r.InstanceStore.UpdateAttributes(instance, key, tx)
+	if err != nil {
+		return outcomeFailed, err
+	}
+	if updated == 0 {
+		tx.Rollback()
+		switch _, err := storeOrphan(r.Indexer, r.InstanceStore, key, data, instance.SOPInstanceUID); err {
+		case nil:
+			return outcomeCreated, nil
+		case errInstanceStored:
+			return outcomeSkipped, nil
+		default:
+			return outcomeFailed, err
+		}
+	}
+
+	if _, err = r.SeriesStore.UpdateAttributes(series, tx); err != nil {
+		return outcomeFailed, err
+	}
+	if _, err = r.StudyStore.UpdateAttributes(study, tx); err != nil {
+		return outcomeFailed, err
+	}
+	if err = r.StudyStore.UpdateComputedFieldsByUID([]string{study.StudyInstanceUID}, tx); err != nil {
+		return outcomeFailed, err
+	}
+	if err = tx.Commit(); err != nil {
+		return outcomeFailed, err
+	}
+	return outcomeUpdated, nil
+}
+
+// loadState returns the saved state, or nil if there is none.
+func (r *Reindexer) loadState() (*ReindexState, error) {
+	data, err := os.ReadFile(r.StatePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	state := &ReindexState{}
+	return state, json.Unmarshal(data, state)
+}
+
+// saveState replaces the state file atomically, so an interruption never leaves a partial one.
+func (r *Reindexer) saveState(state *ReindexState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(r.StatePath+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(r.StatePath+".tmp", r.StatePath)
+}
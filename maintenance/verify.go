@@ -0,0 +1,243 @@
+package maintenance
+
+import (
+	"bytes"
+	"dicom-store-api/database"
+	"dicom-store-api/fs"
+	"dicom-store-api/models"
+	"dicom-store-api/utils"
+	"errors"
+
+	"github.com/go-pg/pg"
+	"github.com/suyashkumar/dicom"
+)
+
+// Repairs applied by the verifier.
+const (
+	RepairReindexed   = "reindexed"
+	RepairQuarantined = "quarantined"
+	RepairRecounted   = "recounted"
+)
+
+var errInstanceStored = errors.New("instance is stored with another file")
+
+// DefaultBatchSize is the number of instances read from the database at once.
+const DefaultBatchSize = 1000
+
+// FileIssue is an instance or a file that does not agree with the other side.
+type FileIssue struct {
+	Key               string `json:"key"`
+	StudyInstanceUID  string `json:"study_instance_uid,omitempty"`
+	SeriesInstanceUID string `json:"series_instance_uid,omitempty"`
+	SOPInstanceUID    string `json:"sop_instance_uid,omitempty"`
+	Error             string `json:"error,omitempty"`
+	Repair            string `json:"repair,omitempty"`
+	RepairError       string `json:"repair_error,omitempty"`
+}
+
+// CountIssue is a study whose recorded related series or instances are wrong.
+type CountIssue struct {
+	*database.StudyCounts
+	Repair      string `json:"repair,omitempty"`
+	RepairError string `json:"repair_error,omitempty"`
+}
+
+// VerifyReport lists the inconsistencies found between the database and the storage.
+type VerifyReport struct {
+	Instances int `json:"instances"`
+	Files     int `json:"files"`
+	// Unverified counts the instances stored before checksums were recorded.
+	Unverified         int           `json:"unverified"`
+	MissingFiles       []*FileIssue  `json:"missing_files"`
+	OrphanFiles        []*FileIssue  `json:"orphan_files"`
+	ChecksumMismatches []*FileIssue  `json:"checksum_mismatches"`
+	WrongCounts        []*CountIssue `json:"wrong_counts"`
+}
+
+// Consistent reports whether no inconsistency was found.
+func (report *VerifyReport) Consistent() bool {
+	return len(report.MissingFiles) == 0 && len(report.OrphanFiles) == 0 &&
+		len(report.ChecksumMismatches) == 0 && len(report.WrongCounts) == 0
+}
+
+// Verifier compares the instances in the database with the files in the storage.
+type Verifier struct {
+	StudyStore    StudyStore
+	InstanceStore InstanceStore
+	Indexer       Indexer
+	BatchSize     int
+}
+
+// NewVerifier returns a Verifier repairing orphan files with the indexer.
+func NewVerifier(db *pg.DB, indexer Indexer) *Verifier {
+	return &Verifier{
+		StudyStore:    database.NewStudyStore(db),
+		InstanceStore: database.NewInstanceStore(db),
+		Indexer:       indexer,
+		BatchSize:     DefaultBatchSize,
+	}
+}
+
+// Run checks every instance file and the study counters. With repair, orphan files are indexed,
+// files failing the checksum are quarantined and counters are recounted. Instances whose file is
+// missing are only reported. The storage is listed before the database is read, so that files of
+// instances stored while the check runs are not taken for orphans.
+func (v *Verifier) Run(repair bool) (*VerifyReport, error) {
+	report := &VerifyReport{
+		MissingFiles:       []*FileIssue{},
+		OrphanFiles:        []*FileIssue{},
+		ChecksumMismatches: []*FileIssue{},
+		WrongCounts:        []*CountIssue{},
+	}
+
+	files, err := fs.ListDicomFiles()
+	if err != nil {
+		return nil, err
+	}
+	report.Files = len(files)
+
+	referenced := map[string]bool{}
+	for afterID := 0; ; {
+		instances, err := v.InstanceStore.FindAfter(afterID, v.BatchSize, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(instances) == 0 {
+			break
+		}
+		for _, instance := range instances {
+			key := fs.GetDicomPath(instance.Series.Study, instance.Series, instance)
+			referenced[key] = true
+			v.checkInstance(report, key, instance, repair)
+		}
+		report.Instances += len(instances)
+		afterID = instances[len(instances)-1].ID
+	}
+
+	for _, file := range files {
+		if referenced[file.Key] {
+			continue
+		}
+		issue := &FileIssue{Key: file.Key}
+		report.OrphanFiles = append(report.OrphanFiles, issue)
+		if repair {
+			v.reindex(issue)
+		}
+	}
+
+	counts, err := v.StudyStore.FindWrongCounts(nil)
+	if err != nil {
+		return nil, err
+	}
+	var uids []string
+	for _, count := range counts {
+		report.WrongCounts = append(report.WrongCounts, &CountIssue{StudyCounts: count})
+		uids = append(uids, count.StudyInstanceUID)
+	}
+	if repair && len(uids) > 0 {
+		err := v.StudyStore.UpdateComputedFieldsByUID(uids, nil)
+		for _, issue := range report.WrongCounts {
+			setRepair(&issue.Repair, &issue.RepairError, RepairRecounted, err)
+		}
+	}
+
+	return report, nil
+}
+
+func (v *Verifier) checkInstance(report *VerifyReport, key string, instance *models.Instance, repair bool) {
+	issue := &FileIssue{
+		Key:               key,
+		StudyInstanceUID:  instance.Series.Study.StudyInstanceUID,
+		SeriesInstanceUID: instance.Series.SeriesInstanceUID,
+		SOPInstanceUID:    instance.SOPInstanceUID,
+	}
+
+	if instance.FileSHA256 == "" {
+		report.Unverified++
+		if _, err := fs.StatStored(key); err != nil {
+			issue.Error = err.Error()
+			report.MissingFiles = append(report.MissingFiles, issue)
+		}
+		return
+	}
+
+	data, err := fs.ReadDicomPath(key, instance.FileCompression)
+	if err != nil {
+		issue.Error = err.Error()
+		report.MissingFiles = append(report.MissingFiles, issue)
+		return
+	}
+	if int64(len(data)) == instance.FileSize && fs.Checksum(data) == instance.FileSHA256 {
+		return
+	}
+
+	issue.Error = fs.ErrChecksumMismatch.Error()
+	report.ChecksumMismatches = append(report.ChecksumMismatches, issue)
+	if repair {
+		_, err := fs.Quarantine(key)
+		setRepair(&issue.Repair, &issue.RepairError, RepairQuarantined, err)
+	}
+}
+
+// reindex stores an orphan file again.
+func (v *Verifier) reindex(issue *FileIssue) {
+	data, err := fs.ReadStoredFile(issue.Key)
+	if err == nil {
+		data, err = fs.DecodeDicomFile(data, fs.DetectCompression(data))
+	}
+	if err != nil {
+		setRepair(&issue.Repair, &issue.RepairError, RepairReindexed, err)
+		return
+	}
+	dataset, err := dicom.Parse(bytes.NewReader(data), int64(len(data)), nil)
+	if err != nil {
+		setRepair(&issue.Repair, &issue.RepairError, RepairReindexed, err)
+		return
+	}
+	instance := &models.Instance{}
+	utils.ExtractDicomObjectFromDataset(dataset, instance)
+	issue.SOPInstanceUID = instance.SOPInstanceUID
+
+	instance, err = storeOrphan(v.Indexer, v.InstanceStore, issue.Key, data, instance.SOPInstanceUID)
+	if err == nil {
+		issue.StudyInstanceUID = instance.Series.Study.StudyInstanceUID
+		issue.SeriesInstanceUID = instance.Series.SeriesInstanceUID
+	}
+	setRepair(&issue.Repair, &issue.RepairError, RepairReindexed, err)
+}
+
+// storeOrphan indexes a file at key that no instance refers to. If the file ends up under another key,
+// as coercion or a changed layout may cause, the orphan is removed. Files of instances that are stored,
+// or trashed, with another file are left alone with errInstanceStored, they are most likely superseded copies.
+func storeOrphan(indexer Indexer, instanceStore InstanceStore, key string, data []byte, sopInstanceUID string) (*models.Instance, error) {
+	stored, err := isStored(instanceStore, sopInstanceUID)
+	if err != nil {
+		return nil, err
+	}
+	if stored {
+		return nil, errInstanceStored
+	}
+
+	instance, err := indexer.Store(data)
+	if err != nil {
+		return nil, err
+	}
+	if fs.GetDicomPath(instance.Series.Study, instance.Series, instance) != key {
+		err = fs.RemoveDicomPath(key)
+	}
+	return instance, err
+}
+
+// isStored reports whether the instance is stored, in the trash or rejected. Rejected instances are not
+// stored again, they would come back into view.
+func isStored(instanceStore InstanceStore, sopInstanceUID string) (bool, error) {
+	return instanceStore.Exists(sopInstanceUID, nil)
+}
+
+func setRepair(repair *string, repairError *string, action string, err error) {
+	if err != nil {
+		*repairError = err.Error()
+		return
+	}
+	*repair = action
+}
@@ -7,19 +7,31 @@ import (
 
 	"github.com/go-ozzo/ozzo-validation"
 
+	"github.com/go-pg/pg"
 	"github.com/go-pg/pg/orm"
 )
 
 type Instance struct {
 	TableName struct{} `sql:"instance"`
 
-	ID        int       `json:"-" sql:",pk"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID        int         `json:"-" sql:",pk"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+	DeletedAt pg.NullTime `json:"deleted_at" pg:",soft_delete"`
 	SeriesId  int
 	Series    *Series `json:"series"`
 	ToolsData string  `json:"tools_data"`
 
+	// FileSize and FileSHA256 describe the file as received, StoragePath is its key in the storage.
+	// FileCompression is the compression at rest, StoredSize the size of the stored, compressed file.
+	FileSize        int64  `json:"file_size"`
+	FileSHA256      string `json:"file_sha256" sql:"file_sha256"`
+	StoragePath     string `json:"-"`
+	FileCompression string `json:"file_compression"`
+	StoredSize      int64  `json:"stored_size"`
+	// Tier is the storage tier holding the file, empty for the hot tier
+	Tier string `json:"tier"`
+
 	SOPClassUID    string `json:"sop_class_uid" dicom:"SOPClassUID"`
 	SOPInstanceUID string `json:"sop_instance_uid" dicom:"SOPInstanceUID"`
 	InstanceNumber string `json:"instance_number" dicom:"InstanceNumber"`
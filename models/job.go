@@ -0,0 +1,99 @@
+package models
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/go-ozzo/ozzo-validation"
+
+	"github.com/go-pg/pg/orm"
+)
+
+const (
+	// JobStatusRetrieving is the status of a retrieve job while its files are still being downloaded.
+	JobStatusRetrieving = "retrieving"
+	JobStatusPending    = "pending"
+	JobStatusProcessing = "processing"
+	JobStatusCompleted  = "completed"
+	JobStatusFailed     = "failed"
+)
+
+// Job is an asynchronous STOW request or a retrieve from a remote node, processed by the ingest workers
+// one item per file.
+type Job struct {
+	TableName struct{} `sql:"job"`
+
+	ID        int        `json:"id" sql:",pk"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	Status    string     `json:"status"`
+	Total     int        `json:"total" sql:",notnull"`
+	Completed int        `json:"completed" sql:",notnull"`
+	Failed    int        `json:"failed" sql:",notnull"`
+	Error     string     `json:"error,omitempty"`
+	Items     []*JobItem `json:"items"`
+}
+
+// BeforeInsert hook executed before database insert operation.
+func (j *Job) BeforeInsert(db orm.DB) error {
+	now := time.Now()
+	j.CreatedAt = now
+	j.UpdatedAt = now
+	return nil
+}
+
+// BeforeUpdate hook executed before database update operation.
+func (j *Job) BeforeUpdate(db orm.DB) error {
+	j.UpdatedAt = time.Now()
+	return j.Validate()
+}
+
+// Validate validates Job struct and returns validation errors.
+func (j *Job) Validate() error {
+	return validation.ValidateStruct(j)
+}
+
+func (j *Job) GetTableName() string {
+	field, _ := reflect.TypeOf(j).Elem().FieldByName("TableName")
+	tableName, _ := field.Tag.Lookup("sql")
+	return tableName
+}
+
+type JobItem struct {
+	TableName struct{} `sql:"job_item"`
+
+	ID             int       `json:"-" sql:",pk"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	JobId          int       `json:"-"`
+	ItemIndex      int       `json:"index" sql:",notnull"`
+	Status         string    `json:"status"`
+	SpoolPath      string    `json:"-"`
+	SOPInstanceUID string    `json:"sop_instance_uid,omitempty"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// BeforeInsert hook executed before database insert operation.
+func (i *JobItem) BeforeInsert(db orm.DB) error {
+	now := time.Now()
+	i.CreatedAt = now
+	i.UpdatedAt = now
+	return nil
+}
+
+// BeforeUpdate hook executed before database update operation.
+func (i *JobItem) BeforeUpdate(db orm.DB) error {
+	i.UpdatedAt = time.Now()
+	return i.Validate()
+}
+
+// Validate validates JobItem struct and returns validation errors.
+func (i *JobItem) Validate() error {
+	return validation.ValidateStruct(i)
+}
+
+func (i *JobItem) GetTableName() string {
+	field, _ := reflect.TypeOf(i).Elem().FieldByName("TableName")
+	tableName, _ := field.Tag.Lookup("sql")
+	return tableName
+}
@@ -0,0 +1,100 @@
+package models
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/go-ozzo/ozzo-validation"
+
+	"github.com/go-pg/pg/orm"
+)
+
+const (
+	PrefetchStatusSearching = "searching"
+	PrefetchStatusCompleted = "completed"
+	PrefetchStatusFailed    = "failed"
+)
+
+// Prefetch is the search for the priors of a newly arrived study, made once per study.
+type Prefetch struct {
+	TableName struct{} `sql:"prefetch"`
+
+	ID               int              `json:"id" sql:",pk"`
+	CreatedAt        time.Time        `json:"created_at"`
+	UpdatedAt        time.Time        `json:"updated_at"`
+	StudyInstanceUID string           `json:"study_instance_uid"`
+	PatientID        string           `json:"patient_id"`
+	Rule             string           `json:"rule"`
+	Status           string           `json:"status"`
+	Error            string           `json:"error,omitempty"`
+	Priors           []*PrefetchPrior `json:"priors"`
+}
+
+// BeforeInsert hook executed before database insert operation.
+func (p *Prefetch) BeforeInsert(db orm.DB) error {
+	now := time.Now()
+	p.CreatedAt = now
+	p.UpdatedAt = now
+	return p.Validate()
+}
+
+// BeforeUpdate hook executed before database update operation.
+func (p *Prefetch) BeforeUpdate(db orm.DB) error {
+	p.UpdatedAt = time.Now()
+	return p.Validate()
+}
+
+// Validate validates Prefetch struct and returns validation errors.
+func (p *Prefetch) Validate() error {
+	return validation.ValidateStruct(p,
+		validation.Field(&p.StudyInstanceUID, validation.Required, validation.Length(1, 64)),
+	)
+}
+
+func (p *Prefetch) GetTableName() string {
+	field, _ := reflect.TypeOf(p).Elem().FieldByName("TableName")
+	tableName, _ := field.Tag.Lookup("sql")
+	return tableName
+}
+
+// PrefetchPrior is a prior study fetched from a remote node, with the job retrieving it.
+type PrefetchPrior struct {
+	TableName struct{} `sql:"prefetch_prior"`
+
+	ID               int       `json:"-" sql:",pk"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+	PrefetchId       int       `json:"-"`
+	AETitle          string    `json:"ae_title" sql:"ae_title"`
+	StudyInstanceUID string    `json:"study_instance_uid"`
+	StudyDate        string    `json:"study_date"`
+	JobId            int       `json:"job_id,omitempty"`
+	Error            string    `json:"error,omitempty"`
+}
+
+// BeforeInsert hook executed before database insert operation.
+func (p *PrefetchPrior) BeforeInsert(db orm.DB) error {
+	now := time.Now()
+	p.CreatedAt = now
+	p.UpdatedAt = now
+	return p.Validate()
+}
+
+// BeforeUpdate hook executed before database update operation.
+func (p *PrefetchPrior) BeforeUpdate(db orm.DB) error {
+	p.UpdatedAt = time.Now()
+	return p.Validate()
+}
+
+// Validate validates PrefetchPrior struct and returns validation errors.
+func (p *PrefetchPrior) Validate() error {
+	return validation.ValidateStruct(p,
+		validation.Field(&p.StudyInstanceUID, validation.Required, validation.Length(1, 64)),
+	)
+}
+
+func (p *PrefetchPrior) GetTableName() string {
+	field, _ := reflect.TypeOf(p).Elem().FieldByName("TableName")
+	tableName, _ := field.Tag.Lookup("sql")
+	return tableName
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/go-ozzo/ozzo-validation"
+
+	"github.com/go-pg/pg/orm"
+)
+
+// IHE IOCM rejection note document titles (DCM coding scheme).
+const (
+	RejectionCodeQuality            = "113001"
+	RejectionCodePatientSafety      = "113037"
+	RejectionCodeIncorrectWorklist  = "113038"
+	RejectionCodeRetentionExpired   = "113039"
+	RejectionCodingSchemeDesignator = "DCM"
+)
+
+// RejectionCodeMeanings maps the supported rejection note titles to their code meaning.
+var RejectionCodeMeanings = map[string]string{
+	RejectionCodeQuality:           "Rejected for Quality Reasons",
+	RejectionCodePatientSafety:     "Rejected for Patient Safety Reasons",
+	RejectionCodeIncorrectWorklist: "Incorrect Modality Worklist Entry",
+	RejectionCodeRetentionExpired:  "Data Retention Policy Expired",
+}
+
+// RejectionNote is a received Key Object Selection document titled with one of the rejection codes.
+type RejectionNote struct {
+	TableName struct{} `sql:"rejection_note"`
+
+	ID                int                 `json:"id" sql:",pk"`
+	CreatedAt         time.Time           `json:"created_at"`
+	UpdatedAt         time.Time           `json:"updated_at"`
+	StudyInstanceUID  string              `json:"study_instance_uid"`
+	SeriesInstanceUID string              `json:"series_instance_uid"`
+	SOPInstanceUID    string              `json:"sop_instance_uid"`
+	CodeValue         string              `json:"code_value"`
+	CodeMeaning       string              `json:"code_meaning"`
+	Instances         []*RejectedInstance `json:"instances,omitempty"`
+}
+
+// BeforeInsert hook executed before database insert operation.
+func (n *RejectionNote) BeforeInsert(db orm.DB) error {
+	now := time.Now()
+	n.CreatedAt = now
+	n.UpdatedAt = now
+	return nil
+}
+
+// BeforeUpdate hook executed before database update operation.
+func (n *RejectionNote) BeforeUpdate(db orm.DB) error {
+	n.UpdatedAt = time.Now()
+	return n.Validate()
+}
+
+// Validate validates RejectionNote struct and returns validation errors.
+func (n *RejectionNote) Validate() error {
+	return validation.ValidateStruct(n)
+}
+
+func (n *RejectionNote) GetTableName() string {
+	field, _ := reflect.TypeOf(n).Elem().FieldByName("TableName")
+	tableName, _ := field.Tag.Lookup("sql")
+	return tableName
+}
+
+// RejectedInstance is an instance referenced by a rejection note. The row is kept whether or not the
+// instance was received, so an instance arriving after its rejection note is hidden as well.
+type RejectedInstance struct {
+	TableName struct{} `sql:"rejected_instance"`
+
+	ID                int       `json:"-" sql:",pk"`
+	CreatedAt         time.Time `json:"created_at"`
+	RejectionNoteId   int       `json:"-"`
+	StudyInstanceUID  string    `json:"study_instance_uid"`
+	SeriesInstanceUID string    `json:"series_instance_uid"`
+	SOPClassUID       string    `json:"sop_class_uid"`
+	SOPInstanceUID    string    `json:"sop_instance_uid"`
+}
+
+// BeforeInsert hook executed before database insert operation.
+func (i *RejectedInstance) BeforeInsert(db orm.DB) error {
+	i.CreatedAt = time.Now()
+	return nil
+}
+
+func (i *RejectedInstance) GetTableName() string {
+	field, _ := reflect.TypeOf(i).Elem().FieldByName("TableName")
+	tableName, _ := field.Tag.Lookup("sql")
+	return tableName
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"reflect"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/go-ozzo/ozzo-validation"
+
+	"github.com/go-pg/pg/orm"
+)
+
+// aeTitlePattern matches AE titles: printable characters other than backslash, without leading or trailing spaces.
+var aeTitlePattern = regexp.MustCompile(`^[!-\[\]-~]([ -\[\]-~]*[!-\[\]-~])?$`)
+
+// urlPattern matches the http and https URLs of DICOMweb servers.
+var urlPattern = regexp.MustCompile(`^https?://[^\s/?#]+[^\s?#]*$`)
+
+// RemoteNode is a known remote DICOM application entity, such as a C-MOVE destination, reached with DIMSE
+// at its host and port and with DICOMweb at its URL.
+type RemoteNode struct {
+	TableName struct{} `sql:"remote_node"`
+
+	ID          int       `json:"-" sql:",pk"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	AETitle     string    `json:"ae_title" sql:"ae_title"`
+	Host        string    `json:"host"`
+	Port        int       `json:"port"`
+	URL         string    `json:"url"`
+	Description string    `json:"description"`
+}
+
+// Addr returns the TCP address of the node.
+func (n *RemoteNode) Addr() string {
+	return n.Host + ":" + strconv.Itoa(n.Port)
+}
+
+// BeforeInsert hook executed before database insert operation.
+func (n *RemoteNode) BeforeInsert(db orm.DB) error {
+	now := time.Now()
+	n.CreatedAt = now
+	n.UpdatedAt = now
+	return n.Validate()
+}
+
+// BeforeUpdate hook executed before database update operation.
+func (n *RemoteNode) BeforeUpdate(db orm.DB) error {
+	n.UpdatedAt = time.Now()
+	return n.Validate()
+}
+
+// Validate validates RemoteNode struct and returns validation errors.
+func (n *RemoteNode) Validate() error {
+	hostRules := []validation.Rule{validation.Length(1, 255)}
+	portRules := []validation.Rule{validation.Min(1), validation.Max(65535)}
+	// a node reached with DICOMweb only has no host and port
+	if n.URL == "" || n.Host != "" || n.Port != 0 {
+		hostRules = append(hostRules, validation.Required)
+		portRules = append(portRules, validation.Required)
+	}
+	return validation.ValidateStruct(n,
+		validation.Field(&n.AETitle, validation.Required, validation.Length(1, 16), validation.Match(aeTitlePattern)),
+		validation.Field(&n.Host, hostRules...),
+		validation.Field(&n.Port, portRules...),
+		validation.Field(&n.URL, validation.Length(0, 2047), validation.Match(urlPattern)),
+	)
+}
+
+func (n *RemoteNode) GetTableName() string {
+	field, _ := reflect.TypeOf(n).Elem().FieldByName("TableName")
+	tableName, _ := field.Tag.Lookup("sql")
+	return tableName
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/go-ozzo/ozzo-validation"
+
+	"github.com/go-pg/pg/orm"
+)
+
+const (
+	RouteStatusPending    = "pending"
+	RouteStatusProcessing = "processing"
+	RouteStatusCompleted  = "completed"
+	RouteStatusDead       = "dead"
+)
+
+// RouteItem is an instance waiting to be forwarded to a routing destination. Failed sends go back to pending
+// until the next attempt, and are left dead once the attempts are exhausted.
+type RouteItem struct {
+	TableName struct{} `sql:"route_item"`
+
+	ID                int       `json:"id" sql:",pk"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+	Rule              string    `json:"rule"`
+	Destination       string    `json:"destination"`
+	StudyInstanceUID  string    `json:"study_instance_uid"`
+	SeriesInstanceUID string    `json:"series_instance_uid"`
+	SOPInstanceUID    string    `json:"sop_instance_uid"`
+	Status            string    `json:"status"`
+	Attempts          int       `json:"attempts" sql:",notnull"`
+	NextAttemptAt     time.Time `json:"next_attempt_at"`
+	LastError         string    `json:"last_error,omitempty"`
+}
+
+// BeforeInsert hook executed before database insert operation.
+func (i *RouteItem) BeforeInsert(db orm.DB) error {
+	now := time.Now()
+	i.CreatedAt = now
+	i.UpdatedAt = now
+	if i.NextAttemptAt.IsZero() {
+		i.NextAttemptAt = now
+	}
+	return i.Validate()
+}
+
+// BeforeUpdate hook executed before database update operation.
+func (i *RouteItem) BeforeUpdate(db orm.DB) error {
+	i.UpdatedAt = time.Now()
+	return i.Validate()
+}
+
+// Validate validates RouteItem struct and returns validation errors.
+func (i *RouteItem) Validate() error {
+	return validation.ValidateStruct(i,
+		validation.Field(&i.Destination, validation.Required),
+		validation.Field(&i.SOPInstanceUID, validation.Required),
+		validation.Field(&i.Status, validation.Required),
+	)
+}
+
+func (i *RouteItem) GetTableName() string {
+	field, _ := reflect.TypeOf(i).Elem().FieldByName("TableName")
+	tableName, _ := field.Tag.Lookup("sql")
+	return tableName
+}
@@ -7,15 +7,17 @@ import (
 
 	"github.com/go-ozzo/ozzo-validation"
 
+	"github.com/go-pg/pg"
 	"github.com/go-pg/pg/orm"
 )
 
 type Series struct {
 	TableName struct{} `sql:"series"`
 
-	ID        int       `json:"-" sql:",pk"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID        int         `json:"-" sql:",pk"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+	DeletedAt pg.NullTime `json:"deleted_at" pg:",soft_delete"`
 	StudyId   int
 	Study     *Study `json:"study"`
 
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/go-ozzo/ozzo-validation"
+
+	"github.com/go-pg/pg/orm"
+)
+
+const (
+	CommitmentStatusPending    = "pending"
+	CommitmentStatusProcessing = "processing"
+	CommitmentStatusReported   = "reported"
+	CommitmentStatusFailed     = "failed"
+)
+
+// StorageCommitment is a storage commitment request received with N-ACTION, verified and reported back to
+// the requesting AE with N-EVENT-REPORT. A failed report is retried until its attempts are exhausted.
+type StorageCommitment struct {
+	TableName struct{} `sql:"storage_commitment"`
+
+	ID             int       `json:"id" sql:",pk"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	TransactionUID string    `json:"transaction_uid"`
+	CallingAETitle string    `json:"calling_ae_title" sql:"calling_ae_title"`
+	Status         string    `json:"status"`
+	Attempts       int       `json:"attempts" sql:",notnull"`
+	NextAttemptAt  time.Time `json:"next_attempt_at"`
+	LastError      string    `json:"last_error,omitempty"`
+	// Committed and Failed count the instances by the result of the last verification.
+	Committed int                      `json:"committed" sql:",notnull"`
+	Failed    int                      `json:"failed" sql:",notnull"`
+	Items     []*StorageCommitmentItem `json:"items,omitempty"`
+}
+
+// BeforeInsert hook executed before database insert operation.
+func (c *StorageCommitment) BeforeInsert(db orm.DB) error {
+	now := time.Now()
+	c.CreatedAt = now
+	c.UpdatedAt = now
+	if c.NextAttemptAt.IsZero() {
+		c.NextAttemptAt = now
+	}
+	return c.Validate()
+}
+
+// BeforeUpdate hook executed before database update operation.
+func (c *StorageCommitment) BeforeUpdate(db orm.DB) error {
+	c.UpdatedAt = time.Now()
+	return c.Validate()
+}
+
+// Validate validates StorageCommitment struct and returns validation errors.
+func (c *StorageCommitment) Validate() error {
+	return validation.ValidateStruct(c,
+		validation.Field(&c.TransactionUID, validation.Required, validation.Length(1, 64)),
+		validation.Field(&c.CallingAETitle, validation.Required, validation.Length(1, 16)),
+	)
+}
+
+func (c *StorageCommitment) GetTableName() string {
+	field, _ := reflect.TypeOf(c).Elem().FieldByName("TableName")
+	tableName, _ := field.Tag.Lookup("sql")
+	return tableName
+}
+
+// StorageCommitmentItem is an instance referenced by a storage commitment request, with the failure reason
+// of its last verification, zero if it is committed.
+type StorageCommitmentItem struct {
+	TableName struct{} `sql:"storage_commitment_item"`
+
+	ID                  int    `json:"-" sql:",pk"`
+	StorageCommitmentId int    `json:"-"`
+	SOPClassUID         string `json:"sop_class_uid" sql:"sop_class_uid"`
+	SOPInstanceUID      string `json:"sop_instance_uid" sql:"sop_instance_uid"`
+	FailureReason       int    `json:"failure_reason,omitempty" sql:",notnull"`
+}
+
+func (i *StorageCommitmentItem) GetTableName() string {
+	field, _ := reflect.TypeOf(i).Elem().FieldByName("TableName")
+	tableName, _ := field.Tag.Lookup("sql")
+	return tableName
+}
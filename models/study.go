@@ -7,15 +7,28 @@ import (
 
 	"github.com/go-ozzo/ozzo-validation"
 
+	"github.com/go-pg/pg"
 	"github.com/go-pg/pg/orm"
 )
 
+// Storage tiers of instance files and the resulting InstanceAvailability of studies.
+const (
+	TierHot  = "hot"
+	TierCold = "cold"
+
+	AvailabilityOnline   = "ONLINE"
+	AvailabilityNearline = "NEARLINE"
+)
+
 type Study struct {
 	TableName struct{} `sql:"study"`
 
-	ID        int       `json:"-" sql:",pk"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID        int         `json:"-" sql:",pk"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+	DeletedAt pg.NullTime `json:"deleted_at" pg:",soft_delete"`
+	// LastAccessedAt is the time the files of the study were last retrieved
+	LastAccessedAt pg.NullTime `json:"last_accessed_at"`
 
 	StudyDate                     string `json:"study_date" dicom:"StudyDate"`
 	StudyTime                     string `json:"study_time" dicom:"StudyTime"`
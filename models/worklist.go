@@ -0,0 +1,144 @@
+package models
+
+import (
+	"reflect"
+	"regexp"
+	"time"
+
+	"github.com/go-ozzo/ozzo-validation"
+	"github.com/suyashkumar/dicom/pkg/tag"
+
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+)
+
+// Procedure step states of worklist items, as the values of ProcedureStepState.
+const (
+	WorklistStateScheduled  = "SCHEDULED"
+	WorklistStateInProgress = "IN PROGRESS"
+	WorklistStateCanceled   = "CANCELED"
+	WorklistStateCompleted  = "COMPLETED"
+)
+
+// GlobalWorklistSubscription is the well-known UID subscribing to every workitem, existing and future.
+const GlobalWorklistSubscription = "1.2.840.10008.5.1.4.34.5"
+
+var (
+	datePattern = regexp.MustCompile(`^[0-9]{8}$`)
+	timePattern = regexp.MustCompile(`^[0-9]{2}([0-9]{2}([0-9]{2}(\.[0-9]{1,6})?)?)?$`)
+)
+
+// WorklistItem is a scheduled procedure step, offered to the modalities with Modality Worklist C-FIND and
+// as a UPS-RS workitem. It is linked to the study that performs it when a series with its
+// ScheduledProcedureStepID arrives.
+type WorklistItem struct {
+	TableName struct{} `sql:"worklist_item"`
+
+	ID        int       `json:"id" sql:",pk"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// TransactionUID is the secret of the performer of an item in progress, never returned.
+	TransactionUID string      `json:"-"`
+	LinkedAt       pg.NullTime `json:"linked_at"`
+
+	SOPInstanceUID                    string `json:"sop_instance_uid" dicom:"SOPInstanceUID"`
+	ProcedureStepState                string `json:"procedure_step_state" dicom:"ProcedureStepState"`
+	PatientName                       string `json:"patient_name" dicom:"PatientName"`
+	PatientID                         string `json:"patient_id" dicom:"PatientID"`
+	PatientBirthDate                  string `json:"patient_birth_date" dicom:"PatientBirthDate"`
+	PatientSex                        string `json:"patient_sex" dicom:"PatientSex"`
+	AccessionNumber                   string `json:"accession_number" dicom:"AccessionNumber"`
+	ReferringPhysicianName            string `json:"referring_physician_name" dicom:"ReferringPhysicianName"`
+	StudyInstanceUID                  string `json:"study_instance_uid" dicom:"StudyInstanceUID"`
+	RequestedProcedureID              string `json:"requested_procedure_id" dicom:"RequestedProcedureID"`
+	RequestedProcedureDescription     string `json:"requested_procedure_description" dicom:"RequestedProcedureDescription"`
+	ScheduledProcedureStepID          string `json:"scheduled_procedure_step_id" dicom:"ScheduledProcedureStepID"`
+	ScheduledProcedureStepDescription string `json:"scheduled_procedure_step_description" dicom:"ScheduledProcedureStepDescription"`
+	ScheduledStationAETitle           string `json:"scheduled_station_ae_title" dicom:"ScheduledStationAETitle"`
+	Modality                          string `json:"modality" dicom:"Modality"`
+	ScheduledProcedureStepStartDate   string `json:"scheduled_procedure_step_start_date" dicom:"ScheduledProcedureStepStartDate"`
+	ScheduledProcedureStepStartTime   string `json:"scheduled_procedure_step_start_time" dicom:"ScheduledProcedureStepStartTime"`
+	ScheduledProcedureStepPriority    string `json:"scheduled_procedure_step_priority" dicom:"ScheduledProcedureStepPriority"`
+}
+
+func (w *WorklistItem) GetObjectIdFieldTag() tag.Tag {
+	return tag.SOPInstanceUID
+}
+
+// Final reports whether the item is completed or canceled, and can change no more.
+func (w *WorklistItem) Final() bool {
+	return w.ProcedureStepState == WorklistStateCompleted || w.ProcedureStepState == WorklistStateCanceled
+}
+
+// BeforeInsert hook executed before database insert operation.
+func (w *WorklistItem) BeforeInsert(db orm.DB) error {
+	now := time.Now()
+	w.CreatedAt = now
+	w.UpdatedAt = now
+	return w.Validate()
+}
+
+// BeforeUpdate hook executed before database update operation.
+func (w *WorklistItem) BeforeUpdate(db orm.DB) error {
+	w.UpdatedAt = time.Now()
+	return w.Validate()
+}
+
+// Validate validates WorklistItem struct and returns validation errors.
+func (w *WorklistItem) Validate() error {
+	return validation.ValidateStruct(w,
+		validation.Field(&w.SOPInstanceUID, validation.Required, validation.Length(1, 64)),
+		validation.Field(&w.ProcedureStepState, validation.Required, validation.In(
+			WorklistStateScheduled, WorklistStateInProgress, WorklistStateCanceled, WorklistStateCompleted)),
+		validation.Field(&w.PatientID, validation.Required, validation.Length(1, 64)),
+		validation.Field(&w.PatientBirthDate, validation.Match(datePattern)),
+		validation.Field(&w.PatientSex, validation.In("M", "F", "O")),
+		validation.Field(&w.AccessionNumber, validation.Length(0, 16)),
+		validation.Field(&w.StudyInstanceUID, validation.Length(0, 64)),
+		validation.Field(&w.RequestedProcedureID, validation.Length(0, 16)),
+		validation.Field(&w.ScheduledProcedureStepID, validation.Length(0, 16)),
+		validation.Field(&w.ScheduledStationAETitle, validation.Length(0, 16), validation.Match(aeTitlePattern)),
+		validation.Field(&w.Modality, validation.Length(0, 16)),
+		validation.Field(&w.ScheduledProcedureStepStartDate, validation.Required, validation.Match(datePattern)),
+		validation.Field(&w.ScheduledProcedureStepStartTime, validation.Match(timePattern)),
+		validation.Field(&w.ScheduledProcedureStepPriority, validation.In("HIGH", "MEDIUM", "LOW")),
+	)
+}
+
+func (w *WorklistItem) GetTableName() string {
+	field, _ := reflect.TypeOf(w).Elem().FieldByName("TableName")
+	tableName, _ := field.Tag.Lookup("sql")
+	return tableName
+}
+
+// WorklistSubscription subscribes an AE title to the events of a workitem, or of every workitem with the
+// GlobalWorklistSubscription UID. Subscriptions to a workitem without a deletion lock end once it is final.
+type WorklistSubscription struct {
+	TableName struct{} `sql:"worklist_subscription"`
+
+	ID           int       `json:"-" sql:",pk"`
+	CreatedAt    time.Time `json:"created_at"`
+	AETitle      string    `json:"ae_title" sql:"ae_title"`
+	WorkitemUID  string    `json:"workitem_uid"`
+	DeletionLock bool      `json:"deletion_lock" sql:",notnull"`
+}
+
+// BeforeInsert hook executed before database insert operation.
+func (s *WorklistSubscription) BeforeInsert(db orm.DB) error {
+	s.CreatedAt = time.Now()
+	return s.Validate()
+}
+
+// Validate validates WorklistSubscription struct and returns validation errors.
+func (s *WorklistSubscription) Validate() error {
+	return validation.ValidateStruct(s,
+		validation.Field(&s.AETitle, validation.Required, validation.Length(1, 16), validation.Match(aeTitlePattern)),
+		validation.Field(&s.WorkitemUID, validation.Required, validation.Length(1, 64)),
+	)
+}
+
+func (s *WorklistSubscription) GetTableName() string {
+	field, _ := reflect.TypeOf(s).Elem().FieldByName("TableName")
+	tableName, _ := field.Tag.Lookup("sql")
+	return tableName
+}
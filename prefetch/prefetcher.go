@@ -0,0 +1,358 @@
+package prefetch
+
+import (
+	"context"
+	"dicom-store-api/database"
+	"dicom-store-api/dicomwebclient"
+	"dicom-store-api/logging"
+	"dicom-store-api/models"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-pg/pg"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/suyashkumar/dicom"
+	"github.com/suyashkumar/dicom/pkg/tag"
+)
+
+// maxSeen bounds the studies remembered as triggered already, the store tells about the others.
+const maxSeen = 10000
+
+type PrefetchStore interface {
+	Start(prefetch *models.Prefetch) (bool, error)
+	Update(prefetch *models.Prefetch) error
+	CreatePrior(prior *models.PrefetchPrior) error
+	UpdatePrior(prior *models.PrefetchPrior) error
+	EndInterrupted() (int, error)
+}
+type StudyStore interface {
+	FindBy(fields map[string]any, options *database.SelectQueryOptions, tx *pg.Tx) ([]*models.Study, error)
+}
+type RemoteNodeStore interface {
+	List() ([]*models.RemoteNode, error)
+}
+
+// Retriever ingests the files fetched in the background, as a job.
+type Retriever interface {
+	Retrieve(fetch func(add func(file []byte) error) error) (*models.Job, error)
+}
+
+// Prefetcher searches the remote nodes for the priors of the new studies matching a rule, and retrieves
+// the most recent ones missing locally. Each study is handled once, and fetched priors trigger nothing.
+type Prefetcher struct {
+	PrefetchStore   PrefetchStore
+	StudyStore      StudyStore
+	RemoteNodeStore RemoteNodeStore
+	Queue           Retriever
+	// Timeout bounds every search of a remote node.
+	Timeout time.Duration
+	Workers int
+
+	rules    []*Rule
+	triggers chan *trigger
+	mutex    sync.Mutex
+	seen     map[string]bool
+}
+
+// trigger is a new study to find the priors of, with the values of the attributes the rule compares.
+type trigger struct {
+	rule      *Rule
+	studyUID  string
+	patientID string
+	studyDate string
+	same      map[tag.Tag]string
+}
+
+// prior is a study found on a remote node.
+type prior struct {
+	node      *models.RemoteNode
+	studyUID  string
+	studyDate string
+}
+
+// NewPrefetcher returns a Prefetcher for the rules, using lookback for those without one.
+func NewPrefetcher(rules []*Rule, lookback time.Duration) (*Prefetcher, error) {
+	for i, rule := range rules {
+		if err := rule.compile(lookback); err != nil {
+			return nil, fmt.Errorf("prefetch rule %d: %w", i, err)
+		}
+	}
+	return &Prefetcher{
+		rules:    rules,
+		Timeout:  30 * time.Second,
+		Workers:  1,
+		triggers: make(chan *trigger, 1000),
+		seen:     map[string]bool{},
+	}, nil
+}
+
+// NewPrefetcherFromConfig returns the Prefetcher configured by the prefetch config key, retrieving with queue.
+func NewPrefetcherFromConfig(db *pg.DB, queue Retriever) (*Prefetcher, error) {
+	viper.SetDefault("prefetch.lookback", "43800h")
+	viper.SetDefault("prefetch.timeout", "30s")
+	viper.SetDefault("prefetch.workers", 1)
+
+	var rules []*Rule
+	if err := viper.UnmarshalKey("prefetch.rules", &rules); err != nil {
+		return nil, err
+	}
+	p, err := NewPrefetcher(rules, viper.GetDuration("prefetch.lookback"))
+	if err != nil {
+		return nil, err
+	}
+
+	p.PrefetchStore = database.NewPrefetchStore(db)
+	p.StudyStore = database.NewStudyStore(db)
+	p.RemoteNodeStore = database.NewRemoteNodeStore(db)
+	p.Queue = queue
+	p.Timeout = viper.GetDuration("prefetch.timeout")
+	p.Workers = viper.GetInt("prefetch.workers")
+	if p.Workers < 1 {
+		p.Workers = 1
+	}
+	return p, nil
+}
+
+// Rules returns the configured rules.
+func (p *Prefetcher) Rules() []*Rule {
+	return p.rules
+}
+
+// Start fails the prefetches interrupted by a previous run and starts the workers. Nothing is started
+// without rules.
+func (p *Prefetcher) Start() error {
+	if len(p.rules) == 0 {
+		return nil
+	}
+	interrupted, err := p.PrefetchStore.EndInterrupted()
+	if err != nil {
+		return err
+	}
+	if interrupted > 0 {
+		logging.Logger.WithField("module", "prefetch").Warnf("ended %d interrupted prefetches", interrupted)
+	}
+	for i := 0; i < p.Workers; i++ {
+		go p.work()
+	}
+	return nil
+}
+
+// Trigger queues the prefetch of the study of a stored instance, if the first rule its dataset matches.
+// Call it once the instance is committed, it never blocks the store.
+func (p *Prefetcher) Trigger(dataset *dicom.Dataset) {
+	var rule *Rule
+	for _, r := range p.rules {
+		if r.matches(dataset) {
+			rule = r
+			break
+		}
+	}
+	studyUID := stringValue(dataset, tag.StudyInstanceUID)
+	if rule == nil || studyUID == "" {
+		return
+	}
+
+	p.mutex.Lock()
+	if p.seen[studyUID] {
+		p.mutex.Unlock()
+		return
+	}
+	if len(p.seen) >= maxSeen {
+		p.seen = map[string]bool{}
+	}
+	p.seen[studyUID] = true
+	p.mutex.Unlock()
+
+	t := &trigger{
+		rule:      rule,
+		studyUID:  studyUID,
+		patientID: stringValue(dataset, tag.PatientID),
+		studyDate: stringValue(dataset, tag.StudyDate),
+		same:      map[tag.Tag]string{},
+	}
+	for _, same := range rule.same {
+		t.same[same] = stringValue(dataset, same)
+	}
+
+	select {
+	case p.triggers <- t:
+	default:
+		logging.Logger.WithField("module", "prefetch").WithField("study", studyUID).Warn("prefetch queue full, skipped")
+	}
+}
+
+func (p *Prefetcher) work() {
+	for t := range p.triggers {
+		p.process(t)
+	}
+}
+
+// process runs the prefetch of a study, unless it had one already.
+func (p *Prefetcher) process(t *trigger) {
+	logger := logging.Logger.WithField("module", "prefetch").WithField("study", t.studyUID)
+	prefetch := &models.Prefetch{
+		StudyInstanceUID: t.studyUID,
+		PatientID:        t.patientID,
+		Rule:             t.rule.Name,
+		Status:           models.PrefetchStatusSearching,
+	}
+	started, err := p.PrefetchStore.Start(prefetch)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	if !started {
+		return
+	}
+
+	prefetch.Status = models.PrefetchStatusCompleted
+	if err = p.fetchPriors(prefetch, t, logger); err != nil {
+		logger.Warn(err)
+		prefetch.Status = models.PrefetchStatusFailed
+		prefetch.Error = err.Error()
+	}
+	if err = p.PrefetchStore.Update(prefetch); err != nil {
+		logger.Error(err)
+	}
+}
+
+// fetchPriors retrieves those of the most recent priors of the study missing locally. A failing node
+// fails the prefetch only if no node answered.
+func (p *Prefetcher) fetchPriors(prefetch *models.Prefetch, t *trigger, logger logrus.FieldLogger) error {
+	if t.patientID == "" {
+		return fmt.Errorf("no PatientID to search priors with")
+	}
+	nodes, err := p.nodes(t.rule)
+	if err != nil {
+		return err
+	}
+	if len(nodes) == 0 {
+		return fmt.Errorf("no remote node with a url to search")
+	}
+
+	query := priorQuery(t)
+	var priors []*prior
+	found := map[string]bool{t.studyUID: true}
+	var searchErr error
+	answered := 0
+	for _, node := range nodes {
+		ctx, cancel := context.WithTimeout(context.Background(), p.Timeout)
+		result, err := dicomwebclient.NewClient(node.URL).SearchSeries(ctx, "", query)
+		cancel()
+		if err != nil {
+			logger.WithField("ae_title", node.AETitle).Warnf("prior search failed: %s", err)
+			searchErr = fmt.Errorf("%s: %w", node.AETitle, err)
+			continue
+		}
+		answered++
+		for _, attributes := range result {
+			studyUID := attributes.String("0020000D")
+			if studyUID == "" || found[studyUID] {
+				continue
+			}
+			found[studyUID] = true
+			priors = append(priors, &prior{node: node, studyUID: studyUID, studyDate: attributes.String("00080020")})
+		}
+	}
+	if answered == 0 {
+		return searchErr
+	}
+
+	// most recent first, the study date format sorts as a string
+	sort.SliceStable(priors, func(i, j int) bool {
+		return priors[i].studyDate > priors[j].studyDate
+	})
+	if len(priors) > t.rule.Count {
+		priors = priors[:t.rule.Count]
+	}
+	for _, prior := range priors {
+		local, err := p.StudyStore.FindBy(map[string]any{"StudyInstanceUID": prior.studyUID}, &database.SelectQueryOptions{Limit: 1}, nil)
+		if err != nil {
+			return err
+		}
+		if len(local) > 0 {
+			continue
+		}
+		if err = p.fetch(prefetch, prior); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetch records the prior, so that its arrival triggers no prefetch, and retrieves it.
+func (p *Prefetcher) fetch(prefetch *models.Prefetch, prior *prior) error {
+	record := &models.PrefetchPrior{
+		PrefetchId:       prefetch.ID,
+		AETitle:          prior.node.AETitle,
+		StudyInstanceUID: prior.studyUID,
+		StudyDate:        prior.studyDate,
+	}
+	if err := p.PrefetchStore.CreatePrior(record); err != nil {
+		return err
+	}
+	prefetch.Priors = append(prefetch.Priors, record)
+
+	client := dicomwebclient.NewClient(prior.node.URL)
+	job, err := p.Queue.Retrieve(func(add func(file []byte) error) error {
+		return client.RetrieveStudy(context.Background(), prior.studyUID, add)
+	})
+	if err != nil {
+		record.Error = err.Error()
+	} else {
+		record.JobId = job.ID
+	}
+	return p.PrefetchStore.UpdatePrior(record)
+}
+
+// nodes returns the remote nodes the rule searches, in order.
+func (p *Prefetcher) nodes(rule *Rule) ([]*models.RemoteNode, error) {
+	all, err := p.RemoteNodeStore.List()
+	if err != nil {
+		return nil, err
+	}
+	var nodes []*models.RemoteNode
+	if len(rule.Nodes) == 0 {
+		for _, node := range all {
+			if node.URL != "" {
+				nodes = append(nodes, node)
+			}
+		}
+		return nodes, nil
+	}
+	for _, aeTitle := range rule.Nodes {
+		for _, node := range all {
+			if node.AETitle == aeTitle && node.URL != "" {
+				nodes = append(nodes, node)
+			}
+		}
+	}
+	return nodes, nil
+}
+
+// priorQuery returns the QIDO-RS series query for the priors: same patient, within the lookback up to the
+// date of the new study, and the same values of the attributes the rule compares when the study has them.
+func priorQuery(t *trigger) url.Values {
+	until := time.Now()
+	if date, err := time.Parse("20060102", t.studyDate); err == nil {
+		until = date
+	}
+	from := until.Add(-t.rule.Lookback)
+
+	query := url.Values{}
+	query.Set("PatientID", t.patientID)
+	query.Set("StudyDate", from.Format("20060102")+"-"+until.Format("20060102"))
+	for same, value := range t.same {
+		if value != "" {
+			query.Set(fmt.Sprintf("%04X%04X", same.Group, same.Element), value)
+		}
+	}
+	query.Add("includefield", "StudyInstanceUID")
+	query.Add("includefield", "StudyDate")
+	query.Set("limit", "1000")
+	return query
+}
@@ -0,0 +1,79 @@
+// Package prefetch fetches the prior studies of the patient from remote DICOMweb nodes when a new study
+// arrives, so that they are at hand for comparison.
+package prefetch
+
+import (
+	"dicom-store-api/routing"
+	"dicom-store-api/utils"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/suyashkumar/dicom"
+	"github.com/suyashkumar/dicom/pkg/tag"
+)
+
+// Rule selects the priors of the new studies matching all of its conditions, as configured in the
+// prefetch.rules list of config.yaml.
+type Rule struct {
+	Name  string           `json:"name" mapstructure:"name"`
+	Match []*routing.Match `json:"match" mapstructure:"match"`
+	// Same lists the attributes the priors share with the new study, such as Modality or BodyPartExamined.
+	Same []string `json:"same" mapstructure:"same"`
+	// Count is the number of most recent priors fetched.
+	Count    int           `json:"count" mapstructure:"count"`
+	Lookback time.Duration `json:"lookback" mapstructure:"lookback"`
+	// Nodes are the AE titles of the remote nodes searched in order, all nodes with a url when empty.
+	Nodes []string `json:"nodes" mapstructure:"nodes"`
+
+	same []tag.Tag
+}
+
+func (r *Rule) compile(lookback time.Duration) error {
+	if r.Name == "" {
+		return fmt.Errorf("missing name")
+	}
+	for _, match := range r.Match {
+		if err := match.Compile(); err != nil {
+			return err
+		}
+	}
+	r.same = nil
+	for _, name := range r.Same {
+		t, err := utils.GetTagByNameOrCode(name)
+		if err != nil {
+			return fmt.Errorf("invalid same tag %q", name)
+		}
+		r.same = append(r.same, t)
+	}
+	if r.Count <= 0 {
+		r.Count = 3
+	}
+	if r.Lookback <= 0 {
+		r.Lookback = lookback
+	}
+	return nil
+}
+
+// matches reports whether every condition of the rule holds for the dataset.
+func (r *Rule) matches(dataset *dicom.Dataset) bool {
+	for _, match := range r.Match {
+		if !match.Matches(dataset) {
+			return false
+		}
+	}
+	return true
+}
+
+// stringValue returns the first value of the attribute in the dataset, or an empty string.
+func stringValue(dataset *dicom.Dataset, t tag.Tag) string {
+	element, err := dataset.FindElementByTag(t)
+	if err != nil || element.Value.ValueType() != dicom.Strings {
+		return ""
+	}
+	values := dicom.MustGetStrings(element.Value)
+	if len(values) == 0 {
+		return ""
+	}
+	return strings.Trim(values[0], "\x00 ")
+}
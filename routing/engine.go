@@ -0,0 +1,333 @@
+package routing
+
+import (
+	"dicom-store-api/database"
+	"dicom-store-api/logging"
+	"dicom-store-api/models"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-pg/pg"
+	"github.com/spf13/viper"
+	"github.com/suyashkumar/dicom"
+)
+
+// purgeInterval is how often completed items past the retention are deleted.
+const purgeInterval = time.Hour
+
+type RouteStore interface {
+	Create(items []*models.RouteItem, tx *pg.Tx) error
+	Update(item *models.RouteItem) error
+	ClaimNextItems(limit int) ([]*models.RouteItem, error)
+	ResetProcessingItems() (int, error)
+	PurgeCompleted(completedBefore time.Time) (int, error)
+}
+type InstanceStore interface {
+	FindBy(fields map[string]any, options *database.SelectQueryOptions, tx *pg.Tx) ([]*models.Instance, error)
+}
+type RemoteNodeStore interface {
+	GetByAETitle(aeTitle string) (*models.RemoteNode, error)
+}
+
+// Engine queues the stored instances matching the rules for their destinations and sends them with a pool
+// of workers. A failed send is retried after a backoff doubling with every attempt, up to MaxAttempts.
+type Engine struct {
+	RouteStore      RouteStore
+	InstanceStore   InstanceStore
+	RemoteNodeStore RemoteNodeStore
+	// AETitle is the calling AE title of the associations to dimse destinations.
+	AETitle      string
+	Workers      int
+	BatchSize    int
+	MaxAttempts  int
+	Backoff      time.Duration
+	MaxBackoff   time.Duration
+	Retention    time.Duration
+	PollInterval time.Duration
+	Client       *http.Client
+
+	rules        []*Rule
+	destinations []*Destination
+	byName       map[string]*Destination
+	wake         chan struct{}
+}
+
+// NewEngine validates the rules and destinations and returns an Engine routing with them.
+func NewEngine(rules []*Rule, destinations []*Destination) (*Engine, error) {
+	byName := map[string]*Destination{}
+	for i, destination := range destinations {
+		if err := destination.compile(); err != nil {
+			return nil, fmt.Errorf("routing destination %d: %w", i, err)
+		}
+		if byName[destination.Name] != nil {
+			return nil, fmt.Errorf("routing destination %d: duplicate name %q", i, destination.Name)
+		}
+		byName[destination.Name] = destination
+	}
+	for i, rule := range rules {
+		if err := rule.compile(byName); err != nil {
+			return nil, fmt.Errorf("routing rule %d: %w", i, err)
+		}
+	}
+	return &Engine{
+		AETitle:      "DICOM_STORE",
+		Workers:      1,
+		BatchSize:    50,
+		MaxAttempts:  10,
+		Backoff:      30 * time.Second,
+		MaxBackoff:   time.Hour,
+		Retention:    24 * time.Hour,
+		PollInterval: 5 * time.Second,
+		Client:       &http.Client{},
+		rules:        rules,
+		destinations: destinations,
+		byName:       byName,
+		wake:         make(chan struct{}, 1),
+	}, nil
+}
+
+// NewEngineFromConfig returns an Engine with the rules, destinations and queue settings of the routing config key.
+func NewEngineFromConfig(db *pg.DB) (*Engine, error) {
+	viper.SetDefault("dimse.ae_title", "DICOM_STORE")
+	viper.SetDefault("routing.workers", 2)
+	viper.SetDefault("routing.batch_size", 50)
+	viper.SetDefault("routing.max_attempts", 10)
+	viper.SetDefault("routing.backoff", "30s")
+	viper.SetDefault("routing.max_backoff", "1h")
+	viper.SetDefault("routing.retention", "24h")
+
+	var rules []*Rule
+	if err := viper.UnmarshalKey("routing.rules", &rules); err != nil {
+		return nil, err
+	}
+	var destinations []*Destination
+	if err := viper.UnmarshalKey("routing.destinations", &destinations); err != nil {
+		return nil, err
+	}
+	e, err := NewEngine(rules, destinations)
+	if err != nil {
+		return nil, err
+	}
+
+	e.RouteStore = database.NewRouteStore(db)
+	e.InstanceStore = database.NewInstanceStore(db)
+	e.RemoteNodeStore = database.NewRemoteNodeStore(db)
+	e.AETitle = viper.GetString("dimse.ae_title")
+	e.Workers = viper.GetInt("routing.workers")
+	e.BatchSize = viper.GetInt("routing.batch_size")
+	e.MaxAttempts = viper.GetInt("routing.max_attempts")
+	e.Backoff = viper.GetDuration("routing.backoff")
+	e.MaxBackoff = viper.GetDuration("routing.max_backoff")
+	e.Retention = viper.GetDuration("routing.retention")
+	if e.Workers < 1 {
+		e.Workers = 1
+	}
+	if e.BatchSize < 1 {
+		e.BatchSize = 1
+	}
+	if e.MaxAttempts < 1 {
+		e.MaxAttempts = 1
+	}
+	return e, nil
+}
+
+// Rules returns the configured rules.
+func (e *Engine) Rules() []*Rule {
+	return e.rules
+}
+
+// Destinations returns the configured destinations.
+func (e *Engine) Destinations() []*Destination {
+	return e.destinations
+}
+
+// Route queues the instance for the destinations of the rules its dataset matches, in the transaction
+// storing it, and reports whether it was queued at all. Call Wake once the transaction is committed.
+func (e *Engine) Route(dataset *dicom.Dataset, instance *models.Instance, tx *pg.Tx) (bool, error) {
+	var items []*models.RouteItem
+	queued := map[string]bool{}
+	for _, rule := range e.rules {
+		if !rule.matches(dataset) {
+			continue
+		}
+		for _, name := range rule.Destinations {
+			if queued[name] {
+				continue
+			}
+			queued[name] = true
+			item := &models.RouteItem{
+				Rule:           rule.Name,
+				Destination:    name,
+				SOPInstanceUID: instance.SOPInstanceUID,
+				Status:         models.RouteStatusPending,
+			}
+			if instance.Series != nil {
+				item.SeriesInstanceUID = instance.Series.SeriesInstanceUID
+				if instance.Series.Study != nil {
+					item.StudyInstanceUID = instance.Series.Study.StudyInstanceUID
+				}
+			}
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		return false, nil
+	}
+	return true, e.RouteStore.Create(items, tx)
+}
+
+// Wake lets an idle worker look for new items without waiting for the poll interval.
+func (e *Engine) Wake() {
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start requeues items interrupted by a previous shutdown, starts the workers and purges completed items
+// past the retention.
+func (e *Engine) Start() error {
+	logger := logging.Logger.WithField("module", "routing")
+	count, err := e.RouteStore.ResetProcessingItems()
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		logger.Infof("resuming %d interrupted routing items", count)
+	}
+
+	for i := 0; i < e.Workers; i++ {
+		go e.work()
+	}
+	if e.Retention > 0 {
+		go func() {
+			for {
+				if _, err := e.RouteStore.PurgeCompleted(time.Now().Add(-e.Retention)); err != nil {
+					logger.Error(err)
+				}
+				time.Sleep(purgeInterval)
+			}
+		}()
+	}
+	return nil
+}
+
+func (e *Engine) work() {
+	logger := logging.Logger.WithField("module", "routing")
+	for {
+		items, err := e.RouteStore.ClaimNextItems(e.BatchSize)
+		if err != nil {
+			logger.Error(err)
+		}
+		if len(items) == 0 {
+			select {
+			case <-e.wake:
+			case <-time.After(e.PollInterval):
+			}
+			continue
+		}
+		e.process(items)
+	}
+}
+
+// process sends a batch of items of the same destination and records the outcome of each one.
+func (e *Engine) process(items []*models.RouteItem) {
+	logger := logging.Logger.WithField("module", "routing").WithField("destination", items[0].Destination)
+	errs := make([]error, len(items))
+
+	destination := e.byName[items[0].Destination]
+	instances, err := e.findInstances(items)
+	switch {
+	case destination == nil:
+		for i := range items {
+			errs[i] = errUnknownDestination
+		}
+	case err != nil:
+		for i := range items {
+			errs[i] = err
+		}
+	default:
+		var found []*models.Instance
+		var indexes []int
+		for i, instance := range instances {
+			if instance == nil {
+				errs[i] = errInstanceNotFound
+				continue
+			}
+			found = append(found, instance)
+			indexes = append(indexes, i)
+		}
+		if len(found) > 0 {
+			for i, err := range e.send(destination, found) {
+				errs[indexes[i]] = err
+			}
+		}
+	}
+
+	sent := 0
+	for i, item := range items {
+		e.finish(item, errs[i])
+		if errs[i] == nil {
+			sent++
+		} else {
+			logger.WithField("instance", item.SOPInstanceUID).Warnf("attempt %d failed: %v", item.Attempts, errs[i])
+		}
+		if err := e.RouteStore.Update(item); err != nil {
+			logger.WithField("instance", item.SOPInstanceUID).Error(err)
+		}
+	}
+	if sent > 0 {
+		logger.Infof("sent %d instances", sent)
+	}
+}
+
+// finish records the outcome of a send on the item. A failure is retried later unless the attempts are
+// exhausted or the instance is gone.
+func (e *Engine) finish(item *models.RouteItem, err error) {
+	item.Attempts++
+	if err == nil {
+		item.Status = models.RouteStatusCompleted
+		return
+	}
+	item.LastError = err.Error()
+	if item.Attempts >= e.MaxAttempts || err == errInstanceNotFound || err == errUnknownDestination {
+		item.Status = models.RouteStatusDead
+		return
+	}
+	item.Status = models.RouteStatusPending
+	item.NextAttemptAt = time.Now().Add(e.backoff(item.Attempts))
+}
+
+// backoff returns the delay before the next attempt, doubling with every failed attempt up to MaxBackoff.
+func (e *Engine) backoff(attempts int) time.Duration {
+	delay := e.Backoff
+	for i := 1; i < attempts && delay < e.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if e.MaxBackoff > 0 && delay > e.MaxBackoff {
+		delay = e.MaxBackoff
+	}
+	return delay
+}
+
+// findInstances returns the stored instance of every item, nil for the instances deleted since.
+func (e *Engine) findInstances(items []*models.RouteItem) ([]*models.Instance, error) {
+	uids := make([]string, len(items))
+	for i, item := range items {
+		uids[i] = item.SOPInstanceUID
+	}
+	found, err := e.InstanceStore.FindBy(map[string]any{"SOPInstanceUID": uids}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	byUID := map[string]*models.Instance{}
+	for _, instance := range found {
+		byUID[instance.SOPInstanceUID] = instance
+	}
+	instances := make([]*models.Instance, len(items))
+	for i, item := range items {
+		instances[i] = byUID[item.SOPInstanceUID]
+	}
+	return instances, nil
+}
@@ -0,0 +1,138 @@
+// Package routing forwards stored instances matching the configured rules to remote destinations, through a
+// persistent queue retried with backoff.
+package routing
+
+import (
+	"dicom-store-api/utils"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/suyashkumar/dicom"
+	"github.com/suyashkumar/dicom/pkg/tag"
+)
+
+// Destination types.
+const (
+	// DestinationSTOW posts the instances to a DICOMweb server with STOW-RS.
+	DestinationSTOW = "stow"
+	// DestinationDIMSE sends the instances with C-STORE to a remote node.
+	DestinationDIMSE = "dimse"
+)
+
+// MatchCallingAETitle matches the AE title the instance was received from, recorded as its
+// SourceApplicationEntityTitle.
+const MatchCallingAETitle = "CallingAETitle"
+
+// Destination is a routing destination as configured in the routing.destinations list of config.yaml.
+type Destination struct {
+	Name string `json:"name" mapstructure:"name"`
+	Type string `json:"type" mapstructure:"type"`
+	// URL is the DICOMweb base URL of a stow destination, such as https://pacs/dicomweb.
+	URL string `json:"url,omitempty" mapstructure:"url"`
+	// Headers are added to the STOW-RS requests, for credentials for instance.
+	Headers map[string]string `json:"-" mapstructure:"headers"`
+	// AETitle is the remote node of a dimse destination, managed with /api/remote.
+	AETitle string        `json:"ae_title,omitempty" mapstructure:"ae_title"`
+	Timeout time.Duration `json:"timeout" mapstructure:"timeout"`
+}
+
+// Rule sends the instances matching all of its conditions to its destinations.
+type Rule struct {
+	Name         string   `json:"name" mapstructure:"name"`
+	Match        []*Match `json:"match" mapstructure:"match"`
+	Destinations []string `json:"destinations" mapstructure:"destinations"`
+}
+
+// Match is a condition on an attribute, with * and ? wildcards in the value. It holds if any value
+// of the attribute matches.
+type Match struct {
+	Tag   string `json:"tag" mapstructure:"tag"`
+	Value string `json:"value" mapstructure:"value"`
+
+	tag     tag.Tag
+	pattern *regexp.Regexp
+}
+
+func (d *Destination) compile() error {
+	if d.Name == "" {
+		return fmt.Errorf("missing name")
+	}
+	switch d.Type {
+	case DestinationSTOW:
+		if u, err := url.Parse(d.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid url %q", d.URL)
+		}
+	case DestinationDIMSE:
+		if d.AETitle == "" || len(d.AETitle) > 16 {
+			return fmt.Errorf("invalid ae_title %q", d.AETitle)
+		}
+	default:
+		return fmt.Errorf("unknown type %q", d.Type)
+	}
+	if d.Timeout <= 0 {
+		d.Timeout = time.Minute
+	}
+	return nil
+}
+
+func (r *Rule) compile(destinations map[string]*Destination) error {
+	if len(r.Destinations) == 0 {
+		return fmt.Errorf("no destinations")
+	}
+	for _, name := range r.Destinations {
+		if destinations[name] == nil {
+			return fmt.Errorf("unknown destination %q", name)
+		}
+	}
+	for _, match := range r.Match {
+		if err := match.Compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matches reports whether every condition of the rule holds for the dataset.
+func (r *Rule) matches(dataset *dicom.Dataset) bool {
+	for _, match := range r.Match {
+		if !match.Matches(dataset) {
+			return false
+		}
+	}
+	return true
+}
+
+// Compile resolves the tag and the wildcard pattern of the condition, it must be called before Matches.
+func (m *Match) Compile() error {
+	if m.Tag == MatchCallingAETitle {
+		m.tag = tag.SourceApplicationEntityTitle
+	} else {
+		t, err := utils.GetTagByNameOrCode(m.Tag)
+		if err != nil {
+			return fmt.Errorf("invalid match tag %q", m.Tag)
+		}
+		m.tag = t
+	}
+	pattern := regexp.QuoteMeta(m.Value)
+	pattern = strings.ReplaceAll(pattern, `\*`, ".*")
+	pattern = strings.ReplaceAll(pattern, `\?`, ".")
+	m.pattern = regexp.MustCompile("^" + pattern + "$")
+	return nil
+}
+
+// Matches reports whether the condition holds for the dataset.
+func (m *Match) Matches(dataset *dicom.Dataset) bool {
+	element, err := dataset.FindElementByTag(m.tag)
+	if err != nil || element.Value.ValueType() != dicom.Strings {
+		return m.pattern.MatchString("")
+	}
+	for _, value := range dicom.MustGetStrings(element.Value) {
+		if m.pattern.MatchString(strings.Trim(value, "\x00 ")) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+package routing
+
+import (
+	"context"
+	"dicom-store-api/dicomwebclient"
+	"dicom-store-api/dimse"
+	"dicom-store-api/fs"
+	"dicom-store-api/models"
+	"errors"
+	"fmt"
+)
+
+// maxRequestSize bounds the body of a STOW-RS request, larger batches are split.
+const maxRequestSize = 64 << 20
+
+var (
+	errUnknownDestination = errors.New("unknown destination")
+	errInstanceNotFound   = errors.New("instance is no longer stored")
+)
+
+// send sends the instances to the destination and returns the error of each one.
+func (e *Engine) send(destination *Destination, instances []*models.Instance) []error {
+	if destination.Type == DestinationDIMSE {
+		return e.sendDIMSE(destination, instances)
+	}
+	return e.sendSTOW(destination, instances)
+}
+
+// sendSTOW posts the files of the instances in multipart requests of up to maxRequestSize.
+func (e *Engine) sendSTOW(destination *Destination, instances []*models.Instance) []error {
+	errs := make([]error, len(instances))
+	var files [][]byte
+	var indexes []int
+	size := 0
+	flush := func() {
+		if len(files) == 0 {
+			return
+		}
+		for i, err := range e.postSTOW(destination, files, instances, indexes) {
+			errs[indexes[i]] = err
+		}
+		files, indexes, size = nil, nil, 0
+	}
+
+	for i, instance := range instances {
+		data, err := fs.ReadDicomFile(instance.Series.Study, instance.Series, instance)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		if size+len(data) > maxRequestSize {
+			flush()
+		}
+		files = append(files, data)
+		indexes = append(indexes, i)
+		size += len(data)
+	}
+	flush()
+	return errs
+}
+
+// postSTOW sends a single STOW-RS request. The instances a 409 response lists failed, the others were stored.
+func (e *Engine) postSTOW(destination *Destination, files [][]byte, instances []*models.Instance, indexes []int) []error {
+	errs := make([]error, len(files))
+	client := dicomwebclient.NewClient(destination.URL)
+	client.HTTPClient = e.Client
+	for name, value := range destination.Headers {
+		client.Header.Set(name, value)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), destination.Timeout)
+	defer cancel()
+	response, err := client.Store(ctx, files)
+	for i, index := range indexes {
+		if err != nil {
+			errs[i] = err
+		} else if reason, ok := response.Failed[instances[index].SOPInstanceUID]; ok {
+			errs[i] = fmt.Errorf("STOW-RS failed the instance with reason %s", reason)
+		}
+	}
+	return errs
+}
+
+// sendDIMSE sends the instances with C-STORE over one association to the remote node of the destination,
+// proposing the SOP class and transfer syntax of every file.
+func (e *Engine) sendDIMSE(destination *Destination, instances []*models.Instance) []error {
+	errs := make([]error, len(instances))
+	fail := func(err error) []error {
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = err
+			}
+		}
+		return errs
+	}
+
+	node, err := e.RemoteNodeStore.GetByAETitle(destination.AETitle)
+	if err != nil {
+		return fail(fmt.Errorf("remote node %s: %w", destination.AETitle, err))
+	}
+
+	var contexts []*dimse.PresentationContext
+	proposed := map[string]bool{}
+	for i, instance := range instances {
+		meta, err := readFileMeta(instance)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		if key := meta.SOPClassUID + "|" + meta.TransferSyntax; !proposed[key] && len(contexts) < 128 {
+			proposed[key] = true
+			contexts = append(contexts, &dimse.PresentationContext{
+				AbstractSyntax:   meta.SOPClassUID,
+				TransferSyntaxes: []string{meta.TransferSyntax},
+			})
+		}
+	}
+	if len(contexts) == 0 {
+		return errs
+	}
+
+	association, err := dimse.Dial(context.Background(), node.Addr(), e.AETitle, node.AETitle, contexts,
+		&dimse.DialOptions{Timeout: destination.Timeout})
+	if err != nil {
+		return fail(err)
+	}
+	defer association.Release()
+
+	for i, instance := range instances {
+		if errs[i] != nil {
+			continue
+		}
+		data, err := fs.ReadDicomFile(instance.Series.Study, instance.Series, instance)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), destination.Timeout)
+		response, err := association.Store(ctx, data, "", 0)
+		cancel()
+		if err != nil {
+			errs[i] = err
+			select {
+			case <-association.Done():
+				return fail(err)
+			default:
+			}
+			continue
+		}
+		if dimse.IsFailure(response.Status) {
+			errs[i] = fmt.Errorf("C-STORE answered status 0x%04X %s", response.Status, response.ErrorComment)
+		}
+	}
+	return errs
+}
+
+func readFileMeta(instance *models.Instance) (*dimse.FileMeta, error) {
+	file, err := fs.OpenDicomFile(instance.Series.Study, instance.Series, instance)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return dimse.ReadFileMeta(file)
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"bytes"
 	"dicom-store-api/models"
 	"encoding/json"
 	"fmt"
@@ -35,6 +36,15 @@ func ExtractDicomObjectFromDataset(dataset dicom.Dataset, object models.DicomObj
 	return nil
 }
 
+// WriteDatasetToBytes encodes a dataset as a Part 10 file.
+func WriteDatasetToBytes(dataset dicom.Dataset) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	if err := dicom.Write(buffer, dataset, dicom.SkipVRVerification()); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
 func GetStringValueFromElement(element *dicom.Element) (string, error) {
 	value, err := getValueFromElement(element)
 	if err != nil {
@@ -176,8 +186,8 @@ func getValueFromElement(element *dicom.Element) (any, error) {
 }
 
 func GetTagByNameOrCode(tagName string) (tag.Tag, error) {
-	isCode, _ := regexp.MatchString(`^[a-zA-Z0-9]+$`, tagName)
-	isCode = isCode && len(tagName) == 8
+	// keywords of eight letters such as Modality are names, codes are eight hex digits
+	isCode, _ := regexp.MatchString(`^[0-9a-fA-F]{8}$`, tagName)
 	var tagInfo tag.Info
 	var err error
 
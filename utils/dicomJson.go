@@ -0,0 +1,527 @@
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/suyashkumar/dicom"
+	"github.com/suyashkumar/dicom/pkg/frame"
+	"github.com/suyashkumar/dicom/pkg/tag"
+	"github.com/suyashkumar/dicom/pkg/uid"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const ImplementationClassUID = "1.2.826.0.1.3680043.10.1011.1"
+
+// bulkDataTransferSyntaxes are the default transfer syntaxes of the media types of encapsulated bulk data
+// sent without a transfer-syntax parameter (PS3.18 Table 8.7.3-2).
+var bulkDataTransferSyntaxes = map[string]string{
+	"image/jpeg":      "1.2.840.10008.1.2.4.50",
+	"image/jls":       "1.2.840.10008.1.2.4.80",
+	"image/jp2":       "1.2.840.10008.1.2.4.90",
+	"image/jpx":       "1.2.840.10008.1.2.4.92",
+	"image/jxl":       "1.2.840.10008.1.2.4.110",
+	"image/dicom-rle": "1.2.840.10008.1.2.5",
+	"video/mpeg":      "1.2.840.10008.1.2.4.100",
+	"video/mp4":       "1.2.840.10008.1.2.4.102",
+	"video/h265":      "1.2.840.10008.1.2.4.107",
+}
+
+// BulkDataTransferSyntax returns the default transfer syntax of the media type of encapsulated bulk data.
+func BulkDataTransferSyntax(mediaType string) (string, bool) {
+	transferSyntax, ok := bulkDataTransferSyntaxes[strings.ToLower(mediaType)]
+	return transferSyntax, ok
+}
+
+// DicomJSONAttribute is a single attribute of the DICOM JSON model (PS3.18 Annex F).
+type DicomJSONAttribute struct {
+	VR           string            `json:"vr"`
+	Value        []json.RawMessage `json:"Value,omitempty"`
+	BulkDataURI  string            `json:"BulkDataURI,omitempty"`
+	InlineBinary string            `json:"InlineBinary,omitempty"`
+}
+
+// DicomJSONDataset is a dataset of the DICOM JSON model keyed by the "GGGGEEEE" tag code.
+type DicomJSONDataset map[string]DicomJSONAttribute
+
+// BulkData is the payload referenced by a BulkDataURI.
+type BulkData struct {
+	Data []byte
+	// Encapsulated is set when the payload is a compressed frame (image/jpeg etc.) rather than native pixel data.
+	Encapsulated   bool
+	TransferSyntax string
+}
+
+// BulkDataResolver returns the payload referenced by a BulkDataURI.
+type BulkDataResolver func(uri string) (*BulkData, error)
+
+// ParseDicomJSON parses a metadata part which is either a single dataset or an array of datasets.
+func ParseDicomJSON(data []byte) ([]DicomJSONDataset, error) {
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "[") {
+		var datasets []DicomJSONDataset
+		err := json.Unmarshal(data, &datasets)
+		return datasets, err
+	}
+
+	var dataset DicomJSONDataset
+	if err := json.Unmarshal(data, &dataset); err != nil {
+		return nil, err
+	}
+	return []DicomJSONDataset{dataset}, nil
+}
+
+// BuildDatasetFromDicomJSON converts a DICOM JSON dataset into a dicom.Dataset with a complete file meta information group.
+func BuildDatasetFromDicomJSON(object DicomJSONDataset, resolve BulkDataResolver) (dicom.Dataset, error) {
+	elements, transferSyntax, err := buildElementsFromDicomJSON(object, resolve)
+	if err != nil {
+		return dicom.Dataset{}, err
+	}
+	if transferSyntax == "" {
+		transferSyntax = uid.ExplicitVRLittleEndian
+	}
+
+	var sopClassUID, sopInstanceUID string
+	var datasetElements []*dicom.Element
+	for _, element := range elements {
+		switch element.Tag {
+		case tag.SOPClassUID:
+			if sopClassUID, err = getUIDValue(element, "SOPClassUID"); err != nil {
+				return dicom.Dataset{}, err
+			}
+		case tag.SOPInstanceUID:
+			if sopInstanceUID, err = getUIDValue(element, "SOPInstanceUID"); err != nil {
+				return dicom.Dataset{}, err
+			}
+		}
+		if element.Tag.Group != tag.MetadataGroup {
+			datasetElements = append(datasetElements, element)
+		}
+	}
+	if sopClassUID == "" || sopInstanceUID == "" {
+		return dicom.Dataset{}, fmt.Errorf("SOPClassUID and SOPInstanceUID are required")
+	}
+
+	metaElements, err := NewFileMetaElements(sopClassUID, sopInstanceUID, transferSyntax)
+	if err != nil {
+		return dicom.Dataset{}, err
+	}
+
+	return dicom.Dataset{Elements: append(metaElements, datasetElements...)}, nil
+}
+
+// getUIDValue returns the single UID of an element, which the client may have sent empty or of another type.
+func getUIDValue(element *dicom.Element, name string) (string, error) {
+	if element.Value.ValueType() == dicom.Strings {
+		if values := dicom.MustGetStrings(element.Value); len(values) == 1 && strings.TrimSpace(values[0]) != "" {
+			return strings.TrimSpace(values[0]), nil
+		}
+	}
+	return "", fmt.Errorf("%s must have a single UID value", name)
+}
+
+// NewFileMetaElements builds the group 0002 elements of a Part 10 file.
+func NewFileMetaElements(sopClassUID string, sopInstanceUID string, transferSyntax string) ([]*dicom.Element, error) {
+	values := []struct {
+		tag  tag.Tag
+		data any
+	}{
+		{tag.FileMetaInformationVersion, []byte{0, 1}},
+		{tag.MediaStorageSOPClassUID, []string{sopClassUID}},
+		{tag.MediaStorageSOPInstanceUID, []string{sopInstanceUID}},
+		{tag.TransferSyntaxUID, []string{transferSyntax}},
+		{tag.ImplementationClassUID, []string{ImplementationClassUID}},
+	}
+
+	elements := make([]*dicom.Element, 0, len(values))
+	for _, value := range values {
+		element, err := dicom.NewElement(value.tag, value.data)
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, element)
+	}
+	return elements, nil
+}
+
+func buildElementsFromDicomJSON(object DicomJSONDataset, resolve BulkDataResolver) ([]*dicom.Element, string, error) {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var transferSyntax string
+	var pixelDataKey string
+	elements := make([]*dicom.Element, 0, len(keys))
+	for _, key := range keys {
+		elementTag, err := parseTagCode(key)
+		if err != nil {
+			return nil, "", err
+		}
+		if elementTag == tag.PixelData {
+			pixelDataKey = key
+			continue
+		}
+
+		element, err := buildElementFromDicomJSON(elementTag, object[key], resolve)
+		if err != nil {
+			return nil, "", fmt.Errorf("%s: %w", key, err)
+		}
+		if element != nil {
+			elements = append(elements, element)
+		}
+	}
+
+	// pixel data goes last and needs the image pixel module attributes which have been converted by now
+	if pixelDataKey != "" {
+		element, ts, err := buildPixelDataElement(object[pixelDataKey], elements, resolve)
+		if err != nil {
+			return nil, "", fmt.Errorf("%s: %w", pixelDataKey, err)
+		}
+		transferSyntax = ts
+		elements = append(elements, element)
+	}
+
+	return elements, transferSyntax, nil
+}
+
+func buildElementFromDicomJSON(elementTag tag.Tag, attribute DicomJSONAttribute, resolve BulkDataResolver) (*dicom.Element, error) {
+	var data any
+	switch attribute.VR {
+	case "SQ":
+		items := make([][]*dicom.Element, 0, len(attribute.Value))
+		for _, rawItem := range attribute.Value {
+			var item DicomJSONDataset
+			if err := json.Unmarshal(rawItem, &item); err != nil {
+				return nil, err
+			}
+			itemElements, _, err := buildElementsFromDicomJSON(item, resolve)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, itemElements)
+		}
+		data = items
+	case "US", "SS", "UL", "SL":
+		values := make([]int, 0, len(attribute.Value))
+		for _, rawValue := range attribute.Value {
+			var value int
+			if err := json.Unmarshal(rawValue, &value); err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		data = values
+	case "AT":
+		values := make([]int, 0, len(attribute.Value)*2)
+		for _, rawValue := range attribute.Value {
+			var code string
+			if err := json.Unmarshal(rawValue, &code); err != nil {
+				return nil, err
+			}
+			valueTag, err := parseTagCode(code)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, int(valueTag.Group), int(valueTag.Element))
+		}
+		data = values
+	case "FL", "FD":
+		values := make([]float64, 0, len(attribute.Value))
+		for _, rawValue := range attribute.Value {
+			var value float64
+			if err := json.Unmarshal(rawValue, &value); err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		data = values
+	case "OB", "OW":
+		value, err := getBinaryValue(attribute, resolve)
+		if err != nil {
+			return nil, err
+		}
+		data = value
+	case "OD", "OF", "OL", "OV", "UN":
+		return nil, fmt.Errorf("unsupported VR %s", attribute.VR)
+	case "PN":
+		values := make([]string, 0, len(attribute.Value))
+		for _, rawValue := range attribute.Value {
+			var value PatientNameValueStruct
+			if err := json.Unmarshal(rawValue, &value); err != nil {
+				return nil, err
+			}
+			values = append(values, strings.TrimRight(strings.Join([]string{value.Alphabetic, value.Ideographic, value.Phonetic}, "="), "="))
+		}
+		data = values
+	default:
+		values := make([]string, 0, len(attribute.Value))
+		for _, rawValue := range attribute.Value {
+			var value any
+			if err := json.Unmarshal(rawValue, &value); err != nil {
+				return nil, err
+			}
+			switch v := value.(type) {
+			case nil:
+				values = append(values, "")
+			case float64:
+				values = append(values, strconv.FormatFloat(v, 'f', -1, 64))
+			default:
+				values = append(values, fmt.Sprintf("%v", v))
+			}
+		}
+		data = values
+	}
+
+	element, err := dicom.NewElement(elementTag, data)
+	if err != nil {
+		// private or unknown tags are kept with the VR stated in the request
+		value, valueErr := dicom.NewValue(data)
+		if valueErr != nil {
+			return nil, valueErr
+		}
+		element = &dicom.Element{
+			Tag:                    elementTag,
+			ValueRepresentation:    tag.GetVRKind(elementTag, attribute.VR),
+			RawValueRepresentation: attribute.VR,
+			Value:                  value,
+		}
+	}
+	element.RawValueRepresentation = attribute.VR
+	return element, nil
+}
+
+func getBinaryValue(attribute DicomJSONAttribute, resolve BulkDataResolver) ([]byte, error) {
+	if attribute.InlineBinary != "" {
+		return base64.StdEncoding.DecodeString(attribute.InlineBinary)
+	}
+	if attribute.BulkDataURI != "" {
+		bulkData, err := resolve(attribute.BulkDataURI)
+		if err != nil {
+			return nil, err
+		}
+		return bulkData.Data, nil
+	}
+	return []byte{}, nil
+}
+
+func buildPixelDataElement(attribute DicomJSONAttribute, elements []*dicom.Element, resolve BulkDataResolver) (*dicom.Element, string, error) {
+	var bulkData *BulkData
+	if attribute.BulkDataURI != "" {
+		var err error
+		bulkData, err = resolve(attribute.BulkDataURI)
+		if err != nil {
+			return nil, "", err
+		}
+	} else {
+		data, err := base64.StdEncoding.DecodeString(attribute.InlineBinary)
+		if err != nil {
+			return nil, "", err
+		}
+		bulkData = &BulkData{Data: data}
+	}
+
+	vr := attribute.VR
+	if vr == "" {
+		vr = "OW"
+	}
+
+	if bulkData.Encapsulated {
+		transferSyntax := bulkData.TransferSyntax
+		if transferSyntax == "" {
+			return nil, "", fmt.Errorf("encapsulated pixel data without a transfer syntax")
+		}
+		value, err := dicom.NewValue(dicom.PixelDataInfo{
+			IsEncapsulated: true,
+			Frames: []frame.Frame{{
+				Encapsulated:     true,
+				EncapsulatedData: frame.EncapsulatedFrame{Data: bulkData.Data},
+			}},
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		return &dicom.Element{
+			Tag:                    tag.PixelData,
+			ValueRepresentation:    tag.VRPixelData,
+			RawValueRepresentation: "OB",
+			ValueLength:            tag.VLUndefinedLength,
+			Value:                  value,
+		}, transferSyntax, nil
+	}
+
+	info, err := getNativePixelDataInfo(bulkData.Data, elements)
+	if err != nil {
+		return nil, "", err
+	}
+	value, err := dicom.NewValue(info)
+	if err != nil {
+		return nil, "", err
+	}
+	return &dicom.Element{
+		Tag:                    tag.PixelData,
+		ValueRepresentation:    tag.VRPixelData,
+		RawValueRepresentation: vr,
+		Value:                  value,
+	}, bulkData.TransferSyntax, nil
+}
+
+// getNativePixelDataInfo splits raw little endian pixel data into frames described by the image pixel module.
+func getNativePixelDataInfo(data []byte, elements []*dicom.Element) (dicom.PixelDataInfo, error) {
+	getInt := func(elementTag tag.Tag, defaultValue int) int {
+		for _, element := range elements {
+			if element.Tag != elementTag {
+				continue
+			}
+			switch element.Value.ValueType() {
+			case dicom.Ints:
+				if values := dicom.MustGetInts(element.Value); len(values) > 0 {
+					return values[0]
+				}
+			case dicom.Strings:
+				if values := dicom.MustGetStrings(element.Value); len(values) > 0 {
+					if value, err := strconv.Atoi(strings.TrimSpace(values[0])); err == nil {
+						return value
+					}
+				}
+			}
+		}
+		return defaultValue
+	}
+
+	rows := getInt(tag.Rows, 0)
+	cols := getInt(tag.Columns, 0)
+	bitsAllocated := getInt(tag.BitsAllocated, 0)
+	samplesPerPixel := getInt(tag.SamplesPerPixel, 1)
+	numberOfFrames := getInt(tag.NumberOfFrames, 1)
+
+	if rows == 0 || cols == 0 {
+		return dicom.PixelDataInfo{}, fmt.Errorf("Rows and Columns are required for native pixel data")
+	}
+	if bitsAllocated != 8 && bitsAllocated != 16 && bitsAllocated != 32 {
+		return dicom.PixelDataInfo{}, fmt.Errorf("unsupported BitsAllocated %d", bitsAllocated)
+	}
+
+	bytesPerSample := bitsAllocated / 8
+	pixelsPerFrame := rows * cols
+	expectedLength := numberOfFrames * pixelsPerFrame * samplesPerPixel * bytesPerSample
+	if len(data) < expectedLength {
+		return dicom.PixelDataInfo{}, fmt.Errorf("pixel data is %d bytes, expected %d", len(data), expectedLength)
+	}
+
+	info := dicom.PixelDataInfo{Frames: make([]frame.Frame, numberOfFrames)}
+	offset := 0
+	for frameIndex := 0; frameIndex < numberOfFrames; frameIndex++ {
+		pixels := make([][]int, pixelsPerFrame)
+		for pixelIndex := range pixels {
+			samples := make([]int, samplesPerPixel)
+			for sampleIndex := range samples {
+				switch bytesPerSample {
+				case 1:
+					samples[sampleIndex] = int(data[offset])
+				case 2:
+					samples[sampleIndex] = int(binary.LittleEndian.Uint16(data[offset:]))
+				case 4:
+					samples[sampleIndex] = int(binary.LittleEndian.Uint32(data[offset:]))
+				}
+				offset += bytesPerSample
+			}
+			pixels[pixelIndex] = samples
+		}
+		info.Frames[frameIndex] = frame.Frame{
+			NativeData: frame.NativeFrame{
+				Data:          pixels,
+				Rows:          rows,
+				Cols:          cols,
+				BitsPerSample: bitsAllocated,
+			},
+		}
+	}
+	return info, nil
+}
+
+func parseTagCode(code string) (tag.Tag, error) {
+	if len(code) != 8 {
+		return tag.Tag{}, fmt.Errorf("invalid tag code %s", code)
+	}
+	group, err := strconv.ParseUint(code[0:4], 16, 16)
+	if err != nil {
+		return tag.Tag{}, fmt.Errorf("invalid tag code %s", code)
+	}
+	element, err := strconv.ParseUint(code[4:], 16, 16)
+	if err != nil {
+		return tag.Tag{}, fmt.Errorf("invalid tag code %s", code)
+	}
+	return tag.Tag{Group: uint16(group), Element: uint16(element)}, nil
+}
+
+// DatasetElementsToDicomJSON converts elements into the DICOM JSON model. Pixel data is left out.
+func DatasetElementsToDicomJSON(elements []*dicom.Element) map[string]any {
+	result := map[string]any{}
+	for _, element := range elements {
+		if element.Tag == tag.PixelData {
+			continue
+		}
+		result[fmt.Sprintf("%04X%04X", element.Tag.Group, element.Tag.Element)] = ElementToDicomJSON(element)
+	}
+	return result
+}
+
+// ElementToDicomJSON converts a single element into a DICOM JSON attribute.
+func ElementToDicomJSON(element *dicom.Element) map[string]any {
+	vr := element.RawValueRepresentation
+	attribute := map[string]any{"vr": vr}
+	if element.Value == nil {
+		return attribute
+	}
+
+	var values []any
+	switch element.Value.ValueType() {
+	case dicom.Strings:
+		for _, value := range dicom.MustGetStrings(element.Value) {
+			switch vr {
+			case "PN":
+				values = append(values, &PatientNameValueStruct{Alphabetic: value})
+			case "IS", "DS":
+				number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if err != nil {
+					values = append(values, value)
+				} else {
+					values = append(values, number)
+				}
+			default:
+				values = append(values, value)
+			}
+		}
+	case dicom.Ints:
+		ints := dicom.MustGetInts(element.Value)
+		if vr == "AT" {
+			for i := 0; i+1 < len(ints); i += 2 {
+				values = append(values, fmt.Sprintf("%04X%04X", ints[i], ints[i+1]))
+			}
+		} else {
+			for _, value := range ints {
+				values = append(values, value)
+			}
+		}
+	case dicom.Floats:
+		for _, value := range dicom.MustGetFloats(element.Value) {
+			values = append(values, value)
+		}
+	case dicom.Bytes:
+		attribute["InlineBinary"] = base64.StdEncoding.EncodeToString(dicom.MustGetBytes(element.Value))
+	case dicom.Sequences:
+		for _, item := range element.Value.GetValue().([]*dicom.SequenceItemValue) {
+			values = append(values, DatasetElementsToDicomJSON(item.GetValue().([]*dicom.Element)))
+		}
+	}
+
+	if len(values) > 0 {
+		attribute["Value"] = values
+	}
+	return attribute
+}
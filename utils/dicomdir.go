@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"bytes"
+	"github.com/suyashkumar/dicom"
+	"github.com/suyashkumar/dicom/pkg/tag"
+	"strings"
+)
+
+// MediaStorageDirectorySOPClassUID identifies a DICOMDIR file.
+const MediaStorageDirectorySOPClassUID = "1.2.840.10008.1.3.10"
+
+// IsDicomFile reports whether the data starts with a Part 10 preamble and the DICM prefix.
+func IsDicomFile(data []byte) bool {
+	return len(data) >= 132 && string(data[128:132]) == "DICM"
+}
+
+// GetDicomDirReferencedFiles returns the ReferencedFileID of every directory record of a DICOMDIR
+// as a slash separated path relative to the DICOMDIR location.
+func GetDicomDirReferencedFiles(data []byte) ([]string, error) {
+	dataset, err := dicom.Parse(bytes.NewReader(data), int64(len(data)), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for element := range dataset.FlatIterator() {
+		if element.Tag != tag.ReferencedFileID || element.Value.ValueType() != dicom.Strings {
+			continue
+		}
+		components := dicom.MustGetStrings(element.Value)
+		for i, component := range components {
+			components[i] = strings.TrimSpace(component)
+		}
+		paths = append(paths, strings.Join(components, "/"))
+	}
+	return paths, nil
+}
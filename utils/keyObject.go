@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/suyashkumar/dicom"
+	"github.com/suyashkumar/dicom/pkg/tag"
+)
+
+// KeyObjectSelectionDocumentSOPClassUID identifies a Key Object Selection document, the carrier of IOCM rejection notes.
+const KeyObjectSelectionDocumentSOPClassUID = "1.2.840.10008.5.1.4.1.1.88.59"
+
+// CodedEntry is a single item of a code sequence.
+type CodedEntry struct {
+	CodeValue              string
+	CodingSchemeDesignator string
+	CodeMeaning            string
+}
+
+// ReferencedInstance is an instance referenced from the evidence sequence of a structured document.
+type ReferencedInstance struct {
+	StudyInstanceUID  string
+	SeriesInstanceUID string
+	SOPClassUID       string
+	SOPInstanceUID    string
+}
+
+// GetDocumentTitle returns the ConceptNameCodeSequence entry of a structured document.
+func GetDocumentTitle(dataset dicom.Dataset) (*CodedEntry, error) {
+	element, err := dataset.FindElementByTag(tag.ConceptNameCodeSequence)
+	if err != nil {
+		return nil, err
+	}
+	items := getSequenceItems(element)
+	if len(items) == 0 {
+		return nil, errors.New("empty ConceptNameCodeSequence")
+	}
+	return &CodedEntry{
+		CodeValue:              getItemString(items[0], tag.CodeValue),
+		CodingSchemeDesignator: getItemString(items[0], tag.CodingSchemeDesignator),
+		CodeMeaning:            getItemString(items[0], tag.CodeMeaning),
+	}, nil
+}
+
+// GetEvidenceReferences returns the instances listed in the CurrentRequestedProcedureEvidenceSequence.
+func GetEvidenceReferences(dataset dicom.Dataset) ([]ReferencedInstance, error) {
+	element, err := dataset.FindElementByTag(tag.CurrentRequestedProcedureEvidenceSequence)
+	if err != nil {
+		return nil, err
+	}
+
+	var references []ReferencedInstance
+	for _, studyItem := range getSequenceItems(element) {
+		studyInstanceUID := getItemString(studyItem, tag.StudyInstanceUID)
+		for _, seriesItem := range getSequenceItems(findItemElement(studyItem, tag.ReferencedSeriesSequence)) {
+			seriesInstanceUID := getItemString(seriesItem, tag.SeriesInstanceUID)
+			for _, sopItem := range getSequenceItems(findItemElement(seriesItem, tag.ReferencedSOPSequence)) {
+				references = append(references, ReferencedInstance{
+					StudyInstanceUID:  studyInstanceUID,
+					SeriesInstanceUID: seriesInstanceUID,
+					SOPClassUID:       getItemString(sopItem, tag.ReferencedSOPClassUID),
+					SOPInstanceUID:    getItemString(sopItem, tag.ReferencedSOPInstanceUID),
+				})
+			}
+		}
+	}
+	return references, nil
+}
+
+func getSequenceItems(element *dicom.Element) [][]*dicom.Element {
+	if element == nil || element.Value.ValueType() != dicom.Sequences {
+		return nil
+	}
+	var items [][]*dicom.Element
+	for _, item := range element.Value.GetValue().([]*dicom.SequenceItemValue) {
+		items = append(items, item.GetValue().([]*dicom.Element))
+	}
+	return items
+}
+
+func findItemElement(item []*dicom.Element, t tag.Tag) *dicom.Element {
+	for _, element := range item {
+		if element.Tag == t {
+			return element
+		}
+	}
+	return nil
+}
+
+func getItemString(item []*dicom.Element, t tag.Tag) string {
+	element := findItemElement(item, t)
+	if element == nil || element.Value.ValueType() != dicom.Strings {
+		return ""
+	}
+	return strings.TrimRight(strings.Join(dicom.MustGetStrings(element.Value), "\\"), " \x00")
+}
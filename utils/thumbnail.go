@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/suyashkumar/dicom"
+	"github.com/suyashkumar/dicom/pkg/tag"
+	"image"
+	"image/color"
+	"image/jpeg"
+)
+
+const ThumbnailSize = 128
+
+// GenerateThumbnail renders the first frame of the dataset as a JPEG no larger than size x size.
+// Native frames are scaled to their own min/max range, encapsulated frames must be baseline JPEG.
+func GenerateThumbnail(dataset dicom.Dataset, size int) ([]byte, error) {
+	element, err := dataset.FindElementByTag(tag.PixelData)
+	if err != nil {
+		return nil, err
+	}
+	info := dicom.MustGetPixelDataInfo(element.Value)
+	if len(info.Frames) == 0 {
+		return nil, fmt.Errorf("no frames in pixel data")
+	}
+
+	var source image.Image
+	firstFrame := info.Frames[0]
+	if firstFrame.Encapsulated {
+		source, err = firstFrame.GetImage()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		source = normalizeNativeFrame(firstFrame.NativeData.Data, firstFrame.NativeData.Rows, firstFrame.NativeData.Cols)
+	}
+
+	bounds := source.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return nil, fmt.Errorf("empty frame")
+	}
+	scale := float64(size) / float64(width)
+	if height > width {
+		scale = float64(size) / float64(height)
+	}
+	if scale > 1 {
+		scale = 1
+	}
+	thumbWidth, thumbHeight := int(float64(width)*scale), int(float64(height)*scale)
+	if thumbWidth == 0 {
+		thumbWidth = 1
+	}
+	if thumbHeight == 0 {
+		thumbHeight = 1
+	}
+
+	thumbnail := image.NewRGBA(image.Rect(0, 0, thumbWidth, thumbHeight))
+	for y := 0; y < thumbHeight; y++ {
+		for x := 0; x < thumbWidth; x++ {
+			thumbnail.Set(x, y, source.At(bounds.Min.X+x*width/thumbWidth, bounds.Min.Y+y*height/thumbHeight))
+		}
+	}
+
+	buffer := &bytes.Buffer{}
+	if err := jpeg.Encode(buffer, thumbnail, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func normalizeNativeFrame(data [][]int, rows int, cols int) image.Image {
+	gray := image.NewGray(image.Rect(0, 0, cols, rows))
+	if len(data) == 0 {
+		return gray
+	}
+
+	min, max := data[0][0], data[0][0]
+	for _, pixel := range data {
+		if pixel[0] < min {
+			min = pixel[0]
+		}
+		if pixel[0] > max {
+			max = pixel[0]
+		}
+	}
+	valueRange := max - min
+	if valueRange == 0 {
+		valueRange = 1
+	}
+
+	for i, pixel := range data {
+		if i >= rows*cols {
+			break
+		}
+		gray.SetGray(i%cols, i/cols, color.Gray{Y: uint8((pixel[0] - min) * 255 / valueRange)})
+	}
+	return gray
+}
@@ -0,0 +1,274 @@
+// Package worklist schedules procedure steps for the modalities, follows their state as Unified Procedure
+// Step workitems and notifies the AE titles subscribed to them.
+package worklist
+
+import (
+	"crypto/rand"
+	"dicom-store-api/logging"
+	"dicom-store-api/models"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/go-pg/pg"
+)
+
+// Event types of the UPS event reports.
+const (
+	EventStateReport     uint16 = 1
+	EventCancelRequested uint16 = 2
+)
+
+var (
+	ErrInvalidTransition     = errors.New("the workitem cannot change to this state")
+	ErrTransactionUIDMissing = errors.New("a transaction UID is required to change the state of the workitem")
+	ErrTransactionUIDWrong   = errors.New("the transaction UID is not that of the workitem")
+	ErrNotScheduled          = errors.New("the workitem is no longer scheduled")
+	ErrAlreadyInState        = errors.New("the workitem is already in this state")
+)
+
+type WorklistStore interface {
+	Create(item *models.WorklistItem) error
+	Update(item *models.WorklistItem, tx *pg.Tx) error
+	UpdateState(item *models.WorklistItem, from string) (bool, error)
+	Link(studyInstanceUID string, scheduledProcedureStepID string, requestedProcedureID string) ([]*models.WorklistItem, error)
+	Subscribe(subscription *models.WorklistSubscription) error
+	Unsubscribe(aeTitle string, workitemUID string) (int, error)
+	Suspend(aeTitle string) (int, error)
+	Subscribers(workitemUID string) ([]*models.WorklistSubscription, error)
+	EndSubscriptions(workitemUID string) error
+}
+
+// Event is a UPS event report on a workitem, delivered to the AE titles subscribed to it.
+type Event struct {
+	Type        uint16
+	WorkitemUID string
+	State       string
+	// Reason is the reason of a cancellation request.
+	Reason string
+}
+
+// Manager creates worklist items and changes their state following the Unified Procedure Step rules,
+// and delivers the events to the subscribers listening. Events of AE titles not listening are dropped.
+type Manager struct {
+	Store WorklistStore
+
+	mutex     sync.Mutex
+	listeners map[string][]chan *Event
+}
+
+// NewManager returns a Manager of the worklist items in the store.
+func NewManager(store WorklistStore) *Manager {
+	return &Manager{
+		Store:     store,
+		listeners: map[string][]chan *Event{},
+	}
+}
+
+// NewUID returns a new UID under the 2.25 root, from a random UUID.
+func NewUID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return "2.25." + new(big.Int).SetBytes(id).String()
+}
+
+// Create schedules a new item, with a new UID if it has none.
+func (m *Manager) Create(item *models.WorklistItem) error {
+	if item.SOPInstanceUID == "" {
+		item.SOPInstanceUID = NewUID()
+	}
+	if item.ProcedureStepState == "" {
+		item.ProcedureStepState = models.WorklistStateScheduled
+	}
+	if item.ProcedureStepState != models.WorklistStateScheduled {
+		return ErrNotScheduled
+	}
+	if item.ScheduledProcedureStepPriority == "" {
+		item.ScheduledProcedureStepPriority = "MEDIUM"
+	}
+	item.TransactionUID = ""
+	if err := m.Store.Create(item); err != nil {
+		return err
+	}
+	m.notify(&Event{Type: EventStateReport, WorkitemUID: item.SOPInstanceUID, State: item.ProcedureStepState})
+	return nil
+}
+
+// Update saves the changes to an item, which must still be scheduled.
+func (m *Manager) Update(item *models.WorklistItem) error {
+	if item.ProcedureStepState != models.WorklistStateScheduled {
+		return ErrNotScheduled
+	}
+	return m.Store.Update(item, nil)
+}
+
+// ChangeState moves an item to the state. The performer claims a scheduled item with a transaction UID
+// of its own, and must present it to complete or cancel the item in progress.
+func (m *Manager) ChangeState(item *models.WorklistItem, state string, transactionUID string) error {
+	from := item.ProcedureStepState
+	switch {
+	case transactionUID == "":
+		return ErrTransactionUIDMissing
+	case from == state && (state != models.WorklistStateInProgress || item.TransactionUID == transactionUID):
+		return ErrAlreadyInState
+	case item.Final():
+		return ErrInvalidTransition
+	case from == models.WorklistStateScheduled && state == models.WorklistStateInProgress:
+		item.TransactionUID = transactionUID
+	case from == models.WorklistStateInProgress && (state == models.WorklistStateCompleted || state == models.WorklistStateCanceled):
+		if item.TransactionUID != transactionUID {
+			return ErrTransactionUIDWrong
+		}
+	case from == models.WorklistStateInProgress && state == models.WorklistStateInProgress:
+		return ErrTransactionUIDWrong
+	default:
+		return ErrInvalidTransition
+	}
+
+	item.ProcedureStepState = state
+	updated, err := m.Store.UpdateState(item, from)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		item.ProcedureStepState = from
+		return fmt.Errorf("%w: it changed meanwhile", ErrInvalidTransition)
+	}
+	m.stateChanged(item)
+	return nil
+}
+
+// RequestCancel cancels a scheduled item at once, and asks the performer of an item in progress to
+// cancel it.
+func (m *Manager) RequestCancel(item *models.WorklistItem, reason string) error {
+	switch item.ProcedureStepState {
+	case models.WorklistStateScheduled:
+		item.ProcedureStepState = models.WorklistStateCanceled
+		updated, err := m.Store.UpdateState(item, models.WorklistStateScheduled)
+		if err != nil {
+			return err
+		}
+		if !updated {
+			item.ProcedureStepState = models.WorklistStateScheduled
+			return fmt.Errorf("%w: it changed meanwhile", ErrInvalidTransition)
+		}
+		m.stateChanged(item)
+		return nil
+	case models.WorklistStateInProgress:
+		m.notify(&Event{Type: EventCancelRequested, WorkitemUID: item.SOPInstanceUID, State: item.ProcedureStepState, Reason: reason})
+		return nil
+	}
+	return ErrInvalidTransition
+}
+
+// stateChanged reports the new state of the item, and ends the subscriptions to it once it is final.
+func (m *Manager) stateChanged(item *models.WorklistItem) {
+	m.notify(&Event{Type: EventStateReport, WorkitemUID: item.SOPInstanceUID, State: item.ProcedureStepState})
+	if item.Final() {
+		if err := m.Store.EndSubscriptions(item.SOPInstanceUID); err != nil {
+			logging.Logger.WithField("module", "worklist").Error(err)
+		}
+	}
+}
+
+// Subscribe subscribes the AE title to the events of an item, or of all items with the global subscription
+// UID, and reports the state of the item subscribed to.
+func (m *Manager) Subscribe(aeTitle string, item *models.WorklistItem, deletionLock bool) error {
+	subscription := &models.WorklistSubscription{AETitle: aeTitle, WorkitemUID: models.GlobalWorklistSubscription, DeletionLock: deletionLock}
+	if item != nil {
+		subscription.WorkitemUID = item.SOPInstanceUID
+	}
+	if err := m.Store.Subscribe(subscription); err != nil {
+		return err
+	}
+	if item != nil {
+		m.send(aeTitle, &Event{Type: EventStateReport, WorkitemUID: item.SOPInstanceUID, State: item.ProcedureStepState})
+	}
+	return nil
+}
+
+// Unsubscribe ends the subscription of the AE title to an item, or all of its subscriptions with the
+// global subscription UID.
+func (m *Manager) Unsubscribe(aeTitle string, workitemUID string) error {
+	_, err := m.Store.Unsubscribe(aeTitle, workitemUID)
+	return err
+}
+
+// Suspend ends the global subscription of the AE title, which stays subscribed to the existing items.
+func (m *Manager) Suspend(aeTitle string) error {
+	_, err := m.Store.Suspend(aeTitle)
+	return err
+}
+
+// Listen returns the events for the AE title until stop is called. A slow listener loses events rather
+// than blocking the changes.
+func (m *Manager) Listen(aeTitle string) (events <-chan *Event, stop func()) {
+	listener := make(chan *Event, 100)
+	m.mutex.Lock()
+	m.listeners[aeTitle] = append(m.listeners[aeTitle], listener)
+	m.mutex.Unlock()
+
+	var once sync.Once
+	return listener, func() {
+		once.Do(func() {
+			m.mutex.Lock()
+			defer m.mutex.Unlock()
+			listeners := m.listeners[aeTitle]
+			for i, l := range listeners {
+				if l == listener {
+					m.listeners[aeTitle] = append(listeners[:i], listeners[i+1:]...)
+					break
+				}
+			}
+			if len(m.listeners[aeTitle]) == 0 {
+				delete(m.listeners, aeTitle)
+			}
+			close(listener)
+		})
+	}
+}
+
+// notify sends the event to the AE titles subscribed to its item.
+func (m *Manager) notify(event *Event) {
+	subscriptions, err := m.Store.Subscribers(event.WorkitemUID)
+	if err != nil {
+		logging.Logger.WithField("module", "worklist").Error(err)
+		return
+	}
+	for _, subscription := range subscriptions {
+		m.send(subscription.AETitle, event)
+	}
+}
+
+func (m *Manager) send(aeTitle string, event *Event) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, listener := range m.listeners[aeTitle] {
+		select {
+		case listener <- event:
+		default:
+			logging.Logger.WithField("module", "worklist").WithField("ae_title", aeTitle).Warn("event listener full, event dropped")
+		}
+	}
+}
+
+// Link links the scheduled procedure step the series performs, if it tells one, to its study.
+func (m *Manager) Link(series *models.Series, study *models.Study) {
+	stepID := strings.TrimSpace(series.ScheduledProcedureStepID)
+	if stepID == "" {
+		return
+	}
+	logger := logging.Logger.WithField("module", "worklist").WithField("series", series.SeriesInstanceUID)
+	items, err := m.Store.Link(study.StudyInstanceUID, stepID, strings.TrimSpace(series.RequestedProcedureID))
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	for _, item := range items {
+		logger.WithField("workitem", item.SOPInstanceUID).Info("linked to the study performing it")
+	}
+}